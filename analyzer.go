@@ -1,13 +1,13 @@
 package lifecycle
 
 import (
-	"log"
 	"os"
 
 	"github.com/pkg/errors"
 
 	"github.com/buildpack/lifecycle/image"
 	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
 type Analyzer struct {
@@ -15,61 +15,51 @@ type Analyzer struct {
 	AppDir     string
 	LayersDir  string
 	In         []byte
-	Out, Err   *log.Logger
+	Logger     Logger
+	Tracer     *telemetry.Tracer
 	UID        int
 	GID        int
+
+	// RegistryRunImage, when set, is compared with the run image of the
+	// previous image to warn when the app should be rebased.
+	RegistryRunImage image.Image
+
+	// BuildImage and RegistryRunImage, when set, are checked for stack
+	// deprecation labels, which fail the analysis if FailDeprecatedStack.
+	BuildImage          image.Image
+	FailDeprecatedStack bool
 }
 
 func (a *Analyzer) Analyze(image image.Image) error {
+	if a.BuildImage != nil {
+		if err := checkStackDeprecation(a.Logger, "build", a.BuildImage, a.FailDeprecatedStack); err != nil {
+			return err
+		}
+	}
+	if a.RegistryRunImage != nil {
+		if err := checkStackDeprecation(a.Logger, "run", a.RegistryRunImage, a.FailDeprecatedStack); err != nil {
+			return err
+		}
+	}
 	data, err := metadata.GetAppMetadata(image)
+	if _, ok := err.(*metadata.IncompatibleError); ok {
+		a.Logger.Warnf("ignoring metadata of previous image: %s", err)
+		err = nil
+	}
 	if err != nil {
 		return err
 	}
+	if a.RegistryRunImage != nil {
+		checkRunImageDigest(a.Logger, a.Tracer, data.RunImage.SHA, a.RegistryRunImage)
+	}
 	for _, buildpack := range a.Buildpacks {
-		cache, err := readBuildpackLayersDir(a.LayersDir, *buildpack)
+		span := a.Tracer.Start("analyze").SetAttribute("buildpack.id", buildpack.ID)
+		err := a.analyzeBuildpack(data, buildpack)
+		span.SetError(err)
+		span.Finish()
 		if err != nil {
 			return err
 		}
-
-		metadataLayers := data.MetadataForBuildpack(buildpack.ID).Layers
-		for _, cachedLayer := range cache.layers {
-			cacheType := cachedLayer.classifyCache(metadataLayers)
-			switch cacheType {
-			case cacheStaleNoMetadata:
-				a.Out.Printf("removing stale cached launch layer '%s', not in metadata \n", cachedLayer.Identifier())
-				if err := cachedLayer.remove(); err != nil {
-					return err
-				}
-			case cacheStaleWrongSHA:
-				a.Out.Printf("removing stale cached launch layer '%s'", cachedLayer.Identifier())
-				if err := cachedLayer.remove(); err != nil {
-					return err
-				}
-			case cacheMalformed:
-				a.Out.Printf("removing malformed cached layer '%s'", cachedLayer.Identifier())
-				if err := cachedLayer.remove(); err != nil {
-					return err
-				}
-			case cacheNotForLaunch:
-				a.Out.Printf("using cached layer '%s'", cachedLayer.Identifier())
-			case cacheValid:
-				a.Out.Printf("using cached launch layer '%s'", cachedLayer.Identifier())
-				a.Out.Printf("rewriting metadata for layer '%s'", cachedLayer.Identifier())
-				if err := cachedLayer.writeMetadata(metadataLayers); err != nil {
-					return err
-				}
-			}
-		}
-
-		for lmd, data := range metadataLayers {
-			if !data.Build && !data.Cache {
-				layer := cache.newBPLayer(lmd)
-				a.Out.Printf("writing metadata for uncached layer '%s'", layer.Identifier())
-				if err := layer.writeMetadata(metadataLayers); err != nil {
-					return err
-				}
-			}
-		}
 	}
 
 	// if analyzer is running as root it needs to fix the ownership of the layers dir
@@ -82,3 +72,53 @@ func (a *Analyzer) Analyze(image image.Image) error {
 	}
 	return nil
 }
+
+func (a *Analyzer) analyzeBuildpack(data metadata.AppImageMetadata, buildpack *Buildpack) error {
+	cache, err := readBuildpackLayersDir(a.LayersDir, *buildpack)
+	if err != nil {
+		return err
+	}
+
+	metadataLayers := data.MetadataForBuildpack(buildpack.ID).Layers
+	for _, cachedLayer := range cache.layers {
+		cacheType, reason := cachedLayer.classifyCache(metadataLayers)
+		a.Logger.Debugf("layer '%s': %s", cachedLayer.Identifier(), reason)
+		switch cacheType {
+		case cacheStaleNoMetadata:
+			a.Logger.Infof("removing stale cached launch layer '%s', not in metadata \n", cachedLayer.Identifier())
+			if err := cachedLayer.remove(); err != nil {
+				return err
+			}
+		case cacheStaleWrongSHA:
+			a.Logger.Infof("removing stale cached launch layer '%s'", cachedLayer.Identifier())
+			if err := cachedLayer.remove(); err != nil {
+				return err
+			}
+		case cacheMalformed:
+			a.Logger.Infof("removing malformed cached layer '%s'", cachedLayer.Identifier())
+			if err := cachedLayer.remove(); err != nil {
+				return err
+			}
+		case cacheNotForLaunch:
+			a.Logger.Infof("using cached layer '%s'", cachedLayer.Identifier())
+		case cacheValid:
+			a.Logger.Infof("using cached launch layer '%s'", cachedLayer.Identifier())
+			a.Logger.Infof("rewriting metadata for layer '%s'", cachedLayer.Identifier())
+			if err := cachedLayer.writeMetadata(metadataLayers); err != nil {
+				return err
+			}
+		}
+	}
+
+	for lmd, data := range metadataLayers {
+		if !data.Build && !data.Cache {
+			layer := cache.newBPLayer(lmd)
+			a.Logger.Debugf("layer '%s': launch-only layer in previous image, restoring metadata so it can be reused", layer.Identifier())
+			a.Logger.Infof("writing metadata for uncached layer '%s'", layer.Identifier())
+			if err := layer.writeMetadata(metadataLayers); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -1,20 +1,23 @@
 package lifecycle_test
 
 import (
+	"archive/tar"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 
 	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/lifecycle/image"
 	h "github.com/buildpack/lifecycle/testhelpers"
 	"github.com/buildpack/lifecycle/testmock"
 )
@@ -49,8 +52,7 @@ func testAnalyzer(t *testing.T, when spec.G, it spec.S) {
 			Buildpacks: []*lifecycle.Buildpack{{ID: "metdata.buildpack"}, {ID: "no.cache.buildpack"}, {ID: "no.metadata.buildpack"}},
 			AppDir:     appDir,
 			LayersDir:  layerDir,
-			Out:        log.New(stdout, "", 0),
-			Err:        log.New(stderr, "", 0),
+			Logger:     lifecycle.NewDefaultLogger(stdout, stderr),
 			UID:        1234,
 			GID:        4321,
 		}
@@ -308,6 +310,19 @@ func testAnalyzer(t *testing.T, when spec.G, it spec.S) {
 							t.Fatalf("Found stale stale-launch.sha, it should be removed")
 						}
 					})
+
+					it("logs why the layer was removed at debug level", func() {
+						h.RecursiveCopy(t, filepath.Join("testdata", "analyzer", "cached-layers"), layerDir)
+						analyzer.Logger.(*lifecycle.DefaultLogger).DebugEnabled = true
+
+						if err := analyzer.Analyze(image); err != nil {
+							t.Fatalf("Error: %s\n", err)
+						}
+
+						if !strings.Contains(stdout.String(), "layer 'metdata.buildpack:stale-launch': cached SHA stale-node-modules-sha does not match previous image SHA new-sha") {
+							t.Fatalf("Unexpected log: %s\n", stdout)
+						}
+					})
 				})
 
 				when("there are malformed layers", func() {
@@ -528,6 +543,31 @@ func testAnalyzer(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 
+		when("the build image labels cannot be read", func() {
+			var buildImage *testmock.MockImage
+
+			it.Before(func() {
+				buildImage = testmock.NewMockImage(mockCtrl)
+				buildImage.EXPECT().Name().AnyTimes().Return("build-image-name")
+				buildImage.EXPECT().Label("io.buildpacks.stack.deprecated").Return("", errors.New("some-error"))
+				analyzer.BuildImage = buildImage
+			})
+
+			it("warns and continues", func() {
+				image.EXPECT().Found().Return(false, nil)
+
+				assertNil(t, analyzer.Analyze(image))
+				warnings := strings.Split(stderr.String(), "\n")
+				h.AssertContains(t, warnings, "Warning: could not check whether build image 'build-image-name' is deprecated: get image label 'io.buildpacks.stack.deprecated': some-error")
+			})
+
+			it("returns the error when failing on deprecated stacks", func() {
+				analyzer.FailDeprecatedStack = true
+
+				h.AssertError(t, analyzer.Analyze(image), "some-error")
+			})
+		})
+
 		when("the image label has incompatible metadata", func() {
 			it.Before(func() {
 				image.EXPECT().Found().Return(true, nil)
@@ -551,6 +591,79 @@ func testAnalyzer(t *testing.T, when spec.G, it spec.S) {
 				}
 			})
 		})
+
+	})
+
+	when("the images are in a registry", func() {
+		var (
+			registry *h.FakeRegistry
+			factory  *image.Factory
+		)
+
+		it.Before(func() {
+			registry = h.NewFakeRegistry()
+			registry.Start(t)
+			factory = &image.Factory{Keychain: authn.DefaultKeychain, Out: ioutil.Discard}
+
+			layerPath := filepath.Join(layerDir, "layer.tar")
+			f, err := os.Create(layerPath)
+			h.AssertNil(t, err)
+			tw := tar.NewWriter(f)
+			h.AssertNil(t, tw.WriteHeader(&tar.Header{Name: "some-file", Mode: 0644, Size: 4}))
+			_, err = tw.Write([]byte("data"))
+			h.AssertNil(t, err)
+			h.AssertNil(t, tw.Close())
+			h.AssertNil(t, f.Close())
+
+			for _, repo := range []string{"some-app", "some-run-image"} {
+				img := factory.NewEmptyRemote(registry.RepoName(repo))
+				h.AssertNil(t, img.AddLayer(layerPath))
+				h.AssertNil(t, img.SetLabel("io.buildpacks.lifecycle.metadata", `{
+  "buildpacks": [
+    {
+      "key": "metdata.buildpack",
+      "layers": {"valid-launch": {"sha": "some-sha", "launch": true}}
+    }
+  ]
+}`))
+				_, err := img.Save()
+				h.AssertNil(t, err)
+			}
+		})
+
+		it.After(func() {
+			registry.Stop(t)
+		})
+
+		it("does not download layers", func() {
+			previous, err := factory.NewRemote(registry.RepoName("some-app"))
+			h.AssertNil(t, err)
+			analyzer.RegistryRunImage, err = factory.NewRemote(registry.RepoName("some-run-image"))
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, analyzer.Analyze(previous))
+
+			if _, err := os.Stat(filepath.Join(layerDir, "metdata.buildpack", "valid-launch.toml")); err != nil {
+				t.Fatalf("Expected metadata of layer 'valid-launch' to be restored: %s", err)
+			}
+			configs := map[string]bool{}
+			for _, repo := range []string{"some-app", "some-run-image"} {
+				stored, ok := registry.Manifest(repo, "latest")
+				h.AssertEq(t, ok, true)
+				var manifest struct {
+					Config struct {
+						Digest string `json:"digest"`
+					} `json:"config"`
+				}
+				h.AssertNil(t, json.Unmarshal(stored.Body, &manifest))
+				configs[manifest.Config.Digest] = true
+			}
+		for _, digest := range registry.BlobReads() {
+				if !configs[digest] {
+					t.Fatalf("Expected only config blobs to be read, read '%s'", digest)
+				}
+			}
+		})
 	})
 }
 
@@ -0,0 +1,201 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// sparseBlockSize is the granularity at which runs of zeros are left as
+// holes when extracting files.
+const sparseBlockSize = 4096
+
+var zeros = make([]byte, 128*1024)
+
+type region struct {
+	offset, length int64
+}
+
+// CopyFile copies the file at from to to, letting the kernel copy the
+// contents where possible so that large files are not read into memory.
+func CopyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return copyContents(out, in, fi.Size())
+}
+
+// copyContents copies size bytes from in to out.
+func copyContents(out, in *os.File, size int64) error {
+	if _, err := sendFile(out, in, size); err != nil {
+		return err
+	}
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	_, err := io.CopyBuffer(out, in, *buf)
+	return err
+}
+
+// CloneTree recreates the tree at src under dest. Files are reflinked where
+// the filesystem supports it and copied otherwise, so changes made to files
+// in dest never reach src.
+func CloneTree(src, dest string) error {
+	return cloneTree(src, dest, cloneFile)
+}
+
+// LinkTree recreates the tree at src under dest, hardlinking files, so src
+// and dest must be on the same filesystem. Files in dest share changes made
+// in place with src, so it is only safe for trees that are never modified.
+func LinkTree(src, dest string) error {
+	return cloneTree(src, dest, func(src, dest string, _ os.FileMode) error {
+		return os.Link(src, dest)
+	})
+}
+
+func cloneTree(src, dest string, file func(src, dest string, mode os.FileMode) error) error {
+	resolvedDest, err := resolve(dest)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if rel == "." {
+			return os.MkdirAll(target, fi.Mode().Perm())
+		}
+		if err := checkResolved(filepath.Dir(target), resolvedDest); err != nil {
+			return err
+		}
+
+		switch {
+		case fi.IsDir():
+			if err := removeIfSymlink(target); err != nil {
+				return err
+			}
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := removeIfExists(target); err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case fi.Mode().IsRegular():
+			if err := removeIfExists(target); err != nil {
+				return err
+			}
+			return file(path, target, fi.Mode())
+		}
+		return nil
+	})
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// copyRegular writes size bytes of f to w. Holes in sparse files are
+// written as zeros instead of being read from disk.
+func copyRegular(w io.Writer, f *os.File, size int64) error {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	var off int64
+	for _, r := range dataRegions(f, size) {
+		if err := writeZeros(w, r.offset-off); err != nil {
+			return err
+		}
+		if _, err := f.Seek(r.offset, io.SeekStart); err != nil {
+			return err
+		}
+		n, err := io.CopyBuffer(w, io.LimitReader(f, r.length), *buf)
+		if err != nil {
+			return err
+		}
+		if n < r.length {
+			return io.ErrUnexpectedEOF
+		}
+		off = r.offset + r.length
+	}
+	return writeZeros(w, size-off)
+}
+
+func writeZeros(w io.Writer, n int64) error {
+	for n > 0 {
+		chunk := zeros
+		if n < int64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		n -= int64(len(chunk))
+	}
+	return nil
+}
+
+// writeSparse copies r to f, skipping blocks of zeros so that they are left
+// as holes on filesystems that support them. Contiguous blocks of data are
+// written at once, so that dense files take as many writes as a plain copy.
+func writeSparse(f *os.File, r io.Reader) error {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	var off int64
+	for {
+		n, err := io.ReadFull(r, *buf)
+		start := -1 // start of the run of data blocks being collected
+		for i := 0; i < n; i += sparseBlockSize {
+			end := i + sparseBlockSize
+			if end > n {
+				end = n
+			}
+			zero := bytes.Equal((*buf)[i:end], zeros[:end-i])
+			if !zero && start < 0 {
+				start = i
+			} else if zero && start >= 0 {
+				if _, err := f.WriteAt((*buf)[start:i], off+int64(start)); err != nil {
+					return err
+				}
+				start = -1
+			}
+		}
+		if start >= 0 {
+			if _, err := f.WriteAt((*buf)[start:n], off+int64(start)); err != nil {
+				return err
+			}
+		}
+		off += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return f.Truncate(off)
+}
@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+const (
+	seekData = 3          // SEEK_DATA
+	seekHole = 4          // SEEK_HOLE
+	ficlone  = 0x40049409 // FICLONE
+)
+
+// cloneFile reflinks src to dest, falling back to copying its contents if
+// the filesystem does not support reflinks.
+func cloneFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd()); errno == 0 {
+		return out.Close()
+	}
+	if err := copyContents(out, in, fi.Size()); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// sendFile copies up to size bytes from src to dst in the kernel, returning
+// how many were copied. The caller copies any remainder itself.
+func sendFile(dst, src *os.File, size int64) (int64, error) {
+	var written int64
+	for written < size {
+		chunk := size - written
+		if chunk > 1<<30 {
+			chunk = 1 << 30
+		}
+		n, err := syscall.Sendfile(int(dst.Fd()), int(src.Fd()), nil, int(chunk))
+		if err == syscall.EINTR || err == syscall.EAGAIN {
+			continue
+		}
+		if err == syscall.EINVAL || err == syscall.ENOSYS {
+			break
+		}
+		if err != nil {
+			return written, &os.PathError{Op: "sendfile", Path: src.Name(), Err: err}
+		}
+		if n == 0 {
+			break
+		}
+		written += int64(n)
+	}
+	return written, nil
+}
+
+// dataRegions returns the parts of f below size that contain data, or a
+// single region covering the whole file if f is not sparse.
+func dataRegions(f *os.File, size int64) []region {
+	whole := []region{{0, size}}
+	fi, err := f.Stat()
+	if err != nil {
+		return whole
+	}
+	if stat, ok := fi.Sys().(*syscall.Stat_t); !ok || stat.Blocks*512 >= size {
+		return whole
+	}
+
+	var regions []region
+	for off := int64(0); off < size; {
+		data, err := f.Seek(off, seekData)
+		if err != nil {
+			if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ENXIO {
+				break
+			}
+			return whole
+		}
+		if data >= size {
+			break
+		}
+		hole, err := f.Seek(data, seekHole)
+		if err != nil {
+			return whole
+		}
+		if hole > size {
+			hole = size
+		}
+		regions = append(regions, region{data, hole - data})
+		off = hole
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return whole
+	}
+	return regions
+}
+
+// changeState returns the inode number and change time of a file.
+func changeState(fi os.FileInfo) (uint64, int64) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fi.ModTime().UnixNano()
+	}
+	return stat.Ino, stat.Ctim.Nano()
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package archive
+
+import (
+	"archive/tar"
+	"os"
+)
+
+func layerName(path string) string {
+	return path
+}
+
+func writeLayerRoot(tw *tar.Writer) error {
+	return nil
+}
+
+func entryName(name string) (string, bool) {
+	return name, true
+}
+
+func setAttributes(hdr *tar.Header, fi os.FileInfo) {}
+
+func applyAttributes(path string, hdr *tar.Header) error {
+	return nil
+}
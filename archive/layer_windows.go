@@ -0,0 +1,79 @@
+package archive
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Windows layers keep files under Files/ and registry hives under Hives/,
+// with paths relative to the system volume.
+const (
+	filesDir = "Files"
+	hivesDir = "Hives"
+
+	fileAttrRecord = "MSWINDOWS.fileattr"
+)
+
+func layerName(path string) string {
+	return filesDir + filepath.ToSlash(path[len(filepath.VolumeName(path)):])
+}
+
+func writeLayerRoot(tw *tar.Writer) error {
+	for _, dir := range []string{filesDir, hivesDir} {
+		header := &tar.Header{
+			Name:     dir,
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+			ModTime:  time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryName returns the path of a layer entry relative to the volume, or
+// false for the layer root and hives, which are not extracted.
+func entryName(name string) (string, bool) {
+	name = strings.TrimSuffix(name, "/")
+	switch {
+	case name == filesDir, name == hivesDir, strings.HasPrefix(name, hivesDir+"/"):
+		return "", false
+	case strings.HasPrefix(name, filesDir+"/"):
+		return strings.TrimPrefix(name, filesDir+"/"), true
+	}
+	return name, true
+}
+
+func setAttributes(hdr *tar.Header, fi os.FileInfo) {
+	data, ok := fi.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return
+	}
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = map[string]string{}
+	}
+	hdr.PAXRecords[fileAttrRecord] = strconv.FormatUint(uint64(data.FileAttributes), 10)
+}
+
+func applyAttributes(path string, hdr *tar.Header) error {
+	value, ok := hdr.PAXRecords[fileAttrRecord]
+	if !ok {
+		return nil
+	}
+	attrs, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return err
+	}
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	return syscall.SetFileAttributes(p, uint32(attrs))
+}
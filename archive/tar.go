@@ -8,7 +8,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 func WriteTarFile(sourceDir, dest string, uid, gid int) (string, error) {
@@ -20,17 +25,168 @@ func WriteTarFile(sourceDir, dest string, uid, gid int) (string, error) {
 	defer f.Close()
 	w := io.MultiWriter(hasher, f)
 
-	if WriteTarArchive(w, sourceDir, uid, gid) != nil {
+	if err := WriteTarArchive(w, sourceDir, uid, gid); err != nil {
 		return "", err
 	}
 	sha := hex.EncodeToString(hasher.Sum(make([]byte, 0, hasher.Size())))
 	return "sha256:" + sha, nil
 }
 
+// TarDigest returns the SHA-256 digest and size of the tar that
+// WriteTarArchive writes for sourceDir, without writing it anywhere.
+func TarDigest(sourceDir string, uid, gid int) (string, int64, error) {
+	hasher := sha256.New()
+	w := &countingWriter{w: hasher}
+	if err := WriteTarArchive(w, sourceDir, uid, gid); err != nil {
+		return "", 0, err
+	}
+	sha := hex.EncodeToString(hasher.Sum(make([]byte, 0, hasher.Size())))
+	return "sha256:" + sha, w.n, nil
+}
+
+// TarOpener returns a function that streams a new tar of sourceDir each time
+// it is called, so that a layer can be hashed, compressed and uploaded
+// without an intermediate file.
+func TarOpener(sourceDir string, uid, gid int) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(WriteTarArchive(pw, sourceDir, uid, gid))
+		}()
+		return pr, nil
+	}
+}
+
+// Digest is the SHA-256 digest and size of a tar written by WriteTarArchive.
+type Digest struct {
+	SHA  string
+	Size int64
+}
+
+// TarDigests returns the digests of the tars of sourceDirs, in order, hashing
+// up to workers of them concurrently. Workers defaults to the number of CPUs.
+func TarDigests(sourceDirs []string, uid, gid, workers int) ([]Digest, error) {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	digests := make([]Digest, len(sourceDirs))
+	errs := make([]error, len(sourceDirs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(sourceDirs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				digests[i].SHA, digests[i].Size, errs[i] = TarDigest(sourceDirs[i], uid, gid)
+			}
+		}()
+	}
+	for i := range sourceDirs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, errors.Wrapf(err, "hash '%s'", sourceDirs[i])
+		}
+	}
+	return digests, nil
+}
+
+// ChangeFingerprint returns a hash of the names, modes, sizes, inode numbers
+// and change times of the files in sourceDir. Change times cannot be set by
+// programs, so the fingerprint changes whenever a file is modified, even if
+// its size and modification time are preserved.
+func ChangeFingerprint(sourceDir string) (string, error) {
+	hasher := sha256.New()
+	err := filepath.Walk(sourceDir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		inode, ctime := changeState(fi)
+		fmt.Fprintf(hasher, "%q %o %d %d %d\n", file, fi.Mode(), fi.Size(), inode, ctime)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// ContentDigest returns a SHA-256 digest of the names, permissions, link
+// targets and contents of the files in sourceDir. Unlike TarDigest, it does
+// not depend on where sourceDir is located, on ownership, or on
+// modification times.
+func ContentDigest(sourceDir string) (string, error) {
+	hasher := sha256.New()
+	err := filepath.Walk(sourceDir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(sourceDir, file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hasher, "%q %o ", filepath.ToSlash(rel), fi.Mode())
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hasher, "%q\n", target)
+		case fi.Mode().IsRegular():
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			fileHasher := sha256.New()
+			_, err = io.Copy(fileHasher, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hasher, "%x\n", fileHasher.Sum(nil))
+		default:
+			fmt.Fprintln(hasher)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// copyBuffers are reused to copy file contents into tars, since hashing many
+// layers concurrently would otherwise allocate a buffer per file.
+var copyBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 128*1024)
+		return &b
+	},
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 func WriteTarArchive(w io.Writer, srcDir string, uid, gid int) error {
 	tw := tar.NewWriter(w)
 	defer tw.Close()
 
+	if err := writeLayerRoot(tw); err != nil {
+		return err
+	}
 	err := writeParentDirectoryHeaders(srcDir, tw, uid, gid)
 	if err != nil {
 		return err
@@ -56,12 +212,13 @@ func WriteTarArchive(w io.Writer, srcDir string, uid, gid int) error {
 				return err
 			}
 		}
-		header.Name = file
+		header.Name = layerName(file)
 		header.ModTime = time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC)
 		header.Uid = uid
 		header.Gid = gid
 		header.Uname = ""
 		header.Gname = ""
+		setAttributes(header, fi)
 
 		if err := tw.WriteHeader(header); err != nil {
 			return err
@@ -72,7 +229,7 @@ func WriteTarArchive(w io.Writer, srcDir string, uid, gid int) error {
 				return err
 			}
 			defer f.Close()
-			if _, err := io.Copy(tw, f); err != nil {
+			if err := copyRegular(tw, f, header.Size); err != nil {
 				return err
 			}
 		}
@@ -82,7 +239,7 @@ func WriteTarArchive(w io.Writer, srcDir string, uid, gid int) error {
 
 func writeParentDirectoryHeaders(tarDir string, tw *tar.Writer, uid int, gid int) error {
 	parent := filepath.Dir(tarDir)
-	if parent == "." || parent == "/" {
+	if parent == filepath.Dir(parent) {
 		return nil
 	} else {
 		if err := writeParentDirectoryHeaders(parent, tw, uid, gid); err != nil {
@@ -98,7 +255,7 @@ func writeParentDirectoryHeaders(tarDir string, tw *tar.Writer, uid int, gid int
 		if err != nil {
 			return err
 		}
-		header.Name = parent
+		header.Name = layerName(parent)
 		header.ModTime = time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC)
 
 		if err := tw.WriteHeader(header); err != nil {
@@ -117,6 +274,16 @@ func AddTextToTar(tw *tar.Writer, name string, contents []byte) error {
 	return err
 }
 
+// AddReaderToTar adds size bytes read from contents to tw as name.
+func AddReaderToTar(tw *tar.Writer, name string, size int64, contents io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, contents)
+	return err
+}
+
 func AddFileToTar(tw *tar.Writer, name string, contents *os.File) error {
 	fi, err := contents.Stat()
 	if err != nil {
@@ -130,7 +297,34 @@ func AddFileToTar(tw *tar.Writer, name string, contents *os.File) error {
 	return err
 }
 
+// Untar extracts r into dest. Entries that would be written outside of
+// dest, through ".." elements or symlinks, are rejected.
 func Untar(r io.Reader, dest string) error {
+	return UntarWithin(r, dest, dest)
+}
+
+// UntarWithin extracts r into dest, rejecting entries that would be written
+// outside of root, which must be dest or a directory under it. Directory
+// entries for the parents of root are created, so that tars of a directory
+// written by WriteTarArchive can be extracted. Symlinks may point anywhere,
+// but entries are never written through them, and existing symlinks are
+// replaced rather than followed.
+func UntarWithin(r io.Reader, dest, root string) error {
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return err
+	}
+	if !within(root, dest) {
+		return fmt.Errorf("'%s' is not within '%s'", root, dest)
+	}
+	resolvedRoot, err := resolve(root)
+	if err != nil {
+		return err
+	}
+
 	tr := tar.NewReader(r)
 	for {
 		hdr, err := tr.Next()
@@ -142,31 +336,62 @@ func Untar(r io.Reader, dest string) error {
 			return err
 		}
 
-		path := filepath.Join(dest, hdr.Name)
+		name, ok := entryName(hdr.Name)
+		if !ok {
+			continue
+		}
+		path := filepath.Join(dest, name)
+		if hdr.Typeflag == tar.TypeDir && within(root, path) {
+			if err := os.MkdirAll(path, hdr.FileInfo().Mode()); err != nil {
+				return err
+			}
+			continue
+		}
+		if path == root || !within(path, root) {
+			return fmt.Errorf("tar entry '%s' is outside of '%s'", hdr.Name, root)
+		}
+		if err := checkResolved(filepath.Dir(path), resolvedRoot); err != nil {
+			return errors.Wrapf(err, "tar entry '%s'", hdr.Name)
+		}
 
 		switch hdr.Typeflag {
 		case tar.TypeDir:
+			if err := removeIfSymlink(path); err != nil {
+				return err
+			}
 			if err := os.MkdirAll(path, hdr.FileInfo().Mode()); err != nil {
 				return err
 			}
-		case tar.TypeReg, tar.TypeRegA:
+			if err := applyAttributes(path, hdr); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
 			_, err := os.Stat(filepath.Dir(path))
 			if os.IsNotExist(err) {
 				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 					return err
 				}
 			}
+			if err := removeIfSymlink(path); err != nil {
+				return err
+			}
 
-			fh, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, hdr.FileInfo().Mode())
+			fh, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode())
 			if err != nil {
 				return err
 			}
-			if _, err := io.Copy(fh, tr); err != nil {
+			if err := writeSparse(fh, tr); err != nil {
 				fh.Close()
 				return err
 			}
 			fh.Close()
+			if err := applyAttributes(path, hdr); err != nil {
+				return err
+			}
 		case tar.TypeSymlink:
+			if err := removeIfSymlink(path); err != nil {
+				return err
+			}
 			if err := os.Symlink(hdr.Linkname, path); err != nil {
 				return err
 			}
@@ -175,3 +400,55 @@ func Untar(r io.Reader, dest string) error {
 		}
 	}
 }
+
+// within reports whether path is root or a path under it.
+func within(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolve evaluates the symlinks in the longest existing prefix of path.
+func resolve(path string) (string, error) {
+	existing := path
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return path, nil
+		}
+		existing = parent
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(existing, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, rel), nil
+}
+
+// checkResolved fails if dir resolves outside of resolvedRoot because one of
+// its existing elements is a symlink.
+func checkResolved(dir, resolvedRoot string) error {
+	resolved, err := resolve(dir)
+	if err != nil {
+		return err
+	}
+	if !within(resolved, resolvedRoot) {
+		return fmt.Errorf("'%s' resolves to '%s', outside of '%s'", dir, resolved, resolvedRoot)
+	}
+	return nil
+}
+
+func removeIfSymlink(path string) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(path)
+	}
+	return nil
+}
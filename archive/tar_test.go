@@ -2,10 +2,16 @@ package archive_test
 
 import (
 	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 
@@ -199,6 +205,402 @@ func testTar(t *testing.T, when spec.G, it spec.S) {
 			}
 		})
 	})
+
+	when("#TarOpener", func() {
+		it("streams the tar that #TarDigest hashes", func() {
+			src = filepath.Join("testdata", "dir-to-tar")
+
+			sha, size, err := archive.TarDigest(src, uid, gid)
+			h.AssertNil(t, err)
+
+			for i := 0; i < 2; i++ {
+				rc, err := archive.TarOpener(src, uid, gid)()
+				h.AssertNil(t, err)
+				hasher := sha256.New()
+				n, err := io.Copy(hasher, rc)
+				h.AssertNil(t, err)
+				h.AssertNil(t, rc.Close())
+
+				h.AssertEq(t, n, size)
+				h.AssertEq(t, "sha256:"+hex.EncodeToString(hasher.Sum(nil)), sha)
+			}
+		})
+	})
+
+	when("#ContentDigest", func() {
+		it("depends on contents but not on location or modification times", func() {
+			dir, err := ioutil.TempDir("", "content-digest-test")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(dir)
+			for _, name := range []string{"a", "b"} {
+				h.AssertNil(t, os.MkdirAll(filepath.Join(dir, name, "bin"), 0777))
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(dir, name, "bin", "build"), []byte("some-script"), 0755))
+			}
+			h.AssertNil(t, os.Chtimes(filepath.Join(dir, "b", "bin", "build"), time.Now(), time.Now().Add(time.Hour)))
+
+			digestA, err := archive.ContentDigest(filepath.Join(dir, "a"))
+			h.AssertNil(t, err)
+			digestB, err := archive.ContentDigest(filepath.Join(dir, "b"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, digestA, digestB)
+
+			h.AssertNil(t, ioutil.WriteFile(filepath.Join(dir, "b", "bin", "build"), []byte("other-script"), 0755))
+			digestB, err = archive.ContentDigest(filepath.Join(dir, "b"))
+			h.AssertNil(t, err)
+			if digestA == digestB {
+				t.Fatalf("Expected digest to change")
+			}
+		})
+	})
+
+	when("sparse files", func() {
+		it("round trips the contents and leaves holes when extracting", func() {
+			dir, err := ioutil.TempDir("", "sparse-test")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(dir)
+			src := filepath.Join(dir, "src")
+			h.AssertNil(t, os.Mkdir(src, 0777))
+			path := filepath.Join(src, "some-file")
+			f, err := os.Create(path)
+			h.AssertNil(t, err)
+			h.AssertNil(t, f.Truncate(4<<20))
+			_, err = f.WriteAt([]byte("some-data"), 2<<20)
+			h.AssertNil(t, err)
+			h.AssertNil(t, f.Close())
+
+			pr, pw := io.Pipe()
+			go func() { pw.CloseWithError(archive.WriteTarArchive(pw, src, uid, gid)) }()
+			dest := filepath.Join(dir, "dest")
+			h.AssertNil(t, archive.Untar(pr, dest))
+
+			expected, err := ioutil.ReadFile(path)
+			h.AssertNil(t, err)
+			extracted := filepath.Join(dest, path)
+			actual, err := ioutil.ReadFile(extracted)
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(actual), 4<<20)
+			if !bytes.Equal(actual, expected) {
+				t.Fatalf("Extracted contents differ")
+			}
+
+			fi, err := os.Stat(extracted)
+			h.AssertNil(t, err)
+			if blocks := fi.Sys().(*syscall.Stat_t).Blocks; blocks*512 >= fi.Size() {
+				t.Fatalf("Expected extracted file to be sparse: %d blocks", blocks)
+			}
+		})
+
+		it("round trips files that mix runs of data and zeros", func() {
+			dir, err := ioutil.TempDir("", "sparse-test")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(dir)
+			src := filepath.Join(dir, "src")
+			h.AssertNil(t, os.Mkdir(src, 0777))
+			contents := make([]byte, 1<<20+123)
+			rand.Read(contents)
+			for _, r := range [][2]int{{0, 8192}, {10000, 20000}, {131000, 270000}, {1 << 19, 1<<19 + 4096}} {
+				copy(contents[r[0]:r[1]], make([]byte, r[1]-r[0]))
+			}
+			path := filepath.Join(src, "some-file")
+			h.AssertNil(t, ioutil.WriteFile(path, contents, 0666))
+
+			pr, pw := io.Pipe()
+			go func() { pw.CloseWithError(archive.WriteTarArchive(pw, src, uid, gid)) }()
+			dest := filepath.Join(dir, "dest")
+			h.AssertNil(t, archive.Untar(pr, dest))
+
+			actual, err := ioutil.ReadFile(filepath.Join(dest, path))
+			h.AssertNil(t, err)
+			if !bytes.Equal(actual, contents) {
+				t.Fatalf("Extracted contents differ")
+			}
+		})
+	})
+
+	when("#CopyFile", func() {
+		it("copies the file contents", func() {
+			dir, err := ioutil.TempDir("", "copy-test")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(dir)
+			contents := make([]byte, 1<<20)
+			rand.Read(contents)
+			from := filepath.Join(dir, "from")
+			h.AssertNil(t, ioutil.WriteFile(from, contents, 0666))
+
+			to := filepath.Join(dir, "to")
+			h.AssertNil(t, archive.CopyFile(from, to))
+			actual, err := ioutil.ReadFile(to)
+			h.AssertNil(t, err)
+			if !bytes.Equal(actual, contents) {
+				t.Fatalf("Copied contents differ")
+			}
+		})
+	})
+
+	when("#CloneTree", func() {
+		it("recreates files, directories and symlinks under dest", func() {
+			dir, err := ioutil.TempDir("", "clone-test")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(dir)
+			src := filepath.Join(dir, "src")
+			h.AssertNil(t, os.MkdirAll(filepath.Join(src, "some-dir"), 0755))
+			h.AssertNil(t, ioutil.WriteFile(filepath.Join(src, "some-dir", "some-file"), []byte("some-data"), 0644))
+			h.AssertNil(t, os.Symlink("some-dir/some-file", filepath.Join(src, "some-link")))
+
+			dest := filepath.Join(dir, "dest")
+			h.AssertNil(t, os.MkdirAll(dest, 0755))
+			h.AssertNil(t, ioutil.WriteFile(filepath.Join(dest, "some-link"), []byte("old-data"), 0644))
+			h.AssertNil(t, archive.CloneTree(src, dest))
+
+			contents, err := ioutil.ReadFile(filepath.Join(dest, "some-link"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "some-data")
+			fi, err := os.Stat(filepath.Join(dest, "some-dir", "some-file"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, fi.Mode().Perm(), os.FileMode(0644))
+		})
+
+		it("does not share changes to files in dest with src", func() {
+			dir, err := ioutil.TempDir("", "clone-test")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(dir)
+			src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+			h.AssertNil(t, os.MkdirAll(src, 0755))
+			h.AssertNil(t, ioutil.WriteFile(filepath.Join(src, "some-file"), []byte("some-data"), 0644))
+
+			h.AssertNil(t, archive.CloneTree(src, dest))
+			h.AssertNil(t, ioutil.WriteFile(filepath.Join(dest, "some-file"), []byte("new-data"), 0644))
+
+			contents, err := ioutil.ReadFile(filepath.Join(src, "some-file"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "some-data")
+		})
+
+		it("does not write through symlinks in dest", func() {
+			dir, err := ioutil.TempDir("", "clone-test")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(dir)
+			src, dest, outside := filepath.Join(dir, "src"), filepath.Join(dir, "dest"), filepath.Join(dir, "outside")
+			h.AssertNil(t, os.MkdirAll(filepath.Join(src, "some-dir"), 0755))
+			h.AssertNil(t, ioutil.WriteFile(filepath.Join(src, "some-dir", "some-file"), []byte("some-data"), 0644))
+			h.AssertNil(t, os.MkdirAll(dest, 0755))
+			h.AssertNil(t, os.MkdirAll(outside, 0755))
+			h.AssertNil(t, os.Symlink(outside, filepath.Join(dest, "some-dir")))
+
+			h.AssertNil(t, archive.CloneTree(src, dest))
+			if _, err := os.Stat(filepath.Join(outside, "some-file")); !os.IsNotExist(err) {
+				t.Fatalf("Expected nothing to be written outside of dest")
+			}
+			contents, err := ioutil.ReadFile(filepath.Join(dest, "some-dir", "some-file"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "some-data")
+		})
+	})
+
+	when("#Untar", func() {
+		var dir, dest, outside string
+
+		it.Before(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "untar-test")
+			h.AssertNil(t, err)
+			dest = filepath.Join(dir, "dest")
+			outside = filepath.Join(dir, "outside")
+			h.AssertNil(t, os.MkdirAll(dest, 0755))
+			h.AssertNil(t, os.MkdirAll(outside, 0755))
+			h.AssertNil(t, ioutil.WriteFile(filepath.Join(outside, "some-file"), []byte("original"), 0644))
+		})
+
+		it.After(func() {
+			os.RemoveAll(dir)
+		})
+
+		it("extracts absolute paths under dest", func() {
+			h.AssertNil(t, archive.Untar(hostileTar(t,
+				&tar.Header{Name: "/some-dir", Typeflag: tar.TypeDir, Mode: 0755},
+				&tar.Header{Name: "/some-dir/some-file", Typeflag: tar.TypeReg, Mode: 0644},
+			), dest))
+			_, err := os.Stat(filepath.Join(dest, "some-dir", "some-file"))
+			h.AssertNil(t, err)
+		})
+
+		it("rejects entries that escape dest with '..'", func() {
+			err := archive.Untar(hostileTar(t,
+				&tar.Header{Name: "../outside/other-file", Typeflag: tar.TypeReg, Mode: 0644},
+			), dest)
+			h.AssertError(t, err, "is outside of")
+			if _, err := os.Stat(filepath.Join(outside, "other-file")); !os.IsNotExist(err) {
+				t.Fatalf("Expected nothing to be written outside of dest")
+			}
+		})
+
+		it("rejects entries written through symlinks", func() {
+			err := archive.Untar(hostileTar(t,
+				&tar.Header{Name: "some-link", Typeflag: tar.TypeSymlink, Linkname: outside},
+				&tar.Header{Name: "some-link/other-file", Typeflag: tar.TypeReg, Mode: 0644},
+			), dest)
+			h.AssertError(t, err, "outside of")
+			if _, err := os.Stat(filepath.Join(outside, "other-file")); !os.IsNotExist(err) {
+				t.Fatalf("Expected nothing to be written outside of dest")
+			}
+		})
+
+		it("replaces symlinks instead of following them", func() {
+			h.AssertNil(t, archive.Untar(hostileTar(t,
+				&tar.Header{Name: "some-link", Typeflag: tar.TypeSymlink, Linkname: filepath.Join(outside, "some-file")},
+				&tar.Header{Name: "some-link", Typeflag: tar.TypeReg, Mode: 0644},
+			), dest))
+			contents, err := ioutil.ReadFile(filepath.Join(outside, "some-file"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "original")
+		})
+
+		when("#UntarWithin", func() {
+			it("creates the parents of root but rejects other entries outside of it", func() {
+				root := filepath.Join(dest, "layers", "some-layer")
+				h.AssertNil(t, archive.UntarWithin(hostileTar(t,
+					&tar.Header{Name: "layers", Typeflag: tar.TypeDir, Mode: 0755},
+					&tar.Header{Name: "layers/some-layer", Typeflag: tar.TypeDir, Mode: 0755},
+					&tar.Header{Name: "layers/some-layer/some-file", Typeflag: tar.TypeReg, Mode: 0644},
+				), dest, root))
+				_, err := os.Stat(filepath.Join(root, "some-file"))
+				h.AssertNil(t, err)
+
+				err = archive.UntarWithin(hostileTar(t,
+					&tar.Header{Name: "layers/other-file", Typeflag: tar.TypeReg, Mode: 0644},
+				), dest, root)
+				h.AssertError(t, err, "is outside of")
+			})
+		})
+	})
+
+	when("#TarDigests", func() {
+		it("returns the digest of each directory in order", func() {
+			dirs := []string{filepath.Join("testdata", "dir-to-tar"), filepath.Join("testdata", "dir-to-tar", "sub-dir")}
+
+			digests, err := archive.TarDigests(dirs, uid, gid, 2)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, len(digests), 2)
+			for i, dir := range dirs {
+				sha, size, err := archive.TarDigest(dir, uid, gid)
+				h.AssertNil(t, err)
+				h.AssertEq(t, digests[i], archive.Digest{SHA: sha, Size: size})
+			}
+		})
+
+		it("fails if any directory cannot be hashed", func() {
+			_, err := archive.TarDigests([]string{filepath.Join("testdata", "dir-to-tar"), "does-not-exist"}, uid, gid, 0)
+			h.AssertError(t, err, "hash 'does-not-exist'")
+		})
+	})
+}
+
+func BenchmarkTarDigests(b *testing.B) {
+	dir, err := ioutil.TempDir("", "tar-digests-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var layers []string
+	data := make([]byte, 4*1024*1024)
+	rand.Read(data)
+	for i := 0; i < 8; i++ {
+		layer := filepath.Join(dir, fmt.Sprintf("layer%d", i))
+		if err := os.Mkdir(layer, 0777); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < 4; j++ {
+			if err := ioutil.WriteFile(filepath.Join(layer, fmt.Sprintf("file%d", j)), data, 0666); err != nil {
+				b.Fatal(err)
+			}
+		}
+		layers = append(layers, layer)
+	}
+
+	for _, workers := range []int{1, 0} {
+		name := "serial"
+		if workers == 0 {
+			name = "concurrent"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := archive.TarDigests(layers, 1234, 2345, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWriteTarArchive(b *testing.B) {
+	dir, err := ioutil.TempDir("", "write-tar-archive-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, set := range h.LayerSets {
+		layer := filepath.Join(dir, set.Name)
+		set.Write(b, layer)
+		b.Run(set.Name, func(b *testing.B) {
+			b.SetBytes(set.Bytes())
+			for i := 0; i < b.N; i++ {
+				if err := archive.WriteTarArchive(ioutil.Discard, layer, 1234, 2345); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDigests(b *testing.B) {
+	dir, err := ioutil.TempDir("", "digests-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, set := range h.LayerSets {
+		layer := filepath.Join(dir, set.Name)
+		set.Write(b, layer)
+		b.Run(set.Name+"/tar", func(b *testing.B) {
+			b.SetBytes(set.Bytes())
+			for i := 0; i < b.N; i++ {
+				if _, _, err := archive.TarDigest(layer, 1234, 2345); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(set.Name+"/content", func(b *testing.B) {
+			b.SetBytes(set.Bytes())
+			for i := 0; i < b.N; i++ {
+				if _, err := archive.ContentDigest(layer); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// hostileTar returns a tar of the given headers, with "some-data" as the
+// contents of regular files.
+func hostileTar(t *testing.T, headers ...*tar.Header) io.Reader {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len("some-data"))
+		}
+		h.AssertNil(t, tw.WriteHeader(hdr))
+		if hdr.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte("some-data"))
+			h.AssertNil(t, err)
+		}
+	}
+	h.AssertNil(t, tw.Close())
+	return buf
 }
 
 func tarContains(t *testing.T, m string, r func()) {
@@ -0,0 +1,75 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/buildpack/lifecycle/metadata"
+)
+
+// planBOM converts build plan entries to BOM entries attributed to
+// buildpackID. The version is taken from the entry's "version" key.
+func planBOM(plan Plan, buildpackID string) []metadata.BOMEntry {
+	var bom []metadata.BOMEntry
+	for name, md := range plan {
+		if len(md) == 0 {
+			continue
+		}
+		version, _ := md["version"].(string)
+		bom = append(bom, metadata.BOMEntry{Name: name, Version: version, Buildpack: buildpackID, Metadata: md})
+	}
+	return bom
+}
+
+// WriteBOM writes bom to path as a CycloneDX document if it has a .json
+// extension, or as a bom.toml file otherwise.
+func WriteBOM(path string, bom []metadata.BOMEntry) error {
+	if !isJSON(path) {
+		return WriteTOML(path, struct {
+			BOM []metadata.BOMEntry `toml:"bom"`
+		}{bom})
+	}
+	b, err := json.MarshalIndent(cycloneDX(bom), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0666)
+}
+
+const cycloneDXMediaType = "application/vnd.cyclonedx+json"
+
+type cycloneDXBOM struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func cycloneDX(bom []metadata.BOMEntry) cycloneDXBOM {
+	out := cycloneDXBOM{BOMFormat: "CycloneDX", SpecVersion: "1.3", Version: 1, Components: []cycloneDXComponent{}}
+	for _, entry := range bom {
+		c := cycloneDXComponent{Type: "library", Name: entry.Name, Version: entry.Version}
+		if entry.Buildpack != "" {
+			c.Properties = append(c.Properties, cycloneDXProperty{Name: metadata.LabelName(metadata.DefaultLabelPrefix + ".buildpack"), Value: entry.Buildpack})
+		}
+		out.Components = append(out.Components, c)
+	}
+	return out
+}
@@ -2,14 +2,20 @@ package lifecycle
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
 type Builder struct {
@@ -19,7 +25,14 @@ type Builder struct {
 	Env         BuildEnv
 	Buildpacks  []*Buildpack
 	Plan        Plan
+	StackID     string // provided to buildpacks as CNB_STACK_ID when set
+	UID, GID    int    // buildpacks run as this user when the builder runs as root
+	Secrets     *Secrets
 	Out, Err    io.Writer
+	Tracer      *telemetry.Tracer
+	Events      Events
+	LogPrefix   string
+	Context     context.Context // buildpacks are stopped when it is done
 }
 
 type BuildEnv interface {
@@ -40,9 +53,9 @@ type LaunchTOML struct {
 type Plan map[string]map[string]interface{}
 
 type BuildMetadata struct {
-	Processes  []Process `toml:"processes"`
-	Buildpacks []string  `toml:"buildpacks"`
-	BOM        Plan      `toml:"bom"`
+	Processes  []Process           `toml:"processes"`
+	Buildpacks []string            `toml:"buildpacks"`
+	BOM        []metadata.BOMEntry `toml:"bom"`
 }
 
 func (b *Builder) Build() (*BuildMetadata, error) {
@@ -66,7 +79,7 @@ func (b *Builder) Build() (*BuildMetadata, error) {
 
 	procMap := processMap{}
 	plan := copyPlan(b.Plan)
-	bom := copyPlan(b.Plan)
+	bom := planBOM(b.Plan, "")
 	var buildpackIDs []string
 	for _, bp := range b.Buildpacks {
 		bpDirName := bp.EscapedID()
@@ -84,6 +97,9 @@ func (b *Builder) Build() (*BuildMetadata, error) {
 		if ioutil.WriteFile(bpPlanPath, nil, 0777); err != nil {
 			return nil, err
 		}
+		if err := b.chown(bpLayersDir, planDir, bpPlanDir, bpPlanPath); err != nil {
+			return nil, err
+		}
 		planIn := &bytes.Buffer{}
 		if err := toml.NewEncoder(planIn).Encode(plan); err != nil {
 			return nil, err
@@ -92,24 +108,46 @@ func (b *Builder) Build() (*BuildMetadata, error) {
 		if err != nil {
 			return nil, err
 		}
-		cmd := exec.Command(buildPath, bpLayersDir, platformDir, bpPlanPath)
-		cmd.Env = b.Env.List()
+		bpEnv, err := buildpackEnv(bp, platformDir, b.StackID)
+		if err != nil {
+			return nil, err
+		}
+		secretEnv, err := b.Secrets.env()
+		if err != nil {
+			return nil, errors.Wrap(err, "read secrets")
+		}
+		bpEnv = append(bpEnv, secretEnv...)
+		cmd := command(b.Context, buildPath, bpLayersDir, platformDir, bpPlanPath)
+		cmd.Env = append(b.Env.List(), bpEnv...)
 		cmd.Dir = appDir
 		cmd.Stdin = planIn
-		cmd.Stdout = b.Out
-		cmd.Stderr = b.Err
-		if err := cmd.Run(); err != nil {
-			return nil, err
+		cmd.Stdout = prefixLines(b.Out, bp.LogPrefix(b.LogPrefix))
+		cmd.Stderr = prefixLines(b.Err, bp.LogPrefix(b.LogPrefix))
+		if b.dropPrivileges() {
+			cmd.SysProcAttr = userProcAttr(b.UID, b.GID)
+		}
+		span := b.Tracer.Start("build").SetAttribute("buildpack.id", bp.ID).SetAttribute("buildpack.version", bp.Version)
+		events := eventsOrNop(b.Events)
+		events.OnBuildpackStarted(BuildpackEvent{Phase: "build", Buildpack: *bp})
+		start := time.Now()
+		err = cmd.Run()
+		events.OnBuildpackFinished(BuildpackEvent{Phase: "build", Buildpack: *bp, Duration: time.Since(start), Err: err})
+		span.SetError(err)
+		span.Finish()
+		if err != nil {
+			return nil, &BuildpackError{ID: bp.ID, Err: err}
 		}
 		if err := setupEnv(b.Env, bpLayersDir); err != nil {
 			return nil, err
 		}
-		if err := consumePlan(bpPlanPath, plan, bom); err != nil {
+		consumed, err := consumePlan(bpPlanPath, plan)
+		if err != nil {
 			return nil, err
 		}
+		bom = metadata.MergeBOM(bom, planBOM(consumed, bp.ID))
 		var launch LaunchTOML
 		tomlPath := filepath.Join(bpLayersDir, "launch.toml")
-		if _, err := toml.DecodeFile(tomlPath, &launch); os.IsNotExist(err) {
+		if err := DecodeFile(tomlPath, &launch); os.IsNotExist(err) {
 			continue
 		} else if err != nil {
 			return nil, err
@@ -117,17 +155,48 @@ func (b *Builder) Build() (*BuildMetadata, error) {
 		procMap.add(launch.Processes)
 	}
 
+	removed, err := b.Secrets.Scrub(layersDir, appDir)
+	for _, path := range removed {
+		fmt.Fprintf(b.Err, "Warning: removed '%s', which contains a build secret\n", path)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "scrub secrets")
+	}
+
 	return &BuildMetadata{
 		Processes:  procMap.list(),
 		Buildpacks: buildpackIDs,
-		BOM:        bom,
+		BOM:        metadata.MergeBOM(bom),
 	}, nil
 }
 
+// dropPrivileges reports whether buildpacks must run as the build user
+// because the builder itself runs as root.
+func (b *Builder) dropPrivileges() bool {
+	return os.Getuid() == 0 && b.UID > 0
+}
+
+// chown gives the build user ownership of the layers and plan files that a
+// buildpack must write, including layers restored as root.
+func (b *Builder) chown(bpLayersDir string, planPaths ...string) error {
+	if !b.dropPrivileges() {
+		return nil
+	}
+	if err := recursiveChown(bpLayersDir, b.UID, b.GID); err != nil {
+		return errors.Wrapf(err, "chowning '%s' to '%d/%d'", bpLayersDir, b.UID, b.GID)
+	}
+	for _, path := range planPaths {
+		if err := os.Chown(path, b.UID, b.GID); err != nil {
+			return errors.Wrapf(err, "chowning '%s' to '%d/%d'", path, b.UID, b.GID)
+		}
+	}
+	return nil
+}
+
 func setupEnv(env BuildEnv, layersDir string) error {
 	if err := eachDir(layersDir, func(path string) error {
-		if !isBuild(path + ".toml") {
-			return nil
+		if build, err := isBuild(path + ".toml"); err != nil || !build {
+			return err
 		}
 		return env.AddRootDir(path)
 	}); err != nil {
@@ -135,8 +204,8 @@ func setupEnv(env BuildEnv, layersDir string) error {
 	}
 
 	return eachDir(layersDir, func(path string) error {
-		if !isBuild(path + ".toml") {
-			return nil
+		if build, err := isBuild(path + ".toml"); err != nil || !build {
+			return err
 		}
 		if err := env.AddEnvDir(filepath.Join(path, "env")); err != nil {
 			return err
@@ -145,26 +214,27 @@ func setupEnv(env BuildEnv, layersDir string) error {
 	})
 }
 
-func isBuild(path string) bool {
-	var layerTOML struct {
-		Build bool `toml:"build"`
+// isBuild reports whether the layer described by the file at path is a build
+// layer. Layers without the file are not.
+func isBuild(path string) (bool, error) {
+	var layerTOML metadata.LayerMetadata
+	if err := DecodeFile(path, &layerTOML, "metadata"); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
-	_, err := toml.DecodeFile(path, &layerTOML)
-	return err == nil && layerTOML.Build
+	return layerTOML.Build, nil
 }
 
-func consumePlan(path string, plan, bom Plan) error {
-	var input map[string]map[string]interface{}
-	if _, err := toml.DecodeFile(path, &input); err != nil {
-		return err
+func consumePlan(path string, plan Plan) (Plan, error) {
+	var input Plan
+	if err := DecodeFile(path, &input); err != nil {
+		return nil, err
 	}
-	for k, v := range input {
+	for k := range input {
 		delete(plan, k)
-		if len(v) > 0 {
-			bom[k] = v
-		}
 	}
-	return nil
+	return input, nil
 }
 
 type processMap map[string]Process
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package lifecycle
+
+import (
+	"os"
+	"syscall"
+)
+
+func userProcAttr(uid, gid int) *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		// an empty group list clears supplementary groups before setgid and setuid
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}},
+	}
+}
+
+// stopProcess asks p to exit, giving it a chance to clean up.
+func stopProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
@@ -19,6 +19,8 @@ import (
 	"github.com/sclevine/spec/report"
 
 	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/lifecycle/metadata"
+	h "github.com/buildpack/lifecycle/testhelpers"
 	"github.com/buildpack/lifecycle/testmock"
 )
 
@@ -133,24 +135,24 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 			})
 
 			it("should return build metadata when processes are present", func() {
-				metadata, err := builder.Build()
+				buildMD, err := builder.Build()
 				if err != nil {
 					t.Fatalf("Error: %s\n", err)
 				}
-				if s := cmp.Diff(metadata, &lifecycle.BuildMetadata{
+				if s := cmp.Diff(buildMD, &lifecycle.BuildMetadata{
 					Processes: []lifecycle.Process{
 						{Type: "override-type", Command: "process2-command"},
 						{Type: "process1-type", Command: "process1-command"},
 						{Type: "process2-type", Command: "process2-command"},
 					},
 					Buildpacks: []string{"buildpack1-id", "buildpack2-id"},
-					BOM: lifecycle.Plan{
-						"dep1":         {"v": "1"},
-						"dep1-keep":    {"v": "2"},
-						"dep1-replace": {"replace": true},
-						"dep2":         {"v": "4"},
-						"dep2-keep":    {"v": "5"},
-						"dep2-replace": {"replace": true},
+					BOM: []metadata.BOMEntry{
+						{Name: "dep1", Metadata: map[string]interface{}{"v": "1"}},
+						{Name: "dep1-keep", Metadata: map[string]interface{}{"v": "2"}},
+						{Name: "dep1-replace", Buildpack: "buildpack1-id", Metadata: map[string]interface{}{"replace": true}},
+						{Name: "dep2", Metadata: map[string]interface{}{"v": "4"}},
+						{Name: "dep2-keep", Metadata: map[string]interface{}{"v": "5"}},
+						{Name: "dep2-replace", Buildpack: "buildpack2-id", Metadata: map[string]interface{}{"replace": true}},
 					},
 				}); s != "" {
 					t.Fatalf("Unexpected metadata:\n%s\n", s)
@@ -159,20 +161,20 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 
 			it("should return build metadata when processes are not present", func() {
 				mkfile(t, "test", filepath.Join(appDir, "skip-processes"))
-				metadata, err := builder.Build()
+				buildMD, err := builder.Build()
 				if err != nil {
 					t.Fatalf("Error: %s\n", err)
 				}
-				if s := cmp.Diff(metadata, &lifecycle.BuildMetadata{
+				if s := cmp.Diff(buildMD, &lifecycle.BuildMetadata{
 					Processes:  []lifecycle.Process{},
 					Buildpacks: []string{"buildpack1-id", "buildpack2-id"},
-					BOM: lifecycle.Plan{
-						"dep1":         {"v": "1"},
-						"dep1-keep":    {"v": "2"},
-						"dep1-replace": {"replace": true},
-						"dep2":         {"v": "4"},
-						"dep2-keep":    {"v": "5"},
-						"dep2-replace": {"replace": true},
+					BOM: []metadata.BOMEntry{
+						{Name: "dep1", Metadata: map[string]interface{}{"v": "1"}},
+						{Name: "dep1-keep", Metadata: map[string]interface{}{"v": "2"}},
+						{Name: "dep1-replace", Buildpack: "buildpack1-id", Metadata: map[string]interface{}{"replace": true}},
+						{Name: "dep2", Metadata: map[string]interface{}{"v": "4"}},
+						{Name: "dep2-keep", Metadata: map[string]interface{}{"v": "5"}},
+						{Name: "dep2-replace", Buildpack: "buildpack2-id", Metadata: map[string]interface{}{"replace": true}},
 					},
 				}); s != "" {
 					t.Fatalf("Unexpected:\n%s\n", s)
@@ -192,6 +194,21 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 				)
 			})
 
+			it("should provide the stack ID, buildpack dir, and platform env", func() {
+				mkfile(t, "some-data", filepath.Join(platformDir, "env", "SOME_VAR"))
+				builder.StackID = "some-stack"
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				bpDir, err := filepath.Abs(filepath.Join("testdata", "buildpack"))
+				if err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				if s := cmp.Diff(rdfile(t, filepath.Join(appDir, "cnb-env-buildpack2")), "some-stack|"+bpDir+"|some-data\n"); s != "" {
+					t.Fatalf("Unexpected env:\n%s\n", s)
+				}
+			})
+
 			it("should connect stdout and stdin to the terminal", func() {
 				if _, err := builder.Build(); err != nil {
 					t.Fatalf("Error: %s\n", err)
@@ -204,6 +221,35 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 				}
 			})
 
+			it("should notify events when each buildpack starts and finishes", func() {
+				events := &recordingEvents{}
+				builder.Events = events
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				if s := cmp.Diff(events.calls, []string{
+					"started build buildpack1-id",
+					"finished build buildpack1-id",
+					"started build buildpack2-id",
+					"finished build buildpack2-id",
+				}); s != "" {
+					t.Fatalf("Unexpected events:\n%s\n", s)
+				}
+			})
+
+			it("should prefix output lines when a log prefix is set", func() {
+				builder.LogPrefix = "[{id}] "
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				if stdout.String() != "[buildpack1-id] STDOUT1\n[buildpack2-id] STDOUT2\n" {
+					t.Fatalf("Unexpected: %s", stdout)
+				}
+				if stderr.String() != "[buildpack1-id] STDERR1\n[buildpack2-id] STDERR2\n" {
+					t.Fatalf("Unexpected: %s", stderr)
+				}
+			})
+
 			it("should provide a subset of the build plan to each buildpack", func() {
 				if _, err := builder.Build(); err != nil {
 					t.Fatalf("Error: %s\n", err)
@@ -229,6 +275,61 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 					filepath.Join(appDir, "plan2.toml"),
 				)
 			})
+
+			it("should provide secrets and remove files that contain them", func() {
+				secretsDir := filepath.Join(tmpDir, "secrets")
+				mkdir(t, filepath.Join(secretsDir, "env"), filepath.Join(appDir, "buildpack1"))
+				mkfile(t, "some-secret-value", filepath.Join(secretsDir, "env", "SOME_VAR"))
+				mkfile(t, "token=some-secret-value", filepath.Join(appDir, "buildpack1", "npmrc"))
+				mkfile(t, "not-secret", filepath.Join(appDir, "buildpack1", "other"))
+				secrets, err := lifecycle.ReadSecrets(secretsDir)
+				if err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				builder.Secrets = secrets
+
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				for _, path := range []string{
+					filepath.Join(appDir, "cnb-env-buildpack1"),
+					filepath.Join(appDir, "buildpack1", "npmrc"),
+					filepath.Join(layersDir, "buildpack1-id", "npmrc"),
+				} {
+					if _, err := os.Stat(path); !os.IsNotExist(err) {
+						t.Fatalf("Expected '%s' to be removed", path)
+					}
+					if !strings.Contains(stderr.String(), "Warning: removed '"+path+"'") {
+						t.Fatalf("Expected removal of '%s' to be logged: %s", path, stderr)
+					}
+				}
+				testExists(t, filepath.Join(layersDir, "buildpack1-id", "other"), filepath.Join(secretsDir, "env", "SOME_VAR"))
+			})
+
+			it("should run buildpacks as the build user when running as root", func() {
+				if os.Getuid() != 0 {
+					t.Skip("Skipped when not running as root")
+				}
+				buildpackDir := filepath.Join(tmpDir, "buildpack")
+				if out, err := exec.Command("cp", "-a", filepath.Join("testdata", "buildpack"), buildpackDir).CombinedOutput(); err != nil {
+					t.Fatalf("Error: %s\n%s\n", err, out)
+				}
+				if out, err := exec.Command("chown", "-R", "1234:2345", tmpDir).CombinedOutput(); err != nil {
+					t.Fatalf("Error: %s\n%s\n", err, out)
+				}
+				mkdir(t, filepath.Join(layersDir, "buildpack1-id", "restored-layer"))
+				for _, bp := range builder.Buildpacks {
+					bp.Dir = buildpackDir
+				}
+				builder.UID, builder.GID = 1234, 2345
+
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				h.AssertUidGid(t, filepath.Join(layersDir, "buildpack1-id", "restored-layer"), 1234, 2345)
+				h.AssertUidGid(t, filepath.Join(layersDir, "buildpack2-id", "launch.toml"), 1234, 2345)
+				h.AssertUidGid(t, filepath.Join(appDir, "plan1.toml"), 1234, 2345)
+			})
 		})
 
 		when("building fails", func() {
@@ -265,7 +366,11 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 					t.Fatalf("Error: %s\n", err)
 				}
 				_, err := builder.Build()
-				if _, ok := err.(*exec.ExitError); !ok {
+				bpErr, ok := err.(*lifecycle.BuildpackError)
+				if !ok {
+					t.Fatalf("Incorrect error: %s\n", err)
+				}
+				if _, ok := bpErr.Err.(*exec.ExitError); !ok {
 					t.Fatalf("Incorrect error: %s\n", err)
 				}
 			})
@@ -312,6 +417,18 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 				})
 			})
 
+			it("should identify the buildpack that failed", func() {
+				env.EXPECT().List().Return([]string{"ID=1"})
+				env.EXPECT().List().Return([]string{"ID=2"})
+				mkfile(t, "", filepath.Join(appDir, "build-fail2"))
+				_, err := builder.Build()
+				bpErr, ok := err.(*lifecycle.BuildpackError)
+				if !ok {
+					t.Fatalf("Incorrect error: %s\n", err)
+				}
+				h.AssertEq(t, bpErr.ID, "buildpack2-id")
+			})
+
 			it("should error when launch.toml is not writable", func() {
 				env.EXPECT().List().Return([]string{"ID=1"})
 				mkdir(t, filepath.Join(layersDir, "buildpack1-id", "launch.toml"))
@@ -377,3 +494,16 @@ func each(it spec.S, befores []func(), text string, f func()) {
 		it(fmt.Sprintf("%s #%d", text, i), func() { before(); f() })
 	}
 }
+
+type recordingEvents struct {
+	lifecycle.NopEvents
+	calls []string
+}
+
+func (r *recordingEvents) OnBuildpackStarted(e lifecycle.BuildpackEvent) {
+	r.calls = append(r.calls, "started "+e.Phase+" "+e.Buildpack.ID)
+}
+
+func (r *recordingEvents) OnBuildpackFinished(e lifecycle.BuildpackEvent) {
+	r.calls = append(r.calls, "finished "+e.Phase+" "+e.Buildpack.ID)
+}
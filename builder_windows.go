@@ -0,0 +1,16 @@
+package lifecycle
+
+import (
+	"os"
+	"syscall"
+)
+
+// userProcAttr is never called on Windows, where privileges are not dropped.
+func userProcAttr(uid, gid int) *syscall.SysProcAttr {
+	return nil
+}
+
+// stopProcess kills p, since Windows processes cannot be sent SIGTERM.
+func stopProcess(p *os.Process) error {
+	return p.Kill()
+}
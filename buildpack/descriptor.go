@@ -0,0 +1,233 @@
+// Package buildpack reads and validates buildpack.toml and extension.toml
+// files.
+package buildpack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+// DefaultAPI is the buildpack API of a buildpack.toml without an api key.
+const DefaultAPI = "0.1"
+
+// reservedIDs would clash with the layers the exporter adds to every image.
+var reservedIDs = []string{"app", "config", "launcher"}
+
+type Descriptor struct {
+	API       string                 `toml:"api"`
+	Buildpack Info                   `toml:"buildpack"`
+	Extension Info                   `toml:"extension"`
+	Stacks    Stacks                 `toml:"stacks"`
+	Order     []Group                `toml:"order"`
+	Metadata  map[string]interface{} `toml:"metadata"`
+}
+
+type Info struct {
+	ID      string `toml:"id"`
+	Version string `toml:"version"`
+	Name    string `toml:"name"`
+}
+
+type Stack struct {
+	ID     string   `toml:"id"`
+	Mixins []string `toml:"mixins"`
+}
+
+type Stacks []Stack
+
+type Group struct {
+	Group []GroupEntry `toml:"group"`
+}
+
+type GroupEntry struct {
+	ID       string `toml:"id"`
+	Version  string `toml:"version"`
+	Optional bool   `toml:"optional"`
+}
+
+// DecodeFile decodes the file at path into v. The lifecycle replaces it with
+// its own DecodeFile, which reports unknown keys outside the metadata table.
+var DecodeFile = func(path string, v interface{}) error {
+	_, err := toml.DecodeFile(path, v)
+	return err
+}
+
+func Read(path string) (*Descriptor, error) {
+	var d Descriptor
+	if err := DecodeFile(path, &d); err != nil {
+		return nil, err
+	}
+	if err := d.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid buildpack descriptor '%s'", path)
+	}
+	return &d, nil
+}
+
+// ReadExtension reads an extension.toml, which describes the extension in an
+// [extension] table. The table is also returned as Buildpack.
+func ReadExtension(path string) (*Descriptor, error) {
+	var d Descriptor
+	if err := DecodeFile(path, &d); err != nil {
+		return nil, err
+	}
+	if err := d.validateExtension(); err != nil {
+		return nil, errors.Wrapf(err, "invalid extension descriptor '%s'", path)
+	}
+	return &d, nil
+}
+
+func (d *Descriptor) validateExtension() error {
+	if d.Buildpack != (Info{}) {
+		return errors.New("extensions cannot have a [buildpack] table")
+	}
+	if d.Extension.ID == "" {
+		return errors.New("extension.id is required")
+	}
+	if d.Extension.Version == "" {
+		return errors.New("extension.version is required")
+	}
+	if len(d.Order) > 0 {
+		return errors.New("extensions cannot have an order")
+	}
+	d.Buildpack = d.Extension
+	return d.Validate()
+}
+
+func (d *Descriptor) Validate() error {
+	if d.Buildpack.ID == "" {
+		return errors.New("buildpack.id is required")
+	}
+	for _, id := range reservedIDs {
+		if d.Buildpack.ID == id {
+			return fmt.Errorf("buildpack.id '%s' is reserved", id)
+		}
+	}
+	if d.Buildpack.Version == "" {
+		return errors.New("buildpack.version is required")
+	}
+	if d.API != "" {
+		if _, _, err := parseAPI(d.API); err != nil {
+			return err
+		}
+	}
+	if len(d.Stacks) > 0 && len(d.Order) > 0 {
+		return errors.New("stacks and order cannot both be set")
+	}
+	for i, s := range d.Stacks {
+		if s.ID == "" {
+			return fmt.Errorf("stacks[%d].id is required", i)
+		}
+	}
+	for i, g := range d.Order {
+		if len(g.Group) == 0 {
+			return fmt.Errorf("order[%d] has no buildpacks", i)
+		}
+		for j, e := range g.Group {
+			if e.ID == "" {
+				return fmt.Errorf("order[%d].group[%d].id is required", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// APIVersion returns the buildpack API, or DefaultAPI if it is not set.
+func (d *Descriptor) APIVersion() string {
+	if d.API == "" {
+		return DefaultAPI
+	}
+	return d.API
+}
+
+// Supports reports whether a buildpack with these stacks can run on the stack
+// with stackID. Buildpacks that declare no stacks support every stack.
+func (s Stacks) Supports(stackID string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, stack := range s {
+		if stack.ID == stackID {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingMixins returns the mixins the buildpack requires on the stack with
+// stackID that are not in provided, the mixins of the stack's image for stage
+// ("build" or "run"). Mixins prefixed with another stage are not required.
+func (s Stacks) MissingMixins(stackID, stage string, provided []string) []string {
+	var names []string
+	for _, mixin := range provided {
+		if name, ok := stageMixin(mixin, stage); ok {
+			names = append(names, name)
+		}
+	}
+	var missing []string
+	for _, stack := range s {
+		if stack.ID != stackID {
+			continue
+		}
+		for _, mixin := range stack.Mixins {
+			if name, ok := stageMixin(mixin, stage); ok && !contains(names, name) {
+				missing = append(missing, mixin)
+			}
+		}
+	}
+	return missing
+}
+
+// stageMixin returns the name of mixin without its stage prefix, and whether
+// it applies to stage. Mixins without a prefix apply to every stage.
+func stageMixin(mixin, stage string) (string, bool) {
+	for _, s := range []string{"build", "run"} {
+		if strings.HasPrefix(mixin, s+":") {
+			return strings.TrimPrefix(mixin, s+":"), s == stage
+		}
+	}
+	return mixin, true
+}
+
+// CompatibleAPI reports whether a buildpack implementing api can be run by a
+// lifecycle implementing lifecycleAPI. An empty api is DefaultAPI.
+func CompatibleAPI(api, lifecycleAPI string) bool {
+	if api == "" {
+		api = DefaultAPI
+	}
+	major, minor, err := parseAPI(api)
+	if err != nil {
+		return false
+	}
+	lcMajor, lcMinor, err := parseAPI(lifecycleAPI)
+	if err != nil {
+		return false
+	}
+	return major == lcMajor && minor <= lcMinor
+}
+
+func parseAPI(api string) (major, minor int, err error) {
+	parts := strings.Split(api, ".")
+	if len(parts) == 2 {
+		major, err = strconv.Atoi(parts[0])
+		if err == nil {
+			minor, err = strconv.Atoi(parts[1])
+		}
+		if err == nil {
+			return major, minor, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("api '%s' must be <major>.<minor>", api)
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package buildpack_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/buildpack"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestDescriptor(t *testing.T) {
+	spec.Run(t, "Descriptor", testDescriptor, spec.Report(report.Terminal{}))
+}
+
+func testDescriptor(t *testing.T, when spec.G, it spec.S) {
+	var tmpDir string
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.buildpack")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	write := func(contents string) string {
+		path := filepath.Join(tmpDir, "buildpack.toml")
+		h.AssertNil(t, ioutil.WriteFile(path, []byte(contents), 0666))
+		return path
+	}
+
+	when("#Read", func() {
+		it("reads the descriptor", func() {
+			d, err := buildpack.Read(write(`
+api = "0.2"
+
+[buildpack]
+id = "some-buildpack"
+version = "1.2.3"
+name = "Some Buildpack"
+
+[[stacks]]
+id = "some-stack"
+mixins = ["some-mixin"]
+
+[metadata]
+some-key = "some-value"
+`))
+			h.AssertNil(t, err)
+			h.AssertEq(t, d.APIVersion(), "0.2")
+			h.AssertEq(t, d.Buildpack, buildpack.Info{ID: "some-buildpack", Version: "1.2.3", Name: "Some Buildpack"})
+			h.AssertEq(t, d.Stacks, buildpack.Stacks{{ID: "some-stack", Mixins: []string{"some-mixin"}}})
+			h.AssertEq(t, d.Metadata["some-key"], "some-value")
+		})
+
+		it("defaults the api", func() {
+			d, err := buildpack.Read(write("[buildpack]\nid = \"some-buildpack\"\nversion = \"1.2.3\"\n"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, d.APIVersion(), buildpack.DefaultAPI)
+		})
+
+		it("fails for an invalid descriptor", func() {
+			path := write("[buildpack]\nid = \"app\"\nversion = \"1.2.3\"\n")
+			_, err := buildpack.Read(path)
+			h.AssertError(t, err, "invalid buildpack descriptor '"+path+"': buildpack.id 'app' is reserved")
+		})
+	})
+
+	when("#ReadExtension", func() {
+		it("reads the extension as a buildpack", func() {
+			d, err := buildpack.ReadExtension(write("[extension]\nid = \"some-extension\"\nversion = \"1.2.3\"\n"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, d.Buildpack, buildpack.Info{ID: "some-extension", Version: "1.2.3"})
+		})
+
+		it("does not allow a buildpack table", func() {
+			path := write("[buildpack]\nid = \"some-buildpack\"\nversion = \"1.2.3\"\n")
+			_, err := buildpack.ReadExtension(path)
+			h.AssertError(t, err, "invalid extension descriptor '"+path+"': extensions cannot have a [buildpack] table")
+		})
+	})
+
+	when("#Validate", func() {
+		var d buildpack.Descriptor
+
+		it.Before(func() {
+			d = buildpack.Descriptor{Buildpack: buildpack.Info{ID: "some-buildpack", Version: "1.2.3"}}
+		})
+
+		it("requires a version", func() {
+			d.Buildpack.Version = ""
+			h.AssertError(t, d.Validate(), "buildpack.version is required")
+		})
+
+		it("requires a valid api", func() {
+			d.API = "1"
+			h.AssertError(t, d.Validate(), "api '1' must be <major>.<minor>")
+		})
+
+		it("does not allow both stacks and order", func() {
+			d.Stacks = buildpack.Stacks{{ID: "some-stack"}}
+			d.Order = []buildpack.Group{{Group: []buildpack.GroupEntry{{ID: "other-buildpack"}}}}
+			h.AssertError(t, d.Validate(), "stacks and order cannot both be set")
+		})
+
+		it("requires buildpacks in each order group", func() {
+			d.Order = []buildpack.Group{{}}
+			h.AssertError(t, d.Validate(), "order[0] has no buildpacks")
+		})
+	})
+
+	when("Stacks", func() {
+		stacks := buildpack.Stacks{{ID: "some-stack", Mixins: []string{"some-mixin", "other-mixin", "build:build-mixin", "run:run-mixin"}}}
+
+		it("supports the declared stacks", func() {
+			h.AssertEq(t, stacks.Supports("some-stack"), true)
+			h.AssertEq(t, stacks.Supports("other-stack"), false)
+			h.AssertEq(t, buildpack.Stacks(nil).Supports("other-stack"), true)
+		})
+
+		it("returns the missing mixins for the stage", func() {
+			h.AssertEq(t, stacks.MissingMixins("some-stack", "build", []string{"other-mixin"}), []string{"some-mixin", "build:build-mixin"})
+			h.AssertEq(t, stacks.MissingMixins("some-stack", "run", []string{"some-mixin", "run:other-mixin", "run:run-mixin"}), []string(nil))
+			h.AssertEq(t, stacks.MissingMixins("other-stack", "run", nil), []string(nil))
+		})
+	})
+
+	when(".CompatibleAPI", func() {
+		it("requires the same major and an equal or older minor", func() {
+			h.AssertEq(t, buildpack.CompatibleAPI("", "0.2"), true)
+			h.AssertEq(t, buildpack.CompatibleAPI("0.2", "0.2"), true)
+			h.AssertEq(t, buildpack.CompatibleAPI("0.3", "0.2"), false)
+			h.AssertEq(t, buildpack.CompatibleAPI("1.0", "0.2"), false)
+		})
+	})
+}
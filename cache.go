@@ -4,6 +4,7 @@ import (
 	"io"
 
 	"github.com/buildpack/lifecycle/cache"
+	"github.com/buildpack/lifecycle/image"
 )
 
 //go:generate mockgen -package testmock -destination testmock/cache.go github.com/buildpack/lifecycle Cache
@@ -12,7 +13,9 @@ type Cache interface {
 	SetMetadata(metadata cache.Metadata) error
 	RetrieveMetadata() (cache.Metadata, error)
 	AddLayer(identifier string, sha string, tarPath string) error
+	AddLayerFromOpener(identifier string, sha string, size int64, open image.Opener) error
 	ReuseLayer(identifier string, sha string) error
 	RetrieveLayer(sha string) (io.ReadCloser, error)
+	List() ([]cache.LayerInfo, error)
 	Commit() error
 }
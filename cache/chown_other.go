@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// chownTree changes the owner of the files under path that are not owned by
+// uid and gid, without following symlinks.
+func chownTree(path string, uid, gid int) error {
+	return filepath.Walk(path, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if stat, ok := fi.Sys().(*syscall.Stat_t); ok && int(stat.Uid) == uid && int(stat.Gid) == gid {
+			return nil
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// DigestMismatchError is returned when a layer tar in the cache does not match
+// its SHA.
+type DigestMismatchError struct {
+	SHA    string
+	Actual string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("layer tar has digest '%s', expected '%s'", e.Actual, e.SHA)
+}
+
+func (e *DigestMismatchError) Is(target error) bool {
+	return target == ErrCacheCorrupt
+}
+
+func digest(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// verifyingReader reads a layer tar, returning a *DigestMismatchError instead
+// of io.EOF if its contents do not match sha.
+type verifyingReader struct {
+	io.ReadCloser
+	sha  string
+	hash hash.Hash
+}
+
+func newVerifyingReader(rc io.ReadCloser, sha string) *verifyingReader {
+	return &verifyingReader{ReadCloser: rc, sha: sha, hash: sha256.New()}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := digest(r.hash); actual != r.sha {
+			return n, &DigestMismatchError{SHA: r.sha, Actual: actual}
+		}
+	}
+	return n, err
+}
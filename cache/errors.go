@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/image"
+)
+
+// Errors returned by caches match these with errors.Is. Errors wrapped by
+// github.com/pkg/errors match once unwrapped with errors.Cause.
+var (
+	// ErrCacheCorrupt is matched by errors for layers whose contents do not
+	// match their SHA.
+	ErrCacheCorrupt = errors.New("cache is corrupt")
+
+	// ErrLayerNotFound is matched by errors for layers missing from a cache,
+	// and is the error of the same name for images.
+	ErrLayerNotFound = image.ErrLayerNotFound
+)
+
+type layerNotFoundError struct {
+	sha string
+	err error
+}
+
+func (e *layerNotFoundError) Error() string {
+	return fmt.Sprintf("layer with SHA '%s' not found: %s", e.sha, e.err)
+}
+
+func (e *layerNotFoundError) Unwrap() error        { return e.err }
+func (e *layerNotFoundError) Is(target error) bool { return target == ErrLayerNotFound }
@@ -2,10 +2,16 @@ package cache
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/pkg/errors"
 
+	"github.com/buildpack/lifecycle/exitcode"
 	"github.com/buildpack/lifecycle/image"
 	"github.com/buildpack/lifecycle/metadata"
 )
@@ -13,20 +19,121 @@ import (
 //go:generate mockgen -package testmock -destination testmock/image_factory.go github.com/buildpack/lifecycle/cache ImageFactory
 type ImageFactory interface {
 	NewEmptyLocal(string) image.Image
+	NewLocal(string) (image.Image, error)
+}
+
+// RemoteImageFactory creates cache images in a registry.
+type RemoteImageFactory interface {
+	NewEmptyRemote(string) image.Image
+	NewRemote(string) (image.Image, error)
+}
+
+// ArtifactType is the artifact type of cache images pushed to registries as
+// OCI artifacts, so that they are not mistaken for runnable images.
+var ArtifactType = image.ArtifactType{
+	Config: "application/vnd.buildpacks.lifecycle.cache.config.v1+json",
+	Layer:  "application/vnd.buildpacks.lifecycle.cache.layer.v1.tar+gzip",
 }
 
 type ImageCache struct {
-	factory   ImageFactory
 	origImage image.Image
 	newImage  image.Image
+	newEmpty  func(string) image.Image
+	open      func(string) (image.Image, error)
+
+	// daemon is set for caches in the daemon, where replaced images that are
+	// not kept are deleted.
+	daemon     bool
+	noPrevious bool
+
+	// pageSize, when set, limits the size of the layers in each image of the
+	// cache, splitting larger caches across page images.
+	pageSize  int64
+	origMeta  *Metadata
+	origPages map[int]image.Image
+	newPages  map[int]image.Image
+	meta      *Metadata
+	pages     []Page
+	totals    []int64
+
+	// retries is the number of times a failed save is retried, after
+	// retryDelay and then twice as long each time. saved records the digests
+	// of the pages of the new cache that a failed commit saved, so that
+	// Commit resumes.
+	retries    int
+	retryDelay time.Duration
+	saved      map[int]string
+}
+
+const (
+	defaultRetries    = 3
+	defaultRetryDelay = time.Second
+)
+
+func NewImageCache(factory ImageFactory, origImage image.Image, ops ...func(*ImageCache)) *ImageCache {
+	c := &ImageCache{
+		origImage:  origImage,
+		newImage:   factory.NewEmptyLocal(origImage.Name()),
+		newEmpty:   factory.NewEmptyLocal,
+		open:       factory.NewLocal,
+		daemon:     true,
+		origPages:  map[int]image.Image{},
+		newPages:   map[int]image.Image{},
+		retries:    defaultRetries,
+		retryDelay: defaultRetryDelay,
+		saved:      map[int]string{},
+	}
+	for _, op := range ops {
+		op(c)
+	}
+	return c
+}
+
+// NewRemoteImageCache returns a cache that is saved to a registry, replacing
+// the tag of origImage.
+func NewRemoteImageCache(factory RemoteImageFactory, origImage image.Image, ops ...func(*ImageCache)) *ImageCache {
+	c := &ImageCache{
+		origImage:  origImage,
+		newImage:   factory.NewEmptyRemote(origImage.Name()),
+		newEmpty:   factory.NewEmptyRemote,
+		open:       factory.NewRemote,
+		origPages:  map[int]image.Image{},
+		newPages:   map[int]image.Image{},
+		retries:    defaultRetries,
+		retryDelay: defaultRetryDelay,
+		saved:      map[int]string{},
+	}
+	for _, op := range ops {
+		op(c)
+	}
+	return c
 }
 
-func NewImageCache(factory ImageFactory, origImage image.Image) *ImageCache {
-	newImage := factory.NewEmptyLocal(origImage.Name())
-	return &ImageCache{
-		factory:   factory,
-		origImage: origImage,
-		newImage:  newImage,
+// WithoutPrevious makes commits delete the cache image they replace from the
+// daemon instead of keeping it under PreviousImageName, so that long-lived
+// builders do not accumulate old cache images. Registry images are replaced
+// without being kept.
+func WithoutPrevious(c *ImageCache) {
+	c.noPrevious = true
+}
+
+// WithPageSize splits the cache across images when its layers exceed size
+// bytes, to stay under registry limits on the size of images. Layers are added
+// to the first image with room for them, and the others are named by
+// PageImageName. Reused layers stay in the image they were in.
+func WithPageSize(size int64) func(*ImageCache) {
+	return func(c *ImageCache) {
+		c.pageSize = size
+	}
+}
+
+// WithRetries sets the number of times a save of a cache image that failed
+// with a retryable error is retried, first after delay and then twice as
+// long each time. Other errors are not retried.
+func WithRetries(retries int, delay time.Duration) func(*ImageCache) {
+	return func(c *ImageCache) {
+		c.retries = retries
+		c.retryDelay = delay
 	}
 }
 
@@ -34,12 +141,48 @@ func (c *ImageCache) Name() string {
 	return c.origImage.Name()
 }
 
-func (c *ImageCache) SetMetadata(metadata Metadata) error {
-	data, err := json.Marshal(metadata)
+// SetMetadata sets the metadata that is written to the new cache image, with
+// the pages of the cache, on Commit.
+func (c *ImageCache) SetMetadata(meta Metadata) error {
+	c.meta = &meta
+	return nil
+}
+
+// ImageMovedError is returned when the tag of a cache image no longer refers
+// to the digest it was expected to.
+type ImageMovedError struct {
+	Name     string
+	Expected string
+	Actual   string
+}
+
+func (e *ImageMovedError) Error() string {
+	if e.Actual == "" {
+		return fmt.Sprintf("cache image '%s' has no digest, expected %s", e.Name, e.Expected)
+	}
+	return fmt.Sprintf("cache image '%s' moved to %s, expected %s", e.Name, e.Actual, e.Expected)
+}
+
+// VerifyDigest returns an *ImageMovedError unless the cache image has the
+// expected digest, which may be given as a digest reference.
+func (c *ImageCache) VerifyDigest(expected string) error {
+	if i := strings.LastIndex(expected, "@"); i >= 0 {
+		expected = expected[i+1:]
+	}
+	actual := ""
+	found, err := c.origImage.Found()
 	if err != nil {
-		return errors.Wrap(err, "serializing metadata")
+		return errors.Wrapf(err, "checking image '%s'", c.origImage.Name())
+	}
+	if found {
+		if actual, err = c.origImage.Digest(); err != nil {
+			return errors.Wrapf(err, "getting digest of image '%s'", c.origImage.Name())
+		}
+	}
+	if actual != expected {
+		return &ImageMovedError{Name: c.origImage.Name(), Expected: expected, Actual: actual}
 	}
-	return c.newImage.SetLabel(MetadataLabel, string(data))
+	return nil
 }
 
 func (c *ImageCache) RetrieveMetadata() (Metadata, error) {
@@ -48,37 +191,421 @@ func (c *ImageCache) RetrieveMetadata() (Metadata, error) {
 		return Metadata{}, errors.Wrap(err, "retrieving metadata")
 	}
 
-	meta := Metadata{}
-	if json.Unmarshal([]byte(contents), &meta) != nil {
-		return Metadata{}, nil
-	}
-	return meta, nil
+	return ParseMetadata([]byte(contents))
 }
 
 func (c *ImageCache) AddLayer(identifier string, sha string, tarPath string) error {
-	return c.newImage.AddLayer(tarPath)
+	fi, err := os.Stat(tarPath)
+	if err != nil {
+		return err
+	}
+	i, page, err := c.place(fi.Size())
+	if err != nil {
+		return err
+	}
+	if err := page.AddLayer(tarPath); err != nil {
+		return err
+	}
+	c.pages[i].Layers[sha] = fi.Size()
+	return nil
+}
+
+func (c *ImageCache) AddLayerFromOpener(identifier string, sha string, size int64, open image.Opener) error {
+	i, page, err := c.place(size)
+	if err != nil {
+		return err
+	}
+	if err := page.AddLayerFromOpener(sha, size, open); err != nil {
+		return err
+	}
+	c.pages[i].Layers[sha] = size
+	return nil
 }
 
+// ReuseLayer adds the layer with sha from the original cache to the new
+// cache. Layers that move to another page, as when the cache is no longer
+// split, are copied from the page they were in.
 func (c *ImageCache) ReuseLayer(identifier string, sha string) error {
-	return c.newImage.ReuseLayer(sha)
+	if err := c.startPages(); err != nil {
+		return err
+	}
+	from, size, err := c.findOrig(sha)
+	if err != nil {
+		return err
+	}
+	to := 0
+	if c.pageSize > 0 {
+		to = from
+	}
+	page, err := c.newPage(to)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		err = page.ReuseLayer(sha)
+	} else {
+		err = c.copyLayer(page, from, sha, size)
+	}
+	if err != nil {
+		return err
+	}
+	c.pages[to].Layers[sha] = size
+	return nil
+}
+
+// copyLayer adds the layer with sha from page from of the original cache to
+// page.
+func (c *ImageCache) copyLayer(page image.Image, from int, sha string, size int64) error {
+	orig, err := c.origPage(from)
+	if err != nil {
+		return err
+	}
+	return page.AddLayerFromOpener(sha, size, func() (io.ReadCloser, error) { return orig.GetLayer(sha) })
 }
 
 func (c *ImageCache) RetrieveLayer(sha string) (io.ReadCloser, error) {
-	return c.origImage.GetLayer(sha)
+	from, _, err := c.findOrig(sha)
+	if err != nil {
+		return nil, err
+	}
+	page, err := c.origPage(from)
+	if err != nil {
+		return nil, err
+	}
+	return page.GetLayer(sha)
 }
 
+// List describes the layers in the cache image. Their sizes are known only
+// for caches committed with a page size. Metadata written by an incompatible
+// lifecycle is read as empty.
+func (c *ImageCache) List() ([]LayerInfo, error) {
+	meta, err := c.origMetadata()
+	if err != nil {
+		return nil, err
+	}
+	infos := meta.layerInfos()
+	for i := range infos {
+		for _, page := range meta.Pages {
+			if size, ok := page.Layers[infos[i].SHA]; ok {
+				infos[i].Size = size
+			}
+		}
+	}
+	return infos, nil
+}
+
+// Commit saves the new cache image, first tagging the image it replaces with
+// PreviousImageName, so that a platform can roll back to it. The image kept by
+// the commit before is untagged, and removed from the daemon. Pages are saved
+// before the image that refers to them, which records their digests. Failed
+// saves are retried, and calling Commit again after it fails resumes with the
+// images that were not saved.
 func (c *ImageCache) Commit() error {
-	_, err := c.newImage.Save()
+	origPages, err := c.origPageImages()
 	if err != nil {
-		return errors.Wrapf(err, "saving image '%s'", c.newImage.Name())
+		return err
+	}
+	if !c.noPrevious {
+		if err := c.keepOrig(origPages); err != nil {
+			return err
+		}
 	}
 
-	if err := c.origImage.Delete(); err != nil {
-		return errors.Wrapf(err, "deleting image '%s'", c.origImage.Name())
+	for i := 1; i < len(c.pages); i++ {
+		page, ok := c.newPages[i]
+		if !ok {
+			continue
+		}
+		if _, ok := c.saved[i]; !ok {
+			digest, err := c.save(page)
+			if err != nil {
+				return err
+			}
+			c.saved[i] = digest
+		}
+		c.pages[i].Digest = c.saved[i]
+	}
+	meta, err := c.writeMetadata()
+	if err != nil {
+		return err
+	}
+	if _, err := c.save(c.newImage); err != nil {
+		return err
+	}
+
+	if c.noPrevious && c.daemon {
+		origPages[0] = c.origImage
+		for _, img := range origPages {
+			if err := img.Delete(); err != nil {
+				return errors.Wrapf(err, "deleting image '%s'", img.Name())
+			}
+		}
 	}
 
 	c.origImage = c.newImage
-	c.newImage = c.factory.NewEmptyLocal(c.origImage.Name())
+	c.origPages = c.newPages
+	c.origMeta = meta
+	c.newImage = c.newEmpty(c.origImage.Name())
+	c.newPages = map[int]image.Image{}
+	c.meta = nil
+	c.pages = nil
+	c.totals = nil
+	c.saved = map[int]string{}
+
+	return nil
+}
+
+// save saves img, retrying saves that fail with retryable errors, and
+// returns its digest. Registries are not sent the layers they already have,
+// so a retried push resumes where it failed.
+func (c *ImageCache) save(img image.Image) (string, error) {
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		digest, err := img.Save()
+		if err == nil {
+			return digest, nil
+		}
+		if attempt == c.retries || !exitcode.IsRetryable(err) {
+			return "", errors.Wrapf(err, "saving image '%s'", img.Name())
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// writeMetadata sets the metadata label of the new cache image, recording its
+// pages when the cache is split.
+func (c *ImageCache) writeMetadata() (*Metadata, error) {
+	if c.meta == nil {
+		return nil, nil
+	}
+	meta := *c.meta
+	if c.pageSize > 0 || len(c.pages) > 1 {
+		meta.Pages = c.pages
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, errors.Wrap(err, "serializing metadata")
+	}
+	if err := c.newImage.SetLabel(metadata.LabelName(MetadataLabel), string(data)); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
 
+func (c *ImageCache) keepOrig(origPages map[int]image.Image) error {
+	if found, err := c.origImage.Found(); err != nil {
+		return errors.Wrapf(err, "checking image '%s'", c.origImage.Name())
+	} else if !found {
+		return nil
+	}
+	previous, err := PreviousImageName(c.origImage.Name())
+	if err != nil {
+		return err
+	}
+	for i, page := range origPages {
+		name, err := PageImageName(previous, i)
+		if err != nil {
+			return err
+		}
+		if err := page.Tag(name); err != nil {
+			return errors.Wrapf(err, "tagging previous image '%s'", name)
+		}
+	}
+	if err := c.origImage.Tag(previous); err != nil {
+		return errors.Wrapf(err, "tagging previous image '%s'", previous)
+	}
 	return nil
 }
+
+// startPages starts the pages of the new cache, reserving room in each for
+// the layers of the original cache, which stay in their page when reused.
+func (c *ImageCache) startPages() error {
+	if c.totals != nil {
+		return nil
+	}
+	c.pages = []Page{{Layers: map[string]int64{}}}
+	c.totals = []int64{0}
+	if c.pageSize == 0 {
+		return nil
+	}
+	meta, err := c.origMetadata()
+	if err != nil {
+		return err
+	}
+	for i, page := range meta.Pages {
+		c.growPages(i)
+		for _, size := range page.Layers {
+			c.totals[i] += size
+		}
+	}
+	return nil
+}
+
+func (c *ImageCache) growPages(i int) {
+	for len(c.pages) <= i {
+		c.pages = append(c.pages, Page{Layers: map[string]int64{}})
+		c.totals = append(c.totals, 0)
+	}
+}
+
+// place returns the first page of the new cache with room for a layer of
+// size bytes. Pages without layers have room for a layer of any size.
+func (c *ImageCache) place(size int64) (int, image.Image, error) {
+	if err := c.startPages(); err != nil {
+		return 0, nil, err
+	}
+	i := 0
+	if c.pageSize > 0 {
+		for i < len(c.totals) && c.totals[i] > 0 && c.totals[i]+size > c.pageSize {
+			i++
+		}
+	}
+	page, err := c.newPage(i)
+	if err != nil {
+		return 0, nil, err
+	}
+	c.totals[i] += size
+	return i, page, nil
+}
+
+// newPage returns page i of the new cache, the new cache image for page 0.
+func (c *ImageCache) newPage(i int) (image.Image, error) {
+	c.growPages(i)
+	if i == 0 {
+		return c.newImage, nil
+	}
+	if page, ok := c.newPages[i]; ok {
+		return page, nil
+	}
+	name, err := PageImageName(c.origImage.Name(), i)
+	if err != nil {
+		return nil, err
+	}
+	c.newPages[i] = c.newEmpty(name)
+	return c.newPages[i], nil
+}
+
+func (c *ImageCache) origMetadata() (Metadata, error) {
+	if c.origMeta == nil {
+		meta, err := c.RetrieveMetadata()
+		if _, ok := err.(*metadata.IncompatibleError); ok {
+			err = nil
+		}
+		if err != nil {
+			return Metadata{}, err
+		}
+		c.origMeta = &meta
+	}
+	return *c.origMeta, nil
+}
+
+// findOrig returns the page of the original cache with the layer with sha,
+// and the size of its tar, or -1 if that is not recorded.
+func (c *ImageCache) findOrig(sha string) (int, int64, error) {
+	meta, err := c.origMetadata()
+	if err != nil {
+		return 0, 0, err
+	}
+	for i, page := range meta.Pages {
+		if size, ok := page.Layers[sha]; ok {
+			return i, size, nil
+		}
+	}
+	return 0, -1, nil
+}
+
+// origPage returns page i of the original cache, the original cache image
+// for page 0. Pages are opened by the digest recorded for them, if any.
+func (c *ImageCache) origPage(i int) (image.Image, error) {
+	if i == 0 {
+		return c.origImage, nil
+	}
+	if page, ok := c.origPages[i]; ok {
+		return page, nil
+	}
+	meta, err := c.origMetadata()
+	if err != nil {
+		return nil, err
+	}
+	digest := ""
+	if i < len(meta.Pages) {
+		digest = meta.Pages[i].Digest
+	}
+	name, err := c.pageRef(i, digest)
+	if err != nil {
+		return nil, err
+	}
+	page, err := c.open(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening cache page '%s'", name)
+	}
+	c.origPages[i] = page
+	return page, nil
+}
+
+// origPageImages returns the pages of the original cache after the first that
+// have layers, so that they are known before the new pages replace them.
+func (c *ImageCache) origPageImages() (map[int]image.Image, error) {
+	meta, err := c.origMetadata()
+	if err != nil {
+		return nil, err
+	}
+	pages := map[int]image.Image{}
+	for i := 1; i < len(meta.Pages); i++ {
+		if len(meta.Pages[i].Layers) == 0 {
+			continue
+		}
+		page, err := c.origPage(i)
+		if err != nil {
+			return nil, err
+		}
+		if found, err := page.Found(); err != nil {
+			return nil, errors.Wrapf(err, "checking image '%s'", page.Name())
+		} else if found {
+			pages[i] = page
+		}
+	}
+	return pages, nil
+}
+
+// pageRef returns the reference to open page i of the original cache by:
+// its digest in the repository of the cache, its ID in the daemon, or its tag
+// if no digest was recorded.
+func (c *ImageCache) pageRef(i int, digest string) (string, error) {
+	if digest == "" {
+		return PageImageName(c.origImage.Name(), i)
+	}
+	if c.daemon {
+		return digest, nil
+	}
+	imageName := c.origImage.Name()
+	tag, err := name.NewTag(imageName, name.WeakValidation)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing cache image name '%s'", imageName)
+	}
+	return strings.TrimSuffix(imageName, ":"+tag.TagStr()) + "@" + digest, nil
+}
+
+// PreviousImageName returns the name a commit keeps the replaced cache image
+// under, which is imageName with "-previous" appended to its tag.
+func PreviousImageName(imageName string) (string, error) {
+	return appendToTag(imageName, "-previous")
+}
+
+// PageImageName returns the name of page i of a cache split across images,
+// which is imageName with "-page-<i>" appended to its tag.
+func PageImageName(imageName string, i int) (string, error) {
+	return appendToTag(imageName, fmt.Sprintf("-page-%d", i))
+}
+
+func appendToTag(imageName, suffix string) (string, error) {
+	tag, err := name.NewTag(imageName, name.WeakValidation)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing cache image name '%s'", imageName)
+	}
+	if !strings.HasSuffix(imageName, ":"+tag.TagStr()) {
+		imageName += ":" + tag.TagStr()
+	}
+	return imageName + suffix, nil
+}
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 
 	"github.com/buildpack/lifecycle/cache"
 	"github.com/buildpack/lifecycle/cache/testmock"
+	"github.com/buildpack/lifecycle/image"
 	"github.com/buildpack/lifecycle/image/fakes"
 	"github.com/buildpack/lifecycle/metadata"
 	h "github.com/buildpack/lifecycle/testhelpers"
@@ -83,6 +85,7 @@ func testImageCache(t *testing.T, when spec.G, it spec.S) {
 
 			it("returns the metadata", func() {
 				expected := cache.Metadata{
+					SchemaVersion: cache.MetadataVersion,
 					Buildpacks: []metadata.BuildpackMetadata{{
 						ID:      "bp.id",
 						Version: "1.2.3",
@@ -109,10 +112,11 @@ func testImageCache(t *testing.T, when spec.G, it spec.S) {
 				h.AssertNil(t, fakeOriginalImage.SetLabel("io.buildpacks.lifecycle.cache.metadata", "garbage"))
 			})
 
-			it("returns empty metadata", func() {
-				meta, err := subject.RetrieveMetadata()
-				h.AssertNil(t, err)
-				h.AssertEq(t, len(meta.Buildpacks), 0)
+			it("returns an incompatible error", func() {
+				_, err := subject.RetrieveMetadata()
+				if _, ok := err.(*metadata.IncompatibleError); !ok {
+					t.Fatalf("Expected an incompatible error, got: %v", err)
+				}
 			})
 		})
 
@@ -125,6 +129,53 @@ func testImageCache(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#VerifyDigest", func() {
+		it.Before(func() {
+			subject = cache.NewImageCache(mockImageFactory, fakes.NewImage(t, "fake-image", "", "sha256:some-digest"))
+		})
+
+		it("accepts the digest of the image", func() {
+			h.AssertNil(t, subject.VerifyDigest("sha256:some-digest"))
+			h.AssertNil(t, subject.VerifyDigest("fake-image@sha256:some-digest"))
+		})
+
+		it("returns a moved error when the image has another digest", func() {
+			err := subject.VerifyDigest("sha256:other-digest")
+			moved, ok := err.(*cache.ImageMovedError)
+			if !ok {
+				t.Fatalf("Expected a moved error, got: %v", err)
+			}
+			h.AssertEq(t, moved.Actual, "sha256:some-digest")
+			h.AssertEq(t, moved.Expected, "sha256:other-digest")
+		})
+	})
+
+	when("#List", func() {
+		it("describes the layers in the metadata without their sizes", func() {
+			h.AssertNil(t, fakeOriginalImage.SetLabel(
+				"io.buildpacks.lifecycle.cache.metadata",
+				`{"buildpacks": [{"key": "some.bp.id", "layers": {"some-layer": {"sha": "some-sha"}}}], "accessed": {"some-sha": "2020-01-01T00:00:00Z"}}`,
+			))
+
+			infos, err := subject.List()
+			h.AssertNil(t, err)
+			h.AssertEq(t, infos, []cache.LayerInfo{{
+				SHA:      "some-sha",
+				Layers:   []string{"some.bp.id:some-layer"},
+				Size:     -1,
+				Accessed: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			}})
+		})
+
+		it("reads incompatible metadata as empty", func() {
+			h.AssertNil(t, fakeOriginalImage.SetLabel("io.buildpacks.lifecycle.cache.metadata", "garbage"))
+
+			infos, err := subject.List()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(infos), 0)
+		})
+	})
+
 	when("#RetrieveLayer", func() {
 		when("layer exists", func() {
 			it.Before(func() {
@@ -157,6 +208,7 @@ func testImageCache(t *testing.T, when spec.G, it spec.S) {
 				h.AssertNil(t, fakeOriginalImage.SetLabel("io.buildpacks.lifecycle.cache.metadata", `{"buildpacks": [{"key": "old.bp.id"}]}`))
 
 				newMetadata = cache.Metadata{
+					SchemaVersion: cache.MetadataVersion,
 					Buildpacks: []metadata.BuildpackMetadata{{
 						ID: "new.bp.id",
 					}},
@@ -179,6 +231,7 @@ func testImageCache(t *testing.T, when spec.G, it spec.S) {
 			when("set without commit", func() {
 				it("retrieve returns the previous metadata", func() {
 					previousMetadata := cache.Metadata{
+						SchemaVersion: cache.MetadataVersion,
 						Buildpacks: []metadata.BuildpackMetadata{{
 							ID: "old.bp.id",
 						}},
@@ -256,5 +309,395 @@ func testImageCache(t *testing.T, when spec.G, it spec.S) {
 			})
 
 		})
+
+		it("keeps the previous image under the previous tag", func() {
+			h.AssertNil(t, subject.Commit())
+
+			found, err := fakeOriginalImage.Found()
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, true)
+			h.AssertEq(t, fakeOriginalImage.Tags(), []string{"fake-image:latest-previous"})
+			h.AssertEq(t, fakeNewImage.IsSaved(), true)
+		})
+
+		when("there is no previous image", func() {
+			it.Before(func() {
+				h.AssertNil(t, fakeOriginalImage.Delete())
+			})
+
+			it("only saves the new image", func() {
+				h.AssertNil(t, subject.Commit())
+
+				h.AssertEq(t, len(fakeOriginalImage.Tags()), 0)
+				h.AssertEq(t, fakeNewImage.IsSaved(), true)
+			})
+		})
+
+		when("without previous", func() {
+			it.Before(func() {
+				subject = cache.NewImageCache(mockImageFactory, fakeOriginalImage, cache.WithoutPrevious)
+			})
+
+			it("deletes the previous image from the daemon", func() {
+				h.AssertNil(t, subject.Commit())
+
+				found, err := fakeOriginalImage.Found()
+				h.AssertNil(t, err)
+				h.AssertEq(t, found, false)
+				h.AssertEq(t, len(fakeOriginalImage.Tags()), 0)
+				h.AssertEq(t, fakeNewImage.IsSaved(), true)
+			})
+		})
+
+		when("the cache is in a registry", func() {
+			it.Before(func() {
+				subject = cache.NewRemoteImageCache(newFakeRemoteFactory(t, fakeNewImage), fakeOriginalImage)
+			})
+
+			it("replaces the previous image, keeping it under the previous tag", func() {
+				h.AssertNil(t, subject.AddLayer("some_identifier", testLayerSHA, testLayerTarPath))
+				h.AssertNil(t, subject.Commit())
+
+				found, err := fakeOriginalImage.Found()
+				h.AssertNil(t, err)
+				h.AssertEq(t, found, true)
+				h.AssertEq(t, fakeOriginalImage.Tags(), []string{"fake-image:latest-previous"})
+				h.AssertEq(t, fakeNewImage.IsSaved(), true)
+			})
+
+			when("without previous", func() {
+				it.Before(func() {
+					subject = cache.NewRemoteImageCache(newFakeRemoteFactory(t, fakeNewImage), fakeOriginalImage, cache.WithoutPrevious)
+				})
+
+				it("replaces the previous image without keeping or deleting it", func() {
+					h.AssertNil(t, subject.Commit())
+
+					found, err := fakeOriginalImage.Found()
+					h.AssertNil(t, err)
+					h.AssertEq(t, found, true)
+					h.AssertEq(t, len(fakeOriginalImage.Tags()), 0)
+					h.AssertEq(t, fakeNewImage.IsSaved(), true)
+				})
+			})
+		})
 	})
+
+	when("#WithPageSize", func() {
+		var (
+			factory   *fakeRemoteFactory
+			layerSHAs []string
+			layers    []string
+		)
+
+		it.Before(func() {
+			h.AssertNil(t, fakeOriginalImage.Delete())
+			factory = newFakeRemoteFactory(t)
+			subject = cache.NewRemoteImageCache(factory, fakeOriginalImage, cache.WithPageSize(15))
+
+			layerSHAs, layers = nil, nil
+			for _, data := range []string{"first data", "other data", "third data", "fresh data"} {
+				path := filepath.Join(tmpDir, data+".tar")
+				h.AssertNil(t, ioutil.WriteFile(path, []byte(data), 0666))
+				layers = append(layers, path)
+				layerSHAs = append(layerSHAs, "sha256:"+h.ComputeSHA256ForFile(t, path))
+			}
+			bpMetadata := metadata.BuildpackMetadata{ID: "some-bp", Layers: map[string]metadata.LayerMetadata{}}
+			for i := 0; i < 3; i++ {
+				h.AssertNil(t, subject.AddLayer("some_identifier", layerSHAs[i], layers[i]))
+				bpMetadata.Layers[fmt.Sprintf("layer-%d", i)] = metadata.LayerMetadata{SHA: layerSHAs[i]}
+			}
+			h.AssertNil(t, subject.SetMetadata(cache.Metadata{Buildpacks: []metadata.BuildpackMetadata{bpMetadata}}))
+			h.AssertNil(t, subject.Commit())
+		})
+
+		it("splits layers across images that fit the page size", func() {
+			for _, name := range []string{"fake-image", "fake-image:latest-page-1", "fake-image:latest-page-2"} {
+				h.AssertEq(t, factory.saved(name) != nil, true)
+			}
+
+			meta, err := cache.NewRemoteImageCache(factory, factory.saved("fake-image")).RetrieveMetadata()
+			h.AssertNil(t, err)
+			h.AssertEq(t, pageLayers(meta), []map[string]int64{
+				{layerSHAs[0]: 10},
+				{layerSHAs[1]: 10},
+				{layerSHAs[2]: 10},
+			})
+			h.AssertEq(t, meta.Pages[0].Digest, "")
+			for _, page := range meta.Pages[1:] {
+				h.AssertEq(t, strings.HasPrefix(page.Digest, "sha256:"), true)
+			}
+		})
+
+		it("retrieves layers from their pages", func() {
+			rc, err := cache.NewRemoteImageCache(factory, factory.saved("fake-image")).RetrieveLayer(layerSHAs[2])
+			h.AssertNil(t, err)
+			defer rc.Close()
+			data, err := ioutil.ReadAll(rc)
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(data), "third data")
+		})
+
+		it("lists the sizes of the layers", func() {
+			infos, err := cache.NewRemoteImageCache(factory, factory.saved("fake-image")).List()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(infos), 3)
+			for _, info := range infos {
+				h.AssertEq(t, info.Size, int64(10))
+			}
+		})
+
+		it("keeps reused layers in their pages, adding layers to pages with room", func() {
+			origPage := factory.saved("fake-image:latest-page-2")
+			next := cache.NewRemoteImageCache(factory, factory.saved("fake-image"), cache.WithPageSize(15))
+			h.AssertNil(t, next.ReuseLayer("some_identifier", layerSHAs[2]))
+			h.AssertNil(t, next.AddLayer("other_identifier", layerSHAs[3], layers[3]))
+			h.AssertNil(t, next.SetMetadata(cache.Metadata{}))
+			h.AssertNil(t, next.Commit())
+
+			h.AssertEq(t, factory.saved("fake-image:latest-page-2").ReusedLayers(), []string{layerSHAs[2]})
+			h.AssertEq(t, factory.saved("fake-image:latest-page-3") != nil, true)
+			h.AssertEq(t, origPage.Tags(), []string{"fake-image:latest-previous-page-2"})
+
+			meta, err := cache.NewRemoteImageCache(factory, factory.saved("fake-image")).RetrieveMetadata()
+			h.AssertNil(t, err)
+			h.AssertEq(t, pageLayers(meta), []map[string]int64{
+				{},
+				{},
+				{layerSHAs[2]: 10},
+				{layerSHAs[3]: 10},
+			})
+		})
+
+		it("reads the pages of the original cache when saving the new cache image fails", func() {
+			next := cache.NewRemoteImageCache(factory, factory.saved("fake-image"), cache.WithPageSize(25), cache.WithRetries(0, 0))
+			h.AssertNil(t, next.AddLayer("other_identifier", layerSHAs[3], layers[3]))
+			fifthPath := filepath.Join(tmpDir, "fifth-layer.tar")
+			h.AssertNil(t, ioutil.WriteFile(fifthPath, []byte("fifth data"), 0666))
+			h.AssertNil(t, next.AddLayer("fifth_identifier", "sha256:"+h.ComputeSHA256ForFile(t, fifthPath), fifthPath))
+			h.AssertNil(t, next.SetMetadata(cache.Metadata{}))
+			factory.failing["fake-image"] = true
+			h.AssertError(t, next.Commit(), "some push error")
+			if _, err := factory.saved("fake-image:latest-page-1").GetLayer(layerSHAs[1]); err == nil {
+				t.Fatalf("Expected the page tag to refer to the page of the failed commit")
+			}
+
+			rc, err := cache.NewRemoteImageCache(factory, factory.saved("fake-image")).RetrieveLayer(layerSHAs[1])
+			h.AssertNil(t, err)
+			defer rc.Close()
+			data, err := ioutil.ReadAll(rc)
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(data), "other data")
+		})
+
+		it("copies reused layers to the first image when the cache is no longer split", func() {
+			next := cache.NewRemoteImageCache(factory, factory.saved("fake-image"))
+			h.AssertNil(t, next.ReuseLayer("some_identifier", layerSHAs[2]))
+			h.AssertNil(t, next.SetMetadata(cache.Metadata{}))
+			h.AssertNil(t, next.Commit())
+
+			main := factory.saved("fake-image")
+			h.AssertEq(t, len(main.ReusedLayers()), 0)
+			rc, err := main.GetLayer(layerSHAs[2])
+			h.AssertNil(t, err)
+			rc.Close()
+
+			meta, err := cache.NewRemoteImageCache(factory, main).RetrieveMetadata()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(meta.Pages), 0)
+		})
+	})
+
+	when("#WithRetries", func() {
+		var (
+			factory  *flakyFactory
+			newImage *flakyImage
+		)
+
+		it.Before(func() {
+			newImage = &flakyImage{Image: fakeNewImage}
+			factory = &flakyFactory{t: t, images: map[string]*flakyImage{"fake-image": newImage}}
+		})
+
+		it("retries failed saves", func() {
+			newImage.failures = 2
+			subject = cache.NewRemoteImageCache(factory, fakeOriginalImage, cache.WithRetries(2, 0))
+
+			h.AssertNil(t, subject.Commit())
+			h.AssertEq(t, newImage.attempts, 3)
+			h.AssertEq(t, fakeNewImage.IsSaved(), true)
+		})
+
+		it("fails when every retry fails", func() {
+			newImage.failures = 3
+			subject = cache.NewRemoteImageCache(factory, fakeOriginalImage, cache.WithRetries(2, 0))
+
+			h.AssertError(t, subject.Commit(), "some push error")
+			h.AssertEq(t, newImage.attempts, 3)
+			h.AssertEq(t, fakeNewImage.IsSaved(), false)
+		})
+
+		it("does not retry errors that are not retryable", func() {
+			newImage.failures = 1
+			newImage.permanent = true
+			subject = cache.NewRemoteImageCache(factory, fakeOriginalImage, cache.WithRetries(2, 0))
+
+			h.AssertError(t, subject.Commit(), "some push error")
+			h.AssertEq(t, newImage.attempts, 1)
+			h.AssertEq(t, fakeNewImage.IsSaved(), false)
+		})
+
+		it("resumes failed commits with the images that were not saved", func() {
+			h.AssertNil(t, fakeOriginalImage.Delete())
+			newImage.failures = 1
+			page := &flakyImage{Image: fakes.NewImage(t, "fake-image:latest-page-1", "", "")}
+			factory.images[page.Name()] = page
+			subject = cache.NewRemoteImageCache(factory, fakeOriginalImage, cache.WithPageSize(15), cache.WithRetries(0, 0))
+			h.AssertNil(t, subject.AddLayer("some_identifier", testLayerSHA, testLayerTarPath))
+			otherLayerPath := filepath.Join(tmpDir, "other-layer.tar")
+			h.AssertNil(t, ioutil.WriteFile(otherLayerPath, []byte("other data"), 0666))
+			h.AssertNil(t, subject.AddLayer("other_identifier", "sha256:"+h.ComputeSHA256ForFile(t, otherLayerPath), otherLayerPath))
+			h.AssertNil(t, subject.SetMetadata(cache.Metadata{}))
+
+			h.AssertError(t, subject.Commit(), "some push error")
+			h.AssertNil(t, subject.Commit())
+			h.AssertEq(t, page.attempts, 1)
+			h.AssertEq(t, newImage.attempts, 2)
+		})
+	})
+
+	when("#PreviousImageName", func() {
+		it("appends to the tag of the name", func() {
+			for imageName, expected := range map[string]string{
+				"some-cache":                      "some-cache:latest-previous",
+				"some-cache:v1":                   "some-cache:v1-previous",
+				"localhost:5000/some-cache":       "localhost:5000/some-cache:latest-previous",
+				"registry.com/org/some-cache:cnb": "registry.com/org/some-cache:cnb-previous",
+			} {
+				previous, err := cache.PreviousImageName(imageName)
+				h.AssertNil(t, err)
+				h.AssertEq(t, previous, expected)
+			}
+		})
+
+		it("fails for digest references", func() {
+			_, err := cache.PreviousImageName("some-cache@sha256:" + h.ComputeSHA256ForFile(t, testLayerTarPath))
+			h.AssertError(t, err, "parsing cache image name")
+		})
+	})
+}
+
+// fakeRemoteFactory keeps the images it creates by name, so that the image
+// last saved with a name can be read again, and by the digest it was saved
+// with.
+type fakeRemoteFactory struct {
+	t       *testing.T
+	images  map[string][]*fakes.Image
+	digests map[string]*fakes.Image
+	failing map[string]bool
+}
+
+func newFakeRemoteFactory(t *testing.T, images ...*fakes.Image) *fakeRemoteFactory {
+	f := &fakeRemoteFactory{t: t, images: map[string][]*fakes.Image{}, digests: map[string]*fakes.Image{}, failing: map[string]bool{}}
+	for _, img := range images {
+		f.images[img.Name()] = append(f.images[img.Name()], img)
+	}
+	return f
+}
+
+func (f *fakeRemoteFactory) NewEmptyRemote(name string) image.Image {
+	if imgs := f.images[name]; len(imgs) > 0 && !imgs[len(imgs)-1].IsSaved() {
+		return &digestImage{Image: imgs[len(imgs)-1], factory: f}
+	}
+	img := fakes.NewImage(f.t, name, "", "")
+	f.images[name] = append(f.images[name], img)
+	return &digestImage{Image: img, factory: f}
+}
+
+func (f *fakeRemoteFactory) NewRemote(name string) (image.Image, error) {
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		if img, ok := f.digests[name[i+1:]]; ok {
+			return img, nil
+		}
+	} else if img := f.saved(name); img != nil {
+		return img, nil
+	}
+	return nil, fmt.Errorf("image '%s' does not exist", name)
+}
+
+func (f *fakeRemoteFactory) saved(name string) *fakes.Image {
+	imgs := f.images[name]
+	for i := len(imgs) - 1; i >= 0; i-- {
+		if imgs[i].IsSaved() {
+			return imgs[i]
+		}
+	}
+	return nil
+}
+
+func pageLayers(meta cache.Metadata) []map[string]int64 {
+	var layers []map[string]int64
+	for _, page := range meta.Pages {
+		layers = append(layers, page.Layers)
+	}
+	return layers
+}
+
+// digestImage is saved with a digest that is unique to it, unless saves of
+// its name fail.
+type digestImage struct {
+	*fakes.Image
+	factory *fakeRemoteFactory
+}
+
+func (d *digestImage) Save() (string, error) {
+	if d.factory.failing[d.Name()] {
+		return "", fmt.Errorf("some push error")
+	}
+	if _, err := d.Image.Save(); err != nil {
+		return "", err
+	}
+	digest := fmt.Sprintf("sha256:%064x", len(d.factory.digests)+1)
+	d.factory.digests[digest] = d.Image
+	return digest, nil
+}
+
+// flakyImage fails to save the first failures times it is saved, with an
+// error that is retryable unless permanent is set.
+type flakyImage struct {
+	*fakes.Image
+	failures  int
+	attempts  int
+	permanent bool
+}
+
+func (f *flakyImage) Save() (string, error) {
+	f.attempts++
+	if f.attempts <= f.failures {
+		return "", &pushError{retryable: !f.permanent}
+	}
+	return f.Image.Save()
+}
+
+type pushError struct {
+	retryable bool
+}
+
+func (e *pushError) Error() string   { return "some push error" }
+func (e *pushError) Retryable() bool { return e.retryable }
+
+type flakyFactory struct {
+	t      *testing.T
+	images map[string]*flakyImage
+}
+
+func (f *flakyFactory) NewEmptyRemote(name string) image.Image {
+	if img, ok := f.images[name]; ok && !img.IsSaved() {
+		return img
+	}
+	return fakes.NewImage(f.t, name, "", "")
+}
+
+func (f *flakyFactory) NewRemote(name string) (image.Image, error) {
+	return nil, fmt.Errorf("image '%s' does not exist", name)
 }
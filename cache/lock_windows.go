@@ -0,0 +1,9 @@
+package cache
+
+import "os"
+
+// lockFile does nothing, as cache directories are not shared between
+// builds on Windows.
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
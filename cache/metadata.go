@@ -1,13 +1,83 @@
 package cache
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
 	"github.com/buildpack/lifecycle/metadata"
 )
 
-const MetadataLabel = "io.buildpacks.lifecycle.cache.metadata"
+const (
+	MetadataLabel = "io.buildpacks.lifecycle.cache.metadata"
+
+	// MetadataVersion is the schema version of the cache metadata written by
+	// this lifecycle. Metadata without a version has version 1.
+	MetadataVersion = 2
+)
 
+// Metadata is the cache metadata, stored in the MetadataLabel label of cache
+// images and in the file of that name in cache directories. It maps each
+// buildpack's cached layers to the SHAs of their tars, whose sizes and last
+// cached times are recorded by SHA.
 type Metadata struct {
-	Buildpacks []metadata.BuildpackMetadata `json:"buildpacks"`
+	SchemaVersion int                          `json:"schemaVersion"`
+	Buildpacks    []metadata.BuildpackMetadata `json:"buildpacks"`
+
+	// Accessed records when each layer, by SHA, was last cached by a build.
+	Accessed map[string]time.Time `json:"accessed,omitempty"`
+
+	// Pages records the layers in each image of a cache split across images.
+	// The first page is the image with the metadata, and the others are named
+	// by PageImageName.
+	Pages []Page `json:"pages,omitempty"`
+}
+
+// ParseMetadata decodes cache metadata written by this or an earlier
+// lifecycle. Metadata that is malformed or has a newer schema version returns
+// a *metadata.IncompatibleError, and is ignored by the restorer and cacher.
+func ParseMetadata(contents []byte) (Metadata, error) {
+	if len(contents) == 0 {
+		return Metadata{}, nil
+	}
+	meta := Metadata{}
+	if err := json.Unmarshal(contents, &meta); err != nil {
+		return Metadata{}, &metadata.IncompatibleError{Err: err}
+	}
+	if meta.SchemaVersion == 0 {
+		meta.SchemaVersion = 1
+	}
+	if meta.SchemaVersion < 0 {
+		return Metadata{}, &metadata.IncompatibleError{Err: fmt.Errorf("invalid schema version %d", meta.SchemaVersion)}
+	}
+	if meta.SchemaVersion > MetadataVersion {
+		return Metadata{}, &metadata.IncompatibleError{Err: fmt.Errorf("schema version %d is newer than supported version %d", meta.SchemaVersion, MetadataVersion)}
+	}
+	if err := meta.validate(); err != nil {
+		return Metadata{}, &metadata.IncompatibleError{Err: err}
+	}
+	meta.SchemaVersion = MetadataVersion
+	return meta, nil
+}
+
+func (m *Metadata) validate() error {
+	ids := map[string]bool{}
+	for _, bp := range m.Buildpacks {
+		if bp.ID == "" {
+			return fmt.Errorf("buildpack without an ID")
+		}
+		if ids[bp.ID] {
+			return fmt.Errorf("buildpack '%s' is listed more than once", bp.ID)
+		}
+		ids[bp.ID] = true
+		for name, layer := range bp.Layers {
+			if layer.SHA == "" {
+				return fmt.Errorf("layer '%s:%s' has no SHA", bp.ID, name)
+			}
+		}
+	}
+	return nil
 }
 
 func (m *Metadata) MetadataForBuildpack(id string) metadata.BuildpackMetadata {
@@ -18,3 +88,51 @@ func (m *Metadata) MetadataForBuildpack(id string) metadata.BuildpackMetadata {
 	}
 	return metadata.BuildpackMetadata{}
 }
+
+// LayerInfo describes a layer tar in a cache.
+type LayerInfo struct {
+	SHA string
+
+	// Layers identifies each buildpack layer with the SHA as
+	// "<buildpack ID>:<layer name>".
+	Layers []string
+
+	// Size is the size of the tar in bytes, or -1 if the cache does not know it.
+	Size int64
+
+	// Accessed is when a build last cached the layer, or zero if that is not
+	// recorded.
+	Accessed time.Time
+}
+
+// layerInfos describes the layers in m, sorted by SHA, without their sizes.
+func (m *Metadata) layerInfos() []LayerInfo {
+	bySHA := map[string]*LayerInfo{}
+	for _, bp := range m.Buildpacks {
+		for name, layer := range bp.Layers {
+			info, ok := bySHA[layer.SHA]
+			if !ok {
+				info = &LayerInfo{SHA: layer.SHA, Size: -1, Accessed: m.Accessed[layer.SHA]}
+				bySHA[layer.SHA] = info
+			}
+			info.Layers = append(info.Layers, bp.ID+":"+name)
+		}
+	}
+	infos := make([]LayerInfo, 0, len(bySHA))
+	for _, info := range bySHA {
+		sort.Strings(info.Layers)
+		infos = append(infos, *info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].SHA < infos[j].SHA })
+	return infos
+}
+
+// Page lists the layers in one image of a cache split across images, with
+// the sizes of their tars by SHA. Digest is the digest the page was saved
+// with, or its ID in the daemon, so that the page is read as it was when the
+// cache image referring to it was saved, even after a later commit replaces
+// its tag.
+type Page struct {
+	Layers map[string]int64 `json:"layers"`
+	Digest string           `json:"digest,omitempty"`
+}
@@ -0,0 +1,66 @@
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/cache"
+	"github.com/buildpack/lifecycle/metadata"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestMetadata(t *testing.T) {
+	spec.Run(t, "Metadata", testMetadata, spec.Report(report.Terminal{}))
+}
+
+func testMetadata(t *testing.T, when spec.G, it spec.S) {
+	when("#ParseMetadata", func() {
+		assertIncompatible := func(contents, expected string) {
+			t.Helper()
+			_, err := cache.ParseMetadata([]byte(contents))
+			if _, ok := err.(*metadata.IncompatibleError); !ok {
+				t.Fatalf("Expected an incompatible error, got: %v", err)
+			}
+			h.AssertError(t, err, expected)
+		}
+
+		it("returns empty metadata for an empty label", func() {
+			meta, err := cache.ParseMetadata(nil)
+			h.AssertNil(t, err)
+			h.AssertEq(t, meta.SchemaVersion, 0)
+		})
+
+		it("reads metadata without a schema version", func() {
+			meta, err := cache.ParseMetadata([]byte(`{"buildpacks": [{"key": "some-buildpack", "layers": {"some-layer": {"sha": "some-layer-sha"}}}]}`))
+			h.AssertNil(t, err)
+			h.AssertEq(t, meta.SchemaVersion, cache.MetadataVersion)
+			h.AssertEq(t, meta.MetadataForBuildpack("some-buildpack").Layers["some-layer"].SHA, "some-layer-sha")
+		})
+
+		it("returns an incompatible error for a newer schema version", func() {
+			assertIncompatible(`{"schemaVersion": 99}`, "schema version 99 is newer than supported version 2")
+		})
+
+		it("returns an incompatible error for an invalid schema version", func() {
+			assertIncompatible(`{"schemaVersion": -1}`, "invalid schema version -1")
+		})
+
+		it("returns an incompatible error for malformed metadata", func() {
+			assertIncompatible(`{"buildpacks": "some-buildpack"}`, "incompatible metadata")
+		})
+
+		it("returns an incompatible error for buildpacks without IDs", func() {
+			assertIncompatible(`{"buildpacks": [{"layers": {}}]}`, "buildpack without an ID")
+		})
+
+		it("returns an incompatible error for buildpacks listed twice", func() {
+			assertIncompatible(`{"buildpacks": [{"key": "some-buildpack"}, {"key": "some-buildpack"}]}`, "buildpack 'some-buildpack' is listed more than once")
+		})
+
+		it("returns an incompatible error for layers without SHAs", func() {
+			assertIncompatible(`{"buildpacks": [{"key": "some-buildpack", "layers": {"some-layer": {"launch": true}}}]}`, "layer 'some-buildpack:some-layer' has no SHA")
+		})
+	})
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package cache
+
+import "os"
+
+// syncDir flushes the entries of dir to disk, so that renames into it are
+// durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,7 @@
+package cache
+
+// syncDir does nothing, as directories cannot be flushed on Windows. NTFS
+// journals renames.
+func syncDir(dir string) error {
+	return nil
+}
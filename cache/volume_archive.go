@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Export writes the committed cache to w as a tar of the layer tars and the
+// metadata, which Import reads into a cache in another directory. Extracted
+// layers are not written, as they are extracted again when needed.
+func (c *VolumeCache) Export(w io.Writer) error {
+	fis, err := ioutil.ReadDir(c.committedDir)
+	if err != nil {
+		return errors.Wrap(err, "reading committed cache")
+	}
+	tw := tar.NewWriter(w)
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || !isArchiveEntry(fi.Name()) {
+			continue
+		}
+		if err := exportFile(tw, filepath.Join(c.committedDir, fi.Name()), fi); err != nil {
+			return errors.Wrapf(err, "exporting '%s'", fi.Name())
+		}
+	}
+	return tw.Close()
+}
+
+func exportFile(tw *tar.Writer, path string, fi os.FileInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := tw.WriteHeader(&tar.Header{Name: fi.Name(), Mode: 0644, Size: fi.Size(), Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Import replaces the committed cache with the cache exported to r. Staged
+// changes are discarded. Layer tars that do not match their SHA are rejected,
+// and the committed cache is left unchanged if any entry cannot be read.
+func (c *VolumeCache) Import(r io.Reader) error {
+	if c.shared {
+		return errReadOnly
+	}
+	if err := c.setupStagingDir(); err != nil {
+		return errors.Wrap(err, "clearing staging directory")
+	}
+	if err := c.importEntries(tar.NewReader(r)); err != nil {
+		c.Rollback()
+		return err
+	}
+	return c.Commit()
+}
+
+func (c *VolumeCache) importEntries(tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "reading cache archive")
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return errors.Errorf("unexpected entry '%s' in cache archive", hdr.Name)
+		}
+		if !isArchiveEntry(hdr.Name) {
+			return errors.Errorf("unexpected file '%s' in cache archive", hdr.Name)
+		}
+		if hdr.Name == MetadataLabel {
+			err = importFile(tr, filepath.Join(c.stagingDir, hdr.Name), "")
+		} else {
+			sha := strings.TrimSuffix(hdr.Name, ".tar")
+			err = c.stageLayer(sha, func(path string) error { return importFile(tr, path, sha) })
+		}
+		if err != nil {
+			return errors.Wrapf(err, "importing '%s'", hdr.Name)
+		}
+	}
+}
+
+// importFile writes r to path, checking that its digest is sha unless sha is
+// empty.
+func importFile(r io.Reader, path, sha string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hasher), r); err != nil {
+		return err
+	}
+	if sha == "" {
+		return nil
+	}
+	if actual := digest(hasher); actual != sha {
+		return &DigestMismatchError{SHA: sha, Actual: actual}
+	}
+	return nil
+}
+
+// isArchiveEntry reports whether name is a file of the committed cache that
+// belongs in an exported cache.
+func isArchiveEntry(name string) bool {
+	return name == MetadataLabel || (strings.HasSuffix(name, ".tar") && shaPattern.MatchString(strings.TrimSuffix(name, ".tar")))
+}
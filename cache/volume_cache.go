@@ -1,12 +1,22 @@
 package cache
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/archive"
+	"github.com/buildpack/lifecycle/image"
+	"github.com/buildpack/lifecycle/metadata"
 )
 
 type VolumeCache struct {
@@ -14,9 +24,18 @@ type VolumeCache struct {
 	backupDir    string
 	stagingDir   string
 	committedDir string
+	blobsDir     string
+	generations  int
+	shared       bool
+	lock         *os.File
 }
 
-func NewVolumeCache(dir string) (*VolumeCache, error) {
+var errReadOnly = errors.New("cache is read-only")
+
+// NewVolumeCache opens the cache in dir, waiting for a lock on it that is
+// held until Close. The lock is exclusive unless the cache is opened with
+// WithSharedLock.
+func NewVolumeCache(dir string, ops ...func(*VolumeCache)) (*VolumeCache, error) {
 	if _, err := os.Stat(dir); err != nil {
 		return nil, err
 	}
@@ -26,21 +45,73 @@ func NewVolumeCache(dir string) (*VolumeCache, error) {
 		backupDir:    filepath.Join(dir, "committed-backup"),
 		stagingDir:   filepath.Join(dir, "staging"),
 		committedDir: filepath.Join(dir, "committed"),
+		blobsDir:     filepath.Join(dir, "blobs"),
+	}
+	for _, op := range ops {
+		op(c)
+	}
+
+	lock, err := os.OpenFile(filepath.Join(dir, "lock"), os.O_RDONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening cache lock")
+	}
+	if err := lockFile(lock, !c.shared); err != nil {
+		lock.Close()
+		return nil, errors.Wrap(err, "locking cache")
 	}
+	c.lock = lock
+	if c.shared {
+		return c, nil
+	}
+
+	if err := c.setup(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// WithSharedLock opens the cache for reading only, sharing it with other
+// readers. Writers wait until every reader has closed the cache.
+func WithSharedLock(c *VolumeCache) {
+	c.shared = true
+}
+
+// Close releases the lock on the cache.
+func (c *VolumeCache) Close() error {
+	return c.lock.Close()
+}
 
+func (c *VolumeCache) setup() error {
 	if err := c.setupStagingDir(); err != nil {
-		return nil, errors.Wrapf(err, "initializing staging directory '%s'", c.stagingDir)
+		return errors.Wrapf(err, "initializing staging directory '%s'", c.stagingDir)
+	}
+
+	if err := c.restoreBackup(); err != nil {
+		return errors.Wrapf(err, "restoring backup directory '%s'", c.backupDir)
 	}
 
 	if err := os.RemoveAll(c.backupDir); err != nil {
-		return nil, errors.Wrapf(err, "removing backup directory '%s'", c.backupDir)
+		return errors.Wrapf(err, "removing backup directory '%s'", c.backupDir)
 	}
 
 	if err := os.MkdirAll(c.committedDir, 0777); err != nil {
-		return nil, errors.Wrapf(err, "creating committed directory '%s'", c.committedDir)
+		return errors.Wrapf(err, "creating committed directory '%s'", c.committedDir)
 	}
 
-	return c, nil
+	if err := os.MkdirAll(c.blobsDir, 0777); err != nil {
+		return errors.Wrapf(err, "creating blobs directory '%s'", c.blobsDir)
+	}
+
+	return nil
+}
+
+// WithGenerations keeps the n caches committed before the current one, so
+// that the cache can be rolled back to them with RollbackTo.
+func WithGenerations(n int) func(*VolumeCache) {
+	return func(c *VolumeCache) {
+		c.generations = n
+	}
 }
 
 func (c *VolumeCache) Name() string {
@@ -48,6 +119,9 @@ func (c *VolumeCache) Name() string {
 }
 
 func (c *VolumeCache) SetMetadata(metadata Metadata) error {
+	if c.shared {
+		return errReadOnly
+	}
 	metadataPath := filepath.Join(c.stagingDir, MetadataLabel)
 	file, err := os.Create(metadataPath)
 	if err != nil {
@@ -73,51 +147,566 @@ func (c *VolumeCache) RetrieveMetadata() (Metadata, error) {
 	}
 	defer file.Close()
 
-	metadata := Metadata{}
-	if json.NewDecoder(file).Decode(&metadata) != nil {
-		return Metadata{}, nil
+	contents, err := ioutil.ReadAll(file)
+	if err != nil {
+		return Metadata{}, errors.Wrapf(err, "reading metadata file '%s'", metadataPath)
+	}
+	return ParseMetadata(contents)
+}
+
+// committedMetadata is RetrieveMetadata, but reads metadata written by an
+// incompatible lifecycle as empty, reporting that it did so.
+func (c *VolumeCache) committedMetadata() (Metadata, bool, error) {
+	meta, err := c.RetrieveMetadata()
+	if _, ok := err.(*metadata.IncompatibleError); ok {
+		return Metadata{SchemaVersion: MetadataVersion}, true, nil
 	}
-	return metadata, nil
+	return meta, false, err
 }
 
 func (c *VolumeCache) AddLayer(identifier string, sha string, tarPath string) error {
-	if err := copyFile(tarPath, filepath.Join(c.stagingDir, sha+".tar")); err != nil {
+	if c.shared {
+		return errReadOnly
+	}
+	if err := c.stageLayer(sha, func(path string) error { return archive.CopyFile(tarPath, path) }); err != nil {
+		return errors.Wrapf(err, "caching layer '%s' (%s)", identifier, sha)
+	}
+	return nil
+}
+
+func (c *VolumeCache) AddLayerFromOpener(identifier string, sha string, size int64, open image.Opener) error {
+	if c.shared {
+		return errReadOnly
+	}
+	if err := c.stageLayer(sha, func(path string) error { return copyFromOpener(open, path) }); err != nil {
 		return errors.Wrapf(err, "caching layer '%s' (%s)", identifier, sha)
 	}
 	return nil
 }
 
+// stageLayer adds the layer tar with the given SHA to the staging directory
+// as a link to a blob shared by every cache in the directory that has the
+// layer, so that identical layers are stored once. The blob is written to
+// path by write if there is none yet.
+func (c *VolumeCache) stageLayer(sha string, write func(path string) error) error {
+	staged := filepath.Join(c.stagingDir, sha+".tar")
+	if _, err := os.Stat(staged); err == nil {
+		// layers with the same SHA have the same contents
+		return nil
+	}
+	blob := filepath.Join(c.blobsDir, sha+".tar")
+	if _, err := os.Stat(blob); os.IsNotExist(err) {
+		tmp := blob + ".tmp"
+		if err := write(tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, blob); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return linkOrCopy(blob, staged)
+}
+
+// linkOrCopy hardlinks from to to, copying it if it cannot be linked.
+func linkOrCopy(from, to string) error {
+	if err := os.Link(from, to); err == nil {
+		return nil
+	}
+	return archive.CopyFile(from, to)
+}
+
+// pruneBlobs removes the blobs of layers that are no longer in the staged,
+// committed or kept caches.
+func (c *VolumeCache) pruneBlobs() error {
+	refs := map[string]int{}
+	dirs := []string{c.stagingDir, c.committedDir}
+	for n := 1; ; n++ {
+		if _, err := os.Stat(c.generationDir(n)); err != nil {
+			break
+		}
+		dirs = append(dirs, c.generationDir(n))
+	}
+	for _, dir := range dirs {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, fi := range fis {
+			refs[fi.Name()]++
+		}
+	}
+	blobs, err := ioutil.ReadDir(c.blobsDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, fi := range blobs {
+		if refs[fi.Name()] > 0 {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.blobsDir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *VolumeCache) ReuseLayer(identifier string, sha string) error {
-	if err := copyFile(filepath.Join(c.committedDir, sha+".tar"), filepath.Join(c.stagingDir, sha+".tar")); err != nil {
+	if c.shared {
+		return errReadOnly
+	}
+	committed := filepath.Join(c.committedDir, sha+".tar")
+	if err := c.stageLayer(sha, func(path string) error { return linkOrCopy(committed, path) }); err != nil {
 		return errors.Wrapf(err, "reusing layer '%s' (%s)", identifier, sha)
 	}
+	tree := filepath.Join(c.committedDir, sha)
+	if _, err := os.Stat(tree); err == nil {
+		if err := archive.LinkTree(tree, filepath.Join(c.stagingDir, sha)); err != nil {
+			os.RemoveAll(filepath.Join(c.stagingDir, sha))
+		}
+	}
+	return nil
+}
+
+// LinkLayer restores the directory at layerPath from the layer with the
+// given SHA by reflinking files from an extracted copy of the layer kept
+// alongside its tar, extracting it first if needed. Files are copied instead
+// where the filesystem does not support reflinks, so the restored layer never
+// shares changes with the cache. Files in the layer outside of layerPath are
+// not restored. The restored files are owned by uid and gid.
+func (c *VolumeCache) LinkLayer(sha string, layerPath string, uid, gid int) error {
+	tree := filepath.Join(c.committedDir, sha)
+	if _, err := os.Stat(tree); os.IsNotExist(err) {
+		if err := c.extractLayer(sha, tree); err != nil {
+			return errors.Wrapf(err, "extracting layer with SHA '%s'", sha)
+		}
+	} else if err != nil {
+		return err
+	}
+	treePath := filepath.Join(tree, layerPath[len(filepath.VolumeName(layerPath)):])
+	if err := archive.CloneTree(treePath, layerPath); err != nil {
+		return errors.Wrapf(err, "linking layer with SHA '%s'", sha)
+	}
+	if err := chownTree(layerPath, uid, gid); err != nil {
+		os.RemoveAll(layerPath)
+		return errors.Wrapf(err, "chowning layer with SHA '%s' to '%d/%d'", sha, uid, gid)
+	}
 	return nil
 }
 
+// extractLayer extracts the layer with the given SHA to tree. Readers sharing
+// the cache may extract the same layer at once, so each extracts to its own
+// directory and the first to finish keeps its copy.
+func (c *VolumeCache) extractLayer(sha, tree string) error {
+	rc, err := c.RetrieveLayer(sha)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tmp, err := ioutil.TempDir(c.committedDir, sha+".tmp.")
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := archive.Untar(rc, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	// read the rest of the tar, so that its digest is checked
+	if _, err := io.Copy(ioutil.Discard, rc); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, tree); err != nil {
+		os.RemoveAll(tmp)
+		if _, statErr := os.Stat(tree); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// List describes the layers in the committed cache. Layers whose tars are
+// missing have a size of -1. Metadata written by an incompatible lifecycle
+// is read as empty.
+func (c *VolumeCache) List() ([]LayerInfo, error) {
+	meta, _, err := c.committedMetadata()
+	if err != nil {
+		return nil, err
+	}
+	infos := meta.layerInfos()
+	for i, info := range infos {
+		if !shaPattern.MatchString(info.SHA) {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(c.committedDir, info.SHA+".tar"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		infos[i].Size = fi.Size()
+	}
+	return infos, nil
+}
+
+// RetrieveLayer returns the layer tar with the given SHA. The tar is checked
+// against the SHA as it is read, and reading it fails with a
+// *DigestMismatchError at the end if it does not match.
 func (c *VolumeCache) RetrieveLayer(sha string) (io.ReadCloser, error) {
 	file, err := os.Open(filepath.Join(c.committedDir, sha+".tar"))
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, errors.Wrapf(err, "layer with SHA '%s' not found", sha)
+			return nil, &layerNotFoundError{sha: sha, err: err}
 		}
 		return nil, errors.Wrapf(err, "retrieving layer with SHA '%s'", sha)
 	}
-	return file, nil
+	if !shaPattern.MatchString(sha) {
+		file.Close()
+		return nil, errors.Errorf("invalid layer SHA '%s'", sha)
+	}
+	return newVerifyingReader(file, sha), nil
 }
 
+// Commit replaces the committed cache with the staged one. The staged files
+// are flushed to disk first, and the committed directory is swapped by
+// renames, so a crash leaves either the previous or the new cache in place.
+// The previous cache is kept as a generation if the cache keeps any.
 func (c *VolumeCache) Commit() error {
+	if c.shared {
+		return errReadOnly
+	}
+	if err := syncStaged(c.stagingDir); err != nil {
+		return errors.Wrap(err, "syncing staged cache")
+	}
 	if err := os.Rename(c.committedDir, c.backupDir); err != nil {
 		return errors.Wrap(err, "backing up cache")
 	}
-	defer os.RemoveAll(c.backupDir)
 
 	if err := os.Rename(c.stagingDir, c.committedDir); err != nil {
 		if err := os.Rename(c.backupDir, c.committedDir); err != nil {
 			return errors.Wrap(err, "rolling back cache")
 		}
+		return errors.Wrap(err, "committing cache")
+	}
+	if err := syncDir(c.dir); err != nil {
+		return errors.Wrap(err, "syncing cache")
+	}
+	if err := c.rotateGenerations(); err != nil {
+		return errors.Wrap(err, "keeping previous cache")
+	}
+	if err := c.setupStagingDir(); err != nil {
+		return err
+	}
+	return c.pruneBlobs()
+}
+
+// Generations returns the number of previous caches that are kept.
+func (c *VolumeCache) Generations() (int, error) {
+	n := 0
+	for {
+		if _, err := os.Stat(c.generationDir(n + 1)); os.IsNotExist(err) {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// RollbackTo replaces the committed cache with a previous one, where
+// generation 1 is the cache committed before the current one. The current
+// cache, newer generations and any staged changes are discarded.
+func (c *VolumeCache) RollbackTo(generation int) error {
+	if c.shared {
+		return errReadOnly
+	}
+	genDir := c.generationDir(generation)
+	if _, err := os.Stat(genDir); generation < 1 || os.IsNotExist(err) {
+		return errors.Errorf("cache generation %d not found", generation)
+	} else if err != nil {
+		return err
+	}
+	if err := os.Rename(c.committedDir, c.backupDir); err != nil {
+		return errors.Wrap(err, "backing up cache")
+	}
+	if err := os.Rename(genDir, c.committedDir); err != nil {
+		if err := os.Rename(c.backupDir, c.committedDir); err != nil {
+			return errors.Wrap(err, "restoring cache")
+		}
+		return errors.Wrapf(err, "rolling back to cache generation %d", generation)
+	}
+	if err := syncDir(c.dir); err != nil {
+		return errors.Wrap(err, "syncing cache")
+	}
+	if err := os.RemoveAll(c.backupDir); err != nil {
+		return err
+	}
+	for n := 1; n < generation; n++ {
+		if err := os.RemoveAll(c.generationDir(n)); err != nil {
+			return err
+		}
+	}
+	for n := generation + 1; ; n++ {
+		if err := os.Rename(c.generationDir(n), c.generationDir(n-generation)); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := c.setupStagingDir(); err != nil {
+		return err
+	}
+	return c.pruneBlobs()
+}
+
+func (c *VolumeCache) generationDir(n int) string {
+	return filepath.Join(c.dir, fmt.Sprintf("committed-%d", n))
+}
+
+// rotateGenerations keeps the backup of the previous cache as generation 1,
+// shifting older generations and removing those beyond the number kept.
+func (c *VolumeCache) rotateGenerations() error {
+	if c.generations < 1 {
+		if err := os.RemoveAll(c.backupDir); err != nil {
+			return err
+		}
+	} else {
+		if err := os.RemoveAll(c.generationDir(c.generations)); err != nil {
+			return err
+		}
+		for n := c.generations - 1; n >= 1; n-- {
+			if err := os.Rename(c.generationDir(n), c.generationDir(n+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(c.backupDir, c.generationDir(1)); err != nil {
+			return err
+		}
+	}
+	for n := c.generations + 1; ; n++ {
+		genDir := c.generationDir(n)
+		if _, err := os.Stat(genDir); os.IsNotExist(err) {
+			return nil
+		}
+		if err := os.RemoveAll(genDir); err != nil {
+			return err
+		}
+	}
+}
+
+// Verify checks that the tar of every layer in the committed metadata exists
+// and matches the layer's SHA. Layers that do not are removed from the
+// metadata and the cache, so that they are rebuilt instead of failing to
+// restore. It returns a description of each removed layer. Metadata written
+// by an incompatible lifecycle is cleared.
+func (c *VolumeCache) Verify() ([]string, error) {
+	if c.shared {
+		return nil, errReadOnly
+	}
+	meta, incompatible, err := c.committedMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if incompatible {
+		return nil, c.writeCommittedMetadata(meta)
+	}
+	var pruned []string
+	for _, bp := range meta.Buildpacks {
+		for name, layer := range bp.Layers {
+			err := c.verifyLayer(layer.SHA)
+			if err == nil {
+				continue
+			}
+			pruned = append(pruned, fmt.Sprintf("%s:%s: %s", bp.ID, name, err))
+			delete(bp.Layers, name)
+			if !shaPattern.MatchString(layer.SHA) {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(c.committedDir, layer.SHA+".tar")); err != nil {
+				return pruned, err
+			}
+			if err := os.RemoveAll(filepath.Join(c.committedDir, layer.SHA)); err != nil {
+				return pruned, err
+			}
+			if err := os.RemoveAll(filepath.Join(c.blobsDir, layer.SHA+".tar")); err != nil {
+				return pruned, err
+			}
+		}
+	}
+	if len(pruned) == 0 {
+		return nil, nil
+	}
+	sort.Strings(pruned)
+	return pruned, c.writeCommittedMetadata(meta)
+}
+
+// Prune removes the layers that were last cached before the given time from
+// the committed cache, and returns a description of each removed layer.
+// Layers cached before times were recorded are kept, and age from now on.
+// Metadata written by an incompatible lifecycle is cleared.
+func (c *VolumeCache) Prune(before time.Time) ([]string, error) {
+	if c.shared {
+		return nil, errReadOnly
+	}
+	meta, incompatible, err := c.committedMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if incompatible {
+		return nil, c.writeCommittedMetadata(meta)
+	}
+	if meta.Accessed == nil {
+		meta.Accessed = map[string]time.Time{}
+	}
+	now := time.Now().UTC()
+	changed := false
+	var pruned []string
+	for _, bp := range meta.Buildpacks {
+		for name, layer := range bp.Layers {
+			accessed, ok := meta.Accessed[layer.SHA]
+			if !ok {
+				meta.Accessed[layer.SHA] = now
+				changed = true
+				continue
+			}
+			if !accessed.Before(before) {
+				continue
+			}
+			pruned = append(pruned, fmt.Sprintf("%s:%s: last cached %s", bp.ID, name, accessed.Format(time.RFC3339)))
+			delete(bp.Layers, name)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+
+	inUse := map[string]bool{}
+	for _, bp := range meta.Buildpacks {
+		for _, layer := range bp.Layers {
+			inUse[layer.SHA] = true
+		}
+	}
+	for sha := range meta.Accessed {
+		if inUse[sha] {
+			continue
+		}
+		delete(meta.Accessed, sha)
+		if !shaPattern.MatchString(sha) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.committedDir, sha+".tar")); err != nil {
+			return pruned, err
+		}
+		if err := os.RemoveAll(filepath.Join(c.committedDir, sha)); err != nil {
+			return pruned, err
+		}
+	}
+	sort.Strings(pruned)
+	if err := c.writeCommittedMetadata(meta); err != nil {
+		return pruned, err
+	}
+	return pruned, c.pruneBlobs()
+}
+
+var shaPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+func (c *VolumeCache) verifyLayer(sha string) error {
+	if !shaPattern.MatchString(sha) {
+		return fmt.Errorf("invalid layer SHA '%s'", sha)
+	}
+	f, err := os.Open(filepath.Join(c.committedDir, sha+".tar"))
+	if os.IsNotExist(err) {
+		return errors.New("layer tar is missing")
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return err
+	}
+	if actual := digest(hasher); actual != sha {
+		return &DigestMismatchError{SHA: sha, Actual: actual}
+	}
+	return nil
+}
+
+// writeCommittedMetadata replaces the committed metadata with meta by
+// renaming a new file over it.
+func (c *VolumeCache) writeCommittedMetadata(meta Metadata) error {
+	metadataPath := filepath.Join(c.committedDir, MetadataLabel)
+	tmp := metadataPath + ".tmp"
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return errors.Wrap(err, "marshalling metadata")
+	}
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	if err := syncFile(tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, metadataPath)
+}
+
+// restoreBackup moves the previous cache back into place when a commit was
+// interrupted after moving it aside but before the staged cache replaced it.
+func (c *VolumeCache) restoreBackup() error {
+	if _, err := os.Stat(c.committedDir); !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := os.Stat(c.backupDir); os.IsNotExist(err) {
 		return nil
+	} else if err != nil {
+		return err
 	}
+	return os.Rename(c.backupDir, c.committedDir)
+}
 
+// syncStaged flushes the files at the top of the staging directory, which are
+// the layer tars and metadata, and the directory itself to disk. Extracted
+// layer trees are not flushed, as they are links to committed files.
+func syncStaged(dir string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		if err := syncFile(filepath.Join(dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return syncDir(dir)
+}
+
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// Rollback discards any layers and metadata staged since the last commit.
+func (c *VolumeCache) Rollback() error {
+	if c.shared {
+		return nil
+	}
 	return c.setupStagingDir()
 }
 
@@ -128,8 +717,8 @@ func (c *VolumeCache) setupStagingDir() error {
 	return os.MkdirAll(c.stagingDir, 0777)
 }
 
-func copyFile(from, to string) error {
-	in, err := os.Open(from)
+func copyFromOpener(open image.Opener, to string) error {
+	in, err := open()
 	if err != nil {
 		return err
 	}
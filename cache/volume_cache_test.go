@@ -1,21 +1,34 @@
 package cache_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 
+	"github.com/buildpack/lifecycle/archive"
 	"github.com/buildpack/lifecycle/cache"
 	"github.com/buildpack/lifecycle/metadata"
 	h "github.com/buildpack/lifecycle/testhelpers"
 )
 
+// dummySHA is the digest of "dummy data".
+const dummySHA = "sha256:797bb0abff798d7200af7685dca7901edffc52bf26500d5bd97282658ee24152"
+
 func TestVolumeCache(t *testing.T) {
 	rand.Seed(time.Now().UTC().UnixNano())
 	spec.Run(t, "VolumeCache", testVolumeCache, spec.Parallel(), spec.Report(report.Terminal{}))
@@ -43,9 +56,13 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 		backupDir = filepath.Join(volumeDir, "committed-backup")
 		stagingDir = filepath.Join(volumeDir, "staging")
 		committedDir = filepath.Join(volumeDir, "committed")
+		subject = nil
 	})
 
 	it.After(func() {
+		if subject != nil {
+			subject.Close()
+		}
 		os.RemoveAll(tmpDir)
 	})
 
@@ -103,6 +120,7 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 
 		when("backup dir already exists", func() {
 			it.Before(func() {
+				h.AssertNil(t, os.MkdirAll(committedDir, 0777))
 				h.AssertNil(t, os.MkdirAll(backupDir, 0777))
 				h.AssertNil(t, ioutil.WriteFile(filepath.Join(backupDir, "some-layer.tar"), []byte("some data"), 0666))
 			})
@@ -119,6 +137,32 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 				}
 			})
 		})
+
+		when("a commit was interrupted after backing up the committed dir", func() {
+			it.Before(func() {
+				h.AssertNil(t, os.MkdirAll(backupDir, 0777))
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(backupDir, dummySHA+".tar"), []byte("dummy data"), 0666))
+				h.AssertNil(t, os.MkdirAll(stagingDir, 0777))
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(stagingDir, "other-layer.tar"), []byte("partial"), 0666))
+			})
+
+			it("restores the previous cache", func() {
+				var err error
+
+				subject, err = cache.NewVolumeCache(volumeDir)
+				h.AssertNil(t, err)
+
+				rc, err := subject.RetrieveLayer(dummySHA)
+				h.AssertNil(t, err)
+				defer rc.Close()
+				contents, err := ioutil.ReadAll(rc)
+				h.AssertNil(t, err)
+				h.AssertEq(t, string(contents), "dummy data")
+				if _, err := subject.RetrieveLayer("other-layer"); err == nil {
+					t.Fatal("expected the interrupted commit to be discarded")
+				}
+			})
+		})
 	})
 
 	when("VolumeCache", func() {
@@ -144,6 +188,7 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 
 				it("returns the metadata", func() {
 					expected := cache.Metadata{
+						SchemaVersion: cache.MetadataVersion,
 						Buildpacks: []metadata.BuildpackMetadata{{
 							ID:      "bp.id",
 							Version: "1.2.3",
@@ -170,10 +215,11 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 					h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, "io.buildpacks.lifecycle.cache.metadata"), []byte("garbage"), 0666))
 				})
 
-				it("returns empty metadata", func() {
-					meta, err := subject.RetrieveMetadata()
-					h.AssertNil(t, err)
-					h.AssertEq(t, len(meta.Buildpacks), 0)
+				it("returns an incompatible error", func() {
+					_, err := subject.RetrieveMetadata()
+					if _, ok := err.(*metadata.IncompatibleError); !ok {
+						t.Fatalf("Expected an incompatible error, got: %v", err)
+					}
 				})
 			})
 
@@ -189,11 +235,11 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 		when("#RetrieveLayer", func() {
 			when("layer exists", func() {
 				it.Before(func() {
-					h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, "some_sha.tar"), []byte("dummy data"), 0666))
+					h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, dummySHA+".tar"), []byte("dummy data"), 0666))
 				})
 
 				it("returns the layer's reader", func() {
-					rc, err := subject.RetrieveLayer("some_sha")
+					rc, err := subject.RetrieveLayer(dummySHA)
 					h.AssertNil(t, err)
 
 					bytes, err := ioutil.ReadAll(rc)
@@ -202,10 +248,91 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 				})
 			})
 
+			when("layer does not match its SHA", func() {
+				it.Before(func() {
+					h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, dummySHA+".tar"), []byte("corrupted data"), 0666))
+				})
+
+				it("fails to read the layer", func() {
+					rc, err := subject.RetrieveLayer(dummySHA)
+					h.AssertNil(t, err)
+					defer rc.Close()
+
+					_, err = ioutil.ReadAll(rc)
+					mismatch, ok := err.(*cache.DigestMismatchError)
+					if !ok {
+						t.Fatalf("expected a *cache.DigestMismatchError, got: %v", err)
+					}
+					h.AssertEq(t, mismatch.SHA, dummySHA)
+					h.AssertEq(t, errors.Is(err, cache.ErrCacheCorrupt), true)
+				})
+			})
+
+			when("layer SHA is not a digest", func() {
+				it("returns an error", func() {
+					h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, "some_sha.tar"), []byte("dummy data"), 0666))
+					_, err := subject.RetrieveLayer("some_sha")
+					h.AssertError(t, err, "invalid layer SHA 'some_sha'")
+				})
+			})
+
 			when("layer does not exist", func() {
 				it("returns an error", func() {
 					_, err := subject.RetrieveLayer("some_nonexistent_sha")
 					h.AssertError(t, err, "layer with SHA 'some_nonexistent_sha' not found")
+					h.AssertEq(t, errors.Is(err, cache.ErrLayerNotFound), true)
+				})
+			})
+		})
+
+		when("#LinkLayer", func() {
+			var (
+				layerDir string
+				layerSHA string
+			)
+
+			it.Before(func() {
+				layerDir = filepath.Join(tmpDir, "layer")
+				h.AssertNil(t, os.MkdirAll(layerDir, 0777))
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(layerDir, "some-file"), []byte("some-contents"), 0666))
+				tarPath := filepath.Join(tmpDir, "layer.tar")
+				var err error
+				layerSHA, err = archive.WriteTarFile(layerDir, tarPath, 0, 0)
+				h.AssertNil(t, err)
+				h.AssertNil(t, os.Rename(tarPath, filepath.Join(committedDir, layerSHA+".tar")))
+				h.AssertNil(t, os.RemoveAll(layerDir))
+			})
+
+			it("restores the layer from an extracted copy", func() {
+				h.AssertNil(t, subject.LinkLayer(layerSHA, layerDir, os.Getuid(), os.Getgid()))
+
+				contents, err := ioutil.ReadFile(filepath.Join(layerDir, "some-file"))
+				h.AssertNil(t, err)
+				h.AssertEq(t, string(contents), "some-contents")
+				_, err = os.Stat(filepath.Join(committedDir, layerSHA, layerDir, "some-file"))
+				h.AssertNil(t, err)
+			})
+
+			it("does not change the cache when restored files are modified", func() {
+				h.AssertNil(t, subject.LinkLayer(layerSHA, layerDir, os.Getuid(), os.Getgid()))
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(layerDir, "some-file"), []byte("new-contents"), 0666))
+
+				contents, err := ioutil.ReadFile(filepath.Join(committedDir, layerSHA, layerDir, "some-file"))
+				h.AssertNil(t, err)
+				h.AssertEq(t, string(contents), "some-contents")
+			})
+
+			when("running as root", func() {
+				it.Before(func() {
+					if os.Getuid() != 0 {
+						t.Skip()
+					}
+				})
+
+				it("chowns the restored files to the given UID/GID", func() {
+					h.AssertNil(t, subject.LinkLayer(layerSHA, layerDir, 1234, 4321))
+					h.AssertUidGid(t, layerDir, 1234, 4321)
+					h.AssertUidGid(t, filepath.Join(layerDir, "some-file"), 1234, 4321)
 				})
 			})
 		})
@@ -232,6 +359,7 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 					h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, "io.buildpacks.lifecycle.cache.metadata"), previousContents, 0666))
 
 					newMetadata = cache.Metadata{
+						SchemaVersion: cache.MetadataVersion,
 						Buildpacks: []metadata.BuildpackMetadata{{
 							ID: "new.bp.id",
 						}},
@@ -254,6 +382,7 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 				when("set without commit", func() {
 					it("retrieve returns the previous metadata", func() {
 						previousMetadata := cache.Metadata{
+							SchemaVersion: cache.MetadataVersion,
 							Buildpacks: []metadata.BuildpackMetadata{{
 								ID: "old.bp.id",
 							}},
@@ -278,12 +407,12 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 
 				when("add then commit", func() {
 					it("retrieve returns newly added layer", func() {
-						h.AssertNil(t, subject.AddLayer("some_identifier", "some_sha", tarPath))
+						h.AssertNil(t, subject.AddLayer("some_identifier", dummySHA, tarPath))
 
 						err := subject.Commit()
 						h.AssertNil(t, err)
 
-						rc, err := subject.RetrieveLayer("some_sha")
+						rc, err := subject.RetrieveLayer(dummySHA)
 						h.AssertNil(t, err)
 
 						bytes, err := ioutil.ReadAll(rc)
@@ -294,28 +423,82 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 
 				when("add without commit", func() {
 					it("retrieve returns not found error", func() {
-						h.AssertNil(t, subject.AddLayer("some_identifier", "some_sha", tarPath))
+						h.AssertNil(t, subject.AddLayer("some_identifier", dummySHA, tarPath))
+
+						_, err := subject.RetrieveLayer(dummySHA)
+						h.AssertError(t, err, "layer with SHA '"+dummySHA+"' not found")
+					})
+				})
+
+				when("the layer is added more than once", func() {
+					it("stores the layer once", func() {
+						h.AssertNil(t, subject.AddLayer("some_identifier", dummySHA, tarPath))
+						h.AssertNil(t, subject.AddLayer("other_identifier", dummySHA, tarPath))
+						h.AssertNil(t, subject.Commit())
+
+						h.AssertNil(t, subject.Close())
+						var err error
+						subject, err = cache.NewVolumeCache(volumeDir, cache.WithGenerations(1))
+						h.AssertNil(t, err)
+						h.AssertNil(t, subject.ReuseLayer("some_identifier", dummySHA))
+						h.AssertNil(t, subject.Commit())
 
-						_, err := subject.RetrieveLayer("some_sha")
-						h.AssertError(t, err, "layer with SHA 'some_sha' not found")
+						committed, err := os.Stat(filepath.Join(committedDir, dummySHA+".tar"))
+						h.AssertNil(t, err)
+						previous, err := os.Stat(filepath.Join(volumeDir, "committed-1", dummySHA+".tar"))
+						h.AssertNil(t, err)
+						blob, err := os.Stat(filepath.Join(volumeDir, "blobs", dummySHA+".tar"))
+						h.AssertNil(t, err)
+						h.AssertEq(t, os.SameFile(committed, blob), true)
+						h.AssertEq(t, os.SameFile(previous, blob), true)
 					})
 				})
 
+				when("no cache has the layer anymore", func() {
+					it("removes the stored layer", func() {
+						h.AssertNil(t, subject.AddLayer("some_identifier", dummySHA, tarPath))
+						h.AssertNil(t, subject.Commit())
+						_, err := os.Stat(filepath.Join(volumeDir, "blobs", dummySHA+".tar"))
+						h.AssertNil(t, err)
+
+						h.AssertNil(t, subject.Commit())
+						_, err = os.Stat(filepath.Join(volumeDir, "blobs", dummySHA+".tar"))
+						h.AssertEq(t, os.IsNotExist(err), true)
+					})
+				})
+			})
+
+			when("with #AddLayerFromOpener", func() {
+				it("retrieve returns the streamed layer after commit", func() {
+					open := func() (io.ReadCloser, error) {
+						return ioutil.NopCloser(strings.NewReader("dummy data")), nil
+					}
+					h.AssertNil(t, subject.AddLayerFromOpener("some_identifier", dummySHA, 10, open))
+					h.AssertNil(t, subject.Commit())
+
+					rc, err := subject.RetrieveLayer(dummySHA)
+					h.AssertNil(t, err)
+					defer rc.Close()
+
+					bytes, err := ioutil.ReadAll(rc)
+					h.AssertNil(t, err)
+					h.AssertEq(t, string(bytes), "dummy data")
+				})
 			})
 
 			when("with #ReuseLayer", func() {
 				it.Before(func() {
-					h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, "some_sha.tar"), []byte("dummy data"), 0666))
+					h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, dummySHA+".tar"), []byte("dummy data"), 0666))
 				})
 
 				when("reuse then commit", func() {
 					it("retrieve returns the reused layer", func() {
-						h.AssertNil(t, subject.ReuseLayer("some_identifier", "some_sha"))
+						h.AssertNil(t, subject.ReuseLayer("some_identifier", dummySHA))
 
 						err := subject.Commit()
 						h.AssertNil(t, err)
 
-						rc, err := subject.RetrieveLayer("some_sha")
+						rc, err := subject.RetrieveLayer(dummySHA)
 						h.AssertNil(t, err)
 
 						bytes, err := ioutil.ReadAll(rc)
@@ -326,9 +509,9 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 
 				when("reuse without commit", func() {
 					it("retrieve returns the previous layer", func() {
-						h.AssertNil(t, subject.ReuseLayer("some_identifier", "some_sha"))
+						h.AssertNil(t, subject.ReuseLayer("some_identifier", dummySHA))
 
-						rc, err := subject.RetrieveLayer("some_sha")
+						rc, err := subject.RetrieveLayer(dummySHA)
 						h.AssertNil(t, err)
 
 						bytes, err := ioutil.ReadAll(rc)
@@ -339,5 +522,489 @@ func testVolumeCache(t *testing.T, when spec.G, it spec.S) {
 
 			})
 		})
+
+		when("#RollbackTo", func() {
+			commit := func(bpID string) {
+				t.Helper()
+				h.AssertNil(t, subject.SetMetadata(cache.Metadata{Buildpacks: []metadata.BuildpackMetadata{{ID: bpID}}}))
+				h.AssertNil(t, subject.Commit())
+			}
+
+			assertCommitted := func(bpID string) {
+				t.Helper()
+				meta, err := subject.RetrieveMetadata()
+				h.AssertNil(t, err)
+				h.AssertEq(t, meta.Buildpacks[0].ID, bpID)
+			}
+
+			assertGenerations := func(expected int) {
+				t.Helper()
+				n, err := subject.Generations()
+				h.AssertNil(t, err)
+				h.AssertEq(t, n, expected)
+			}
+
+			it("does not keep previous caches by default", func() {
+				commit("first.bp.id")
+				commit("second.bp.id")
+				assertGenerations(0)
+				h.AssertError(t, subject.RollbackTo(1), "cache generation 1 not found")
+			})
+
+			when("generations are kept", func() {
+				it.Before(func() {
+					h.AssertNil(t, subject.Close())
+					var err error
+					subject, err = cache.NewVolumeCache(volumeDir, cache.WithGenerations(2))
+					h.AssertNil(t, err)
+					commit("first.bp.id")
+					commit("second.bp.id")
+					commit("third.bp.id")
+					commit("fourth.bp.id")
+				})
+
+				it("keeps the given number of previous caches", func() {
+					assertGenerations(2)
+					assertCommitted("fourth.bp.id")
+				})
+
+				it("rolls back to the previous cache", func() {
+					h.AssertNil(t, subject.RollbackTo(1))
+					assertCommitted("third.bp.id")
+					assertGenerations(1)
+					h.AssertNil(t, subject.RollbackTo(1))
+					assertCommitted("second.bp.id")
+					assertGenerations(0)
+				})
+
+				it("discards newer generations when rolling back to an older one", func() {
+					h.AssertNil(t, subject.RollbackTo(2))
+					assertCommitted("second.bp.id")
+					assertGenerations(0)
+				})
+
+				it("discards staged changes", func() {
+					h.AssertNil(t, ioutil.WriteFile(filepath.Join(stagingDir, "some-layer.tar"), []byte("some data"), 0666))
+					h.AssertNil(t, subject.RollbackTo(1))
+					_, err := os.Stat(filepath.Join(stagingDir, "some-layer.tar"))
+					h.AssertEq(t, os.IsNotExist(err), true)
+				})
+
+				it("removes generations beyond the number kept", func() {
+					h.AssertNil(t, subject.Close())
+					var err error
+					subject, err = cache.NewVolumeCache(volumeDir, cache.WithGenerations(1))
+					h.AssertNil(t, err)
+					commit("fifth.bp.id")
+					assertGenerations(1)
+					h.AssertNil(t, subject.RollbackTo(1))
+					assertCommitted("fourth.bp.id")
+				})
+
+				it("fails for generations that are not kept", func() {
+					h.AssertError(t, subject.RollbackTo(3), "cache generation 3 not found")
+					h.AssertError(t, subject.RollbackTo(0), "cache generation 0 not found")
+					assertCommitted("fourth.bp.id")
+				})
+			})
+		})
+
+		when("#WithSharedLock", func() {
+			it.Before(func() {
+				if runtime.GOOS == "windows" {
+					t.Skip("cache directories are not locked on Windows")
+				}
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(stagingDir, "some-layer.tar"), []byte("some data"), 0666))
+				h.AssertNil(t, subject.Close())
+				subject = nil
+			})
+
+			openShared := func() *cache.VolumeCache {
+				t.Helper()
+				reader, err := cache.NewVolumeCache(volumeDir, cache.WithSharedLock)
+				h.AssertNil(t, err)
+				return reader
+			}
+
+			it("shares the cache between readers", func() {
+				reader := openShared()
+				defer reader.Close()
+				other := openShared()
+				defer other.Close()
+			})
+
+			it("does not clear the staging dir", func() {
+				reader := openShared()
+				defer reader.Close()
+				_, err := os.Stat(filepath.Join(stagingDir, "some-layer.tar"))
+				h.AssertNil(t, err)
+			})
+
+			it("does not allow writes", func() {
+				reader := openShared()
+				defer reader.Close()
+				h.AssertError(t, reader.SetMetadata(cache.Metadata{}), "cache is read-only")
+				h.AssertError(t, reader.Commit(), "cache is read-only")
+			})
+
+			it("makes writers wait until readers close the cache", func() {
+				reader := openShared()
+				opened := make(chan *cache.VolumeCache)
+				go func() {
+					writer, err := cache.NewVolumeCache(volumeDir)
+					h.AssertNil(t, err)
+					opened <- writer
+				}()
+
+				select {
+				case <-opened:
+					t.Fatal("expected the writer to wait for the reader")
+				case <-time.After(100 * time.Millisecond):
+				}
+				h.AssertNil(t, reader.Close())
+				subject = <-opened
+			})
+		})
+
+		when("#Export", func() {
+			var (
+				layerSHA string
+				otherDir string
+				other    *cache.VolumeCache
+			)
+
+			it.Before(func() {
+				layerPath := filepath.Join(tmpDir, "layer.tar")
+				h.AssertNil(t, ioutil.WriteFile(layerPath, []byte("some-layer"), 0666))
+				sum := sha256.Sum256([]byte("some-layer"))
+				layerSHA = "sha256:" + hex.EncodeToString(sum[:])
+				h.AssertNil(t, subject.AddLayer("some-layer", layerSHA, layerPath))
+				h.AssertNil(t, subject.SetMetadata(cache.Metadata{Buildpacks: []metadata.BuildpackMetadata{{ID: "some.bp.id"}}}))
+				h.AssertNil(t, subject.Commit())
+				h.AssertNil(t, os.MkdirAll(filepath.Join(committedDir, layerSHA, "some-dir"), 0777))
+
+				otherDir = filepath.Join(tmpDir, "other_volume")
+				h.AssertNil(t, os.MkdirAll(otherDir, 0777))
+				var err error
+				other, err = cache.NewVolumeCache(otherDir)
+				h.AssertNil(t, err)
+			})
+
+			it.After(func() {
+				other.Close()
+			})
+
+			it("writes an archive that #Import restores in another cache", func() {
+				var buf bytes.Buffer
+				h.AssertNil(t, subject.Export(&buf))
+				h.AssertNil(t, other.Import(&buf))
+
+				meta, err := other.RetrieveMetadata()
+				h.AssertNil(t, err)
+				h.AssertEq(t, meta.Buildpacks[0].ID, "some.bp.id")
+				rc, err := other.RetrieveLayer(layerSHA)
+				h.AssertNil(t, err)
+				defer rc.Close()
+				contents, err := ioutil.ReadAll(rc)
+				h.AssertNil(t, err)
+				h.AssertEq(t, string(contents), "some-layer")
+				_, err = os.Stat(filepath.Join(otherDir, "committed", layerSHA))
+				h.AssertEq(t, os.IsNotExist(err), true)
+			})
+
+			when("#Import", func() {
+				var committedMetadata []byte
+
+				it.Before(func() {
+					h.AssertNil(t, other.SetMetadata(cache.Metadata{Buildpacks: []metadata.BuildpackMetadata{{ID: "other.bp.id"}}}))
+					h.AssertNil(t, other.Commit())
+					var err error
+					committedMetadata, err = ioutil.ReadFile(filepath.Join(otherDir, "committed", cache.MetadataLabel))
+					h.AssertNil(t, err)
+				})
+
+				importEntry := func(name, contents string) error {
+					var buf bytes.Buffer
+					tw := tar.NewWriter(&buf)
+					h.AssertNil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}))
+					_, err := tw.Write([]byte(contents))
+					h.AssertNil(t, err)
+					h.AssertNil(t, tw.Close())
+					return other.Import(&buf)
+				}
+
+				assertUnchanged := func() {
+					t.Helper()
+					contents, err := ioutil.ReadFile(filepath.Join(otherDir, "committed", cache.MetadataLabel))
+					h.AssertNil(t, err)
+					h.AssertEq(t, string(contents), string(committedMetadata))
+				}
+
+				it("rejects layers that do not match their SHA", func() {
+					err := importEntry(layerSHA+".tar", "other-layer")
+					h.AssertError(t, err, "expected '"+layerSHA+"'")
+					assertUnchanged()
+				})
+
+				it("rejects files that are not in a cache", func() {
+					err := importEntry("../some-file", "some-contents")
+					h.AssertError(t, err, "unexpected file '../some-file' in cache archive")
+					assertUnchanged()
+				})
+			})
+		})
+
+		when("#List", func() {
+			it("describes the layers in the committed cache", func() {
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, dummySHA+".tar"), []byte("dummy data"), 0666))
+				missingSHA := "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, "io.buildpacks.lifecycle.cache.metadata"), []byte(fmt.Sprintf(
+					`{"buildpacks": [{"key": "some.bp.id", "layers": {"some-layer": {"sha": "%s"}, "missing": {"sha": "%s"}}}, {"key": "other.bp.id", "layers": {"other-layer": {"sha": "%s"}}}],`+
+						`"accessed": {"%s": "2020-01-01T00:00:00Z"}}`,
+					dummySHA, missingSHA, dummySHA, dummySHA,
+				)), 0666))
+
+				infos, err := subject.List()
+				h.AssertNil(t, err)
+				h.AssertEq(t, infos, []cache.LayerInfo{{
+					SHA:    missingSHA,
+					Layers: []string{"some.bp.id:missing"},
+					Size:   -1,
+				}, {
+					SHA:      dummySHA,
+					Layers:   []string{"other.bp.id:other-layer", "some.bp.id:some-layer"},
+					Size:     int64(len("dummy data")),
+					Accessed: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				}})
+			})
+
+			it("reads incompatible metadata as empty", func() {
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, "io.buildpacks.lifecycle.cache.metadata"), []byte("garbage"), 0666))
+
+				infos, err := subject.List()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(infos), 0)
+			})
+		})
+
+		when("#Prune", func() {
+			var oldSHA, newSHA, untrackedSHA, sharedSHA string
+
+			it.Before(func() {
+				writeLayer := func(contents string) string {
+					sum := sha256.Sum256([]byte(contents))
+					sha := "sha256:" + hex.EncodeToString(sum[:])
+					h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, sha+".tar"), []byte(contents), 0666))
+					return sha
+				}
+				oldSHA = writeLayer("old-layer")
+				newSHA = writeLayer("new-layer")
+				untrackedSHA = writeLayer("untracked-layer")
+				sharedSHA = writeLayer("shared-layer")
+
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, "io.buildpacks.lifecycle.cache.metadata"), []byte(fmt.Sprintf(
+					`{"buildpacks": [{"key": "some.bp.id", "layers": {"old": {"sha": "%s"}, "new": {"sha": "%s"}, "untracked": {"sha": "%s"}, "shared": {"sha": "%s"}}}, {"key": "other.bp.id", "layers": {"shared": {"sha": "%s"}}}],`+
+						`"accessed": {"%s": "2020-01-01T00:00:00Z", "%s": "2020-03-01T00:00:00Z", "%s": "2020-03-01T00:00:00Z"}}`,
+					oldSHA, newSHA, untrackedSHA, sharedSHA, sharedSHA, oldSHA, newSHA, sharedSHA,
+				)), 0666))
+			})
+
+			it("removes layers last cached before the given time", func() {
+				pruned, err := subject.Prune(time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC))
+				h.AssertNil(t, err)
+				h.AssertEq(t, pruned, []string{"some.bp.id:old: last cached 2020-01-01T00:00:00Z"})
+
+				meta, err := subject.RetrieveMetadata()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(meta.Buildpacks[0].Layers), 3)
+				if _, ok := meta.Accessed[oldSHA]; ok {
+					t.Fatal("expected the pruned layer to be removed from the access times")
+				}
+				_, err = os.Stat(filepath.Join(committedDir, oldSHA+".tar"))
+				h.AssertEq(t, os.IsNotExist(err), true)
+				_, err = os.Stat(filepath.Join(committedDir, newSHA+".tar"))
+				h.AssertNil(t, err)
+			})
+
+			it("removes layers from every buildpack that has them", func() {
+				pruned, err := subject.Prune(time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC))
+				h.AssertNil(t, err)
+				h.AssertEq(t, pruned, []string{
+					"other.bp.id:shared: last cached 2020-03-01T00:00:00Z",
+					"some.bp.id:new: last cached 2020-03-01T00:00:00Z",
+					"some.bp.id:old: last cached 2020-01-01T00:00:00Z",
+					"some.bp.id:shared: last cached 2020-03-01T00:00:00Z",
+				})
+
+				_, err = os.Stat(filepath.Join(committedDir, sharedSHA+".tar"))
+				h.AssertEq(t, os.IsNotExist(err), true)
+			})
+
+			it("starts tracking layers cached before times were recorded", func() {
+				_, err := subject.Prune(time.Now().Add(-time.Hour))
+				h.AssertNil(t, err)
+
+				meta, err := subject.RetrieveMetadata()
+				h.AssertNil(t, err)
+				h.AssertEq(t, meta.Buildpacks[0].Layers["untracked"].SHA, untrackedSHA)
+				if accessed := meta.Accessed[untrackedSHA]; time.Since(accessed) > time.Minute {
+					t.Fatalf("expected the layer to age from now, got: %s", accessed)
+				}
+			})
+
+			it("clears incompatible metadata", func() {
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, "io.buildpacks.lifecycle.cache.metadata"), []byte("garbage"), 0666))
+
+				pruned, err := subject.Prune(time.Now())
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(pruned), 0)
+
+				meta, err := subject.RetrieveMetadata()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(meta.Buildpacks), 0)
+			})
+		})
+
+		when("#Verify", func() {
+			var goodSHA, corruptSHA, missingSHA string
+
+			it.Before(func() {
+				writeLayer := func(contents string) string {
+					sum := sha256.Sum256([]byte(contents))
+					sha := "sha256:" + hex.EncodeToString(sum[:])
+					h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, sha+".tar"), []byte(contents), 0666))
+					return sha
+				}
+				goodSHA = writeLayer("good-layer")
+				corruptSHA = writeLayer("corrupt-layer")
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, corruptSHA+".tar"), []byte("corrupted"), 0666))
+				sum := sha256.Sum256([]byte("missing-layer"))
+				missingSHA = "sha256:" + hex.EncodeToString(sum[:])
+
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, "io.buildpacks.lifecycle.cache.metadata"), []byte(fmt.Sprintf(
+					`{"buildpacks": [{"key": "some.bp.id", "layers": {"good": {"sha": "%s"}, "corrupt": {"sha": "%s"}, "missing": {"sha": "%s"}, "escape": {"sha": "../escape"}}}]}`,
+					goodSHA, corruptSHA, missingSHA,
+				)), 0666))
+			})
+
+			it("removes layers whose tars are missing or do not match their SHA", func() {
+				corrupted := sha256.Sum256([]byte("corrupted"))
+				pruned, err := subject.Verify()
+				h.AssertNil(t, err)
+				h.AssertEq(t, pruned, []string{
+					"some.bp.id:corrupt: layer tar has digest 'sha256:" + hex.EncodeToString(corrupted[:]) + "', expected '" + corruptSHA + "'",
+					"some.bp.id:escape: invalid layer SHA '../escape'",
+					"some.bp.id:missing: layer tar is missing",
+				})
+
+				meta, err := subject.RetrieveMetadata()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(meta.Buildpacks[0].Layers), 1)
+				h.AssertEq(t, meta.Buildpacks[0].Layers["good"].SHA, goodSHA)
+				if _, err := os.Stat(filepath.Join(committedDir, corruptSHA+".tar")); !os.IsNotExist(err) {
+					t.Fatalf("expected the corrupt layer tar to be removed: %v", err)
+				}
+				_, err = os.Stat(filepath.Join(committedDir, goodSHA+".tar"))
+				h.AssertNil(t, err)
+			})
+
+			it("leaves a valid cache unchanged", func() {
+				_, err := subject.Verify()
+				h.AssertNil(t, err)
+				pruned, err := subject.Verify()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(pruned), 0)
+			})
+
+			it("clears incompatible metadata", func() {
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(committedDir, "io.buildpacks.lifecycle.cache.metadata"), []byte("garbage"), 0666))
+
+				pruned, err := subject.Verify()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(pruned), 0)
+
+				meta, err := subject.RetrieveMetadata()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(meta.Buildpacks), 0)
+			})
+		})
+
+		when("#Rollback", func() {
+			it("discards staged layers", func() {
+				tarPath := filepath.Join(tmpDir, "some-layer.tar")
+				h.AssertNil(t, ioutil.WriteFile(tarPath, []byte("dummy data"), 0666))
+				h.AssertNil(t, subject.AddLayer("some_identifier", dummySHA, tarPath))
+
+				h.AssertNil(t, subject.Rollback())
+				h.AssertNil(t, subject.Commit())
+
+				_, err := subject.RetrieveLayer(dummySHA)
+				h.AssertError(t, err, "layer with SHA '"+dummySHA+"' not found")
+			})
+		})
 	})
 }
+
+func BenchmarkVolumeCache(b *testing.B) {
+	tmpDir, err := ioutil.TempDir("", "lifecycle.cache.volume_cache.bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, set := range h.LayerSets {
+		layerDir := filepath.Join(tmpDir, set.Name, "layer")
+		set.Write(b, layerDir)
+		tarPath := filepath.Join(tmpDir, set.Name, "layer.tar")
+		sha, err := archive.WriteTarFile(layerDir, tarPath, 1234, 2345)
+		if err != nil {
+			b.Fatal(err)
+		}
+		volumeDir := filepath.Join(tmpDir, set.Name, "volume")
+		if err := os.MkdirAll(volumeDir, 0777); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(set.Name+"/commit", func(b *testing.B) {
+			b.SetBytes(set.Bytes())
+			for i := 0; i < b.N; i++ {
+				subject, err := cache.NewVolumeCache(volumeDir)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := subject.AddLayer("some-layer", sha, tarPath); err != nil {
+					b.Fatal(err)
+				}
+				if err := subject.Commit(); err != nil {
+					b.Fatal(err)
+				}
+				subject.Close()
+			}
+		})
+
+		b.Run(set.Name+"/retrieve", func(b *testing.B) {
+			subject, err := cache.NewVolumeCache(volumeDir, cache.WithSharedLock)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer subject.Close()
+			restoreDir := filepath.Join(tmpDir, set.Name, "restored")
+			b.SetBytes(set.Bytes())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rc, err := subject.RetrieveLayer(sha)
+				if err != nil {
+					b.Fatal(err)
+				}
+				err = archive.Untar(rc, restoreDir)
+				rc.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				os.RemoveAll(restoreDir)
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -2,30 +2,42 @@ package lifecycle
 
 import (
 	"fmt"
-	"log"
-	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/buildpack/lifecycle/archive"
 	"github.com/buildpack/lifecycle/cache"
 	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
 type Cacher struct {
-	ArtifactsDir string
-	Buildpacks   []*Buildpack
-	Out, Err     *log.Logger
-	UID, GID     int
+	Buildpacks  []*Buildpack
+	DiffIDIndex *DiffIDIndex
+	Logger      Logger
+	Tracer      *telemetry.Tracer
+	Events      Events
+	UID, GID    int
 }
 
 func (c *Cacher) Cache(layersDir string, cacheStore Cache) error {
 	origMetadata, err := cacheStore.RetrieveMetadata()
+	if _, ok := err.(*metadata.IncompatibleError); ok {
+		c.Logger.Warnf("ignoring metadata of previous cache: %s", err)
+		err = nil
+	}
 	if err != nil {
 		return errors.Wrap(err, "metadata for previous cache")
 	}
 
-	newMetadata := cache.Metadata{}
+	digests, err := c.digestLayers(layersDir)
+	if err != nil {
+		return err
+	}
+
+	newMetadata := cache.Metadata{SchemaVersion: cache.MetadataVersion, Accessed: map[string]time.Time{}}
+	now := time.Now().UTC()
 	for _, bp := range c.Buildpacks {
 		bpDir, err := readBuildpackLayersDir(layersDir, *bp)
 		if err != nil {
@@ -36,6 +48,9 @@ func (c *Cacher) Cache(layersDir string, cacheStore Cache) error {
 			Version: bp.Version,
 			Layers:  map[string]metadata.LayerMetadata{},
 		}
+		for _, l := range bpDir.findLayers(notCached) {
+			c.Logger.Debugf("layer '%s': cache=false, not caching", l.Identifier())
+		}
 		for _, l := range bpDir.findLayers(cached) {
 			if !l.hasLocalContents() {
 				return fmt.Errorf("failed to cache layer '%s' because it has no contents", l.Identifier())
@@ -45,10 +60,11 @@ func (c *Cacher) Cache(layersDir string, cacheStore Cache) error {
 				return err
 			}
 			origLayerMetadata := origMetadata.MetadataForBuildpack(bp.ID).Layers[l.name()]
-			if data.SHA, err = c.addOrReuseLayer(cacheStore, l, origLayerMetadata.SHA); err != nil {
+			if data.SHA, err = c.addOrReuseLayer(cacheStore, l, origLayerMetadata.SHA, digests); err != nil {
 				return err
 			}
 			bpMetadata.Layers[l.name()] = data
+			newMetadata.Accessed[data.SHA] = now
 		}
 		newMetadata.Buildpacks = append(newMetadata.Buildpacks, bpMetadata)
 	}
@@ -60,18 +76,48 @@ func (c *Cacher) Cache(layersDir string, cacheStore Cache) error {
 	return cacheStore.Commit()
 }
 
-func (c *Cacher) addOrReuseLayer(cache Cache, layer bpLayer, previousSHA string) (string, error) {
-	tarPath := filepath.Join(c.ArtifactsDir, escapeIdentifier(layer.Identifier())+".tar")
-	sha, err := archive.WriteTarFile(layer.Path(), tarPath, c.UID, c.GID)
+// digestLayers hashes every layer with contents that may be cached, concurrently.
+func (c *Cacher) digestLayers(layersDir string) (layerDigests, error) {
+	var paths []string
+	for _, bp := range c.Buildpacks {
+		bpDir, err := readBuildpackLayersDir(layersDir, *bp)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range bpDir.findLayers(cached) {
+			if l.hasLocalContents() {
+				paths = append(paths, l.Path())
+			}
+		}
+	}
+	return digestLayers(paths, c.UID, c.GID, c.DiffIDIndex), nil
+}
+
+func (c *Cacher) addOrReuseLayer(cache Cache, layer bpLayer, previousSHA string, digests layerDigests) (string, error) {
+	span := c.Tracer.Start("cache-layer").SetAttribute("layer", layer.Identifier())
+	defer span.Finish()
+
+	digest, err := digests.get(layer.Path(), c.UID, c.GID)
+	sha, size := digest.SHA, digest.Size
 	if err != nil {
+		span.SetError(err)
 		return "", errors.Wrapf(err, "caching layer '%s'", layer.Identifier())
 	}
+	span.SetAttribute("sha", sha).SetAttribute("size", size)
 
+	c.Logger.Debugf("layer '%s': %s", layer.Identifier(), reuseReason(sha, previousSHA))
 	if sha == previousSHA {
-		c.Out.Printf("Reusing layer '%s' with SHA %s\n", layer.Identifier(), sha)
-		return sha, cache.ReuseLayer(layer.Identifier(), previousSHA)
+		c.Logger.Infof("Reusing layer '%s' with SHA %s\n", layer.Identifier(), sha)
+		span.SetAttribute("reused", true)
+		err = cache.ReuseLayer(layer.Identifier(), previousSHA)
+	} else {
+		c.Logger.Infof("Caching layer '%s' with SHA %s\n", layer.Identifier(), sha)
+		span.SetAttribute("reused", false)
+		err = cache.AddLayerFromOpener(layer.Identifier(), sha, size, archive.TarOpener(layer.Path(), c.UID, c.GID))
 	}
-
-	c.Out.Printf("Caching layer '%s' with SHA %s\n", layer.Identifier(), sha)
-	return sha, cache.AddLayer(layer.Identifier(), sha, tarPath)
+	span.SetError(err)
+	if err == nil {
+		eventsOrNop(c.Events).OnLayerCached(LayerEvent{ID: layer.Identifier(), SHA: sha, Size: size, Reused: sha == previousSHA})
+	}
+	return sha, err
 }
@@ -3,7 +3,6 @@ package lifecycle_test
 import (
 	"fmt"
 	"io/ioutil"
-	"log"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -39,7 +38,7 @@ func testCacher(t *testing.T, when spec.G, it spec.S) {
 		it.Before(func() {
 			var err error
 
-			emptyLogger := log.New(ioutil.Discard, "", 0)
+			emptyLogger := lifecycle.NewDefaultLogger(ioutil.Discard, ioutil.Discard)
 
 			tmpDir, err = ioutil.TempDir("", "lifecycle.cacher.layer")
 			h.AssertNil(t, err)
@@ -51,14 +50,13 @@ func testCacher(t *testing.T, when spec.G, it spec.S) {
 			h.AssertNil(t, err)
 
 			subject = &lifecycle.Cacher{
-				ArtifactsDir: tmpDir,
 				Buildpacks: []*lifecycle.Buildpack{
 					{ID: "buildpack.id"},
 					{ID: "other.buildpack.id"},
 				},
-				Out: emptyLogger,
-				UID: 1234,
-				GID: 4321,
+				Logger: emptyLogger,
+				UID:    1234,
+				GID:    4321,
 			}
 		})
 
@@ -123,6 +121,7 @@ func testCacher(t *testing.T, when spec.G, it spec.S) {
 					h.AssertNil(t, err)
 
 					t.Log("adds layer shas to metadata")
+					h.AssertEq(t, metadata.SchemaVersion, cache.MetadataVersion)
 					h.AssertEq(t, metadata.Buildpacks[0].ID, "buildpack.id")
 					h.AssertEq(t, metadata.Buildpacks[0].Layers["cache-true-layer"].SHA, cacheTrueLayerSHA)
 					h.AssertEq(t, metadata.Buildpacks[0].Layers["cache-true-layer"].Launch, true)
@@ -131,6 +130,11 @@ func testCacher(t *testing.T, when spec.G, it spec.S) {
 					h.AssertEq(t, metadata.Buildpacks[0].Layers["cache-true-layer"].Data, map[string]interface{}{
 						"cache-true-key": "cache-true-val",
 					})
+
+					t.Log("records when layers were cached")
+					if accessed := metadata.Accessed[cacheTrueLayerSHA]; time.Since(accessed) > time.Minute {
+						t.Fatalf("expected the layer to have been cached just now, got: %s", accessed)
+					}
 				})
 
 				it("doesn't export uncached layers", func() {
@@ -1,61 +1,150 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 
-	"github.com/BurntSushi/toml"
-
 	"github.com/buildpack/lifecycle"
 	"github.com/buildpack/lifecycle/cmd"
 	"github.com/buildpack/lifecycle/image"
+	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/stack"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
 var (
-	repoName   string
-	layersDir  string
-	appDir     string
-	groupPath  string
-	useDaemon  bool
-	useHelpers bool
-	uid        int
-	gid        int
+	configPath      string
+	strict          bool
+	buildID         string
+	debug           bool
+	noColor         bool
+	labelPrefix     string
+	telemetryConfig cmd.Telemetry
+	profileConfig   cmd.Profile
+	repoName        string
+	layersDir       string
+	appDir          string
+	groupPath       string
+	runImageRef     string
+	buildImageRef   string
+	stackPath       string
+	failDeprecated  bool
+	useDaemon       bool
+	useHelpers      bool
+	cloudAuth       bool
+	ctrNamespace    string
+	ctrPath         string
+	platform        string
+	uid             int
+	gid             int
 )
 
 func init() {
+	cmd.FlagConfigPath(&configPath)
+	cmd.FlagStrict(&strict)
+	cmd.FlagBuildID(&buildID)
+	cmd.FlagDebug(&debug)
+	cmd.FlagNoColor(&noColor)
+	cmd.FlagLabelPrefix(&labelPrefix)
+	cmd.FlagMetricsPath(&telemetryConfig.MetricsPath)
+	cmd.FlagMetricsPushgateway(&telemetryConfig.MetricsPushgateway)
+	cmd.FlagTimingsPath(&telemetryConfig.TimingsPath)
+	cmd.FlagCPUProfile(&profileConfig.CPUPath)
+	cmd.FlagMemProfile(&profileConfig.MemPath)
+	cmd.FlagTrace(&profileConfig.TracePath)
 	cmd.FlagLayersDir(&layersDir)
 	cmd.FlagAppDir(&appDir)
 	cmd.FlagGroupPath(&groupPath)
+	cmd.FlagRunImage(&runImageRef)
+	cmd.FlagBuildImage(&buildImageRef)
+	cmd.FlagStackPath(&stackPath)
+	cmd.FlagFailDeprecatedStack(&failDeprecated)
 	cmd.FlagUseDaemon(&useDaemon)
 	cmd.FlagUseCredHelpers(&useHelpers)
+	cmd.FlagCloudAuth(&cloudAuth)
+	cmd.FlagContainerdNamespace(&ctrNamespace)
+	cmd.FlagCtrPath(&ctrPath)
+	cmd.FlagTargetPlatform(&platform)
 	cmd.FlagUID(&uid)
 	cmd.FlagGID(&gid)
 }
 
 func main() {
+	defer cmd.RecoverPanic()
 	// suppress output from libraries, lifecycle will not use standard logger
 	log.SetOutput(ioutil.Discard)
+	ctx := cmd.HandleSignals()
 
 	flag.Parse()
+	if err := cmd.ReadConfigFile(configPath); err != nil {
+		cmd.Exit(cmd.FailErrCode(err, cmd.CodeInvalidArgs, "read config file"))
+	}
+	cmd.SetBuildID(&buildID)
+	if err := cmd.VerifyPlatformAPI(); err != nil {
+		cmd.Exit(err)
+	}
+	cmd.ResolveLayersPath(&groupPath, layersDir, cmd.DefaultGroupPath)
 	repoName = flag.Arg(0)
-	if flag.NArg() > 1 || repoName == "" {
-		cmd.Exit(cmd.FailCode(cmd.CodeInvalidArgs, "parse arguments"))
+	var v cmd.Validator
+	v.Check(flag.NArg() <= 1 && repoName != "", "expected one argument, the image name")
+	v.Check(ctrNamespace == "" || !useDaemon, "-containerd-namespace and -daemon are exclusive")
+	v.CheckPhaseOutput("group", groupPath)
+	v.CheckUIDGID(uid, gid)
+	if v.Valid() {
+		v.CheckErr(cmd.DetectUIDGID(&uid, &gid, layersDir, appDir), "determine uid/gid")
+	}
+	if err := v.Err(); err != nil {
+		cmd.Exit(err)
 	}
-	cmd.Exit(analyzer())
+	tracer := telemetry.NewTracer("analyzer", buildID)
+	cmd.Exit(telemetryConfig.Finish(tracer, profileConfig.Run(func() error { return analyzer(ctx, tracer) })))
 }
 
-func analyzer() error {
+func analyzer(ctx context.Context, tracer *telemetry.Tracer) error {
+	logger := lifecycle.NewDefaultLogger(os.Stdout, os.Stderr)
+	logger.OnWarn = cmd.AddWarning
+	logger.SetPrefix(cmd.LogPrefix())
+	logger.DebugEnabled = debug
+	if noColor {
+		logger.Color = false
+	}
+	lifecycle.UnknownKeys = lifecycle.WarnUnknownKeys(logger)
+	if strict {
+		lifecycle.UnknownKeys = lifecycle.RejectUnknownKeys
+	}
+	if labelPrefix != "" {
+		metadata.LabelPrefix = labelPrefix
+	}
+
+	if buildImageRef == "" {
+		stk, err := stack.Read(stackPath)
+		if err != nil && !os.IsNotExist(err) {
+			return cmd.FailErr(err, "read stack")
+		} else if err == nil {
+			buildImageRef = stk.BuildImage.Image
+		}
+	}
+
 	if useHelpers {
-		if err := lifecycle.SetupCredHelpers(filepath.Join(os.Getenv("HOME"), ".docker"), repoName); err != nil {
+		refs := []string{repoName}
+		if runImageRef != "" {
+			refs = append(refs, runImageRef)
+		}
+		if buildImageRef != "" {
+			refs = append(refs, buildImageRef)
+		}
+		if err := lifecycle.SetupCredHelpers(filepath.Join(os.Getenv("HOME"), ".docker"), refs...); err != nil {
 			return cmd.FailErr(err, "setup credential helpers")
 		}
 	}
 
 	var group lifecycle.BuildpackGroup
-	if _, err := toml.DecodeFile(groupPath, &group); err != nil {
+	if err := lifecycle.DecodeFile(groupPath, &group); err != nil {
 		return cmd.FailErr(err, "read group")
 	}
 
@@ -63,24 +152,43 @@ func analyzer() error {
 		Buildpacks: group.Buildpacks,
 		AppDir:     appDir,
 		LayersDir:  layersDir,
-		Out:        log.New(os.Stdout, "", 0),
-		Err:        log.New(os.Stderr, "", 0),
+		Logger:     logger,
+		Tracer:     tracer,
 		UID:        uid,
 		GID:        gid,
+
+		FailDeprecatedStack: failDeprecated,
 	}
 
 	var err error
 	var previousImage image.Image
-	factory, err := image.NewFactory(image.WithOutWriter(os.Stdout), image.WithEnvKeychain)
+	targetPlatform, err := image.ParsePlatform(platform)
+	if err != nil {
+		return cmd.FailErrCode(err, cmd.CodeInvalidArgs, "parse target platform")
+	}
+	ops := []func(*image.Factory){image.WithOutWriter(os.Stdout), image.WithContext(ctx), image.WithEnvKeychain, image.WithPlatform(targetPlatform)}
+	if cloudAuth {
+		ops = append(ops, image.WithCloudKeychain)
+	}
+	if ctrNamespace != "" {
+		ops = append(ops, image.WithContainerd(ctrNamespace, ctrPath))
+	}
+	factory, err := image.NewFactory(ops...)
 	if err != nil {
 		return err
 	}
+	defer factory.Cleanup()
 
 	if useDaemon {
 		previousImage, err = factory.NewLocal(repoName)
 		if err != nil {
 			return err
 		}
+	} else if ctrNamespace != "" {
+		previousImage, err = factory.NewContainerd(repoName)
+		if err != nil {
+			return err
+		}
 	} else {
 		previousImage, err = factory.NewRemote(repoName)
 		if err != nil {
@@ -91,6 +199,20 @@ func analyzer() error {
 		return cmd.FailErr(err, "repository configuration", repoName)
 	}
 
+	if runImageRef != "" {
+		if err := checkRunImage(factory); err != nil {
+			return err
+		}
+		analyzer.RegistryRunImage = registryRunImage(logger, factory)
+	}
+
+	if buildImageRef != "" {
+		analyzer.BuildImage, err = buildImage(logger, factory)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = analyzer.Analyze(
 		previousImage,
 	)
@@ -100,3 +222,62 @@ func analyzer() error {
 
 	return nil
 }
+
+// checkRunImage fails before the build when the run image the exporter will
+// need cannot be found with the current credentials.
+func checkRunImage(factory *image.Factory) error {
+	var runImage image.Image
+	var err error
+	if useDaemon {
+		runImage, err = factory.NewLocal(runImageRef)
+	} else {
+		runImage, err = factory.NewRemote(runImageRef)
+	}
+	if err != nil {
+		return cmd.FailErr(err, "access run image", runImageRef)
+	}
+	found, err := runImage.Found()
+	if err != nil {
+		return cmd.FailErr(err, "access run image", runImageRef)
+	}
+	if !found {
+		return cmd.FailErrCode(errors.New("it does not exist or is not accessible with the current credentials"), cmd.CodeNotFound, "find run image", runImageRef)
+	}
+	return nil
+}
+
+// registryRunImage returns the run image in the registry to compare the run
+// image of the previous image with, or nil if it cannot be accessed, since
+// the comparison is advisory.
+func registryRunImage(logger lifecycle.Logger, factory *image.Factory) image.Image {
+	runImage, err := factory.NewRemote(runImageRef)
+	if err != nil {
+		logger.Warnf("could not check whether run image '%s' is outdated: %s", runImageRef, err)
+		return nil
+	}
+	return runImage
+}
+
+// buildImage returns the build image to check for stack deprecation, or nil
+// if it cannot be found or accessed, since the build is already running on
+// it. Only when failing on deprecated stacks is an inaccessible build image
+// an error, since its deprecation cannot be ruled out.
+func buildImage(logger lifecycle.Logger, factory *image.Factory) (image.Image, error) {
+	buildImage, err := factory.NewRemote(buildImageRef)
+	if err == nil {
+		var found bool
+		found, err = buildImage.Found()
+		if err == nil && !found {
+			logger.Debugf("build image '%s' not found, not checking it for stack deprecation", buildImageRef)
+			return nil, nil
+		}
+	}
+	if err != nil {
+		if failDeprecated {
+			return nil, cmd.FailErr(err, "access build image", buildImageRef)
+		}
+		logger.Warnf("could not check whether build image '%s' is deprecated: %s", buildImageRef, err)
+		return nil, nil
+	}
+	return buildImage, nil
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"os"
+)
+
+const EnvBuildID = "CNB_BUILD_ID"
+
+var logPrefix string
+
+func FlagBuildID(id *string) {
+	flag.StringVar(id, "build-id", os.Getenv(EnvBuildID), "ID included in logs, reports and image labels to correlate the phases of a build (defaults to an ID generated for each phase, which is not added to image labels)")
+}
+
+// SetBuildID generates an ID if id is empty and prefixes the lifecycle's own
+// log lines with it. Generated IDs are specific to the phase, so they only
+// correlate its logs and reports.
+func SetBuildID(id *string) {
+	if *id == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		*id = hex.EncodeToString(b)
+	}
+	logPrefix = "[" + *id + "] "
+}
+
+// LogPrefix returns the prefix for log lines set by SetBuildID.
+func LogPrefix() string {
+	return logPrefix
+}
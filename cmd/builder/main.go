@@ -1,48 +1,105 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 
-	"github.com/BurntSushi/toml"
-
 	"github.com/buildpack/lifecycle"
 	"github.com/buildpack/lifecycle/cmd"
+	"github.com/buildpack/lifecycle/stack"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
 var (
-	buildpacksDir string
-	groupPath     string
-	planPath      string
-	layersDir     string
-	appDir        string
-	platformDir   string
+	configPath      string
+	strict          bool
+	buildID         string
+	logPrefix       string
+	telemetryConfig cmd.Telemetry
+	profileConfig   cmd.Profile
+	buildpacksDir   string
+	groupPath       string
+	planPath        string
+	layersDir       string
+	appDir          string
+	platformDir     string
+	generatedDir    string
+	secretsDir      string
+	stackPath       string
+	stackID         string
+	uid             int
+	gid             int
 )
 
 func init() {
+	cmd.FlagConfigPath(&configPath)
+	cmd.FlagStrict(&strict)
+	cmd.FlagBuildID(&buildID)
+	cmd.FlagLogPrefix(&logPrefix)
+	cmd.FlagMetricsPath(&telemetryConfig.MetricsPath)
+	cmd.FlagMetricsPushgateway(&telemetryConfig.MetricsPushgateway)
+	cmd.FlagTimingsPath(&telemetryConfig.TimingsPath)
+	cmd.FlagCPUProfile(&profileConfig.CPUPath)
+	cmd.FlagMemProfile(&profileConfig.MemPath)
+	cmd.FlagTrace(&profileConfig.TracePath)
 	cmd.FlagBuildpacksDir(&buildpacksDir)
 	cmd.FlagGroupPath(&groupPath)
 	cmd.FlagPlanPath(&planPath)
 	cmd.FlagLayersDir(&layersDir)
 	cmd.FlagAppDir(&appDir)
 	cmd.FlagPlatformDir(&platformDir)
+	cmd.FlagGeneratedDir(&generatedDir)
+	cmd.FlagSecretsDir(&secretsDir)
+	cmd.FlagStackPath(&stackPath)
+	cmd.FlagStackID(&stackID)
+	cmd.FlagUID(&uid)
+	cmd.FlagGID(&gid)
 }
 
 func main() {
+	defer cmd.RecoverPanic()
 	// suppress output from libraries, lifecycle will not use standard logger
 	log.SetOutput(ioutil.Discard)
+	ctx := cmd.HandleSignals()
 
 	flag.Parse()
-	if flag.NArg() != 0 {
-		cmd.Exit(cmd.FailCode(cmd.CodeInvalidArgs, "parse arguments"))
+	if err := cmd.ReadConfigFile(configPath); err != nil {
+		cmd.Exit(cmd.FailErrCode(err, cmd.CodeInvalidArgs, "read config file"))
+	}
+	cmd.SetBuildID(&buildID)
+	if err := cmd.VerifyPlatformAPI(); err != nil {
+		cmd.Exit(err)
+	}
+	cmd.ResolveLayersPath(&groupPath, layersDir, cmd.DefaultGroupPath)
+	cmd.ResolveLayersPath(&planPath, layersDir, cmd.DefaultPlanPath)
+	var v cmd.Validator
+	v.Check(flag.NArg() == 0, "expected no arguments")
+	v.CheckPhaseOutput("group", groupPath)
+	v.CheckPhaseOutput("plan", planPath)
+	v.CheckUIDGID(uid, gid)
+	if v.Valid() {
+		v.CheckErr(cmd.DetectUIDGID(&uid, &gid, layersDir, appDir), "determine uid/gid")
 	}
-	cmd.Exit(build())
+	if err := v.Err(); err != nil {
+		cmd.Exit(err)
+	}
+	tracer := telemetry.NewTracer("builder", buildID)
+	cmd.Exit(telemetryConfig.Finish(tracer, profileConfig.Run(func() error { return build(ctx, tracer) })))
 }
 
-func build() error {
+func build(ctx context.Context, tracer *telemetry.Tracer) error {
+	logger := lifecycle.NewDefaultLogger(os.Stdout, os.Stderr)
+	logger.OnWarn = cmd.AddWarning
+	logger.SetPrefix(cmd.LogPrefix())
+	lifecycle.UnknownKeys = lifecycle.WarnUnknownKeys(logger)
+	if strict {
+		lifecycle.UnknownKeys = lifecycle.RejectUnknownKeys
+	}
+
 	buildpacks, err := lifecycle.NewBuildpackMap(buildpacksDir)
 	if err != nil {
 		return cmd.FailErr(err, "read buildpack directory")
@@ -52,8 +109,17 @@ func build() error {
 		return cmd.FailErr(err, "read buildpack group")
 	}
 
+	if stackID == "" {
+		stk, err := stack.Read(stackPath)
+		if err != nil && !os.IsNotExist(err) {
+			return cmd.FailErr(err, "read stack")
+		} else if err == nil {
+			stackID = stk.ID
+		}
+	}
+
 	var plan lifecycle.Plan
-	if _, err := toml.DecodeFile(planPath, &plan); err != nil {
+	if err := lifecycle.DecodeFile(planPath, &plan); err != nil {
 		return cmd.FailErr(err, "parse build plan")
 	}
 
@@ -63,6 +129,15 @@ func build() error {
 		Environ: os.Environ,
 		Map:     lifecycle.POSIXBuildEnv,
 	}
+	if err := env.AddEnvDir(filepath.Join(generatedDir, "env.build")); err != nil {
+		return cmd.FailErr(err, "read extension environment")
+	}
+	var secrets *lifecycle.Secrets
+	if secretsDir != "" {
+		if secrets, err = lifecycle.ReadSecrets(secretsDir); err != nil {
+			return cmd.FailErr(err, "read secrets")
+		}
+	}
 	builder := &lifecycle.Builder{
 		PlatformDir: platformDir,
 		LayersDir:   layersDir,
@@ -70,8 +145,15 @@ func build() error {
 		Env:         env,
 		Buildpacks:  group.Buildpacks,
 		Plan:        plan,
+		StackID:     stackID,
+		UID:         uid,
+		GID:         gid,
+		Secrets:     secrets,
 		Out:         os.Stdout,
 		Err:         os.Stderr,
+		Tracer:      tracer,
+		LogPrefix:   logPrefix,
+		Context:     ctx,
 	}
 
 	metadata, err := builder.Build()
@@ -1,98 +1,222 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 
-	"github.com/BurntSushi/toml"
-
 	"github.com/buildpack/lifecycle"
 	"github.com/buildpack/lifecycle/cache"
 	"github.com/buildpack/lifecycle/cmd"
 	"github.com/buildpack/lifecycle/image"
+	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
 var (
-	cacheImageTag string
-	cachePath     string
-	layersDir     string
-	groupPath     string
-	uid           int
-	gid           int
+	configPath      string
+	strict          bool
+	buildID         string
+	debug           bool
+	noColor         bool
+	labelPrefix     string
+	telemetryConfig cmd.Telemetry
+	profileConfig   cmd.Profile
+	cacheImageTag   string
+	useDaemon       bool
+	cachePath       string
+	layersDir       string
+	groupPath       string
+	uid             int
+	gid             int
+	diffIDIndexPath string
+	generations     int
+	exportPath      string
+	noPrevious      bool
+	pageSize        int
+	compression     string
+	artifact        bool
+	cloudAuth       bool
 )
 
 func init() {
+	cmd.FlagConfigPath(&configPath)
+	cmd.FlagStrict(&strict)
+	cmd.FlagBuildID(&buildID)
+	cmd.FlagDebug(&debug)
+	cmd.FlagNoColor(&noColor)
+	cmd.FlagLabelPrefix(&labelPrefix)
+	cmd.FlagMetricsPath(&telemetryConfig.MetricsPath)
+	cmd.FlagMetricsPushgateway(&telemetryConfig.MetricsPushgateway)
+	cmd.FlagTimingsPath(&telemetryConfig.TimingsPath)
+	cmd.FlagCPUProfile(&profileConfig.CPUPath)
+	cmd.FlagMemProfile(&profileConfig.MemPath)
+	cmd.FlagTrace(&profileConfig.TracePath)
 	cmd.FlagLayersDir(&layersDir)
 	cmd.FlagCacheImage(&cacheImageTag)
 	cmd.FlagCachePath(&cachePath)
+	cmd.FlagUseDaemon(&useDaemon)
 	cmd.FlagGroupPath(&groupPath)
 	cmd.FlagUID(&uid)
 	cmd.FlagGID(&gid)
+	cmd.FlagDiffIDIndex(&diffIDIndexPath)
+	cmd.FlagCacheGenerations(&generations)
+	cmd.FlagExportCache(&exportPath)
+	cmd.FlagNoPreviousCache(&noPrevious)
+	cmd.FlagCachePageSize(&pageSize)
+	cmd.FlagCacheCompressionLevel(&compression)
+	cmd.FlagCacheArtifact(&artifact)
+	cmd.FlagCloudAuth(&cloudAuth)
 }
 
 func main() {
+	defer cmd.RecoverPanic()
 	// suppress output from libraries, lifecycle will not use standard logger
 	log.SetOutput(ioutil.Discard)
+	ctx := cmd.HandleSignals()
 
 	flag.Parse()
-	if flag.NArg() > 0 {
-		args := map[string]interface{}{"narg": flag.NArg(), "layersDir": layersDir}
-		cmd.Exit(cmd.FailCode(cmd.CodeInvalidArgs, "parse arguments", fmt.Sprintf("%+v", args)))
+	if err := cmd.ReadConfigFile(configPath); err != nil {
+		cmd.Exit(cmd.FailErrCode(err, cmd.CodeInvalidArgs, "read config file"))
+	}
+	cmd.SetBuildID(&buildID)
+	if err := cmd.VerifyPlatformAPI(); err != nil {
+		cmd.Exit(err)
 	}
-	if cacheImageTag == "" && cachePath == "" {
-		cmd.Exit(cmd.FailCode(cmd.CodeInvalidArgs, "must supply either -image or -path"))
+	cmd.ResolveLayersPath(&groupPath, layersDir, cmd.DefaultGroupPath)
+	var v cmd.Validator
+	v.Check(flag.NArg() == 0, fmt.Sprintf("expected no arguments, got %d", flag.NArg()))
+	v.Check(cacheImageTag != "" || cachePath != "", "must supply either -image or -path")
+	v.Check(exportPath == "" || (cacheImageTag == "" && cachePath != ""), "-export requires a cache directory (-path) instead of an image")
+	v.CheckPhaseOutput("group", groupPath)
+	v.CheckUIDGID(uid, gid)
+	if v.Valid() {
+		v.CheckErr(cmd.DetectUIDGID(&uid, &gid, layersDir), "determine uid/gid")
 	}
-	cmd.Exit(doCache())
+	if err := v.Err(); err != nil {
+		cmd.Exit(err)
+	}
+	tracer := telemetry.NewTracer("cacher", buildID)
+	cmd.Exit(telemetryConfig.Finish(tracer, profileConfig.Run(func() error { return doCache(ctx, tracer) })))
 }
 
-func doCache() error {
-	var group lifecycle.BuildpackGroup
-	if _, err := toml.DecodeFile(groupPath, &group); err != nil {
-		return cmd.FailErr(err, "read group")
+func doCache(ctx context.Context, tracer *telemetry.Tracer) error {
+	logger := lifecycle.NewDefaultLogger(os.Stdout, os.Stderr)
+	logger.OnWarn = cmd.AddWarning
+	logger.SetPrefix(cmd.LogPrefix())
+	logger.DebugEnabled = debug
+	if noColor {
+		logger.Color = false
+	}
+	lifecycle.UnknownKeys = lifecycle.WarnUnknownKeys(logger)
+	if strict {
+		lifecycle.UnknownKeys = lifecycle.RejectUnknownKeys
+	}
+	if labelPrefix != "" {
+		metadata.LabelPrefix = labelPrefix
 	}
 
-	artifactsDir, err := ioutil.TempDir("", "lifecycle.exporter.layer")
-	if err != nil {
-		return cmd.FailErr(err, "create temp directory")
+	var group lifecycle.BuildpackGroup
+	if err := lifecycle.DecodeFile(groupPath, &group); err != nil {
+		return cmd.FailErr(err, "read group")
 	}
-	defer os.RemoveAll(artifactsDir)
 
 	cacher := &lifecycle.Cacher{
-		Buildpacks:   group.Buildpacks,
-		ArtifactsDir: artifactsDir,
-		Out:          log.New(os.Stdout, "", 0),
-		Err:          log.New(os.Stderr, "", 0),
-		UID:          uid,
-		GID:          gid,
+		Buildpacks: group.Buildpacks,
+		Logger:     logger,
+		Tracer:     tracer,
+		UID:        uid,
+		GID:        gid,
+	}
+	if diffIDIndexPath != "" {
+		index, err := lifecycle.ReadDiffIDIndex(diffIDIndexPath)
+		if err != nil {
+			return cmd.FailErr(err, "read diff ID index")
+		}
+		cacher.DiffIDIndex = index
 	}
 
-	var cacheStore lifecycle.Cache
+	var (
+		cacheStore  lifecycle.Cache
+		volumeCache *cache.VolumeCache
+	)
 	if cacheImageTag != "" {
-		factory, err := image.NewFactory(image.WithOutWriter(os.Stdout))
+		level, err := image.ParseCompressionLevel(compression)
 		if err != nil {
-			return err
+			return cmd.FailErrCode(err, cmd.CodeInvalidArgs, "parse compression level")
 		}
-
-		origCacheImage, err := factory.NewLocal(cacheImageTag)
+		factoryOps := []func(*image.Factory){image.WithOutWriter(os.Stdout), image.WithContext(ctx), image.WithEnvKeychain, image.WithCompressionLevel(level)}
+		if artifact {
+			factoryOps = append(factoryOps, image.WithArtifactType(cache.ArtifactType))
+		}
+		if cloudAuth {
+			factoryOps = append(factoryOps, image.WithCloudKeychain)
+		}
+		factory, err := image.NewFactory(factoryOps...)
 		if err != nil {
 			return err
 		}
 
-		cacheStore = cache.NewImageCache(factory, origCacheImage)
+		var ops []func(*cache.ImageCache)
+		if noPrevious {
+			ops = append(ops, cache.WithoutPrevious)
+		}
+		if pageSize > 0 {
+			ops = append(ops, cache.WithPageSize(int64(pageSize)<<20))
+		}
+		if useDaemon {
+			origCacheImage, err := factory.NewLocal(cacheImageTag)
+			if err != nil {
+				return err
+			}
+			cacheStore = cache.NewImageCache(factory, origCacheImage, ops...)
+		} else {
+			origCacheImage, err := factory.NewRemote(cacheImageTag)
+			if err != nil {
+				return err
+			}
+			cacheStore = cache.NewRemoteImageCache(factory, origCacheImage, ops...)
+		}
 	} else {
 		var err error
-		cacheStore, err = cache.NewVolumeCache(cachePath)
+		volumeCache, err = cache.NewVolumeCache(cachePath, cache.WithGenerations(generations))
 		if err != nil {
 			return err
 		}
+		defer volumeCache.Close()
+		cacheStore = volumeCache
 	}
 
 	if err := cacher.Cache(layersDir, cacheStore); err != nil {
+		if volumeCache != nil {
+			// discard the layers staged before failing or being interrupted, while the cache is locked
+			volumeCache.Rollback()
+		}
 		return cmd.FailErrCode(err, cmd.CodeFailed)
 	}
+	if err := cacher.DiffIDIndex.Save(); err != nil {
+		logger.Warnf("could not save diff ID index: %s", err)
+	}
+	if exportPath != "" {
+		if err := exportCache(volumeCache, exportPath); err != nil {
+			return cmd.FailErr(err, "export cache")
+		}
+	}
 
 	return nil
 }
+
+func exportCache(volumeCache *cache.VolumeCache, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := volumeCache.Export(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
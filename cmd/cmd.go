@@ -7,36 +7,92 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/buildpack/lifecycle/exitcode"
 )
 
 const (
 	DefaultLayersDir     = "/layers"
 	DefaultAppDir        = "/workspace"
 	DefaultBuildpacksDir = "/buildpacks"
+	DefaultExtensionsDir = "/extensions"
 	DefaultPlatformDir   = "/platform"
 	DefaultOrderPath     = "/buildpacks/order.toml"
 	DefaultGroupPath     = "./group.toml"
 	DefaultStackPath     = "/buildpacks/stack.toml"
 	DefaultPlanPath      = "./plan.toml"
+	DefaultGeneratedDir  = "./generated"
 
 	EnvLayersDir     = "CNB_LAYERS_DIR"
 	EnvAppDir        = "CNB_APP_DIR"
 	EnvBuildpacksDir = "CNB_BUILDPACKS_DIR"
+	EnvExtensionsDir = "CNB_EXTENSIONS_DIR"
 	EnvPlatformDir   = "CNB_PLATFORM_DIR"
 	EnvOrderPath     = "CNB_ORDER_PATH"
 	EnvGroupPath     = "CNB_GROUP_PATH"
 	EnvStackPath     = "CNB_STACK_PATH"
 	EnvPlanPath      = "CNB_PLAN_PATH"
+	EnvGeneratedDir  = "CNB_GENERATED_DIR"
 	EnvUseDaemon     = "CNB_USE_DAEMON"       // defaults to false
 	EnvUseHelpers    = "CNB_USE_CRED_HELPERS" // defaults to false
 	EnvRunImage      = "CNB_RUN_IMAGE"
+	EnvBuildImage    = "CNB_BUILD_IMAGE"
 	EnvCacheImage    = "CNB_CACHE_IMAGE"
 	EnvCachePath     = "CNB_CACHE_PATH"
 	EnvUID           = "CNB_USER_ID"
 	EnvGID           = "CNB_GROUP_ID"
 	EnvRegistryAuth  = "CNB_REGISTRY_AUTH"
+	EnvConfigPath    = "CNB_CONFIG_PATH"
+	EnvNoColor       = "CNB_NO_COLOR" // defaults to false
+	EnvLogPrefix     = "CNB_LOG_PREFIX"
+	EnvDebug         = "CNB_DEBUG"  // defaults to false
+	EnvStrict        = "CNB_STRICT" // defaults to false
+	EnvStackID       = "CNB_STACK_ID"
+	EnvBOMPath       = "CNB_BOM_PATH"
+	EnvLabelPrefix   = "CNB_LABEL_PREFIX"
+	EnvBuildMixins   = "CNB_BUILD_MIXINS"
+	EnvDiffIDIndex   = "CNB_DIFFID_INDEX_PATH"
+	EnvCompression   = "CNB_COMPRESSION_LEVEL" // defaults to "default"
+	EnvLinkCache     = "CNB_LINK_CACHE"        // defaults to false
+	EnvVerifyCache   = "CNB_VERIFY_CACHE"      // defaults to false
+	EnvCacheGens     = "CNB_CACHE_GENERATIONS" // defaults to 0
+	EnvRollbackCache = "CNB_ROLLBACK_CACHE"    // defaults to 0
+	EnvImportCache   = "CNB_CACHE_IMPORT_PATH"
+	EnvExportCache   = "CNB_CACHE_EXPORT_PATH"
+	EnvPruneCache    = "CNB_CACHE_PRUNE_AGE"         // defaults to 0, which does not prune
+	EnvListCache     = "CNB_CACHE_LIST"              // defaults to false
+	EnvNoPrevCache   = "CNB_CACHE_NO_PREVIOUS"       // defaults to false
+	EnvCachePageSize = "CNB_CACHE_PAGE_SIZE"         // defaults to 0, which does not split
+	EnvCacheCompress = "CNB_CACHE_COMPRESSION_LEVEL" // defaults to "default"
+	EnvCacheDigest   = "CNB_CACHE_DIGEST"
+	EnvCacheArtifact = "CNB_CACHE_ARTIFACT"    // defaults to false
+	EnvWarnDigest    = "CNB_WARN_CACHE_DIGEST" // defaults to false
+	EnvSignKey       = "CNB_SIGN_KEY"
+	EnvCosignPath    = "CNB_COSIGN_PATH" // defaults to cosign on the PATH
+	EnvAttachBOM     = "CNB_ATTACH_BOM"  // defaults to false
+	EnvSecretsDir    = "CNB_SECRETS_DIR"
+
+	EnvFailDeprecatedStack = "CNB_FAIL_DEPRECATED_STACK" // defaults to false
+
+	EnvRegistryPullAuth = "CNB_REGISTRY_PULL_AUTH" // overrides CNB_REGISTRY_AUTH for reading images
+	EnvRegistryPushAuth = "CNB_REGISTRY_PUSH_AUTH" // overrides CNB_REGISTRY_AUTH for writing images
+
+	EnvProvenancePath   = "CNB_PROVENANCE_PATH"
+	EnvAttachProvenance = "CNB_ATTACH_PROVENANCE" // defaults to false
+	EnvCloudAuth        = "CNB_CLOUD_AUTH"        // defaults to false
+
+	EnvContainerdNamespace = "CNB_CONTAINERD_NAMESPACE"
+	EnvCtrPath             = "CNB_CTR_PATH" // defaults to ctr on the PATH
+
+	EnvTargetPlatform = "CNB_TARGET_PLATFORM" // defaults to the platform of the lifecycle
+	EnvImageIndex     = "CNB_IMAGE_INDEX"
 )
 
+func FlagConfigPath(path *string) {
+	flag.StringVar(path, "config", os.Getenv(EnvConfigPath), "path to TOML file containing flag values")
+}
+
 func FlagLayersDir(dir *string) {
 	flag.StringVar(dir, "layers", envWithDefault(EnvLayersDir, DefaultLayersDir), "path to layers directory")
 }
@@ -49,6 +105,10 @@ func FlagBuildpacksDir(dir *string) {
 	flag.StringVar(dir, "buildpacks", envWithDefault(EnvBuildpacksDir, DefaultBuildpacksDir), "path to buildpacks directory")
 }
 
+func FlagExtensionsDir(dir *string) {
+	flag.StringVar(dir, "extensions", envWithDefault(EnvExtensionsDir, DefaultExtensionsDir), "path to extensions directory")
+}
+
 func FlagPlatformDir(dir *string) {
 	flag.StringVar(dir, "platform", envWithDefault(EnvPlatformDir, DefaultPlatformDir), "path to platform directory")
 }
@@ -58,7 +118,7 @@ func FlagOrderPath(path *string) {
 }
 
 func FlagGroupPath(path *string) {
-	flag.StringVar(path, "group", envWithDefault(EnvGroupPath, DefaultGroupPath), "path to group.toml")
+	flag.StringVar(path, "group", envWithDefault(EnvGroupPath, defaultLayersPath(DefaultGroupPath)), "path to group.toml (defaults to the working directory on platform API 0.1, and to the layers directory on later versions)")
 }
 
 func FlagStackPath(path *string) {
@@ -66,11 +126,23 @@ func FlagStackPath(path *string) {
 }
 
 func FlagPlanPath(path *string) {
-	flag.StringVar(path, "plan", envWithDefault(EnvPlanPath, DefaultPlanPath), "path to plan.toml")
+	flag.StringVar(path, "plan", envWithDefault(EnvPlanPath, defaultLayersPath(DefaultPlanPath)), "path to plan.toml (defaults to the working directory on platform API 0.1, and to the layers directory on later versions)")
+}
+
+func FlagSecretsDir(dir *string) {
+	flag.StringVar(dir, "secrets", os.Getenv(EnvSecretsDir), "path to a directory of secrets that buildpacks may read during the build but that are removed from exported layers")
+}
+
+func FlagGeneratedDir(dir *string) {
+	flag.StringVar(dir, "generated", envWithDefault(EnvGeneratedDir, DefaultGeneratedDir), "path to directory for the output of extensions")
 }
 
 func FlagRunImage(image *string) {
-	flag.StringVar(image, "image", os.Getenv(EnvRunImage), "reference to run image")
+	flag.StringVar(image, "image", os.Getenv(EnvRunImage), "reference to run image (defaults to the stack's run image or mirror in the app image's registry)")
+}
+
+func FlagBuildImage(image *string) {
+	flag.StringVar(image, "build-image", os.Getenv(EnvBuildImage), "reference to build image, checked for stack deprecation (defaults to the stack's build image)")
 }
 
 func FlagCacheImage(image *string) {
@@ -82,30 +154,175 @@ func FlagCachePath(path *string) {
 }
 
 func FlagUseDaemon(use *bool) {
-	flag.BoolVar(use, "daemon", boolEnv(EnvUseDaemon), "export to docker daemon")
+	flag.BoolVar(use, "daemon", boolEnv(EnvUseDaemon), "use the docker daemon for the previous, run, cache and exported images instead of a registry")
 }
 
 func FlagUseCredHelpers(use *bool) {
 	flag.BoolVar(use, "helpers", boolEnv(EnvUseHelpers), "use credential helpers")
 }
 
+func FlagContainerdNamespace(namespace *string) {
+	flag.StringVar(namespace, "containerd-namespace", os.Getenv(EnvContainerdNamespace), "containerd namespace, such as k8s.io, to read the previous image from and export to instead of a registry")
+}
+
+func FlagCtrPath(path *string) {
+	flag.StringVar(path, "ctr", envWithDefault(EnvCtrPath, "ctr"), "path to the ctr binary used to access the containerd namespace")
+}
+
+func FlagTargetPlatform(platform *string) {
+	flag.StringVar(platform, "target-platform", os.Getenv(EnvTargetPlatform), "os/arch[/variant] of the image to use from multi-arch run and previous images (defaults to the platform of the lifecycle)")
+}
+
+func FlagImageIndex(index *string) {
+	flag.StringVar(index, "index", os.Getenv(EnvImageIndex), "manifest list in the same repository to add the exported image to, replacing the image for its platform")
+}
+
+func FlagCloudAuth(use *bool) {
+	flag.BoolVar(use, "cloud-auth", boolEnv(EnvCloudAuth), "get credentials for ECR, GCR, Artifact Registry and ACR from the identity of the cloud instance or workload")
+}
+
+func FlagNoColor(noColor *bool) {
+	flag.BoolVar(noColor, "no-color", boolEnv(EnvNoColor) || os.Getenv("NO_COLOR") != "", "disable color output")
+}
+
+func FlagDebug(debug *bool) {
+	flag.BoolVar(debug, "debug", boolEnv(EnvDebug), "enable debug logging")
+}
+
+func FlagStrict(strict *bool) {
+	flag.BoolVar(strict, "strict", boolEnv(EnvStrict), "fail on unknown keys in group, plan, layer, metadata, buildpack and stack files instead of warning")
+}
+
+func FlagLogPrefix(prefix *string) {
+	flag.StringVar(prefix, "log-prefix", os.Getenv(EnvLogPrefix), "prefix for each line of buildpack output, with {id}, {version} and {name} placeholders")
+}
+
+func FlagLabelPrefix(prefix *string) {
+	flag.StringVar(prefix, "label-prefix", os.Getenv(EnvLabelPrefix), "prefix of the labels read and written by the lifecycle (defaults to io.buildpacks)")
+}
+
+func FlagStackID(id *string) {
+	flag.StringVar(id, "stack-id", os.Getenv(EnvStackID), "ID of the stack, provided to buildpacks as CNB_STACK_ID and checked against buildpacks during detection and against the run image during export (defaults to the ID in stack.toml)")
+}
+
+func FlagBOMPath(path *string) {
+	flag.StringVar(path, "bom", os.Getenv(EnvBOMPath), "path to write the bill of materials to, as CycloneDX if it ends in .json")
+}
+
+func FlagBuildMixins(mixins *string) {
+	flag.StringVar(mixins, "build-mixins", os.Getenv(EnvBuildMixins), "mixins label of the build image, a JSON array (defaults to the build image mixins in stack.toml)")
+}
+
+func FlagFailDeprecatedStack(fail *bool) {
+	flag.BoolVar(fail, "fail-deprecated-stack", boolEnv(EnvFailDeprecatedStack), "fail instead of warning when the build or run image is deprecated or past its end of life, or cannot be checked")
+}
+
+func FlagDiffIDIndex(path *string) {
+	flag.StringVar(path, "diffid-index", os.Getenv(EnvDiffIDIndex), "path to an index of layer digests, kept across phases and builds to avoid re-tarring unchanged layers (disabled when empty)")
+}
+
+func FlagCompressionLevel(level *string) {
+	flag.StringVar(level, "compression-level", envWithDefault(EnvCompression, "default"), "gzip level for layers pushed to a registry: 0-9, default, none, fastest, or best (layers exported to the daemon are not compressed)")
+}
+
+func FlagLinkCache(link *bool) {
+	flag.BoolVar(link, "link-cache", boolEnv(EnvLinkCache), "restore layers from an extracted copy kept in a cache directory, reflinking files where the filesystem supports it")
+}
+
+func FlagVerifyCache(verify *bool) {
+	flag.BoolVar(verify, "verify", boolEnv(EnvVerifyCache), "check the digest of every layer in a cache directory before restoring, removing broken layers from the cache")
+}
+
+func FlagCacheGenerations(n *int) {
+	flag.IntVar(n, "generations", intEnvWithDefault(EnvCacheGens, 0), "number of previous caches to keep in a cache directory")
+}
+
+func FlagImportCache(path *string) {
+	flag.StringVar(path, "import", os.Getenv(EnvImportCache), "path to a cache archive written by the cacher with -export, imported into the cache directory before restoring")
+}
+
+func FlagExportCache(path *string) {
+	flag.StringVar(path, "export", os.Getenv(EnvExportCache), "path to write the cache directory to as a single archive after caching, such as to upload it as a CI artifact")
+}
+
+func FlagNoPreviousCache(noPrevious *bool) {
+	flag.BoolVar(noPrevious, "no-previous", boolEnv(EnvNoPrevCache), "delete the cache image replaced in the daemon instead of keeping it with -previous appended to its tag")
+}
+
+func FlagCacheCompressionLevel(level *string) {
+	flag.StringVar(level, "compression-level", envWithDefault(EnvCacheCompress, "default"), "gzip level for cache layers pushed to a registry, separate from the level for app images: 0-9, default, none, fastest, or best")
+}
+
+func FlagCachePageSize(mb *int) {
+	flag.IntVar(mb, "page-size", intEnvWithDefault(EnvCachePageSize, 0), "maximum size in megabytes of the layers in each cache image, splitting larger caches across images with -page-N appended to their tags")
+}
+
+func FlagListCache(list *bool) {
+	flag.BoolVar(list, "list", boolEnv(EnvListCache), "print the layers in the cache instead of restoring them")
+}
+
+func FlagCacheArtifact(artifact *bool) {
+	flag.BoolVar(artifact, "artifact", boolEnv(EnvCacheArtifact), "push the cache image to the registry as an OCI artifact instead of a runnable image")
+}
+
+func FlagCacheDigest(digest *string) {
+	flag.StringVar(digest, "cache-digest", os.Getenv(EnvCacheDigest), "digest the cache image is expected to have, failing if its tag has moved")
+}
+
+func FlagWarnCacheDigest(warn *bool) {
+	flag.BoolVar(warn, "warn-cache-digest", boolEnv(EnvWarnDigest), "warn instead of failing when the cache image does not have the expected digest")
+}
+
+func FlagPruneCache(age *time.Duration) {
+	flag.DurationVar(age, "prune", durationEnv(EnvPruneCache), "remove layers from a cache directory that no build has cached within this duration, such as 720h, before restoring")
+}
+
+func FlagRollbackCache(generation *int) {
+	flag.IntVar(generation, "rollback", intEnvWithDefault(EnvRollbackCache, 0), "roll a cache directory back to a previous cache before restoring, where 1 is the cache committed before the current one")
+}
+
+func FlagAttachBOM(attach *bool) {
+	flag.BoolVar(attach, "attach-bom", boolEnv(EnvAttachBOM), "attach the BOM to the exported image as a CycloneDX artifact tagged after the image digest")
+}
+
+func FlagProvenancePath(path *string) {
+	flag.StringVar(path, "provenance", os.Getenv(EnvProvenancePath), "path to write a SLSA provenance statement for the exported image to")
+}
+
+func FlagAttachProvenance(attach *bool) {
+	flag.BoolVar(attach, "attach-provenance", boolEnv(EnvAttachProvenance), "attach a SLSA provenance statement to the exported image as an in-toto attestation tagged after the image digest")
+}
+
+func FlagSignKey(key *string) {
+	flag.StringVar(key, "sign-key", os.Getenv(EnvSignKey), "cosign key file or KMS URI to sign the exported image with (signing is disabled when empty)")
+}
+
+func FlagCosignPath(path *string) {
+	flag.StringVar(path, "cosign", envWithDefault(EnvCosignPath, "cosign"), "path to the cosign binary used to sign the exported image")
+}
+
 func FlagUID(uid *int) {
-	flag.IntVar(uid, "uid", intEnv(EnvUID), "UID of user in the stack's build and run images")
+	flag.IntVar(uid, "uid", intEnvWithDefault(EnvUID, -1), "UID of user in the stack's build and run images (defaults to the run image USER in the exporter, or the owner of layers directory)")
 }
 
 func FlagGID(gid *int) {
-	flag.IntVar(gid, "gid", intEnv(EnvGID), "GID of user's group in the stack's build and run images")
+	flag.IntVar(gid, "gid", intEnvWithDefault(EnvGID, -1), "GID of user's group in the stack's build and run images (defaults to the run image USER group in the exporter, or the group of layers directory)")
 }
 
+// Exit codes of the phases, as documented in package exitcode.
 const (
-	CodeFailed      = 1
-	CodeInvalidArgs = iota + 2
-	CodeInvalidEnv
-	CodeNotFound
-	CodeFailedDetect
-	CodeFailedBuild
-	CodeFailedLaunch
-	CodeFailedUpdate
+	CodeFailed                  = exitcode.Failed
+	CodeInvalidArgs             = exitcode.InvalidArgs
+	CodeInvalidEnv              = exitcode.InvalidEnv
+	CodeNotFound                = exitcode.NotFound
+	CodeFailedDetect            = exitcode.FailedDetect
+	CodeFailedBuild             = exitcode.FailedBuild
+	CodeFailedLaunch            = exitcode.FailedLaunch
+	CodeFailedUpdate            = exitcode.FailedUpdate
+	CodeInterrupted             = exitcode.Interrupted
+	CodeIncompatiblePlatformAPI = exitcode.IncompatiblePlatformAPI
+	CodeRetryable               = exitcode.Retryable
+	CodeInternal                = exitcode.Internal
 )
 
 type ErrorFail struct {
@@ -122,6 +339,14 @@ func (e *ErrorFail) Error() string {
 	return fmt.Sprintf("%s: %s", message, e.Err)
 }
 
+func (e *ErrorFail) Unwrap() error {
+	return e.Err
+}
+
+func (e *ErrorFail) ExitCode() int {
+	return e.Code
+}
+
 func FailCode(code int, action ...string) error {
 	return FailErrCode(nil, code, action...)
 }
@@ -138,21 +363,37 @@ func FailErrCode(err error, code int, action ...string) error {
 	return &ErrorFail{Err: err, Code: code, Action: action}
 }
 
+// Exit exits with the code of err, after logging it and writing the
+// termination message and error report. When the phase was interrupted, it
+// exits with the InterruptedError instead, after running the functions
+// registered with OnInterrupt.
 func Exit(err error) {
+	exitMu.Lock()
+	if interruptErr := interrupted(); interruptErr != nil {
+		err = interruptErr
+	}
 	if err == nil {
+		writeErrorReport(nil)
 		os.Exit(0)
 	}
-	logger := log.New(os.Stderr, "", 0)
+	logger := log.New(os.Stderr, logPrefix, 0)
 	logger.Printf("Error: %s\n", err)
-	if err, ok := err.(*ErrorFail); ok {
-		os.Exit(err.Code)
-	}
-	os.Exit(CodeFailed)
+	writeTerminationMessage(err)
+	writeErrorReport(err)
+	os.Exit(exitcode.Of(err))
 }
 
-func intEnv(k string) int {
+func intEnvWithDefault(k string, defaultVal int) int {
 	v := os.Getenv(k)
 	d, err := strconv.Atoi(v)
+	if err != nil {
+		return defaultVal
+	}
+	return d
+}
+
+func durationEnv(k string) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(k))
 	if err != nil {
 		return 0
 	}
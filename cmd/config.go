@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+// ReadConfigFile sets any flags not provided on the command line from the
+// top-level keys of the TOML file at path. Keys are flag names, e.g.
+//
+//	layers = "/layers"
+//	daemon = true
+//	uid = 1000
+//
+// Flags on the command line take precedence over the file, which takes
+// precedence over the environment variables flags default to, such as
+// CNB_LAYERS_DIR, since the file is given explicitly for the invocation.
+// Unknown keys and values of the wrong type are errors.
+func ReadConfigFile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	var config map[string]interface{}
+	if _, err := toml.DecodeFile(path, &config); err != nil {
+		return err
+	}
+
+	provided := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		provided[f.Name] = true
+	})
+
+	var keys []string
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if k == "config" {
+			return fmt.Errorf("config file '%s' may not set key 'config'", path)
+		}
+		if flag.Lookup(k) == nil {
+			return fmt.Errorf("config file '%s' contains unknown key '%s'", path, k)
+		}
+		if provided[k] {
+			continue
+		}
+		switch v := config[k].(type) {
+		case string, bool, int64, float64:
+			if err := flag.Set(k, fmt.Sprint(v)); err != nil {
+				return errors.Wrapf(err, "config file '%s' has invalid value for key '%s'", path, k)
+			}
+		default:
+			return fmt.Errorf("config file '%s' has unsupported value for key '%s'", path, k)
+		}
+	}
+	return nil
+}
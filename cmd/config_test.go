@@ -0,0 +1,123 @@
+package cmd_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/cmd"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestConfig(t *testing.T) {
+	spec.Run(t, "Config", testConfig, spec.Report(report.Terminal{}))
+}
+
+func testConfig(t *testing.T, when spec.G, it spec.S) {
+	when("#ReadConfigFile", func() {
+		var (
+			tmpDir      string
+			commandLine *flag.FlagSet
+			layersDir   string
+			useDaemon   bool
+			uid         int
+		)
+
+		// parse registers the flags, reading their defaults from the
+		// environment, and parses args as the command line.
+		parse := func(args ...string) {
+			flag.CommandLine = flag.NewFlagSet("phase", flag.ContinueOnError)
+			cmd.FlagLayersDir(&layersDir)
+			cmd.FlagUseDaemon(&useDaemon)
+			cmd.FlagUID(&uid)
+			h.AssertNil(t, flag.CommandLine.Parse(args))
+		}
+
+		writeConfig := func(contents string) string {
+			path := filepath.Join(tmpDir, "config.toml")
+			h.AssertNil(t, ioutil.WriteFile(path, []byte(contents), 0666))
+			return path
+		}
+
+		it.Before(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "lifecycle.config")
+			h.AssertNil(t, err)
+			commandLine = flag.CommandLine
+		})
+
+		it.After(func() {
+			flag.CommandLine = commandLine
+			os.Unsetenv(cmd.EnvLayersDir)
+			os.RemoveAll(tmpDir)
+		})
+
+		it("does nothing without a path", func() {
+			parse()
+			h.AssertNil(t, cmd.ReadConfigFile(""))
+			h.AssertEq(t, layersDir, cmd.DefaultLayersDir)
+		})
+
+		it("sets flags not provided on the command line", func() {
+			parse()
+			h.AssertNil(t, cmd.ReadConfigFile(writeConfig("layers = \"/some-layers\"\ndaemon = true\nuid = 1000\n")))
+			h.AssertEq(t, layersDir, "/some-layers")
+			h.AssertEq(t, useDaemon, true)
+			h.AssertEq(t, uid, 1000)
+		})
+
+		it("prefers flags on the command line", func() {
+			parse("-layers", "/cli-layers")
+			h.AssertNil(t, cmd.ReadConfigFile(writeConfig("layers = \"/file-layers\"\n")))
+			h.AssertEq(t, layersDir, "/cli-layers")
+		})
+
+		it("prefers the file over the environment", func() {
+			h.AssertNil(t, os.Setenv(cmd.EnvLayersDir, "/env-layers"))
+			parse()
+			h.AssertEq(t, layersDir, "/env-layers")
+			h.AssertNil(t, cmd.ReadConfigFile(writeConfig("layers = \"/file-layers\"\n")))
+			h.AssertEq(t, layersDir, "/file-layers")
+		})
+
+		it("keeps the environment for keys not in the file", func() {
+			h.AssertNil(t, os.Setenv(cmd.EnvLayersDir, "/env-layers"))
+			parse()
+			h.AssertNil(t, cmd.ReadConfigFile(writeConfig("daemon = true\n")))
+			h.AssertEq(t, layersDir, "/env-layers")
+		})
+
+		it("rejects unknown keys", func() {
+			parse()
+			path := writeConfig("some-key = \"some-value\"\n")
+			h.AssertError(t, cmd.ReadConfigFile(path), "config file '"+path+"' contains unknown key 'some-key'")
+		})
+
+		it("rejects the config key", func() {
+			parse()
+			path := writeConfig("config = \"other.toml\"\n")
+			h.AssertError(t, cmd.ReadConfigFile(path), "may not set key 'config'")
+		})
+
+		it("rejects values of the wrong type", func() {
+			parse()
+			path := writeConfig("uid = \"some-uid\"\n")
+			h.AssertError(t, cmd.ReadConfigFile(path), "config file '"+path+"' has invalid value for key 'uid'")
+
+			path = writeConfig("layers = [\"/some-layers\"]\n")
+			h.AssertError(t, cmd.ReadConfigFile(path), "config file '"+path+"' has unsupported value for key 'layers'")
+		})
+
+		it("rejects invalid TOML", func() {
+			parse()
+			if err := cmd.ReadConfigFile(writeConfig("layers = \n")); err == nil {
+				t.Fatal("Expected an error but got nil")
+			}
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"io/ioutil"
 	"log"
@@ -8,44 +9,124 @@ import (
 
 	"github.com/buildpack/lifecycle"
 	"github.com/buildpack/lifecycle/cmd"
+	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/stack"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
 var (
-	buildpacksDir string
-	appDir        string
-	platformDir   string
-	orderPath     string
+	configPath      string
+	strict          bool
+	buildID         string
+	debug           bool
+	logPrefix       string
+	noColor         bool
+	telemetryConfig cmd.Telemetry
+	profileConfig   cmd.Profile
+	buildpacksDir   string
+	extensionsDir   string
+	appDir          string
+	platformDir     string
+	stackPath       string
+	stackID         string
+	buildMixins     string
+	orderPath       string
 
-	groupPath string
-	planPath  string
+	groupPath    string
+	planPath     string
+	generatedDir string
 )
 
 func init() {
+	cmd.FlagConfigPath(&configPath)
+	cmd.FlagStrict(&strict)
+	cmd.FlagBuildID(&buildID)
+	cmd.FlagDebug(&debug)
+	cmd.FlagLogPrefix(&logPrefix)
+	cmd.FlagNoColor(&noColor)
+	cmd.FlagMetricsPath(&telemetryConfig.MetricsPath)
+	cmd.FlagMetricsPushgateway(&telemetryConfig.MetricsPushgateway)
+	cmd.FlagTimingsPath(&telemetryConfig.TimingsPath)
+	cmd.FlagCPUProfile(&profileConfig.CPUPath)
+	cmd.FlagMemProfile(&profileConfig.MemPath)
+	cmd.FlagTrace(&profileConfig.TracePath)
 	cmd.FlagBuildpacksDir(&buildpacksDir)
+	cmd.FlagExtensionsDir(&extensionsDir)
 	cmd.FlagAppDir(&appDir)
 	cmd.FlagPlatformDir(&platformDir)
+	cmd.FlagStackPath(&stackPath)
+	cmd.FlagStackID(&stackID)
+	cmd.FlagBuildMixins(&buildMixins)
 	cmd.FlagOrderPath(&orderPath)
 
 	cmd.FlagGroupPath(&groupPath)
 	cmd.FlagPlanPath(&planPath)
+	cmd.FlagGeneratedDir(&generatedDir)
 }
 
 func main() {
+	defer cmd.RecoverPanic()
 	// suppress output from libraries, lifecycle will not use standard logger
 	log.SetOutput(ioutil.Discard)
+	ctx := cmd.HandleSignals()
 
 	flag.Parse()
-	if flag.NArg() != 0 {
-		cmd.Exit(cmd.FailCode(cmd.CodeInvalidArgs, "parse arguments"))
+	if err := cmd.ReadConfigFile(configPath); err != nil {
+		cmd.Exit(cmd.FailErrCode(err, cmd.CodeInvalidArgs, "read config file"))
 	}
-	cmd.Exit(detect())
+	cmd.SetBuildID(&buildID)
+	if err := cmd.VerifyPlatformAPI(); err != nil {
+		cmd.Exit(err)
+	}
+	cmd.ResolveLayersPath(&groupPath, "", cmd.DefaultGroupPath)
+	cmd.ResolveLayersPath(&planPath, "", cmd.DefaultPlanPath)
+	var v cmd.Validator
+	v.Check(flag.NArg() == 0, "expected no arguments")
+	v.CheckFile("order", orderPath)
+	if err := v.Err(); err != nil {
+		cmd.Exit(err)
+	}
+	tracer := telemetry.NewTracer("detector", buildID)
+	cmd.Exit(telemetryConfig.Finish(tracer, profileConfig.Run(func() error { return detect(ctx, tracer) })))
 }
 
-func detect() error {
+func detect(ctx context.Context, tracer *telemetry.Tracer) error {
+	logger := lifecycle.NewDefaultLogger(os.Stdout, os.Stderr)
+	logger.OnWarn = cmd.AddWarning
+	logger.SetPrefix(cmd.LogPrefix())
+	logger.DebugEnabled = debug
+	if noColor {
+		logger.Color = false
+	}
+	lifecycle.UnknownKeys = lifecycle.WarnUnknownKeys(logger)
+	if strict {
+		lifecycle.UnknownKeys = lifecycle.RejectUnknownKeys
+	}
+
+	stk, err := stack.Read(stackPath)
+	if os.IsNotExist(err) {
+		stk = &stack.Stack{}
+	} else if err != nil {
+		return cmd.FailErr(err, "read stack")
+	}
+	if stackID == "" {
+		stackID = stk.ID
+	}
+	mixins := stk.BuildImage.Mixins
+	if buildMixins != "" {
+		mixins, err = metadata.ParseMixins([]byte(buildMixins))
+		if err != nil {
+			return cmd.FailErrCode(err, cmd.CodeInvalidArgs, "parse build mixins")
+		}
+	}
+
 	buildpacks, err := lifecycle.NewBuildpackMap(buildpacksDir)
 	if err != nil {
 		return cmd.FailErr(err, "read buildpack directory")
 	}
+	if err := buildpacks.AddExtensions(extensionsDir); err != nil {
+		return cmd.FailErr(err, "read extensions directory")
+	}
 	order, err := buildpacks.ReadOrder(orderPath)
 	if err != nil {
 		return cmd.FailErr(err, "read buildpack order file")
@@ -54,8 +135,12 @@ func detect() error {
 	info, group := order.Detect(&lifecycle.DetectConfig{
 		AppDir:      appDir,
 		PlatformDir: platformDir,
-		Out:         log.New(os.Stdout, "", 0),
-		Err:         log.New(os.Stderr, "", 0),
+		StackID:     stackID,
+		BuildMixins: mixins,
+		Logger:      logger,
+		Tracer:      tracer,
+		LogPrefix:   logPrefix,
+		Context:     ctx,
 	})
 	if group == nil {
 		return cmd.FailCode(cmd.CodeFailedDetect, "detect")
@@ -65,9 +150,31 @@ func detect() error {
 		return cmd.FailErr(err, "write buildpack group")
 	}
 
-	if err := ioutil.WriteFile(planPath, info, 0666); err != nil {
+	if err := lifecycle.WritePlan(planPath, info); err != nil {
 		return cmd.FailErr(err, "write detect info")
 	}
 
+	if len(group.Extensions) > 0 {
+		var plan lifecycle.Plan
+		if err := lifecycle.DecodeFile(planPath, &plan); err != nil {
+			return cmd.FailErr(err, "parse build plan")
+		}
+		generator := &lifecycle.Generator{
+			AppDir:       appDir,
+			PlatformDir:  platformDir,
+			GeneratedDir: generatedDir,
+			Extensions:   group.Extensions,
+			Plan:         plan,
+			Out:          os.Stdout,
+			Err:          os.Stderr,
+			Tracer:       tracer,
+			LogPrefix:    logPrefix,
+			Context:      ctx,
+		}
+		if err := generator.Generate(); err != nil {
+			return cmd.FailErrCode(err, cmd.CodeFailedDetect, "generate")
+		}
+	}
+
 	return nil
 }
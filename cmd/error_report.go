@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildpack/lifecycle/exitcode"
+)
+
+// EnvErrorReport is the path to write a JSON report of the error of a phase
+// to when it exits, or - for stderr, where the report is only written when
+// the phase fails or succeeds with warnings. Nothing is written when empty.
+const EnvErrorReport = "CNB_ERROR_REPORT"
+
+// ErrorReport describes the error a phase failed with, if any, and the
+// warnings it logged, for platforms to act on without parsing the logs.
+type ErrorReport struct {
+	Code      int      `json:"code"`
+	Phase     string   `json:"phase"`
+	Message   string   `json:"message,omitempty"`
+	Buildpack string   `json:"buildpack,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// NewErrorReport describes err, which is nil when the phase succeeded, as
+// the error of phase, with the warnings recorded so far. The buildpack is
+// that of the first error in the chain of causes of err with a BuildpackID.
+func NewErrorReport(phase string, err error) ErrorReport {
+	report := ErrorReport{Code: exitcode.Of(err), Phase: phase, Warnings: Warnings()}
+	if err != nil {
+		report.Message = err.Error()
+	}
+	for cause := err; cause != nil; {
+		if bpErr, ok := cause.(interface{ BuildpackID() string }); ok {
+			report.Buildpack = bpErr.BuildpackID()
+			break
+		}
+		switch e := cause.(type) {
+		case interface{ Cause() error }:
+			cause = e.Cause()
+		case interface{ Unwrap() error }:
+			cause = e.Unwrap()
+		default:
+			cause = nil
+		}
+	}
+	return report
+}
+
+// writeErrorReport writes the report of err, or of the warnings when err is
+// nil. Reports are written to files even when there is nothing to report, so
+// that a report left by an earlier run is not taken for that of this one.
+// Failing to write it must not hide err, so write errors are ignored.
+func writeErrorReport(err error) {
+	path := os.Getenv(EnvErrorReport)
+	if path == "" {
+		return
+	}
+	report := NewErrorReport(phaseName(), err)
+	if path == "-" && err == nil && len(report.Warnings) == 0 {
+		return
+	}
+	data, jsonErr := json.Marshal(report)
+	if jsonErr != nil {
+		if path != "-" {
+			os.Remove(path)
+		}
+		return
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		os.Stderr.Write(data)
+		return
+	}
+	ioutil.WriteFile(path, data, 0666)
+}
+
+// phaseName is the name of the running phase, that of its binary.
+func phaseName() string {
+	return strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -8,62 +9,185 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/BurntSushi/toml"
-
 	"github.com/buildpack/lifecycle"
 	"github.com/buildpack/lifecycle/cmd"
 	"github.com/buildpack/lifecycle/image"
+	"github.com/buildpack/lifecycle/image/auth"
 	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/stack"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
 var (
-	repoName    string
-	runImageRef string
-	layersDir   string
-	appDir      string
-	groupPath   string
-	stackPath   string
-	useDaemon   bool
-	useHelpers  bool
-	uid         int
-	gid         int
+	configPath      string
+	strict          bool
+	buildID         string
+	labelBuildID    string
+	debug           bool
+	noColor         bool
+	labelPrefix     string
+	telemetryConfig cmd.Telemetry
+	profileConfig   cmd.Profile
+	buildpacksDir   string
+	repoName        string
+	runImageRef     string
+	layersDir       string
+	appDir          string
+	groupPath       string
+	stackPath       string
+	bomPath         string
+	generatedDir    string
+	stackID         string
+	failDeprecated  bool
+	useDaemon       bool
+	useHelpers      bool
+	cloudAuth       bool
+	ctrNamespace    string
+	ctrPath         string
+	uid             int
+	gid             int
+	diffIDIndexPath string
+	compression     string
+	signKey         string
+	cosignPath      string
+	attachBOM       bool
+	provenancePath  string
+	attachProv      bool
+	platform        string
+	index           string
 )
 
 const launcherPath = "/lifecycle/launcher"
 
 func init() {
+	cmd.FlagConfigPath(&configPath)
+	cmd.FlagStrict(&strict)
+	cmd.FlagBuildID(&buildID)
+	cmd.FlagDebug(&debug)
+	cmd.FlagNoColor(&noColor)
+	cmd.FlagLabelPrefix(&labelPrefix)
+	cmd.FlagMetricsPath(&telemetryConfig.MetricsPath)
+	cmd.FlagMetricsPushgateway(&telemetryConfig.MetricsPushgateway)
+	cmd.FlagTimingsPath(&telemetryConfig.TimingsPath)
+	cmd.FlagCPUProfile(&profileConfig.CPUPath)
+	cmd.FlagMemProfile(&profileConfig.MemPath)
+	cmd.FlagTrace(&profileConfig.TracePath)
+	cmd.FlagBuildpacksDir(&buildpacksDir)
 	cmd.FlagRunImage(&runImageRef)
 	cmd.FlagLayersDir(&layersDir)
 	cmd.FlagAppDir(&appDir)
 	cmd.FlagGroupPath(&groupPath)
 	cmd.FlagStackPath(&stackPath)
+	cmd.FlagBOMPath(&bomPath)
+	cmd.FlagGeneratedDir(&generatedDir)
+	cmd.FlagStackID(&stackID)
+	cmd.FlagFailDeprecatedStack(&failDeprecated)
 	cmd.FlagUseDaemon(&useDaemon)
 	cmd.FlagUseCredHelpers(&useHelpers)
+	cmd.FlagCloudAuth(&cloudAuth)
+	cmd.FlagContainerdNamespace(&ctrNamespace)
+	cmd.FlagCtrPath(&ctrPath)
 	cmd.FlagUID(&uid)
 	cmd.FlagGID(&gid)
+	cmd.FlagDiffIDIndex(&diffIDIndexPath)
+	cmd.FlagCompressionLevel(&compression)
+	cmd.FlagSignKey(&signKey)
+	cmd.FlagCosignPath(&cosignPath)
+	cmd.FlagAttachBOM(&attachBOM)
+	cmd.FlagProvenancePath(&provenancePath)
+	cmd.FlagAttachProvenance(&attachProv)
+	cmd.FlagTargetPlatform(&platform)
+	cmd.FlagImageIndex(&index)
 }
 
 func main() {
+	defer cmd.RecoverPanic()
 	// suppress output from libraries, lifecycle will not use standard logger
 	log.SetOutput(ioutil.Discard)
+	ctx := cmd.HandleSignals()
 
 	flag.Parse()
-	if flag.NArg() > 1 || flag.Arg(0) == "" || runImageRef == "" {
-		args := map[string]interface{}{"narg": flag.NArg(), "runImage": runImageRef, "layersDir": layersDir}
-		cmd.Exit(cmd.FailCode(cmd.CodeInvalidArgs, "parse arguments", fmt.Sprintf("%+v", args)))
+	if err := cmd.ReadConfigFile(configPath); err != nil {
+		cmd.Exit(cmd.FailErrCode(err, cmd.CodeInvalidArgs, "read config file"))
+	}
+	// only an ID given by the platform identifies the build in image labels,
+	// since other phases generate their own
+	labelBuildID = buildID
+	cmd.SetBuildID(&buildID)
+	if err := cmd.VerifyPlatformAPI(); err != nil {
+		cmd.Exit(err)
 	}
+	cmd.ResolveLayersPath(&groupPath, layersDir, cmd.DefaultGroupPath)
 	repoName = flag.Arg(0)
-	cmd.Exit(export())
+	toRegistry := !useDaemon && ctrNamespace == ""
+	var v cmd.Validator
+	v.Check(flag.NArg() <= 1 && repoName != "", fmt.Sprintf("expected one argument, the image name, got %d", flag.NArg()))
+	v.Check(ctrNamespace == "" || !useDaemon, "-containerd-namespace and -daemon are exclusive")
+	v.Check(signKey == "" || toRegistry, "signing requires exporting to a registry")
+	v.Check(!attachBOM || toRegistry, "attaching the BOM requires exporting to a registry")
+	v.Check(!attachProv || toRegistry, "attaching provenance requires exporting to a registry")
+	v.Check(index == "" || toRegistry, "adding the image to an index requires exporting to a registry")
+	v.CheckPhaseOutput("group", groupPath)
+	v.CheckUIDGID(uid, gid)
+	if err := v.Err(); err != nil {
+		cmd.Exit(err)
+	}
+	tracer := telemetry.NewTracer("exporter", buildID)
+	cmd.Exit(telemetryConfig.Finish(tracer, profileConfig.Run(func() error { return export(ctx, tracer) })))
 }
 
-func export() error {
-	var err error
+func export(ctx context.Context, tracer *telemetry.Tracer) error {
+	logger := lifecycle.NewDefaultLogger(os.Stdout, os.Stderr)
+	logger.OnWarn = cmd.AddWarning
+	logger.SetPrefix(cmd.LogPrefix())
+	logger.DebugEnabled = debug
+	if noColor {
+		logger.Color = false
+	}
+	lifecycle.UnknownKeys = lifecycle.WarnUnknownKeys(logger)
+	if strict {
+		lifecycle.UnknownKeys = lifecycle.RejectUnknownKeys
+	}
+	if labelPrefix != "" {
+		metadata.LabelPrefix = labelPrefix
+	}
 
-	var group lifecycle.BuildpackGroup
-	if _, err := toml.DecodeFile(groupPath, &group); err != nil {
+	buildpacks, err := lifecycle.NewBuildpackMap(buildpacksDir)
+	if err != nil {
+		return cmd.FailErr(err, "read buildpack directory")
+	}
+	group, err := buildpacks.ReadGroup(groupPath)
+	if err != nil {
 		return cmd.FailErr(err, "read group")
 	}
 
+	stk, err := stack.Read(stackPath)
+	if os.IsNotExist(err) {
+		logger.Infof("no stack.toml found at path '%s', stack metadata will not be exported\n", stackPath)
+		stk = &stack.Stack{}
+	} else if err != nil {
+		return cmd.FailErr(err, "read stack")
+	}
+	if stackID == "" {
+		stackID = stk.ID
+	}
+
+	if runImageRef == "" {
+		generated, err := stack.Read(filepath.Join(generatedDir, "run.toml"))
+		if err == nil {
+			runImageRef = generated.RunImage.Image
+		} else if !os.IsNotExist(err) {
+			return cmd.FailErr(err, "read run image selected by extensions")
+		}
+	}
+	if runImageRef == "" {
+		runImageRef, err = stk.Metadata().RunImageForRegistry(repoName)
+		if err != nil {
+			return cmd.FailErrCode(err, cmd.CodeInvalidArgs, "select run image")
+		}
+		logger.Debugf("using run image '%s' for app image '%s'", runImageRef, repoName)
+	}
+
 	if useHelpers {
 		if err := lifecycle.SetupCredHelpers(filepath.Join(os.Getenv("HOME"), ".docker"), repoName, runImageRef); err != nil {
 			return cmd.FailErr(err, "setup credential helpers")
@@ -75,28 +199,41 @@ func export() error {
 		return cmd.FailErr(err, "create temp directory")
 	}
 	defer os.RemoveAll(artifactsDir)
+	cmd.OnInterrupt(func() { os.RemoveAll(artifactsDir) })
 
-	outLog := log.New(os.Stdout, "", 0)
-	errLog := log.New(os.Stderr, "", 0)
 	exporter := &lifecycle.Exporter{
 		Buildpacks:   group.Buildpacks,
-		Out:          outLog,
-		Err:          errLog,
-		UID:          uid,
-		GID:          gid,
+		Logger:       logger,
+		Tracer:       tracer,
+		BuildID:      labelBuildID,
+		BOMPath:      bomPath,
+		StackID:      stackID,
 		ArtifactsDir: artifactsDir,
+
+		ProvenancePath:      provenancePath,
+		FailDeprecatedStack: failDeprecated,
 	}
 
-	factory, err := image.NewFactory(image.WithOutWriter(os.Stdout), image.WithEnvKeychain)
+	level, err := image.ParseCompressionLevel(compression)
 	if err != nil {
-		return err
+		return cmd.FailErrCode(err, cmd.CodeInvalidArgs, "parse compression level")
 	}
-
-	var stack metadata.StackMetadata
-	_, err = toml.DecodeFile(stackPath, &stack)
+	targetPlatform, err := image.ParsePlatform(platform)
 	if err != nil {
-		outLog.Printf("no stack.toml found at path '%s', stack metadata will not be exported\n", stackPath)
+		return cmd.FailErrCode(err, cmd.CodeInvalidArgs, "parse target platform")
 	}
+	ops := []func(*image.Factory){image.WithOutWriter(os.Stdout), image.WithContext(ctx), image.WithEnvKeychain, image.WithCompressionLevel(level), image.WithPlatform(targetPlatform)}
+	if cloudAuth {
+		ops = append(ops, image.WithCloudKeychain)
+	}
+	if ctrNamespace != "" {
+		ops = append(ops, image.WithContainerd(ctrNamespace, ctrPath))
+	}
+	factory, err := image.NewFactory(ops...)
+	if err != nil {
+		return err
+	}
+	defer factory.Cleanup()
 
 	var runImage, origImage image.Image
 	if useDaemon {
@@ -104,6 +241,7 @@ func export() error {
 		if err != nil {
 			return err
 		}
+		exporter.RegistryRunImage = registryRunImage(logger, factory)
 		origImage, err = factory.NewLocal(repoName)
 		if err != nil {
 			return err
@@ -113,15 +251,100 @@ func export() error {
 		if err != nil {
 			return err
 		}
-		origImage, err = factory.NewRemote(repoName)
+		if ctrNamespace != "" {
+			origImage, err = factory.NewContainerd(repoName)
+		} else {
+			origImage, err = factory.NewRemote(repoName)
+		}
 		if err != nil {
 			return err
 		}
 	}
 
-	if err := exporter.Export(layersDir, appDir, runImage, origImage, launcherPath, stack); err != nil {
+	if uid >= 0 && gid >= 0 {
+		exporter.User = fmt.Sprintf("%d:%d", uid, gid)
+	} else if user, err := runImage.User(); err == nil {
+		cmd.UserUIDGID(&uid, &gid, user)
+	}
+	if err := cmd.DetectUIDGID(&uid, &gid, layersDir, appDir); err != nil {
+		return cmd.FailErrCode(err, cmd.CodeInvalidArgs, "determine uid/gid")
+	}
+	exporter.UID, exporter.GID = uid, gid
+
+	if attachBOM {
+		exporter.SBOMAttacher = factory
+	}
+	if attachProv {
+		exporter.ProvenanceAttacher = factory
+	}
+	if index != "" {
+		exporter.Index, exporter.IndexWriter = index, factory
+	}
+	if signKey != "" {
+		signer, cleanup, err := newSigner()
+		if err != nil {
+			return cmd.FailErr(err, "configure image signing")
+		}
+		defer cleanup()
+		exporter.Signer = signer
+	}
+
+	if diffIDIndexPath != "" {
+		if exporter.DiffIDIndex, err = lifecycle.ReadDiffIDIndex(diffIDIndexPath); err != nil {
+			return cmd.FailErr(err, "read diff ID index")
+		}
+	}
+
+	if err := exporter.Export(layersDir, appDir, runImage, origImage, launcherPath, stk.Metadata()); err != nil {
 		return cmd.FailErrCode(err, cmd.CodeFailedBuild)
 	}
+	if err := exporter.DiffIDIndex.Save(); err != nil {
+		logger.Warnf("could not save diff ID index: %s", err)
+	}
 
 	return nil
 }
+
+// registryRunImage returns the run image in the registry to compare the
+// daemon run image with, or nil if it cannot be accessed, since the
+// comparison is advisory.
+func registryRunImage(logger lifecycle.Logger, factory *image.Factory) image.Image {
+	runImage, err := factory.NewRemote(runImageRef)
+	if err != nil {
+		logger.Warnf("could not check whether run image '%s' is outdated: %s", runImageRef, err)
+		return nil
+	}
+	return runImage
+}
+
+// newSigner returns a signer that gives cosign the registry push credentials
+// in the environment through a temporary docker config, and a function that
+// removes it.
+func newSigner() (*lifecycle.Signer, func(), error) {
+	signer := &lifecycle.Signer{
+		Cosign: cosignPath,
+		Key:    signKey,
+		Out:    os.Stdout,
+		Err:    os.Stderr,
+	}
+	if os.Getenv(cmd.EnvRegistryAuth) == "" && os.Getenv(cmd.EnvRegistryPushAuth) == "" {
+		return signer, func() {}, nil
+	}
+
+	config, err := auth.DockerConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, err := ioutil.TempDir("", "lifecycle.exporter.docker-config")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	cmd.OnInterrupt(cleanup)
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), config, 0600); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	signer.Env = []string{"DOCKER_CONFIG=" + dir}
+	return signer, cleanup, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/lifecycle/cmd"
+	"github.com/buildpack/lifecycle/stack"
+	"github.com/buildpack/lifecycle/telemetry"
+)
+
+var (
+	configPath      string
+	strict          bool
+	buildID         string
+	debug           bool
+	noColor         bool
+	telemetryConfig cmd.Telemetry
+	profileConfig   cmd.Profile
+	groupPath       string
+	generatedDir    string
+	stackPath       string
+	runImageRef     string
+)
+
+func init() {
+	cmd.FlagConfigPath(&configPath)
+	cmd.FlagStrict(&strict)
+	cmd.FlagBuildID(&buildID)
+	cmd.FlagDebug(&debug)
+	cmd.FlagNoColor(&noColor)
+	cmd.FlagMetricsPath(&telemetryConfig.MetricsPath)
+	cmd.FlagMetricsPushgateway(&telemetryConfig.MetricsPushgateway)
+	cmd.FlagTimingsPath(&telemetryConfig.TimingsPath)
+	cmd.FlagCPUProfile(&profileConfig.CPUPath)
+	cmd.FlagMemProfile(&profileConfig.MemPath)
+	cmd.FlagTrace(&profileConfig.TracePath)
+	cmd.FlagGroupPath(&groupPath)
+	cmd.FlagGeneratedDir(&generatedDir)
+	cmd.FlagStackPath(&stackPath)
+	cmd.FlagRunImage(&runImageRef)
+}
+
+func main() {
+	defer cmd.RecoverPanic()
+	// suppress output from libraries, lifecycle will not use standard logger
+	log.SetOutput(ioutil.Discard)
+	ctx := cmd.HandleSignals()
+
+	flag.Parse()
+	if err := cmd.ReadConfigFile(configPath); err != nil {
+		cmd.Exit(cmd.FailErrCode(err, cmd.CodeInvalidArgs, "read config file"))
+	}
+	cmd.SetBuildID(&buildID)
+	if err := cmd.VerifyPlatformAPI(); err != nil {
+		cmd.Exit(err)
+	}
+	cmd.ResolveLayersPath(&groupPath, "", cmd.DefaultGroupPath)
+	var v cmd.Validator
+	v.Check(flag.NArg() == 0, "expected no arguments")
+	v.CheckPhaseOutput("group", groupPath)
+	if err := v.Err(); err != nil {
+		cmd.Exit(err)
+	}
+	tracer := telemetry.NewTracer("extender", buildID)
+	cmd.Exit(telemetryConfig.Finish(tracer, profileConfig.Run(func() error { return extend(ctx, tracer) })))
+}
+
+func extend(ctx context.Context, tracer *telemetry.Tracer) error {
+	logger := lifecycle.NewDefaultLogger(os.Stdout, os.Stderr)
+	logger.OnWarn = cmd.AddWarning
+	logger.SetPrefix(cmd.LogPrefix())
+	logger.DebugEnabled = debug
+	if noColor {
+		logger.Color = false
+	}
+	lifecycle.UnknownKeys = lifecycle.WarnUnknownKeys(logger)
+	if strict {
+		lifecycle.UnknownKeys = lifecycle.RejectUnknownKeys
+	}
+
+	var group lifecycle.BuildpackGroup
+	if err := lifecycle.DecodeFile(groupPath, &group); err != nil {
+		return cmd.FailErr(err, "read buildpack group")
+	}
+	if len(group.Extensions) == 0 {
+		logger.Debugf("no extensions in group, nothing to extend")
+		return nil
+	}
+
+	extender := &lifecycle.Extender{
+		GeneratedDir: generatedDir,
+		Extensions:   group.Extensions,
+		Out:          os.Stdout,
+		Err:          os.Stderr,
+		Tracer:       tracer,
+		Context:      ctx,
+	}
+	if err := extender.ExtendBuild(); err != nil {
+		return cmd.FailErrCode(err, cmd.CodeFailedBuild, "extend build image")
+	}
+
+	if runImageRef == "" {
+		stk, err := stack.Read(stackPath)
+		if err != nil && !os.IsNotExist(err) {
+			return cmd.FailErr(err, "read stack")
+		} else if err == nil {
+			runImageRef = stk.RunImage.Image
+		}
+	}
+	image, found, err := extender.RunImage(runImageRef)
+	if err != nil {
+		return cmd.FailErrCode(err, cmd.CodeFailedBuild, "select run image")
+	}
+	if !found {
+		return nil
+	}
+	logger.Infof("using run image '%s' selected by extensions", image)
+	runTOML := struct {
+		RunImage stack.RunImage `toml:"run-image"`
+	}{stack.RunImage{Image: image}}
+	if err := lifecycle.WriteTOML(filepath.Join(generatedDir, "run.toml"), runTOML); err != nil {
+		return cmd.FailErr(err, "write run image")
+	}
+	return nil
+}
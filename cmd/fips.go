@@ -0,0 +1,10 @@
+//go:build fips
+// +build fips
+
+package cmd
+
+// Building with the fips tag restricts TLS for registries and telemetry
+// endpoints to FIPS-approved settings. It requires a toolchain with a FIPS
+// validated crypto module, such as GOEXPERIMENT=boringcrypto, and fails to
+// build otherwise.
+import _ "crypto/tls/fipsonly"
@@ -6,8 +6,6 @@ import (
 	"strings"
 	"syscall"
 
-	"github.com/BurntSushi/toml"
-
 	"github.com/buildpack/lifecycle"
 	"github.com/buildpack/lifecycle/cmd"
 )
@@ -18,10 +16,15 @@ var (
 )
 
 func main() {
+	defer cmd.RecoverPanic()
 	cmd.Exit(launch())
 }
 
 func launch() error {
+	if err := cmd.VerifyPlatformAPI(); err != nil {
+		return err
+	}
+
 	defaultProcessType := "web"
 	if v := os.Getenv("PACK_PROCESS_TYPE"); v != "" {
 		defaultProcessType = v
@@ -41,7 +44,7 @@ func launch() error {
 
 	var metadata lifecycle.BuildMetadata
 	metadataPath := filepath.Join(layersDir, "config", "metadata.toml")
-	if _, err := toml.DecodeFile(metadataPath, &metadata); err != nil {
+	if err := lifecycle.DecodeFile(metadataPath, &metadata, "bom"); err != nil {
 		return cmd.FailErr(err, "read metadata")
 	}
 
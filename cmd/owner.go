@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DetectUIDGID sets uid and gid, when they were not provided, to the owner
+// of the first directory in dirs that exists.
+func DetectUIDGID(uid, gid *int, dirs ...string) error {
+	if *uid >= 0 && *gid >= 0 {
+		return nil
+	}
+	for _, dir := range dirs {
+		fi, err := os.Stat(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		fileUID, fileGID, ok := owner(fi)
+		if !ok {
+			break
+		}
+		if *uid < 0 {
+			*uid = fileUID
+		}
+		if *gid < 0 {
+			*gid = fileGID
+		}
+		return nil
+	}
+	return fmt.Errorf("could not determine owner of %v, provide -uid and -gid", dirs)
+}
+
+// UserUIDGID sets uid and gid, when they were not provided, from an image
+// USER of the form uid[:gid]. Users given by name are ignored.
+func UserUIDGID(uid, gid *int, user string) {
+	parts := strings.SplitN(user, ":", 2)
+	if u, err := strconv.Atoi(parts[0]); err == nil && *uid < 0 {
+		*uid = u
+	}
+	if len(parts) < 2 {
+		return
+	}
+	if g, err := strconv.Atoi(parts[1]); err == nil && *gid < 0 {
+		*gid = g
+	}
+}
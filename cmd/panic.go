@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// EnvDiagnosticsDir is the directory to write the stack trace of a phase that
+// panics to, defaulting to the temporary directory.
+const EnvDiagnosticsDir = "CNB_DIAGNOSTICS_DIR"
+
+// PanicError is the error of a phase that panicked.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+	Path  string // diagnostics file with the stack trace, if written
+}
+
+func (e *PanicError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("internal error: %v", e.Value)
+	}
+	return fmt.Sprintf("internal error: %v (stack trace written to %s, please include it when reporting this issue)", e.Value, e.Path)
+}
+
+func (e *PanicError) ExitCode() int { return CodeInternal }
+
+// RecoverPanic, deferred at the start of main, exits with CodeInternal when
+// the phase panics, after writing the stack trace to a diagnostics file.
+func RecoverPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := &PanicError{Value: r, Stack: debug.Stack()}
+	err.Path = writeDiagnostics(err)
+	Exit(err)
+}
+
+// writeDiagnostics writes the stack trace of err to a new file and returns its
+// path, or an empty path if it could not be written.
+func writeDiagnostics(err *PanicError) string {
+	dir := os.Getenv(EnvDiagnosticsDir)
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	f, fileErr := ioutil.TempFile(dir, phaseName()+"-panic-*.txt")
+	if fileErr != nil {
+		return ""
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "phase: %s\n", phaseName())
+	fmt.Fprintf(f, "args: %s\n", strings.Join(os.Args[1:], " "))
+	fmt.Fprintf(f, "platform API: %s\n", PlatformAPIVersion())
+	fmt.Fprintf(f, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(f, "time: %s\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(f, "panic: %v\n\n%s", err.Value, err.Stack)
+	return f.Name()
+}
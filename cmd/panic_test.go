@@ -0,0 +1,86 @@
+package cmd_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/cmd"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+// envPanic makes TestRecoverPanic panic instead of running its specs, in the
+// test binary the specs run as a phase.
+const envPanic = "LIFECYCLE_TEST_PANIC"
+
+func TestRecoverPanic(t *testing.T) {
+	if os.Getenv(envPanic) != "" {
+		defer cmd.RecoverPanic()
+		panic("some-panic")
+	}
+	spec.Run(t, "RecoverPanic", testRecoverPanic, spec.Report(report.Terminal{}))
+}
+
+func testRecoverPanic(t *testing.T, when spec.G, it spec.S) {
+	var tmpDir string
+
+	// runPanic runs the test binary as a phase that panics, with the
+	// diagnostics directory dir and platform API 0.2, and returns its stderr
+	// and exit code.
+	runPanic := func(dir string) (string, int) {
+		c := exec.Command(os.Args[0], "-test.run=^TestRecoverPanic$")
+		c.Env = append(os.Environ(), envPanic+"=1", cmd.EnvDiagnosticsDir+"="+dir, cmd.EnvPlatformAPI+"=0.2")
+		stderr := &bytes.Buffer{}
+		c.Stderr = stderr
+		err := c.Run()
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatalf("Expected the phase to exit with an error, got: %v", err)
+		}
+		return stderr.String(), exitErr.ExitCode()
+	}
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.panic.test.")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	when("the phase panics", func() {
+		it("writes the stack trace to a diagnostics file and exits with the internal error code", func() {
+			stderr, code := runPanic(tmpDir)
+
+			h.AssertEq(t, code, cmd.CodeInternal)
+			matches := regexp.MustCompile(`internal error: some-panic \(stack trace written to (\S+), please include it when reporting this issue\)`).FindStringSubmatch(stderr)
+			if matches == nil {
+				t.Fatalf("Expected the error to name the diagnostics file, got: %s", stderr)
+			}
+			h.AssertEq(t, filepath.Dir(matches[1]), tmpDir)
+			contents, err := ioutil.ReadFile(matches[1])
+			h.AssertNil(t, err)
+			h.AssertMatch(t, string(contents), regexp.MustCompile(`(?m)^phase: \S+\.test$`))
+			h.AssertMatch(t, string(contents), regexp.MustCompile(`(?m)^args: -test\.run=\^TestRecoverPanic\$$`))
+			h.AssertMatch(t, string(contents), regexp.MustCompile(`(?m)^platform API: 0\.2$`))
+			h.AssertMatch(t, string(contents), regexp.MustCompile(`(?m)^panic: some-panic$`))
+			h.AssertMatch(t, string(contents), regexp.MustCompile(`goroutine \d+`))
+		})
+
+		it("exits with the internal error code when the diagnostics file cannot be written", func() {
+			stderr, code := runPanic(filepath.Join(tmpDir, "missing"))
+
+			h.AssertEq(t, code, cmd.CodeInternal)
+			h.AssertMatch(t, stderr, regexp.MustCompile(`internal error: some-panic\n`))
+		})
+	})
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	EnvPlatformAPI = "CNB_PLATFORM_API"
+
+	// DefaultPlatformAPI is the platform API used when CNB_PLATFORM_API is
+	// not set, so that platforms which do not request a version keep the
+	// behavior they were written against.
+	DefaultPlatformAPI = "0.1"
+
+	// PlatformAPI is the latest platform API implemented by the lifecycle.
+	PlatformAPI = "0.2"
+)
+
+// SupportedPlatformAPIs are the platform APIs the lifecycle implements.
+var SupportedPlatformAPIs = []string{"0.1", "0.2"}
+
+// PlatformAPIVersion returns the platform API requested by the platform.
+func PlatformAPIVersion() string {
+	return envWithDefault(EnvPlatformAPI, DefaultPlatformAPI)
+}
+
+// VerifyPlatformAPI fails if the requested platform API is not implemented
+// by the lifecycle.
+func VerifyPlatformAPI() error {
+	platformAPI := PlatformAPIVersion()
+	for _, api := range SupportedPlatformAPIs {
+		if api == platformAPI {
+			return nil
+		}
+	}
+	return FailErrCode(
+		fmt.Errorf("platform API '%s' is not supported, the lifecycle supports %s", platformAPI, strings.Join(SupportedPlatformAPIs, ", ")),
+		CodeIncompatiblePlatformAPI, "verify platform API",
+	)
+}
+
+// defaultLayersPath returns the default of a flag for a file the platform
+// API 0.1 expects in the working directory, or an empty default on later
+// versions, which expect it in the layers directory; see ResolveLayersPath.
+func defaultLayersPath(workingDirPath string) string {
+	if PlatformAPIVersion() == "0.1" {
+		return workingDirPath
+	}
+	return ""
+}
+
+// ResolveLayersPath sets path, if it was not given, to the file of
+// workingDirPath in layersDir, where platform APIs after 0.1 expect it. It is
+// called after flags and the config file are read, so that the layers
+// directory they give is used. Phases without a layers directory flag pass an
+// empty layersDir, for CNB_LAYERS_DIR or DefaultLayersDir.
+func ResolveLayersPath(path *string, layersDir, workingDirPath string) {
+	if *path != "" {
+		return
+	}
+	if layersDir == "" {
+		layersDir = envWithDefault(EnvLayersDir, DefaultLayersDir)
+	}
+	*path = filepath.Join(layersDir, filepath.Base(workingDirPath))
+}
@@ -0,0 +1,124 @@
+package cmd_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/cmd"
+	"github.com/buildpack/lifecycle/exitcode"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestPlatform(t *testing.T) {
+	spec.Run(t, "Platform", testPlatform, spec.Report(report.Terminal{}))
+}
+
+func testPlatform(t *testing.T, when spec.G, it spec.S) {
+	var (
+		commandLine *flag.FlagSet
+		layersDir   string
+		groupPath   string
+		planPath    string
+	)
+
+	// parse registers the flags, reading their defaults from the
+	// environment, parses args as the command line, reads the config file
+	// and resolves the paths in the layers directory, as phases do.
+	parse := func(configPath string, args ...string) {
+		flag.CommandLine = flag.NewFlagSet("phase", flag.ContinueOnError)
+		cmd.FlagLayersDir(&layersDir)
+		cmd.FlagGroupPath(&groupPath)
+		cmd.FlagPlanPath(&planPath)
+		h.AssertNil(t, flag.CommandLine.Parse(args))
+		h.AssertNil(t, cmd.ReadConfigFile(configPath))
+		cmd.ResolveLayersPath(&groupPath, layersDir, cmd.DefaultGroupPath)
+		cmd.ResolveLayersPath(&planPath, layersDir, cmd.DefaultPlanPath)
+	}
+
+	it.Before(func() {
+		commandLine = flag.CommandLine
+	})
+
+	it.After(func() {
+		flag.CommandLine = commandLine
+		os.Unsetenv(cmd.EnvPlatformAPI)
+		os.Unsetenv(cmd.EnvLayersDir)
+	})
+
+	when("the platform API is not set", func() {
+		it("uses platform API 0.1", func() {
+			h.AssertEq(t, cmd.PlatformAPIVersion(), "0.1")
+			h.AssertNil(t, cmd.VerifyPlatformAPI())
+		})
+	})
+
+	when("the platform API is 0.1", func() {
+		it.Before(func() {
+			h.AssertNil(t, os.Setenv(cmd.EnvPlatformAPI, "0.1"))
+		})
+
+		it("defaults group and plan paths to the working directory", func() {
+			parse("", "-layers", "/some/layers")
+			h.AssertNil(t, cmd.VerifyPlatformAPI())
+			h.AssertEq(t, groupPath, cmd.DefaultGroupPath)
+			h.AssertEq(t, planPath, cmd.DefaultPlanPath)
+		})
+	})
+
+	when("the platform API is 0.2", func() {
+		it.Before(func() {
+			h.AssertNil(t, os.Setenv(cmd.EnvPlatformAPI, "0.2"))
+		})
+
+		it("defaults group and plan paths to the layers directory given on the command line", func() {
+			h.AssertNil(t, os.Setenv(cmd.EnvLayersDir, "/env/layers"))
+			parse("", "-layers", "/some/layers")
+			h.AssertNil(t, cmd.VerifyPlatformAPI())
+			h.AssertEq(t, groupPath, filepath.Join("/some/layers", "group.toml"))
+			h.AssertEq(t, planPath, filepath.Join("/some/layers", "plan.toml"))
+		})
+
+		it("defaults group and plan paths to CNB_LAYERS_DIR", func() {
+			h.AssertNil(t, os.Setenv(cmd.EnvLayersDir, "/env/layers"))
+			parse("")
+			h.AssertEq(t, groupPath, filepath.Join("/env/layers", "group.toml"))
+			h.AssertEq(t, planPath, filepath.Join("/env/layers", "plan.toml"))
+		})
+
+		it("defaults group and plan paths to the layers directory in the config file", func() {
+			tmpDir, err := ioutil.TempDir("", "lifecycle.platform")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(tmpDir)
+			configPath := filepath.Join(tmpDir, "config.toml")
+			h.AssertNil(t, ioutil.WriteFile(configPath, []byte(`layers = "/config/layers"`), 0666))
+
+			parse(configPath)
+			h.AssertEq(t, groupPath, filepath.Join("/config/layers", "group.toml"))
+			h.AssertEq(t, planPath, filepath.Join("/config/layers", "plan.toml"))
+		})
+
+		it("keeps paths that were given", func() {
+			parse("", "-layers", "/some/layers", "-group", "/other/group.toml")
+			h.AssertEq(t, groupPath, "/other/group.toml")
+			h.AssertEq(t, planPath, filepath.Join("/some/layers", "plan.toml"))
+		})
+	})
+
+	when("the platform API is not supported", func() {
+		it.Before(func() {
+			h.AssertNil(t, os.Setenv(cmd.EnvPlatformAPI, "0.9"))
+		})
+
+		it("fails with the incompatible platform API code", func() {
+			err := cmd.VerifyPlatformAPI()
+			h.AssertError(t, err, "platform API '0.9' is not supported, the lifecycle supports 0.1, 0.2")
+			h.AssertEq(t, exitcode.Of(err), cmd.CodeIncompatiblePlatformAPI)
+		})
+	})
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"flag"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+const (
+	EnvCPUProfile = "CNB_CPU_PROFILE"
+	EnvMemProfile = "CNB_MEM_PROFILE"
+	EnvTrace      = "CNB_TRACE_PATH"
+)
+
+// Profile holds where a phase writes Go runtime profiles.
+type Profile struct {
+	CPUPath   string
+	MemPath   string
+	TracePath string
+}
+
+func FlagCPUProfile(path *string) {
+	flag.StringVar(path, "cpuprofile", os.Getenv(EnvCPUProfile), "path to write a pprof CPU profile of the phase to")
+}
+
+func FlagMemProfile(path *string) {
+	flag.StringVar(path, "memprofile", os.Getenv(EnvMemProfile), "path to write a pprof heap profile to when the phase finishes")
+}
+
+func FlagTrace(path *string) {
+	flag.StringVar(path, "trace", os.Getenv(EnvTrace), "path to write a Go execution trace of the phase to")
+}
+
+// Run calls phase while collecting the configured profiles, and returns its
+// result. Profiling failures do not fail the phase and are logged as
+// warnings.
+func (p *Profile) Run(phase func() error) error {
+	warn := func(err error) {
+		if err != nil {
+			Warnf("%s", err)
+		}
+	}
+
+	if p.CPUPath != "" {
+		f, err := os.Create(p.CPUPath)
+		warn(err)
+		if err == nil {
+			defer f.Close()
+			if err := pprof.StartCPUProfile(f); err != nil {
+				warn(err)
+			} else {
+				defer pprof.StopCPUProfile()
+			}
+		}
+	}
+	if p.TracePath != "" {
+		f, err := os.Create(p.TracePath)
+		warn(err)
+		if err == nil {
+			defer f.Close()
+			if err := trace.Start(f); err != nil {
+				warn(err)
+			} else {
+				defer trace.Stop()
+			}
+		}
+	}
+
+	err := phase()
+
+	if p.MemPath != "" {
+		f, ferr := os.Create(p.MemPath)
+		warn(ferr)
+		if ferr == nil {
+			runtime.GC()
+			warn(pprof.WriteHeapProfile(f))
+			warn(f.Close())
+		}
+	}
+	return err
+}
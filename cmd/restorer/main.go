@@ -1,87 +1,236 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
-
-	"github.com/BurntSushi/toml"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/buildpack/lifecycle"
 	"github.com/buildpack/lifecycle/cache"
 	"github.com/buildpack/lifecycle/cmd"
 	"github.com/buildpack/lifecycle/image"
+	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/progress"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
 var (
-	cacheImageTag string
-	cachePath     string
-	layersDir     string
-	groupPath     string
-	uid           int
-	gid           int
+	configPath      string
+	strict          bool
+	buildID         string
+	debug           bool
+	noColor         bool
+	labelPrefix     string
+	telemetryConfig cmd.Telemetry
+	profileConfig   cmd.Profile
+	cacheImageTag   string
+	useDaemon       bool
+	cachePath       string
+	layersDir       string
+	groupPath       string
+	uid             int
+	gid             int
+	linkCache       bool
+	verifyCache     bool
+	rollbackCache   int
+	importPath      string
+	pruneCache      time.Duration
+	listCache       bool
+	cacheDigest     string
+	warnDigest      bool
+	cloudAuth       bool
 )
 
 func init() {
+	cmd.FlagConfigPath(&configPath)
+	cmd.FlagStrict(&strict)
+	cmd.FlagBuildID(&buildID)
+	cmd.FlagDebug(&debug)
+	cmd.FlagNoColor(&noColor)
+	cmd.FlagLabelPrefix(&labelPrefix)
+	cmd.FlagMetricsPath(&telemetryConfig.MetricsPath)
+	cmd.FlagMetricsPushgateway(&telemetryConfig.MetricsPushgateway)
+	cmd.FlagTimingsPath(&telemetryConfig.TimingsPath)
+	cmd.FlagCPUProfile(&profileConfig.CPUPath)
+	cmd.FlagMemProfile(&profileConfig.MemPath)
+	cmd.FlagTrace(&profileConfig.TracePath)
 	cmd.FlagLayersDir(&layersDir)
 	cmd.FlagCacheImage(&cacheImageTag)
 	cmd.FlagCachePath(&cachePath)
+	cmd.FlagUseDaemon(&useDaemon)
 	cmd.FlagGroupPath(&groupPath)
 	cmd.FlagUID(&uid)
 	cmd.FlagGID(&gid)
+	cmd.FlagLinkCache(&linkCache)
+	cmd.FlagVerifyCache(&verifyCache)
+	cmd.FlagRollbackCache(&rollbackCache)
+	cmd.FlagImportCache(&importPath)
+	cmd.FlagPruneCache(&pruneCache)
+	cmd.FlagListCache(&listCache)
+	cmd.FlagCacheDigest(&cacheDigest)
+	cmd.FlagWarnCacheDigest(&warnDigest)
+	cmd.FlagCloudAuth(&cloudAuth)
 }
 
 func main() {
+	defer cmd.RecoverPanic()
 	// suppress output from libraries, lifecycle will not use standard logger
 	log.SetOutput(ioutil.Discard)
+	ctx := cmd.HandleSignals()
 
 	flag.Parse()
-	if flag.NArg() > 0 {
-		args := map[string]interface{}{"narg": flag.NArg(), "layersDir": layersDir}
-		cmd.Exit(cmd.FailCode(cmd.CodeInvalidArgs, "parse arguments", fmt.Sprintf("%+v", args)))
+	if err := cmd.ReadConfigFile(configPath); err != nil {
+		cmd.Exit(cmd.FailErrCode(err, cmd.CodeInvalidArgs, "read config file"))
+	}
+	cmd.SetBuildID(&buildID)
+	if err := cmd.VerifyPlatformAPI(); err != nil {
+		cmd.Exit(err)
+	}
+	cmd.ResolveLayersPath(&groupPath, layersDir, cmd.DefaultGroupPath)
+	var v cmd.Validator
+	v.Check(flag.NArg() == 0, fmt.Sprintf("expected no arguments, got %d", flag.NArg()))
+	v.Check(cacheImageTag != "" || cachePath != "", "must supply either -image or -path")
+	v.Check(cacheDigest == "" || cacheImageTag != "", "-cache-digest requires a cache image (-image)")
+	v.Check(importPath == "" || (cacheImageTag == "" && cachePath != ""), "-import requires a cache directory (-path) instead of an image")
+	v.CheckPhaseOutput("group", groupPath)
+	v.CheckUIDGID(uid, gid)
+	if v.Valid() {
+		v.CheckErr(cmd.DetectUIDGID(&uid, &gid, layersDir), "determine uid/gid")
 	}
-	if cacheImageTag == "" && cachePath == "" {
-		cmd.Exit(cmd.FailCode(cmd.CodeInvalidArgs, "must supply either -image or -path"))
+	if err := v.Err(); err != nil {
+		cmd.Exit(err)
 	}
-	cmd.Exit(restore())
+	tracer := telemetry.NewTracer("restorer", buildID)
+	cmd.Exit(telemetryConfig.Finish(tracer, profileConfig.Run(func() error { return restore(ctx, tracer) })))
 }
 
-func restore() error {
+func restore(ctx context.Context, tracer *telemetry.Tracer) error {
+	logger := lifecycle.NewDefaultLogger(os.Stdout, os.Stderr)
+	logger.OnWarn = cmd.AddWarning
+	logger.SetPrefix(cmd.LogPrefix())
+	logger.DebugEnabled = debug
+	if noColor {
+		logger.Color = false
+	}
+	lifecycle.UnknownKeys = lifecycle.WarnUnknownKeys(logger)
+	if strict {
+		lifecycle.UnknownKeys = lifecycle.RejectUnknownKeys
+	}
+	if labelPrefix != "" {
+		metadata.LabelPrefix = labelPrefix
+	}
+
 	var group lifecycle.BuildpackGroup
-	if _, err := toml.DecodeFile(groupPath, &group); err != nil {
+	if err := lifecycle.DecodeFile(groupPath, &group); err != nil {
 		return cmd.FailErr(err, "read group")
 	}
 
 	restorer := &lifecycle.Restorer{
 		LayersDir:  layersDir,
 		Buildpacks: group.Buildpacks,
-		Out:        log.New(os.Stdout, "", 0),
-		Err:        log.New(os.Stderr, "", 0),
+		Logger:     logger,
+		Tracer:     tracer,
+		Progress:   progress.NewTracker(os.Stdout),
 		UID:        uid,
 		GID:        gid,
+		LinkLayers: linkCache,
+
+		Context:     ctx,
+		OnInterrupt: cmd.OnInterrupt,
 	}
 
 	var cacheStore lifecycle.Cache
 	if cacheImageTag != "" {
-		factory, err := image.NewFactory(image.WithOutWriter(os.Stdout))
-		if err != nil {
-			return err
+		factoryOps := []func(*image.Factory){image.WithOutWriter(os.Stdout), image.WithContext(ctx), image.WithEnvKeychain}
+		if cloudAuth {
+			factoryOps = append(factoryOps, image.WithCloudKeychain)
 		}
-
-		cacheImage, err := factory.NewLocal(cacheImageTag)
+		factory, err := image.NewFactory(factoryOps...)
 		if err != nil {
 			return err
 		}
 
-		cacheStore = cache.NewImageCache(factory, cacheImage)
+		var imageCache *cache.ImageCache
+		if useDaemon {
+			cacheImage, err := factory.NewLocal(cacheImageTag)
+			if err != nil {
+				return err
+			}
+			imageCache = cache.NewImageCache(factory, cacheImage)
+		} else {
+			cacheImage, err := factory.NewRemote(cacheImageTag)
+			if err != nil {
+				return err
+			}
+			imageCache = cache.NewRemoteImageCache(factory, cacheImage)
+		}
+		if cacheDigest != "" {
+			if err := imageCache.VerifyDigest(cacheDigest); err != nil {
+				if _, ok := err.(*cache.ImageMovedError); !ok || !warnDigest {
+					return cmd.FailErr(err, "verify cache image")
+				}
+				logger.Warnf("restoring from the cache image anyway: %s", err)
+			}
+		}
+		cacheStore = imageCache
 	} else {
-		var err error
-		cacheStore, err = cache.NewVolumeCache(cachePath)
+		var ops []func(*cache.VolumeCache)
+		if importPath == "" && rollbackCache == 0 && pruneCache == 0 && !verifyCache {
+			// other restorers may read the cache at the same time
+			ops = append(ops, cache.WithSharedLock)
+		}
+		volumeCache, err := cache.NewVolumeCache(cachePath, ops...)
 		if err != nil {
 			return err
 		}
+		defer volumeCache.Close()
+		if importPath != "" {
+			if err := importCache(volumeCache, importPath); err != nil {
+				return cmd.FailErr(err, "import cache")
+			}
+		}
+		if rollbackCache > 0 {
+			if err := volumeCache.RollbackTo(rollbackCache); err != nil {
+				return cmd.FailErr(err, "roll back cache")
+			}
+		}
+		if pruneCache > 0 || verifyCache {
+			warnIncompatibleMetadata(logger, volumeCache)
+		}
+		if pruneCache > 0 {
+			pruned, err := volumeCache.Prune(time.Now().Add(-pruneCache))
+			for _, layer := range pruned {
+				logger.Infof("removed unused layer from cache: %s", layer)
+			}
+			if err != nil {
+				return cmd.FailErr(err, "prune cache")
+			}
+		}
+		if verifyCache {
+			pruned, err := volumeCache.Verify()
+			for _, layer := range pruned {
+				logger.Warnf("removed broken layer from cache: %s", layer)
+			}
+			if err != nil {
+				return cmd.FailErr(err, "verify cache")
+			}
+		}
+		cacheStore = volumeCache
+	}
+
+	if listCache {
+		warnIncompatibleMetadata(logger, cacheStore)
+		if err := printCache(os.Stdout, cacheStore); err != nil {
+			return cmd.FailErr(err, "list cache")
+		}
+		return nil
 	}
 
 	if err := restorer.Restore(cacheStore); err != nil {
@@ -89,3 +238,42 @@ func restore() error {
 	}
 	return nil
 }
+
+// warnIncompatibleMetadata warns when the metadata of cacheStore was written
+// by an incompatible lifecycle, which cache operations treat as empty.
+func warnIncompatibleMetadata(logger lifecycle.Logger, cacheStore lifecycle.Cache) {
+	if _, err := cacheStore.RetrieveMetadata(); err != nil {
+		if _, ok := err.(*metadata.IncompatibleError); ok {
+			logger.Warnf("ignoring metadata of cache '%s': %s", cacheStore.Name(), err)
+		}
+	}
+}
+
+func importCache(volumeCache *cache.VolumeCache, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return volumeCache.Import(f)
+}
+
+func printCache(w io.Writer, cacheStore lifecycle.Cache) error {
+	infos, err := cacheStore.List()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHA\tSIZE\tLAST CACHED\tLAYERS")
+	for _, info := range infos {
+		size, accessed := "-", "-"
+		if info.Size >= 0 {
+			size = fmt.Sprint(info.Size)
+		}
+		if !info.Accessed.IsZero() {
+			accessed = info.Accessed.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", info.SHA, size, accessed, strings.Join(info.Layers, ","))
+	}
+	return tw.Flush()
+}
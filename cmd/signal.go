@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// interruptTimeout is how long an interrupted phase has to stop before it
+// exits anyway. A second signal exits at once.
+const interruptTimeout = 20 * time.Second
+
+var (
+	cleanupMu sync.Mutex
+	cleanups  []func()
+
+	interruptMu sync.Mutex
+	interrupt   os.Signal
+
+	// exitMu is held by the first caller of Exit until the process exits.
+	exitMu sync.Mutex
+)
+
+// InterruptedError is the error of a phase stopped by a signal.
+type InterruptedError struct {
+	Signal os.Signal
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("interrupted by %s", e.Signal)
+}
+
+func (e *InterruptedError) ExitCode() int { return CodeInterrupted }
+
+// HandleSignals returns a context that is cancelled when the process receives
+// SIGINT or SIGTERM. The phase should then stop and call Exit, which runs the
+// functions registered with OnInterrupt in reverse order and exits with
+// CodeInterrupted. If the phase has not stopped within interruptTimeout, or
+// another signal is received, Exit is called without waiting for it.
+func HandleSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		interruptMu.Lock()
+		interrupt = sig
+		interruptMu.Unlock()
+		log.New(os.Stderr, logPrefix, 0).Printf("Interrupted by %s, stopping\n", sig)
+		cancel()
+
+		select {
+		case <-sigs:
+		case <-time.After(interruptTimeout):
+		}
+		Exit(nil)
+	}()
+	return ctx
+}
+
+// OnInterrupt registers fn to be run if the process is interrupted.
+func OnInterrupt(fn func()) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	cleanups = append(cleanups, fn)
+}
+
+// interrupted runs the functions registered with OnInterrupt and returns the
+// error of the interrupted phase, or nil if the phase was not interrupted.
+func interrupted() error {
+	interruptMu.Lock()
+	sig := interrupt
+	interruptMu.Unlock()
+	if sig == nil {
+		return nil
+	}
+
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	return &InterruptedError{Signal: sig}
+}
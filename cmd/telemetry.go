@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"flag"
+	"os"
+
+	"github.com/buildpack/lifecycle/telemetry"
+)
+
+const (
+	EnvMetricsPath        = "CNB_METRICS_PATH"
+	EnvMetricsPushgateway = "CNB_METRICS_PUSHGATEWAY"
+	EnvTimingsPath        = "CNB_TIMINGS_PATH"
+)
+
+// Telemetry holds where a phase reports the spans recorded by its tracer.
+type Telemetry struct {
+	MetricsPath        string
+	MetricsPushgateway string
+	TimingsPath        string
+}
+
+func FlagMetricsPath(path *string) {
+	flag.StringVar(path, "metrics", os.Getenv(EnvMetricsPath), "path to write Prometheus metrics to, for a node-exporter textfile collector")
+}
+
+func FlagMetricsPushgateway(url *string) {
+	flag.StringVar(url, "metrics-pushgateway", os.Getenv(EnvMetricsPushgateway), "URL of a Prometheus Pushgateway to push metrics to")
+}
+
+func FlagTimingsPath(path *string) {
+	flag.StringVar(path, "timings", os.Getenv(EnvTimingsPath), "path to write a JSON report of phase and operation timings to")
+}
+
+// Finish ends the phase span with the phase's result and reports the
+// recorded spans. Reporting failures do not fail the phase and are logged as
+// warnings.
+func (t *Telemetry) Finish(tracer *telemetry.Tracer, err error) error {
+	root := tracer.Root()
+	root.SetError(err)
+	root.Finish()
+
+	warn := func(err error) {
+		if err != nil {
+			Warnf("%s", err)
+		}
+	}
+	if endpoint := telemetry.OTLPEndpointFromEnv(); endpoint != "" {
+		warn(telemetry.ExportOTLP(endpoint, telemetry.OTLPHeadersFromEnv(), tracer.Spans()))
+	}
+	if t.MetricsPath != "" {
+		warn(tracer.WriteMetricsFile(t.MetricsPath))
+	}
+	if t.MetricsPushgateway != "" {
+		warn(tracer.PushMetrics(t.MetricsPushgateway))
+	}
+	if t.TimingsPath != "" {
+		warn(tracer.WriteTimingsFile(t.TimingsPath))
+	}
+	return err
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// EnvTerminationLog is the path, such as /dev/termination-log on Kubernetes,
+// to write the error to when a phase fails. Nothing is written when empty.
+const EnvTerminationLog = "CNB_TERMINATION_LOG"
+
+// maxTerminationMessage is the size Kubernetes truncates termination
+// messages to.
+const maxTerminationMessage = 4096
+
+// writeTerminationMessage writes a summary of err for the platform to surface
+// without reading the logs. Failing to write it must not hide err, so write
+// errors are ignored.
+func writeTerminationMessage(err error) {
+	path := os.Getenv(EnvTerminationLog)
+	if path == "" {
+		return
+	}
+	message := err.Error()
+	if len(message) > maxTerminationMessage {
+		message = message[:maxTerminationMessage-3] + "..."
+	}
+	ioutil.WriteFile(path, []byte(message), 0666)
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ValidationError lists every problem found with the flags and arguments of
+// a phase.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Validator collects problems with the flags and arguments of a phase so
+// they are reported at once instead of one invocation at a time.
+type Validator struct {
+	problems []string
+}
+
+// Check records problem unless ok.
+func (v *Validator) Check(ok bool, problem string) {
+	if !ok {
+		v.problems = append(v.problems, problem)
+	}
+}
+
+// CheckErr records err, if any, as a problem with action.
+func (v *Validator) CheckErr(err error, action string) {
+	if err != nil {
+		v.problems = append(v.problems, fmt.Sprintf("%s: %s", action, err))
+	}
+}
+
+// CheckFile records a problem if the file given by the named flag does not
+// exist.
+func (v *Validator) CheckFile(name, path string) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		v.problems = append(v.problems, fmt.Sprintf("-%s: '%s' does not exist", name, path))
+	}
+}
+
+// phaseOutputs are the files, by flag, that an earlier phase writes for
+// later phases to read.
+var phaseOutputs = map[string]struct{ env, phase string }{
+	"group": {EnvGroupPath, "detector"},
+	"plan":  {EnvPlanPath, "detector"},
+}
+
+// CheckPhaseOutput records a problem if the file given by the named flag,
+// which an earlier phase writes, does not exist. The problem names that
+// phase and the expected path.
+func (v *Validator) CheckPhaseOutput(name, path string) {
+	output, ok := phaseOutputs[name]
+	if !ok {
+		v.CheckFile(name, path)
+		return
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		v.problems = append(v.problems, fmt.Sprintf(
+			"-%s: %s not found at '%s', it is written by the %s, which must run first; set -%s or %s if it was written elsewhere",
+			name, filepath.Base(path), path, output.phase, name, output.env,
+		))
+	}
+}
+
+// CheckUIDGID records a problem for a negative uid or gid, other than the
+// -1 default, and for uid or gid environment variables that are not integers.
+func (v *Validator) CheckUIDGID(uid, gid int) {
+	provided := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		provided[f.Name] = true
+	})
+	for _, id := range []struct {
+		name, env string
+		value     int
+	}{{"uid", EnvUID, uid}, {"gid", EnvGID, gid}} {
+		if s := os.Getenv(id.env); s != "" && !provided[id.name] {
+			if _, err := strconv.Atoi(s); err != nil {
+				v.problems = append(v.problems, fmt.Sprintf("%s must be an integer, got '%s'", id.env, s))
+				continue
+			}
+		}
+		if id.value < -1 {
+			v.problems = append(v.problems, fmt.Sprintf("-%s must not be negative, got %d", id.name, id.value))
+		}
+	}
+}
+
+// Valid reports whether no problems have been recorded.
+func (v *Validator) Valid() bool {
+	return len(v.problems) == 0
+}
+
+// Err returns an error listing every recorded problem, or nil.
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+	return FailErrCode(&ValidationError{Problems: v.problems}, CodeInvalidArgs, "validate arguments")
+}
@@ -0,0 +1,136 @@
+package cmd_test
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/cmd"
+	"github.com/buildpack/lifecycle/exitcode"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestValidator(t *testing.T) {
+	spec.Run(t, "Validator", testValidator, spec.Report(report.Terminal{}))
+}
+
+func testValidator(t *testing.T, when spec.G, it spec.S) {
+	var (
+		commandLine *flag.FlagSet
+		tmpDir      string
+		v           *cmd.Validator
+	)
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.validate.test.")
+		h.AssertNil(t, err)
+		commandLine = flag.CommandLine
+		flag.CommandLine = flag.NewFlagSet("phase", flag.ContinueOnError)
+		v = &cmd.Validator{}
+	})
+
+	it.After(func() {
+		flag.CommandLine = commandLine
+		os.Unsetenv(cmd.EnvUID)
+		os.RemoveAll(tmpDir)
+	})
+
+	when("nothing is wrong", func() {
+		it("returns no error", func() {
+			existing := filepath.Join(tmpDir, "some-file")
+			h.AssertNil(t, ioutil.WriteFile(existing, nil, 0666))
+
+			v.Check(true, "some-problem")
+			v.CheckErr(nil, "do something")
+			v.CheckFile("some-flag", existing)
+			v.CheckUIDGID(-1, 1000)
+
+			h.AssertEq(t, v.Valid(), true)
+			h.AssertNil(t, v.Err())
+		})
+	})
+
+	when("several things are wrong", func() {
+		it("reports every problem at once", func() {
+			h.AssertNil(t, os.Setenv(cmd.EnvUID, "some-user"))
+
+			v.Check(false, "some-problem")
+			v.CheckErr(errors.New("some-error"), "do something")
+			v.CheckFile("some-flag", filepath.Join(tmpDir, "missing"))
+			v.CheckUIDGID(-1, -2)
+
+			h.AssertEq(t, v.Valid(), false)
+			err := v.Err()
+			h.AssertEq(t, exitcode.Of(err), cmd.CodeInvalidArgs)
+			var validationErr *cmd.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected a validation error, got %T: %s", err, err)
+			}
+			h.AssertEq(t, validationErr.Problems, []string{
+				"some-problem",
+				"do something: some-error",
+				"-some-flag: '" + filepath.Join(tmpDir, "missing") + "' does not exist",
+				cmd.EnvUID + " must be an integer, got 'some-user'",
+				"-gid must not be negative, got -2",
+			})
+			h.AssertEq(t, err.Error(), "failed to validate arguments: "+validationErr.Error())
+		})
+	})
+
+	when("the uid flag is given", func() {
+		it("does not check the uid environment variable", func() {
+			h.AssertNil(t, os.Setenv(cmd.EnvUID, "some-user"))
+			var uid int
+			flag.IntVar(&uid, "uid", -1, "")
+			h.AssertNil(t, flag.CommandLine.Parse([]string{"-uid", "1000"}))
+
+			v.CheckUIDGID(uid, -1)
+
+			h.AssertNil(t, v.Err())
+		})
+	})
+	when("a file written by an earlier phase is missing", func() {
+		it("names the phase that writes it and the expected path", func() {
+			groupPath := filepath.Join(tmpDir, "group.toml")
+			planPath := filepath.Join(tmpDir, "plan.toml")
+
+			v.CheckPhaseOutput("group", groupPath)
+			v.CheckPhaseOutput("plan", planPath)
+
+			var validationErr *cmd.ValidationError
+			if !errors.As(v.Err(), &validationErr) {
+				t.Fatalf("Expected a validation error, got %s", v.Err())
+			}
+			h.AssertEq(t, validationErr.Problems, []string{
+				"-group: group.toml not found at '" + groupPath + "', it is written by the detector, which must run first; set -group or " + cmd.EnvGroupPath + " if it was written elsewhere",
+				"-plan: plan.toml not found at '" + planPath + "', it is written by the detector, which must run first; set -plan or " + cmd.EnvPlanPath + " if it was written elsewhere",
+			})
+		})
+
+		it("reports other files as missing", func() {
+			path := filepath.Join(tmpDir, "missing")
+
+			v.CheckPhaseOutput("some-flag", path)
+
+			h.AssertError(t, v.Err(), "-some-flag: '"+path+"' does not exist")
+		})
+	})
+
+	when("files written by an earlier phase exist", func() {
+		it("returns no error", func() {
+			groupPath := filepath.Join(tmpDir, "group.toml")
+			h.AssertNil(t, ioutil.WriteFile(groupPath, nil, 0666))
+
+			v.CheckPhaseOutput("group", groupPath)
+
+			h.AssertNil(t, v.Err())
+		})
+	})
+}
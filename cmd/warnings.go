@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+var (
+	warningsMu sync.Mutex
+	warnings   []string
+)
+
+// AddWarning records a non-fatal problem of the phase for its report. Set it
+// as the OnWarn of the phase's logger to record the warnings it logs.
+func AddWarning(message string) {
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	warnings = append(warnings, message)
+}
+
+// Warnings returns the warnings recorded so far.
+func Warnings() []string {
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	return append([]string(nil), warnings...)
+}
+
+// Warnf logs and records a warning.
+func Warnf(format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	AddWarning(message)
+	log.New(os.Stderr, logPrefix, 0).Printf("Warning: %s\n", message)
+}
@@ -0,0 +1,155 @@
+package lifecycle
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/buildpack/lifecycle/buildpack"
+	"github.com/buildpack/lifecycle/stack"
+)
+
+func init() {
+	// buildpack descriptors and stack files are read like the lifecycle's own
+	buildpack.DecodeFile = func(path string, v interface{}) error {
+		return DecodeFile(path, v, "metadata")
+	}
+	stack.DecodeFile = func(path string, v interface{}) error {
+		return DecodeFile(path, v)
+	}
+}
+
+// UnknownKeys handles the keys of a file read by the lifecycle that do not
+// match the type it is decoded into. They are ignored when it is nil.
+var UnknownKeys func(path string, keys []string) error
+
+// WarnUnknownKeys logs a warning the first time each file has unknown keys.
+func WarnUnknownKeys(logger Logger) func(path string, keys []string) error {
+	var mu sync.Mutex
+	warned := map[string]bool{}
+	return func(path string, keys []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !warned[path] {
+			logger.Warnf("unknown keys in '%s': %s", path, strings.Join(keys, ", "))
+			warned[path] = true
+		}
+		return nil
+	}
+}
+
+func RejectUnknownKeys(path string, keys []string) error {
+	return fmt.Errorf("unknown keys in '%s': %s", path, strings.Join(keys, ", "))
+}
+
+// DecodeFile decodes path into v as JSON if it has a .json extension, or as
+// TOML otherwise, and passes any unknown keys to UnknownKeys. Keys within the
+// freeform tables, which are decoded into an interface{}, are never unknown.
+func DecodeFile(path string, v interface{}, freeform ...string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if isJSON(path) {
+		return DecodeJSON(path, data, v)
+	}
+	return DecodeTOML(path, data, v, freeform...)
+}
+
+// DecodeTOML decodes data, read from path, into v and passes any unknown keys
+// outside the freeform tables to UnknownKeys.
+func DecodeTOML(path string, data []byte, v interface{}, freeform ...string) error {
+	md, err := toml.Decode(string(data), v)
+	if err != nil || UnknownKeys == nil {
+		return err
+	}
+	var keys []string
+	for _, k := range md.Undecoded() {
+		if !inFreeform(k, freeform) {
+			keys = append(keys, k.String())
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	return UnknownKeys(path, keys)
+}
+
+func inFreeform(key toml.Key, freeform []string) bool {
+	for _, f := range freeform {
+		if len(key) > 1 && key[0] == f {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeJSON decodes data, read from path, into v. It only passes the first
+// unknown key to UnknownKeys, as encoding/json does not list them.
+func DecodeJSON(path string, data []byte, v interface{}) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	if UnknownKeys == nil {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(reflect.New(reflect.TypeOf(v).Elem()).Interface())
+	const prefix = "json: unknown field "
+	if err != nil && strings.HasPrefix(err.Error(), prefix) {
+		return UnknownKeys(path, []string{strings.Trim(strings.TrimPrefix(err.Error(), prefix), `"`)})
+	}
+	return nil
+}
+
+// WriteFile writes data to path as JSON if it has a .json extension, or as
+// TOML otherwise.
+func WriteFile(path string, data interface{}) error {
+	if !isJSON(path) {
+		return WriteTOML(path, data)
+	}
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0666)
+}
+
+// WritePlan writes a build plan, as output by detection, to path.
+func WritePlan(path string, plan []byte) error {
+	if !isJSON(path) {
+		return ioutil.WriteFile(path, plan, 0666)
+	}
+	p, err := ParsePlan(plan)
+	if err != nil {
+		return err
+	}
+	return WriteFile(path, p)
+}
+
+// ParsePlan parses a build plan, as output by detection.
+func ParsePlan(plan []byte) (Plan, error) {
+	p := Plan{}
+	if _, err := toml.Decode(string(plan), &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func isJSON(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
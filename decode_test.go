@@ -0,0 +1,122 @@
+package lifecycle_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/lifecycle/buildpack"
+	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/stack"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestDecode(t *testing.T) {
+	spec.Run(t, "Decode", testDecode, spec.Sequential(), spec.Report(report.Terminal{}))
+}
+
+func testDecode(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir string
+		path   string
+		stderr *bytes.Buffer
+	)
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.decode")
+		h.AssertNil(t, err)
+		path = filepath.Join(tmpDir, "layer.toml")
+		mkfile(t, "launch = true\nlunch = true\n[metadata]\nsome-key = \"some-value\"\n", path)
+		stderr = &bytes.Buffer{}
+	})
+
+	it.After(func() {
+		lifecycle.UnknownKeys = nil
+		os.RemoveAll(tmpDir)
+	})
+
+	when("#DecodeFile", func() {
+		it("ignores unknown keys by default", func() {
+			var data metadata.LayerMetadata
+			h.AssertNil(t, lifecycle.DecodeFile(path, &data, "metadata"))
+			h.AssertEq(t, data.Launch, true)
+		})
+
+		it("warns once about unknown keys outside of freeform tables", func() {
+			lifecycle.UnknownKeys = lifecycle.WarnUnknownKeys(lifecycle.NewDefaultLogger(ioutil.Discard, stderr))
+
+			var data metadata.LayerMetadata
+			h.AssertNil(t, lifecycle.DecodeFile(path, &data, "metadata"))
+			h.AssertNil(t, lifecycle.DecodeFile(path, &data, "metadata"))
+			h.AssertEq(t, stderr.String(), "Warning: unknown keys in '"+path+"': lunch\n")
+		})
+
+		it("fails on unknown keys when rejecting them", func() {
+			lifecycle.UnknownKeys = lifecycle.RejectUnknownKeys
+
+			var data metadata.LayerMetadata
+			err := lifecycle.DecodeFile(path, &data)
+			h.AssertError(t, err, "unknown keys in '"+path+"': lunch, metadata.some-key")
+		})
+
+		it("reports unknown keys in buildpack descriptors and stack files", func() {
+			lifecycle.UnknownKeys = lifecycle.RejectUnknownKeys
+
+			bpPath := filepath.Join(tmpDir, "buildpack.toml")
+			mkfile(t, "[buildpack]\nid = \"some-buildpack\"\nversion = \"1.2.3\"\nverison = \"1.2.3\"\n[metadata]\nsome-key = \"some-value\"\n", bpPath)
+			_, err := buildpack.Read(bpPath)
+			h.AssertError(t, err, "unknown keys in '"+bpPath+"': buildpack.verison")
+
+			stackPath := filepath.Join(tmpDir, "stack.toml")
+			mkfile(t, "[run-image]\nimage = \"some-run-image\"\nmirror = [\"some-mirror\"]\n", stackPath)
+			_, err = stack.Read(stackPath)
+			h.AssertError(t, err, "unknown keys in '"+stackPath+"': run-image.mirror")
+		})
+	})
+	when("the file has a .json extension", func() {
+		it("decodes JSON", func() {
+			path := filepath.Join(tmpDir, "group.json")
+			mkfile(t, `{"buildpacks": [{"id": "some-buildpack", "version": "1.2.3", "optional": true}]}`, path)
+
+			var group lifecycle.BuildpackGroup
+			h.AssertNil(t, lifecycle.DecodeFile(path, &group))
+			h.AssertEq(t, group.Buildpacks, []*lifecycle.Buildpack{{ID: "some-buildpack", Version: "1.2.3", Optional: true}})
+		})
+
+		it("reports the first unknown key", func() {
+			lifecycle.UnknownKeys = lifecycle.RejectUnknownKeys
+			path := filepath.Join(tmpDir, "group.json")
+			mkfile(t, `{"buildpacks": [], "bildpacks": []}`, path)
+
+			var group lifecycle.BuildpackGroup
+			err := lifecycle.DecodeFile(path, &group)
+			h.AssertError(t, err, "unknown keys in '"+path+"': bildpacks")
+		})
+
+		it("writes JSON that decodes to the same value", func() {
+			path := filepath.Join(tmpDir, "group.json")
+			group := lifecycle.BuildpackGroup{Buildpacks: []*lifecycle.Buildpack{{ID: "some-buildpack", Version: "1.2.3", Name: "some-name"}}}
+			h.AssertNil(t, group.Write(path))
+
+			contents, err := ioutil.ReadFile(path)
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "{\n  \"buildpacks\": [\n    {\n      \"id\": \"some-buildpack\",\n      \"version\": \"1.2.3\"\n    }\n  ]\n}\n")
+		})
+
+		it("writes a detected plan as JSON", func() {
+			path := filepath.Join(tmpDir, "plan.json")
+			h.AssertNil(t, lifecycle.WritePlan(path, []byte("[some-dep]\n  version = \"1.2.3\"\n")))
+
+			var plan lifecycle.Plan
+			h.AssertNil(t, lifecycle.DecodeFile(path, &plan))
+			h.AssertEq(t, plan, lifecycle.Plan{"some-dep": {"version": "1.2.3"}})
+		})
+	})
+}
@@ -0,0 +1,91 @@
+package lifecycle
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/image"
+	"github.com/buildpack/lifecycle/metadata"
+)
+
+// checkStackDeprecation warns when img, the build or run image named by kind,
+// is labeled as deprecated or past its end of life. With fail set, it
+// returns an error instead of warning, including when the labels cannot be
+// read. Upcoming dates are always warnings.
+func checkStackDeprecation(logger Logger, kind string, img image.Image, fail bool) error {
+	now := time.Now()
+	var problems []string
+
+	deprecated, err := stackLabel(img, metadata.StackDeprecatedLabel)
+	if err != nil {
+		return deprecationCheckFailed(logger, kind, img, fail, err)
+	}
+	switch deprecated {
+	case "", "false":
+	case "true":
+		problems = append(problems, "is deprecated")
+	default:
+		date, err := parseStackDate(deprecated)
+		if err != nil {
+			logger.Warnf("%s image '%s' has invalid deprecation date '%s'", kind, img.Name(), deprecated)
+		} else if now.Before(date) {
+			logger.Warnf("%s image '%s' will be deprecated on %s", kind, img.Name(), deprecated)
+		} else {
+			problems = append(problems, "is deprecated since "+deprecated)
+		}
+	}
+
+	eol, err := stackLabel(img, metadata.StackEOLLabel)
+	if err != nil {
+		return deprecationCheckFailed(logger, kind, img, fail, err)
+	}
+	if eol != "" {
+		date, err := parseStackDate(eol)
+		if err != nil {
+			logger.Warnf("%s image '%s' has invalid end of life date '%s'", kind, img.Name(), eol)
+		} else if now.Before(date) {
+			logger.Warnf("%s image '%s' will reach end of life on %s", kind, img.Name(), eol)
+		} else {
+			problems = append(problems, "reached end of life on "+eol)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	message := fmt.Sprintf("%s image '%s' %s, move to a supported stack", kind, img.Name(), strings.Join(problems, " and "))
+	if fail {
+		return errors.New(message)
+	}
+	logger.Warnf("%s", message)
+	return nil
+}
+
+// deprecationCheckFailed returns err when failing on deprecated stacks, and
+// otherwise warns that the check could not be done.
+func deprecationCheckFailed(logger Logger, kind string, img image.Image, fail bool, err error) error {
+	if fail {
+		return err
+	}
+	logger.Warnf("could not check whether %s image '%s' is deprecated: %s", kind, img.Name(), err)
+	return nil
+}
+
+func stackLabel(img image.Image, label string) (string, error) {
+	label = metadata.LabelName(label)
+	value, err := img.Label(label)
+	if err != nil {
+		return "", errors.Wrapf(err, "get image label '%s'", label)
+	}
+	return strings.TrimSpace(value), nil
+}
+
+func parseStackDate(value string) (time.Time, error) {
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
@@ -2,18 +2,26 @@ package lifecycle
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/buildpack/lifecycle/buildpack"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
+// BuildpackAPI is the latest buildpack API implemented by the lifecycle.
+const BuildpackAPI = "0.2"
+
 const (
 	CodeDetectPass = iota
 	CodeDetectError
@@ -21,17 +29,27 @@ const (
 )
 
 type Buildpack struct {
-	ID       string `toml:"id"`
-	Version  string `toml:"version"`
-	Optional bool   `toml:"optional,omitempty"`
-	Name     string `toml:"-"`
-	Dir      string `toml:"-"`
+	ID        string           `toml:"id" json:"id"`
+	Version   string           `toml:"version" json:"version"`
+	Optional  bool             `toml:"optional,omitempty" json:"optional,omitempty"`
+	Extension bool             `toml:"extension,omitempty" json:"extension,omitempty"`
+	Digest    string           `toml:"digest,omitempty" json:"digest,omitempty"`
+	Name      string           `toml:"-" json:"-"`
+	API       string           `toml:"-" json:"-"`
+	Stacks    buildpack.Stacks `toml:"-" json:"-"`
+	Dir       string           `toml:"-" json:"-"`
 }
 
 type DetectConfig struct {
 	AppDir      string
 	PlatformDir string
-	Out, Err    *log.Logger
+	StackID     string
+	BuildMixins []string // mixins are not checked when nil
+	Logger      Logger
+	Tracer      *telemetry.Tracer
+	Events      Events
+	LogPrefix   string
+	Context     context.Context // buildpacks are stopped when it is done
 }
 
 func (bp *Buildpack) EscapedID() string {
@@ -39,54 +57,87 @@ func (bp *Buildpack) EscapedID() string {
 }
 
 func (bp *Buildpack) Detect(c *DetectConfig, in io.Reader, out io.Writer) int {
+	span := c.Tracer.Start("detect").SetAttribute("buildpack.id", bp.ID).SetAttribute("buildpack.version", bp.Version)
+	events := eventsOrNop(c.Events)
+	events.OnBuildpackStarted(BuildpackEvent{Phase: "detect", Buildpack: *bp})
+	start := time.Now()
+	code := bp.detect(c, in, out)
+	events.OnBuildpackFinished(BuildpackEvent{Phase: "detect", Buildpack: *bp, Duration: time.Since(start), Code: code})
+	span.SetAttribute("code", code)
+	span.Finish()
+	return code
+}
+
+func (bp *Buildpack) detect(c *DetectConfig, in io.Reader, out io.Writer) int {
+	if !buildpack.CompatibleAPI(bp.API, BuildpackAPI) {
+		c.Logger.Errorf("buildpack '%s' implements buildpack API %s, lifecycle supports %s", bp.ID, bp.API, BuildpackAPI)
+		return CodeDetectError
+	}
+	if c.StackID != "" && !bp.Stacks.Supports(c.StackID) {
+		c.Logger.Debugf("buildpack '%s' does not support stack '%s'", bp.ID, c.StackID)
+		return CodeDetectFail
+	}
+	if c.BuildMixins != nil {
+		if missing := bp.Stacks.MissingMixins(c.StackID, "build", c.BuildMixins); len(missing) > 0 {
+			c.Logger.Warnf("buildpack '%s' requires mixins missing from the build image: %s", bp.ID, strings.Join(missing, ", "))
+			return CodeDetectFail
+		}
+	}
 	detectPath, err := filepath.Abs(filepath.Join(bp.Dir, "bin", "detect"))
 	if err != nil {
-		c.Err.Print("Error: ", err)
+		c.Logger.Errorf("%s", err)
 		return CodeDetectError
 	}
 	appDir, err := filepath.Abs(c.AppDir)
 	if err != nil {
-		c.Err.Print("Error: ", err)
+		c.Logger.Errorf("%s", err)
 		return CodeDetectError
 	}
 	platformDir, err := filepath.Abs(c.PlatformDir)
 	if err != nil {
-		c.Err.Print("Error: ", err)
+		c.Logger.Errorf("%s", err)
 		return CodeDetectError
 	}
 	planDir, err := ioutil.TempDir("", filepath.Base(bp.Dir)+".plan.")
 	if err != nil {
-		c.Err.Print("Error: ", err)
+		c.Logger.Errorf("%s", err)
 		return CodeDetectError
 	}
 	defer os.RemoveAll(planDir)
 	planPath := filepath.Join(planDir, "plan.toml")
 	if ioutil.WriteFile(planPath, nil, 0777); err != nil {
-		c.Err.Print("Error: ", err)
+		c.Logger.Errorf("%s", err)
 		return CodeDetectError
 	}
 	log := &bytes.Buffer{}
 	defer func() {
 		if log.Len() > 0 {
-			c.Out.Printf("======== Output: %s ========\n%s", bp.Name, log)
+			c.Logger.Infof("%s\n%s", logHeader("======== Output: "+bp.Name+" ========"), log)
 		}
 	}()
-	cmd := exec.Command(detectPath, platformDir, planPath)
+	bpEnv, err := buildpackEnv(bp, platformDir, c.StackID)
+	if err != nil {
+		c.Logger.Errorf("%s", err)
+		return CodeDetectError
+	}
+	cmd := command(c.Context, detectPath, platformDir, planPath)
+	cmd.Env = append(os.Environ(), bpEnv...)
 	cmd.Dir = appDir
 	cmd.Stdin = in
-	cmd.Stdout = log
-	cmd.Stderr = log
+	output := prefixLines(log, bp.LogPrefix(c.LogPrefix))
+	cmd.Stdout = output
+	cmd.Stderr = output
 	if err := cmd.Run(); err != nil {
 		if err, ok := err.(*exec.ExitError); ok {
 			if status, ok := err.Sys().(syscall.WaitStatus); ok {
 				return status.ExitStatus()
 			}
 		}
-		c.Err.Print("Error: ", err)
+		c.Logger.Errorf("%s", err)
 		return CodeDetectError
 	}
 	if err := parsePlan(out, planPath); err != nil {
-		c.Err.Print("Error: ", err)
+		c.Logger.Errorf("%s", err)
 		return CodeDetectError
 	}
 	return CodeDetectPass
@@ -102,31 +153,38 @@ func parsePlan(out io.Writer, path string) error {
 	return err
 }
 
+// BuildpackGroup lists the buildpacks and, once detected, the extensions
+// that passed detection separately, so that only the extender sees them.
 type BuildpackGroup struct {
-	Buildpacks []*Buildpack `toml:"buildpacks"`
+	Buildpacks []*Buildpack `toml:"buildpacks" json:"buildpacks"`
+	Extensions []*Buildpack `toml:"extensions,omitempty" json:"extensions,omitempty"`
 }
 
 func (bg *BuildpackGroup) Detect(c *DetectConfig) (plan []byte, group *BuildpackGroup, ok bool) {
 	group = &BuildpackGroup{}
 	detected := true
 	plan, codes := bg.pDetect(c)
-	c.Out.Printf("======== Results ========")
+	c.Logger.Infof("%s", logHeader("======== Results ========"))
 	for i, code := range codes {
-		name := bg.Buildpacks[i].Name
+		name := logBuildpack(bg.Buildpacks[i].Name)
 		optional := bg.Buildpacks[i].Optional
 		switch code {
 		case CodeDetectPass:
-			c.Out.Printf("%s: pass", name)
-			group.Buildpacks = append(group.Buildpacks, bg.Buildpacks[i])
+			c.Logger.Infof("%s: pass", name)
+			if bg.Buildpacks[i].Extension {
+				group.Extensions = append(group.Extensions, bg.Buildpacks[i])
+			} else {
+				group.Buildpacks = append(group.Buildpacks, bg.Buildpacks[i])
+			}
 		case CodeDetectFail:
 			if optional {
-				c.Out.Printf("%s: skip", name)
+				c.Logger.Infof("%s: skip", name)
 			} else {
-				c.Out.Printf("%s: fail", name)
+				c.Logger.Infof("%s: fail", name)
 			}
 			detected = detected && optional
 		default:
-			c.Out.Printf("%s: error (%d)", name, code)
+			c.Logger.Infof("%s: error (%d)", name, code)
 			detected = detected && optional
 		}
 	}
@@ -153,14 +211,14 @@ func (bg *BuildpackGroup) pDetect(c *DetectConfig) (plan []byte, codes []int) {
 				codes[i] = bg.Buildpacks[i].Detect(c, last, add)
 				io.Copy(ioutil.Discard, last)
 				if codes[i] == CodeDetectPass {
-					mergeTOML(c.Err, out, orig, add)
+					mergeTOML(c.Logger, out, orig, add)
 				} else {
-					mergeTOML(c.Err, out, orig)
+					mergeTOML(c.Logger, out, orig)
 				}
 			} else {
 				codes[i] = bg.Buildpacks[i].Detect(c, nil, add)
 				if codes[i] == CodeDetectPass {
-					mergeTOML(c.Err, out, add)
+					mergeTOML(c.Logger, out, add)
 				}
 			}
 		}(i, lastIn)
@@ -169,7 +227,7 @@ func (bg *BuildpackGroup) pDetect(c *DetectConfig) (plan []byte, codes []int) {
 	if lastIn != nil {
 		defer lastIn.Close()
 		if p, err := ioutil.ReadAll(lastIn); err != nil {
-			c.Err.Print("Warning: ", err)
+			c.Logger.Warnf("%s", err)
 		} else {
 			plan = p
 		}
@@ -177,12 +235,12 @@ func (bg *BuildpackGroup) pDetect(c *DetectConfig) (plan []byte, codes []int) {
 	return plan, codes
 }
 
-func mergeTOML(l *log.Logger, out io.Writer, in ...io.Reader) {
+func mergeTOML(l Logger, out io.Writer, in ...io.Reader) {
 	result := map[string]interface{}{}
 	for _, r := range in {
 		var m map[string]interface{}
 		if _, err := toml.DecodeReader(r, &m); err != nil {
-			l.Print("Warning: ", err)
+			l.Warnf("%s", err)
 			continue
 		}
 		for k, v := range m {
@@ -190,7 +248,7 @@ func mergeTOML(l *log.Logger, out io.Writer, in ...io.Reader) {
 		}
 	}
 	if err := toml.NewEncoder(out).Encode(result); err != nil {
-		l.Print("Warning: ", err)
+		l.Warnf("%s", err)
 	}
 }
 
@@ -198,7 +256,7 @@ type BuildpackOrder []BuildpackGroup
 
 func (bo BuildpackOrder) Detect(c *DetectConfig) (plan []byte, group *BuildpackGroup) {
 	for i := range bo {
-		c.Out.Printf("Trying group %d out of %d with %d buildpacks...", i+1, len(bo), len(bo[i].Buildpacks))
+		c.Logger.Infof("Trying group %d out of %d with %d buildpacks...", i+1, len(bo), len(bo[i].Buildpacks))
 		if p, g, ok := bo[i].Detect(c); ok {
 			return p, g
 		}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,6 +14,7 @@ import (
 	"github.com/sclevine/spec/report"
 
 	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/lifecycle/buildpack"
 )
 
 func TestDetector(t *testing.T) {
@@ -46,8 +46,7 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 		config = &lifecycle.DetectConfig{
 			AppDir:      appDir,
 			PlatformDir: platformDir,
-			Out:         log.New(io.MultiWriter(outLog, it.Out()), "", 0),
-			Err:         log.New(io.MultiWriter(errLog, it.Out()), "", 0),
+			Logger:      lifecycle.NewDefaultLogger(io.MultiWriter(outLog, it.Out()), io.MultiWriter(errLog, it.Out())),
 		}
 
 		buildpackDir := filepath.Join("testdata", "buildpack")
@@ -123,6 +122,97 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 			}
 		})
 
+		it("should prefix buildpack output when a log prefix is set", func() {
+			mkfile(t, "1", filepath.Join(appDir, "add"))
+			mkfile(t, "0", filepath.Join(appDir, "last"))
+			config.LogPrefix = "{id}@{version}| "
+
+			list[0].Buildpacks[1].Version = "1.2.3"
+			list.Detect(config)
+
+			if !strings.Contains(outLog.String(),
+				"======== Output: buildpack2-name ========\n"+
+					"com.buildpack2@1.2.3| stdout: 1\ncom.buildpack2@1.2.3| stderr: 1\n",
+			) {
+				t.Fatalf("Unexpected log: %s\n", outLog)
+			}
+		})
+
+		it("should fail buildpacks that do not support the stack", func() {
+			mkfile(t, "1", filepath.Join(appDir, "add"))
+			mkfile(t, "3", filepath.Join(appDir, "last"))
+			config.StackID = "some-stack"
+			list = list[1:2]
+			for _, bp := range list[0].Buildpacks {
+				bp.Stacks = buildpack.Stacks{{ID: "some-stack"}}
+			}
+			list[0].Buildpacks[2].Stacks = buildpack.Stacks{{ID: "other-stack"}}
+
+			_, group := list.Detect(config)
+			if group != nil {
+				t.Fatalf("Unexpected group: %#v\n", group)
+			}
+			if !strings.Contains(outLog.String(), "buildpack3-name: fail\n") {
+				t.Fatalf("Unexpected log: %s\n", outLog)
+			}
+		})
+
+		it("should provide the stack ID, buildpack dir, and platform env", func() {
+			mkfile(t, "1", filepath.Join(appDir, "add"))
+			mkfile(t, "3", filepath.Join(appDir, "last"))
+			mkfile(t, "some-data", filepath.Join(platformDir, "env", "SOME_VAR"))
+			config.StackID = "some-stack"
+			list = list[2:3]
+
+			list.Detect(config)
+			bpDir, err := filepath.Abs(filepath.Join("testdata", "buildpack"))
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			if s := cmp.Diff(rdfile(t, filepath.Join(appDir, "cnb-env")), "some-stack|"+bpDir+"|some-data\n"); s != "" {
+				t.Fatalf("Unexpected env:\n%s\n", s)
+			}
+		})
+
+		it("should return passing extensions separately from the buildpacks", func() {
+			mkfile(t, "1", filepath.Join(appDir, "add"))
+			mkfile(t, "3", filepath.Join(appDir, "last"))
+			list = list[1:2]
+			list[0].Buildpacks[0].Extension = true
+
+			_, group := list.Detect(config)
+			if group == nil {
+				t.Fatalf("Expected group")
+			}
+			if s := cmp.Diff(group.Extensions, list[0].Buildpacks[:1]); s != "" {
+				t.Fatalf("Unexpected extensions:\n%s\n", s)
+			}
+			if s := cmp.Diff(group.Buildpacks, list[0].Buildpacks[1:3]); s != "" {
+				t.Fatalf("Unexpected buildpacks:\n%s\n", s)
+			}
+		})
+
+		it("should fail buildpacks that require mixins missing from the build image", func() {
+			mkfile(t, "1", filepath.Join(appDir, "add"))
+			mkfile(t, "3", filepath.Join(appDir, "last"))
+			config.StackID = "some-stack"
+			config.BuildMixins = []string{"some-mixin"}
+			list = list[1:2]
+			for _, bp := range list[0].Buildpacks {
+				bp.Stacks = buildpack.Stacks{{ID: "some-stack", Mixins: []string{"some-mixin", "run:run-mixin"}}}
+			}
+			list[0].Buildpacks[2].ID = "buildpack3-id"
+			list[0].Buildpacks[2].Stacks[0].Mixins = []string{"some-mixin", "build:other-mixin"}
+
+			_, group := list.Detect(config)
+			if group != nil {
+				t.Fatalf("Unexpected group: %#v\n", group)
+			}
+			if !strings.Contains(errLog.String(), "buildpack 'buildpack3-id' requires mixins missing from the build image: build:other-mixin\n") {
+				t.Fatalf("Unexpected log: %s\n", errLog)
+			}
+		})
+
 		it("should return empty if no groups match", func() {
 			mkfile(t, "1", filepath.Join(appDir, "add"))
 			mkfile(t, "0", filepath.Join(appDir, "last"))
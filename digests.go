@@ -0,0 +1,134 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/buildpack/lifecycle/archive"
+)
+
+// layerDigests holds layer tar digests computed ahead of time by path, so
+// that the layers of an export or cache can be hashed concurrently and then
+// added to the image in order.
+type layerDigests map[string]archive.Digest
+
+// digestLayers hashes paths concurrently, skipping paths whose digest is in
+// index. Paths that fail to hash are left out, so that get reports the error
+// for the layer that it belongs to.
+func digestLayers(paths []string, uid, gid int, index *DiffIDIndex) layerDigests {
+	digests := layerDigests{}
+	var misses, keys []string
+	for _, path := range paths {
+		key, digest, ok := index.lookup(path, uid, gid)
+		if ok {
+			digests[path] = digest
+			continue
+		}
+		misses = append(misses, path)
+		keys = append(keys, key)
+	}
+	list, err := archive.TarDigests(misses, uid, gid, 0)
+	if err != nil {
+		return digests
+	}
+	for i, path := range misses {
+		digests[path] = list[i]
+		index.record(keys[i], list[i])
+	}
+	return digests
+}
+
+func (d layerDigests) get(path string, uid, gid int) (archive.Digest, error) {
+	if digest, ok := d[path]; ok {
+		return digest, nil
+	}
+	sha, size, err := archive.TarDigest(path, uid, gid)
+	return archive.Digest{SHA: sha, Size: size}, err
+}
+
+// DiffIDIndex maps the contents of layer directories to the digests of the
+// tars produced for them, so that layers that are identical to ones exported
+// or cached before, or that a previous phase already hashed, are not tarred
+// again. Entries are keyed on the path of the layer, the owner of its files
+// in the tar and archive.ContentDigest. A nil index does nothing.
+type DiffIDIndex struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]archive.Digest
+	used    map[string]archive.Digest
+}
+
+// ReadDiffIDIndex reads the index at path, or returns an empty index if
+// the file does not exist or cannot be parsed.
+func ReadDiffIDIndex(path string) (*DiffIDIndex, error) {
+	index := &DiffIDIndex{path: path, entries: map[string]archive.Digest{}, used: map[string]archive.Digest{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index.entries); err != nil {
+		index.entries = map[string]archive.Digest{}
+	}
+	return index, nil
+}
+
+// Save writes the entries that were looked up or recorded since the index
+// was read, dropping the rest.
+func (i *DiffIDIndex) Save() error {
+	if i == nil {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	data, err := json.Marshal(i.used)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(i.path), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(i.path, data, 0666)
+}
+
+func (i *DiffIDIndex) lookup(path string, uid, gid int) (string, archive.Digest, bool) {
+	if i == nil {
+		return "", archive.Digest{}, false
+	}
+	key, err := indexKey(path, uid, gid)
+	if err != nil {
+		return "", archive.Digest{}, false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	digest, ok := i.entries[key]
+	if ok {
+		i.used[key] = digest
+	}
+	return key, digest, ok
+}
+
+func (i *DiffIDIndex) record(key string, digest archive.Digest) {
+	if i == nil || key == "" {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.entries[key] = digest
+	i.used[key] = digest
+}
+
+// indexKey covers everything that WriteTarArchive writes for path: tar
+// entries are named after their paths and owned by uid and gid.
+func indexKey(path string, uid, gid int) (string, error) {
+	digest, err := archive.ContentDigest(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %d:%d %s", path, uid, gid, digest), nil
+}
@@ -0,0 +1,107 @@
+package lifecycle
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// instruction is a Dockerfile instruction, with continuation lines joined.
+type instruction struct {
+	Line int
+	Cmd  string
+	Args string
+}
+
+func parseDockerfile(path string) ([]instruction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		out     []instruction
+		current []string
+		start   int
+	)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(current) == 0 {
+			start = n
+		}
+		if strings.HasSuffix(line, `\`) {
+			current = append(current, strings.TrimSuffix(line, `\`))
+			continue
+		}
+		current = append(current, line)
+		parts := strings.SplitN(strings.Join(current, " "), " ", 2)
+		ins := instruction{Line: start, Cmd: strings.ToUpper(parts[0])}
+		if len(parts) > 1 {
+			ins.Args = strings.TrimSpace(parts[1])
+		}
+		out = append(out, ins)
+		current = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// splitWords splits s on whitespace, keeping double-quoted strings, without
+// their quotes, in one word.
+func splitWords(s string) []string {
+	var (
+		words  []string
+		word   strings.Builder
+		quoted bool
+		inWord bool
+	)
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inWord = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// parseKeyValues parses the arguments of ENV and ARG, either "key=value ..."
+// or the legacy "key value" form.
+func parseKeyValues(args string) [][2]string {
+	words := splitWords(args)
+	if len(words) > 0 && !strings.Contains(words[0], "=") {
+		if len(words) == 1 {
+			return [][2]string{{words[0], ""}}
+		}
+		return [][2]string{{words[0], strings.Join(words[1:], " ")}}
+	}
+	var pairs [][2]string
+	for _, w := range words {
+		kv := strings.SplitN(w, "=", 2)
+		if len(kv) == 1 {
+			pairs = append(pairs, [2]string{kv[0], ""})
+		} else {
+			pairs = append(pairs, [2]string{kv[0], kv[1]})
+		}
+	}
+	return pairs
+}
@@ -85,6 +85,28 @@ func eachEnvFile(dir string, fn func(k, v string) error) error {
 	return nil
 }
 
+// buildpackEnv returns the variables that the lifecycle provides to the
+// executables of bp: the platform env files, CNB_BUILDPACK_DIR, and
+// CNB_STACK_ID when stackID is set.
+func buildpackEnv(bp *Buildpack, platformDir, stackID string) ([]string, error) {
+	var env []string
+	if err := eachEnvFile(filepath.Join(platformDir, "env"), func(k, v string) error {
+		env = append(env, k+"="+v)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	bpDir, err := filepath.Abs(bp.Dir)
+	if err != nil {
+		return nil, err
+	}
+	env = append(env, "CNB_BUILDPACK_DIR="+bpDir)
+	if stackID != "" {
+		env = append(env, "CNB_STACK_ID="+stackID)
+	}
+	return env, nil
+}
+
 func (p *Env) List() []string {
 	return p.Environ()
 }
@@ -0,0 +1,47 @@
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/buildpack/lifecycle/cache"
+	"github.com/buildpack/lifecycle/image"
+)
+
+// Errors from images and caches match these with errors.Is, so that
+// platforms embedding the lifecycle can tell failures apart. Errors the
+// lifecycle wraps with github.com/pkg/errors match once unwrapped with
+// errors.Cause.
+var (
+	ErrImageNotFound = image.ErrImageNotFound
+	ErrLayerNotFound = image.ErrLayerNotFound
+	ErrAuthFailure   = image.ErrAuthFailure
+	ErrCacheCorrupt  = cache.ErrCacheCorrupt
+)
+
+// BuildpackError is returned when a buildpack fails to build.
+type BuildpackError struct {
+	ID  string
+	Err error
+}
+
+func (e *BuildpackError) Error() string {
+	return fmt.Sprintf("buildpack '%s' failed: %s", e.ID, e.Err)
+}
+
+func (e *BuildpackError) Unwrap() error { return e.Err }
+
+// BuildpackID returns the ID of the buildpack, for error reports.
+func (e *BuildpackError) BuildpackID() string { return e.ID }
+
+// isCacheCorrupt reports whether err, or the error it was wrapped from with
+// github.com/pkg/errors, matches ErrCacheCorrupt.
+func isCacheCorrupt(err error) bool {
+	if errors.Is(err, ErrCacheCorrupt) {
+		return true
+	}
+	if causer, ok := err.(interface{ Cause() error }); ok {
+		return isCacheCorrupt(causer.Cause())
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package lifecycle
+
+import "time"
+
+// Events receives notifications about lifecycle operations, so that platforms
+// embedding the lifecycle can drive a UI or telemetry without parsing output.
+// Detection runs buildpacks concurrently, so implementations must be safe for
+// concurrent use. Embed NopEvents to implement only some of the methods.
+type Events interface {
+	OnBuildpackStarted(e BuildpackEvent)
+	OnBuildpackFinished(e BuildpackEvent)
+	OnLayerRestored(e LayerEvent)
+	OnLayerExported(e LayerEvent)
+	OnLayerCached(e LayerEvent)
+	OnImageSaved(e ImageEvent)
+}
+
+type BuildpackEvent struct {
+	Phase     string
+	Buildpack Buildpack
+	Duration  time.Duration // set when finished
+	Code      int           // detect exit code, set when detection finished
+	Err       error         // set when the build failed
+}
+
+type LayerEvent struct {
+	ID     string
+	SHA    string
+	Size   int64
+	Reused bool
+}
+
+type ImageEvent struct {
+	Name   string
+	Digest string
+}
+
+type NopEvents struct{}
+
+func (NopEvents) OnBuildpackStarted(BuildpackEvent)  {}
+func (NopEvents) OnBuildpackFinished(BuildpackEvent) {}
+func (NopEvents) OnLayerRestored(LayerEvent)         {}
+func (NopEvents) OnLayerExported(LayerEvent)         {}
+func (NopEvents) OnLayerCached(LayerEvent)           {}
+func (NopEvents) OnImageSaved(ImageEvent)            {}
+
+func eventsOrNop(e Events) Events {
+	if e == nil {
+		return NopEvents{}
+	}
+	return e
+}
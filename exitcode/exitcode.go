@@ -0,0 +1,106 @@
+// Package exitcode defines the codes the lifecycle's phases exit with, so
+// that platforms running or embedding them can tell failures apart.
+package exitcode
+
+import (
+	"io"
+	"net"
+	"syscall"
+)
+
+const (
+	Success = 0
+	// Failed is the code of failures without a more specific code.
+	Failed = 1
+	// InvalidArgs is the code of invalid flags, arguments and config files.
+	InvalidArgs = 3
+	// InvalidEnv is the code of invalid environment variables.
+	InvalidEnv = 4
+	// NotFound is the code of missing files, images and processes.
+	NotFound = 5
+	// FailedDetect is the code of groups where no buildpack passed detection.
+	FailedDetect = 6
+	// FailedBuild is the code of buildpacks that failed to build.
+	FailedBuild = 7
+	// FailedLaunch is the code of processes the launcher failed to start.
+	FailedLaunch = 8
+	// FailedUpdate is the code of failures to update the app image.
+	FailedUpdate = 9
+	// Interrupted is the code of phases stopped by a signal.
+	Interrupted = 10
+	// IncompatiblePlatformAPI is the code of unsupported platform API
+	// versions.
+	IncompatiblePlatformAPI = 11
+	// Retryable is the code of failures without a more specific code that
+	// may not recur when the phase is run again, such as network failures
+	// and registry outages.
+	Retryable = 12
+	// Internal is the code of phases that crashed on a bug in the
+	// lifecycle.
+	Internal = 13
+)
+
+// Coder is implemented by errors that have an exit code.
+type Coder interface {
+	ExitCode() int
+}
+
+// Of returns the code a phase exits with for err: Success for nil, the code
+// of the first error in its chain of causes that has one, and Failed
+// otherwise, or Retryable instead of Failed when err is retryable.
+func Of(err error) int {
+	if err == nil {
+		return Success
+	}
+	code := Failed
+	walk(err, func(err error) bool {
+		if coder, ok := err.(Coder); ok {
+			code = coder.ExitCode()
+			return true
+		}
+		return false
+	})
+	if code == Failed && IsRetryable(err) {
+		return Retryable
+	}
+	return code
+}
+
+// IsRetryable reports whether err may not recur when retried, which is the
+// case when an error in its chain of causes is a network timeout, a reset
+// or refused connection, or has a Retryable method that returns true. A
+// Retryable method that returns false makes err permanent.
+func IsRetryable(err error) bool {
+	retryable := false
+	walk(err, func(err error) bool {
+		if r, ok := err.(interface{ Retryable() bool }); ok {
+			retryable = r.Retryable()
+			return true
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			retryable = true
+			return true
+		}
+		switch err {
+		case io.ErrUnexpectedEOF, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.ETIMEDOUT, syscall.EPIPE:
+			retryable = true
+			return true
+		}
+		return false
+	})
+	return retryable
+}
+
+// walk calls f with err and each of its causes until f returns true.
+func walk(err error, f func(error) bool) {
+	for err != nil && !f(err) {
+		switch e := err.(type) {
+		case interface{ Cause() error }:
+			err = e.Cause()
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		default:
+			err = nil
+		}
+	}
+}
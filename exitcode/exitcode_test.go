@@ -0,0 +1,83 @@
+package exitcode_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/exitcode"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestExitCode(t *testing.T) {
+	spec.Run(t, "ExitCode", testExitCode, spec.Report(report.Terminal{}))
+}
+
+type codeError int
+
+func (e codeError) Error() string { return fmt.Sprintf("failed with %d", int(e)) }
+func (e codeError) ExitCode() int { return int(e) }
+
+type retryableError bool
+
+func (e retryableError) Error() string   { return "some-error" }
+func (e retryableError) Retryable() bool { return bool(e) }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func testExitCode(t *testing.T, when spec.G, it spec.S) {
+	when("#Of", func() {
+		it("returns success for no error", func() {
+			h.AssertEq(t, exitcode.Of(nil), exitcode.Success)
+		})
+
+		it("returns the code of the error", func() {
+			h.AssertEq(t, exitcode.Of(codeError(exitcode.FailedBuild)), exitcode.FailedBuild)
+		})
+
+		it("returns the code of a wrapped error", func() {
+			h.AssertEq(t, exitcode.Of(errors.Wrap(codeError(exitcode.NotFound), "some-context")), exitcode.NotFound)
+			h.AssertEq(t, exitcode.Of(fmt.Errorf("some-context: %w", codeError(exitcode.InvalidEnv))), exitcode.InvalidEnv)
+		})
+
+		it("returns failed for errors without a code", func() {
+			h.AssertEq(t, exitcode.Of(errors.New("some-error")), exitcode.Failed)
+		})
+
+		it("returns retryable for retryable errors without a more specific code", func() {
+			h.AssertEq(t, exitcode.Of(errors.Wrap(syscall.ECONNRESET, "some-context")), exitcode.Retryable)
+			h.AssertEq(t, exitcode.Of(codeError(exitcode.Failed)), exitcode.Failed)
+		})
+	})
+
+	when("#IsRetryable", func() {
+		it("returns true for network failures", func() {
+			timeout := &net.OpError{Op: "dial", Err: timeoutError{}}
+			h.AssertEq(t, exitcode.IsRetryable(errors.Wrap(timeout, "some-context")), true)
+			reset := &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+			h.AssertEq(t, exitcode.IsRetryable(reset), true)
+			h.AssertEq(t, exitcode.IsRetryable(io.ErrUnexpectedEOF), true)
+		})
+
+		it("returns what errors in the chain report", func() {
+			h.AssertEq(t, exitcode.IsRetryable(errors.Wrap(retryableError(true), "some-context")), true)
+			h.AssertEq(t, exitcode.IsRetryable(fmt.Errorf("some-context: %w", retryableError(false))), false)
+		})
+
+		it("returns false for other errors", func() {
+			h.AssertEq(t, exitcode.IsRetryable(errors.New("some-error")), false)
+			h.AssertEq(t, exitcode.IsRetryable(nil), false)
+		})
+	})
+}
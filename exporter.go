@@ -3,8 +3,9 @@ package lifecycle
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -12,20 +13,77 @@ import (
 	"github.com/buildpack/lifecycle/cmd"
 	"github.com/buildpack/lifecycle/image"
 	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
 type Exporter struct {
-	Buildpacks   []*Buildpack
-	ArtifactsDir string
-	In           []byte
-	Out, Err     *log.Logger
-	UID, GID     int
+	Buildpacks     []*Buildpack
+	ArtifactsDir   string
+	In             []byte
+	Logger         Logger
+	Tracer         *telemetry.Tracer
+	Events         Events
+	BuildID        string // labels the image when set
+	StackID        string // checked against the run image's stack ID and mixins when set
+	User           string // replaces the run image's USER when set
+	LabelSizeLimit int    // defaults to metadata.DefaultLabelSizeLimit
+	BOMPath        string // BOM is not written to a file when empty
+	DiffIDIndex    *DiffIDIndex
+	UID, GID       int
+
+	// RegistryRunImage, when set, is compared with the run image to warn
+	// when a daemon run image is outdated.
+	RegistryRunImage image.Image
+
+	// FailDeprecatedStack fails the export instead of warning when the run
+	// image is labeled as deprecated or past its end of life.
+	FailDeprecatedStack bool
+
+	// Signer, when set, signs the saved image by its digest.
+	Signer *Signer
+
+	// SBOMAttacher, when set, attaches the BOM to the saved image as a
+	// CycloneDX artifact if any buildpack contributed to it.
+	SBOMAttacher SBOMAttacher
+
+	// ProvenancePath, when set, is where a SLSA provenance statement for the
+	// saved image is written.
+	ProvenancePath string
+
+	// ProvenanceAttacher, when set, attaches the provenance statement to the
+	// saved image.
+	ProvenanceAttacher ProvenanceAttacher
+
+	// Index, when set, is the manifest list that IndexWriter adds the saved
+	// image to.
+	Index       string
+	IndexWriter IndexWriter
+}
+
+// IndexWriter adds a saved image to a manifest list.
+type IndexWriter interface {
+	AddToIndex(indexName, repoName, digest string) (string, error)
+}
+
+// SBOMAttacher attaches a software bill of materials to a saved image.
+type SBOMAttacher interface {
+	AttachSBOM(repoName, digest, mediaType string, sbom []byte) (string, error)
 }
 
 func (e *Exporter) Export(layersDir, appDir string, runImage, origImage image.Image, launcher string, stack metadata.StackMetadata) error {
 	var err error
 
-	meta := metadata.AppImageMetadata{}
+	meta := metadata.AppImageMetadata{SchemaVersion: metadata.AppMetadataVersion}
+
+	if err := e.checkStackID(runImage); err != nil {
+		return err
+	}
+	if err := e.checkMixins(runImage); err != nil {
+		return err
+	}
+	if err := checkStackDeprecation(e.Logger, "run", runImage, e.FailDeprecatedStack); err != nil {
+		return err
+	}
 
 	meta.RunImage.TopLayer, err = runImage.TopLayer()
 	if err != nil {
@@ -36,28 +94,41 @@ func (e *Exporter) Export(layersDir, appDir string, runImage, origImage image.Im
 	if err != nil {
 		return errors.Wrap(err, "get run image digest")
 	}
+	if e.RegistryRunImage != nil {
+		checkRunImageDigest(e.Logger, e.Tracer, meta.RunImage.SHA, e.RegistryRunImage)
+	}
 
 	meta.Stack = stack
 
 	origMetadata, err := metadata.GetAppMetadata(origImage)
+	if _, ok := err.(*metadata.IncompatibleError); ok {
+		e.Logger.Warnf("ignoring metadata of previous image: %s", err)
+		err = nil
+	}
 	if err != nil {
 		return errors.Wrap(err, "metadata for previous image")
 	}
 
+	runImageName := runImage.Name()
 	runImage.Rename(origImage.Name())
 	appImage := runImage
 
-	meta.App.SHA, err = e.addOrReuseLayer(appImage, &layer{path: appDir, identifier: "app"}, origMetadata.App.SHA)
+	digests, err := e.digestLayers(layersDir, appDir, launcher)
+	if err != nil {
+		return err
+	}
+
+	meta.App.SHA, err = e.addOrReuseLayer(appImage, &layer{path: appDir, identifier: "app"}, origMetadata.App.SHA, digests)
 	if err != nil {
 		return errors.Wrap(err, "exporting app layer")
 	}
 
-	meta.Config.SHA, err = e.addOrReuseLayer(appImage, &layer{path: filepath.Join(layersDir, "config"), identifier: "config"}, origMetadata.Config.SHA)
+	meta.Config.SHA, err = e.addOrReuseLayer(appImage, &layer{path: filepath.Join(layersDir, "config"), identifier: "config"}, origMetadata.Config.SHA, digests)
 	if err != nil {
 		return errors.Wrap(err, "exporting config layer")
 	}
 
-	meta.Launcher.SHA, err = e.addOrReuseLayer(appImage, &layer{path: launcher, identifier: "launcher"}, origMetadata.Launcher.SHA)
+	meta.Launcher.SHA, err = e.addOrReuseLayer(appImage, &layer{path: launcher, identifier: "launcher"}, origMetadata.Launcher.SHA, digests)
 	if err != nil {
 		return errors.Wrap(err, "exporting launcher layer")
 	}
@@ -69,6 +140,9 @@ func (e *Exporter) Export(layersDir, appDir string, runImage, origImage image.Im
 		}
 		bpMD := metadata.BuildpackMetadata{ID: bp.ID, Version: bp.Version, Layers: map[string]metadata.LayerMetadata{}}
 
+		for _, layer := range bpDir.findLayers(notLaunch) {
+			e.Logger.Debugf("layer '%s': launch=false, not exporting", layer.Identifier())
+		}
 		for _, layer := range bpDir.findLayers(launch) {
 			lmd, err := layer.read()
 			if err != nil {
@@ -77,7 +151,7 @@ func (e *Exporter) Export(layersDir, appDir string, runImage, origImage image.Im
 
 			if layer.hasLocalContents() {
 				origLayerMetadata := origMetadata.MetadataForBuildpack(bp.ID).Layers[layer.name()]
-				lmd.SHA, err = e.addOrReuseLayer(appImage, &layer, origLayerMetadata.SHA)
+				lmd.SHA, err = e.addOrReuseLayer(appImage, &layer, origLayerMetadata.SHA, digests)
 				if err != nil {
 					return err
 				}
@@ -90,10 +164,16 @@ func (e *Exporter) Export(layersDir, appDir string, runImage, origImage image.Im
 					return fmt.Errorf("cannot reuse '%s', previous image has no metadata for layer '%s'", layer.Identifier(), layer.Identifier())
 				}
 
-				e.Out.Printf("Reusing layer '%s' with SHA %s\n", layer.Identifier(), origLayerMetadata.SHA)
-				if err := appImage.ReuseLayer(origLayerMetadata.SHA); err != nil {
+				e.Logger.Debugf("layer '%s': launch=true with no local contents, reusing previous image SHA %s", layer.Identifier(), origLayerMetadata.SHA)
+				e.Logger.Infof("Reusing layer '%s' with SHA %s\n", layer.Identifier(), origLayerMetadata.SHA)
+				span := e.Tracer.Start("export-layer").SetAttribute("layer", layer.Identifier()).SetAttribute("sha", origLayerMetadata.SHA).SetAttribute("reused", true)
+				err := appImage.ReuseLayer(origLayerMetadata.SHA)
+				span.SetError(err)
+				span.Finish()
+				if err != nil {
 					return errors.Wrapf(err, "reusing layer: '%s'", layer.Identifier())
 				}
+				eventsOrNop(e.Events).OnLayerExported(LayerEvent{ID: layer.Identifier(), SHA: origLayerMetadata.SHA, Reused: true})
 				lmd.SHA = origLayerMetadata.SHA
 			}
 			bpMD.Layers[layer.name()] = lmd
@@ -101,23 +181,48 @@ func (e *Exporter) Export(layersDir, appDir string, runImage, origImage image.Im
 
 		if malformedLayers := bpDir.findLayers(malformed); len(malformedLayers) > 0 {
 			ids := make([]string, 0, len(malformedLayers))
+			reasons := make([]string, 0, len(malformedLayers))
 			for _, ml := range malformedLayers {
 				ids = append(ids, ml.Identifier())
+				if _, err := ml.read(); err != nil {
+					reasons = append(reasons, err.Error())
+				}
 			}
-			return fmt.Errorf("failed to parse metadata for layers '%s'", ids)
+			return fmt.Errorf("failed to parse metadata for layers '%s': %s", ids, strings.Join(reasons, "; "))
 		}
 
 		meta.Buildpacks = append(meta.Buildpacks, bpMD)
 	}
 
+	var buildMD BuildMetadata
+	if err := DecodeFile(filepath.Join(layersDir, "config", "metadata.toml"), &buildMD, "bom"); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "read build metadata")
+	}
+	meta.BOM = buildMD.BOM
+	if e.BOMPath != "" {
+		if err := WriteBOM(e.BOMPath, meta.BOM); err != nil {
+			return errors.Wrap(err, "write BOM")
+		}
+	}
+
 	data, err := json.Marshal(meta)
 	if err != nil {
 		return errors.Wrap(err, "marshall metadata")
 	}
-	if err := appImage.SetLabel(metadata.AppMetadataLabel, string(data)); err != nil {
+	limit := e.LabelSizeLimit
+	if limit == 0 {
+		limit = metadata.DefaultLabelSizeLimit
+	}
+	if err := metadata.SetLabel(appImage, metadata.AppMetadataLabel, data, limit, e.ArtifactsDir); err != nil {
 		return errors.Wrap(err, "set app image metadata label")
 	}
 
+	if e.BuildID != "" {
+		if err := appImage.SetLabel(metadata.LabelName(metadata.BuildIDLabel), e.BuildID); err != nil {
+			return errors.Wrap(err, "set app image build ID label")
+		}
+	}
+
 	if err := appImage.SetEnv(cmd.EnvLayersDir, layersDir); err != nil {
 		return errors.Wrapf(err, "set app image env %s", cmd.EnvLayersDir)
 	}
@@ -134,24 +239,171 @@ func (e *Exporter) Export(layersDir, appDir string, runImage, origImage image.Im
 		return errors.Wrap(err, "setting cmd")
 	}
 
+	if e.User != "" {
+		if err := appImage.SetUser(e.User); err != nil {
+			return errors.Wrap(err, "setting user")
+		}
+	}
+
 	sha, err := appImage.Save()
-	if err == nil {
-		e.Out.Printf("\n*** Image: %s@%s\n", runImage.Name(), sha)
+	if err != nil {
+		return err
 	}
+	e.Logger.Infof("\n*** Image: %s@%s\n", runImage.Name(), sha)
+	eventsOrNop(e.Events).OnImageSaved(ImageEvent{Name: runImage.Name(), Digest: sha})
 
-	return err
+	if e.SBOMAttacher != nil && len(meta.BOM) > 0 {
+		sbom, err := json.Marshal(cycloneDX(meta.BOM))
+		if err != nil {
+			return errors.Wrap(err, "marshal BOM")
+		}
+		ref, err := e.SBOMAttacher.AttachSBOM(runImage.Name(), sha, cycloneDXMediaType, sbom)
+		if err != nil {
+			return errors.Wrap(err, "attach BOM")
+		}
+		e.Logger.Infof("*** BOM: %s\n", ref)
+	}
+	if e.ProvenancePath != "" || e.ProvenanceAttacher != nil {
+		statement, err := marshalProvenance(e.provenance(runImage.Name(), sha, runImageName, meta))
+		if err != nil {
+			return errors.Wrap(err, "marshal provenance")
+		}
+		if e.ProvenancePath != "" {
+			if err := writeProvenance(e.ProvenancePath, statement); err != nil {
+				return errors.Wrap(err, "write provenance")
+			}
+		}
+		if e.ProvenanceAttacher != nil {
+			ref, err := e.ProvenanceAttacher.AttachProvenance(runImage.Name(), sha, provenanceMediaType, statement)
+			if err != nil {
+				return errors.Wrap(err, "attach provenance")
+			}
+			e.Logger.Infof("*** Provenance: %s\n", ref)
+		}
+	}
+	if e.Signer != nil {
+		span := e.Tracer.Start("sign-image").SetAttribute("image", runImage.Name())
+		err := e.Signer.Sign(runImage.Name(), sha)
+		span.SetError(err)
+		span.Finish()
+		if err != nil {
+			return errors.Wrap(err, "sign image")
+		}
+		e.Logger.Infof("*** Signed: %s@%s\n", runImage.Name(), sha)
+	}
+	if e.Index != "" && e.IndexWriter != nil {
+		indexDigest, err := e.IndexWriter.AddToIndex(e.Index, runImage.Name(), sha)
+		if err != nil {
+			return errors.Wrap(err, "add image to index")
+		}
+		e.Logger.Infof("*** Index: %s@%s\n", e.Index, indexDigest)
+	}
+	return nil
+}
+
+func (e *Exporter) checkStackID(runImage image.Image) error {
+	if e.StackID == "" {
+		return nil
+	}
+	label := metadata.LabelName(metadata.StackIDLabel)
+	runStackID, err := runImage.Label(label)
+	if err != nil {
+		return errors.Wrapf(err, "get run image label '%s'", label)
+	}
+	if runStackID == "" {
+		e.Logger.Warnf("run image '%s' has no label '%s', cannot check that it matches stack '%s'", runImage.Name(), label, e.StackID)
+		return nil
+	}
+	if runStackID != e.StackID {
+		return fmt.Errorf("run image '%s' has stack ID '%s', but the app was built for stack ID '%s'", runImage.Name(), runStackID, e.StackID)
+	}
+	return nil
+}
+
+func (e *Exporter) checkMixins(runImage image.Image) error {
+	if e.StackID == "" {
+		return nil
+	}
+	label := metadata.LabelName(metadata.MixinsLabel)
+	value, err := runImage.Label(label)
+	if err != nil {
+		return errors.Wrapf(err, "get run image label '%s'", label)
+	}
+	mixins, err := metadata.ParseMixins([]byte(value))
+	if err != nil {
+		return errors.Wrapf(err, "run image label '%s'", label)
+	}
+	var reasons []string
+	for _, bp := range e.Buildpacks {
+		if missing := bp.Stacks.MissingMixins(e.StackID, "run", mixins); len(missing) > 0 {
+			reasons = append(reasons, fmt.Sprintf("buildpack '%s' requires %s", bp.ID, strings.Join(missing, ", ")))
+		}
+	}
+	if len(reasons) > 0 {
+		return fmt.Errorf("run image '%s' is missing mixins: %s", runImage.Name(), strings.Join(reasons, "; "))
+	}
+	return nil
 }
 
-func (e *Exporter) addOrReuseLayer(image image.Image, layer identifiableLayer, previousSha string) (string, error) {
-	tarPath := filepath.Join(e.ArtifactsDir, escapeIdentifier(layer.Identifier())+".tar")
-	sha, err := archive.WriteTarFile(layer.Path(), tarPath, e.UID, e.GID)
+// digestLayers hashes every layer that the export may add, concurrently.
+func (e *Exporter) digestLayers(layersDir, appDir, launcher string) (layerDigests, error) {
+	paths := []string{appDir, filepath.Join(layersDir, "config"), launcher}
+	var modified []bpLayer
+	for _, bp := range e.Buildpacks {
+		bpDir, err := readBuildpackLayersDir(layersDir, *bp)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading layers for buildpack '%s'", bp.ID)
+		}
+		for _, layer := range bpDir.findLayers(launch) {
+			if !layer.hasLocalContents() {
+				continue
+			}
+			if layer.modifiedSinceRestore() {
+				modified = append(modified, layer)
+			}
+			paths = append(paths, layer.Path())
+		}
+	}
+	digests := digestLayers(paths, e.UID, e.GID, e.DiffIDIndex)
+
+	for _, layer := range modified {
+		restored, err := layer.read()
+		if err != nil {
+			continue
+		}
+		digest, err := digests.get(layer.Path(), e.UID, e.GID)
+		if err == nil && digest.SHA != restored.SHA {
+			e.Logger.Warnf("layer '%s' was modified after it was restored, exporting it again", layer.Identifier())
+		}
+	}
+	return digests, nil
+}
+
+func (e *Exporter) addOrReuseLayer(image image.Image, layer identifiableLayer, previousSha string, digests layerDigests) (string, error) {
+	span := e.Tracer.Start("export-layer").SetAttribute("layer", layer.Identifier())
+	defer span.Finish()
+
+	digest, err := digests.get(layer.Path(), e.UID, e.GID)
+	sha, size := digest.SHA, digest.Size
 	if err != nil {
+		span.SetError(err)
 		return "", errors.Wrapf(err, "exporting layer '%s'", layer.Identifier())
 	}
+	span.SetAttribute("sha", sha).SetAttribute("size", size)
+
+	e.Logger.Debugf("layer '%s': %s", layer.Identifier(), reuseReason(sha, previousSha))
 	if sha == previousSha {
-		e.Out.Printf("Reusing layer '%s' with SHA %s\n", layer.Identifier(), sha)
-		return sha, image.ReuseLayer(previousSha)
+		e.Logger.Infof("Reusing layer '%s' with SHA %s\n", layer.Identifier(), sha)
+		span.SetAttribute("reused", true)
+		err = image.ReuseLayer(previousSha)
+	} else {
+		e.Logger.Infof("Exporting layer '%s' with SHA %s\n", layer.Identifier(), sha)
+		span.SetAttribute("reused", false)
+		err = image.AddLayerFromOpener(sha, size, archive.TarOpener(layer.Path(), e.UID, e.GID))
+	}
+	span.SetError(err)
+	if err == nil {
+		eventsOrNop(e.Events).OnLayerExported(LayerEvent{ID: layer.Identifier(), SHA: sha, Size: size, Reused: sha == previousSha})
 	}
-	e.Out.Printf("Exporting layer '%s' with SHA %s\n", layer.Identifier(), sha)
-	return sha, image.AddLayer(tarPath)
+	return sha, err
 }
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -21,6 +20,8 @@ import (
 	"github.com/sclevine/spec/report"
 
 	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/lifecycle/archive"
+	"github.com/buildpack/lifecycle/buildpack"
 	"github.com/buildpack/lifecycle/image"
 	"github.com/buildpack/lifecycle/image/fakes"
 	"github.com/buildpack/lifecycle/metadata"
@@ -69,10 +70,9 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 				{ID: "buildpack.id", Version: "1.2.3"},
 				{ID: "other.buildpack.id", Version: "4.5.6"},
 			},
-			Out: log.New(&stdout, "", 0),
-			Err: log.New(&stderr, "", 0),
-			UID: uid,
-			GID: gid,
+			Logger: lifecycle.NewDefaultLogger(&stdout, &stderr),
+			UID:    uid,
+			GID:    gid,
 		}
 	})
 
@@ -348,6 +348,30 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 				}
 			})
 
+			when("a signer is set", func() {
+				var cosign, argsFile string
+
+				it.Before(func() {
+					argsFile = filepath.Join(filepath.Dir(layersDir), "cosign-args")
+					cosign = filepath.Join(filepath.Dir(layersDir), "cosign")
+					mkfile(t, "#!/bin/sh\necho \"$@\" > "+argsFile+"\n", cosign)
+					h.AssertNil(t, os.Chmod(cosign, 0755))
+					exporter.Signer = &lifecycle.Signer{Cosign: cosign, Key: "some-key"}
+				})
+
+				it("signs the saved image by digest", func() {
+					h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, fakeOriginalImage, launcherPath, stack))
+
+					h.AssertEq(t, rdfile(t, argsFile), "sign --yes --key some-key app/original-Image-Name@saved-digest-from-fake-run-image\n")
+				})
+
+				it("returns an error when signing fails", func() {
+					mkfile(t, "#!/bin/sh\nexit 1\n", cosign)
+					err := exporter.Export(layersDir, appDir, fakeRunImage, fakeOriginalImage, launcherPath, stack)
+					h.AssertError(t, err, "sign image: cosign sign 'app/original-Image-Name@saved-digest-from-fake-run-image'")
+				})
+			})
+
 			when("previous image metadata is missing buildpack for reused layer", func() {
 				it.Before(func() {
 					_ = fakeOriginalImage.SetLabel("io.buildpacks.lifecycle.metadata", `{"buildpacks":[{}]}`)
@@ -400,6 +424,78 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 				nonExistingOriginalImage = mockNonExistingOriginalImage
 			})
 
+			it("reuses digests from the diff ID index for unchanged layers", func() {
+				indexPath := filepath.Join(filepath.Dir(layersDir), "diffid-index.json")
+				index, err := lifecycle.ReadDiffIDIndex(indexPath)
+				h.AssertNil(t, err)
+				exporter.DiffIDIndex = index
+				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+				h.AssertNil(t, index.Save())
+
+				var entries map[string]archive.Digest
+				data, err := ioutil.ReadFile(indexPath)
+				h.AssertNil(t, err)
+				h.AssertNil(t, json.Unmarshal(data, &entries))
+				for key := range entries {
+					if strings.HasPrefix(key, appDir+" ") {
+						entries[key] = archive.Digest{SHA: "sha256:app-from-index", Size: 1}
+					}
+				}
+				data, err = json.Marshal(entries)
+				h.AssertNil(t, err)
+				h.AssertNil(t, ioutil.WriteFile(indexPath, data, 0666))
+
+				exporter.DiffIDIndex, err = lifecycle.ReadDiffIDIndex(indexPath)
+				h.AssertNil(t, err)
+				origImage := fakes.NewImage(t, "app/original-Image-Name", "original-top-layer-sha", "some-original-run-image-digest")
+				defer origImage.Cleanup()
+				h.AssertNil(t, origImage.SetLabel("io.buildpacks.lifecycle.metadata", `{"app": {"sha": "sha256:app-from-index"}}`))
+				runImage := fakes.NewImage(t, "runImageName", "some-top-layer-sha", "some-run-image-digest")
+				defer runImage.Cleanup()
+
+				h.AssertNil(t, exporter.Export(layersDir, appDir, runImage, origImage, launcherPath, stack))
+				h.AssertContains(t, runImage.ReusedLayers(), "sha256:app-from-index")
+			})
+
+			it("does not reuse the digest of restored layers that were modified", func() {
+				indexPath := filepath.Join(filepath.Dir(layersDir), "diffid-index.json")
+				index, err := lifecycle.ReadDiffIDIndex(indexPath)
+				h.AssertNil(t, err)
+				exporter.DiffIDIndex = index
+				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+				h.AssertNil(t, index.Save())
+
+				layerPath := filepath.Join(layersDir, "buildpack.id", "layer1")
+				restoredSHA, _, err := archive.TarDigest(layerPath, uid, gid)
+				h.AssertNil(t, err)
+				h.AssertNil(t, ioutil.WriteFile(layerPath+".sha", []byte(restoredSHA), 0666))
+
+				filePath := filepath.Join(layerPath, "file-from-layer-1")
+				fi, err := os.Stat(filePath)
+				h.AssertNil(t, err)
+				contents, err := ioutil.ReadFile(filePath)
+				h.AssertNil(t, err)
+				h.AssertNil(t, ioutil.WriteFile(filePath, bytes.Repeat([]byte("x"), len(contents)), 0666))
+				h.AssertNil(t, os.Chtimes(filePath, fi.ModTime(), fi.ModTime()))
+
+				exporter.DiffIDIndex, err = lifecycle.ReadDiffIDIndex(indexPath)
+				h.AssertNil(t, err)
+				origImage := fakes.NewImage(t, "app/original-Image-Name", "original-top-layer-sha", "some-original-run-image-digest")
+				defer origImage.Cleanup()
+				h.AssertNil(t, origImage.SetLabel("io.buildpacks.lifecycle.metadata", fmt.Sprintf(`{"buildpacks": [{"key": "buildpack.id", "layers": {"layer1": {"sha": "%s"}}}]}`, restoredSHA)))
+				runImage := fakes.NewImage(t, "runImageName", "some-top-layer-sha", "some-run-image-digest")
+				defer runImage.Cleanup()
+
+				h.AssertNil(t, exporter.Export(layersDir, appDir, runImage, origImage, launcherPath, stack))
+				for _, sha := range runImage.ReusedLayers() {
+					if sha == restoredSHA {
+						t.Fatalf("Expected modified layer not to be reused")
+					}
+				}
+				h.AssertContains(t, strings.Split(stderr.String(), "\n"),
+					"Warning: layer 'buildpack.id:layer1' was modified after it was restored, exporting it again")
+			})
+
 			it("creates app layer on Run image", func() {
 				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
 
@@ -506,6 +602,150 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 				})
 			})
 
+			it("saves the bill of materials to the label and the BOM file", func() {
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(layersDir, "config", "metadata.toml"), []byte(`
+[[bom]]
+name = "some-dep"
+version = "1.2.3"
+buildpack = "buildpack.id"
+[bom.metadata]
+version = "1.2.3"
+`), 0666))
+				exporter.BOMPath = filepath.Join(filepath.Dir(layersDir), "bom.json")
+				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+
+				metadataJSON, err := fakeRunImage.Label("io.buildpacks.lifecycle.metadata")
+				h.AssertNil(t, err)
+				var meta metadata.AppImageMetadata
+				h.AssertNil(t, json.Unmarshal([]byte(metadataJSON), &meta))
+				h.AssertEq(t, meta.BOM, []metadata.BOMEntry{
+					{Name: "some-dep", Version: "1.2.3", Buildpack: "buildpack.id", Metadata: map[string]interface{}{"version": "1.2.3"}},
+				})
+
+				bomJSON, err := ioutil.ReadFile(exporter.BOMPath)
+				h.AssertNil(t, err)
+				var bom struct {
+					BOMFormat  string `json:"bomFormat"`
+					Components []struct {
+						Name    string `json:"name"`
+						Version string `json:"version"`
+					} `json:"components"`
+				}
+				h.AssertNil(t, json.Unmarshal(bomJSON, &bom))
+				h.AssertEq(t, bom.BOMFormat, "CycloneDX")
+				h.AssertEq(t, len(bom.Components), 1)
+				h.AssertEq(t, bom.Components[0].Name, "some-dep")
+				h.AssertEq(t, bom.Components[0].Version, "1.2.3")
+			})
+
+			it("attaches the bill of materials to the saved image", func() {
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(layersDir, "config", "metadata.toml"), []byte(`
+[[bom]]
+name = "some-dep"
+version = "1.2.3"
+buildpack = "buildpack.id"
+`), 0666))
+				attacher := &fakeSBOMAttacher{}
+				exporter.SBOMAttacher = attacher
+				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+
+				h.AssertEq(t, attacher.repoName, "app/original-Image-Name")
+				h.AssertEq(t, attacher.digest, "saved-digest-from-fake-run-image")
+				h.AssertEq(t, attacher.mediaType, "application/vnd.cyclonedx+json")
+				var bom struct {
+					BOMFormat string `json:"bomFormat"`
+				}
+				h.AssertNil(t, json.Unmarshal(attacher.sbom, &bom))
+				h.AssertEq(t, bom.BOMFormat, "CycloneDX")
+				if !strings.Contains(stdout.String(), "*** BOM: some-registry/app:sbom") {
+					t.Fatalf("expected attached BOM to be logged: %s", stdout.String())
+				}
+			})
+
+			it("does not attach an empty bill of materials", func() {
+				attacher := &fakeSBOMAttacher{}
+				exporter.SBOMAttacher = attacher
+				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+
+				h.AssertEq(t, attacher.sbom, []byte(nil))
+			})
+
+			it("labels the image with the build ID", func() {
+				exporter.BuildID = "some-build-id"
+				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+				label, err := fakeRunImage.Label("io.buildpacks.lifecycle.build-id")
+				h.AssertNil(t, err)
+				h.AssertEq(t, label, "some-build-id")
+			})
+
+			it("does not label the image without a build ID", func() {
+				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+				label, err := fakeRunImage.Label("io.buildpacks.lifecycle.build-id")
+				h.AssertNil(t, err)
+				h.AssertEq(t, label, "")
+			})
+
+			it("writes a provenance statement for the saved image", func() {
+				exporter.BuildID = "some-build-id"
+				exporter.Buildpacks[0].Digest = "sha256:some-buildpack-digest"
+				exporter.ProvenancePath = filepath.Join(filepath.Dir(layersDir), "provenance.json")
+				attacher := &fakeProvenanceAttacher{}
+				exporter.ProvenanceAttacher = attacher
+				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+
+				statement, err := ioutil.ReadFile(exporter.ProvenancePath)
+				h.AssertNil(t, err)
+				var provenance struct {
+					PredicateType string `json:"predicateType"`
+					Subject       []struct {
+						Name string `json:"name"`
+					} `json:"subject"`
+					Predicate struct {
+						Invocation struct {
+							Parameters map[string]string `json:"parameters"`
+						} `json:"invocation"`
+						Materials []struct {
+							URI    string            `json:"uri"`
+							Digest map[string]string `json:"digest"`
+						} `json:"materials"`
+					} `json:"predicate"`
+				}
+				h.AssertNil(t, json.Unmarshal(statement, &provenance))
+				h.AssertEq(t, provenance.PredicateType, "https://slsa.dev/provenance/v0.2")
+				h.AssertEq(t, provenance.Subject[0].Name, "app/original-Image-Name")
+				h.AssertEq(t, provenance.Predicate.Invocation.Parameters, map[string]string{
+					"buildID":  "some-build-id",
+					"runImage": "runImageName",
+				})
+				h.AssertEq(t, len(provenance.Predicate.Materials), 3)
+				h.AssertEq(t, provenance.Predicate.Materials[0].URI, "pkg:docker/runImageName")
+				h.AssertEq(t, provenance.Predicate.Materials[1].URI, "urn:cnb:buildpack:buildpack.id@1.2.3")
+				h.AssertEq(t, provenance.Predicate.Materials[1].Digest, map[string]string{"sha256": "some-buildpack-digest"})
+				h.AssertEq(t, provenance.Predicate.Materials[2].URI, "urn:cnb:buildpack:other.buildpack.id@4.5.6")
+
+				h.AssertEq(t, attacher.repoName, "app/original-Image-Name")
+				h.AssertEq(t, attacher.digest, "saved-digest-from-fake-run-image")
+				h.AssertEq(t, attacher.mediaType, "application/vnd.in-toto+json")
+				h.AssertEq(t, string(attacher.statement), strings.TrimSuffix(string(statement), "\n"))
+				if !strings.Contains(stdout.String(), "*** Provenance: some-registry/app:att") {
+					t.Fatalf("expected attached provenance to be logged: %s", stdout.String())
+				}
+			})
+
+			it("adds the saved image to the index", func() {
+				writer := &fakeIndexWriter{}
+				exporter.Index = "app/original-Image-Name:latest"
+				exporter.IndexWriter = writer
+				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+
+				h.AssertEq(t, writer.indexName, "app/original-Image-Name:latest")
+				h.AssertEq(t, writer.repoName, "app/original-Image-Name")
+				h.AssertEq(t, writer.digest, "saved-digest-from-fake-run-image")
+				if !strings.Contains(stdout.String(), "*** Index: app/original-Image-Name:latest@sha256:some-index-digest") {
+					t.Fatalf("expected index to be logged: %s", stdout.String())
+				}
+			})
+
 			it("sets CNB_LAYERS_DIR", func() {
 				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
 
@@ -538,6 +778,51 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, val, []string(nil))
 			})
 
+			when("the run image in the registry is set", func() {
+				var registryRunImage *fakes.Image
+
+				it.After(func() {
+					registryRunImage.Cleanup()
+				})
+
+				it("warns when the run image is outdated", func() {
+					registryRunImage = fakes.NewImage(t, "runImageName", "some-top-layer-sha", "other-run-image-digest")
+					exporter.RegistryRunImage = registryRunImage
+					h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+
+					h.AssertContains(t, strings.Split(stderr.String(), "\n"),
+						"Warning: run image 'runImageName' is outdated, using some-run-image-digest but the registry has other-run-image-digest, rebase the app image to update it",
+					)
+				})
+
+				it("does not warn when the run image is current", func() {
+					registryRunImage = fakes.NewImage(t, "runImageName", "some-top-layer-sha", "some-run-image-digest")
+					exporter.RegistryRunImage = registryRunImage
+					h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+
+					h.AssertEq(t, stderr.String(), "")
+				})
+			})
+
+			it("retains the run image USER", func() {
+				h.AssertNil(t, fakeRunImage.SetUser("1234:4321"))
+				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+
+				val, err := fakeRunImage.User()
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, "1234:4321")
+			})
+
+			it("sets USER when a user is provided", func() {
+				h.AssertNil(t, fakeRunImage.SetUser("1000:1000"))
+				exporter.User = "1234:4321"
+				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+
+				val, err := fakeRunImage.User()
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, "1234:4321")
+			})
+
 			it("sets name to match original image", func() {
 				h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
 
@@ -549,6 +834,61 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 
 				h.AssertEq(t, fakeRunImage.IsSaved(), true)
 			})
+
+			when("the stack ID is set", func() {
+				it.Before(func() {
+					exporter.StackID = "some-stack"
+				})
+
+				it("exports when the run image has the same stack ID", func() {
+					h.AssertNil(t, fakeRunImage.SetLabel("io.buildpacks.stack.id", "some-stack"))
+					h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+
+					h.AssertEq(t, fakeRunImage.IsSaved(), true)
+				})
+
+				it("returns an error when the run image is missing mixins required by buildpacks", func() {
+					exporter.Buildpacks[0].Stacks = buildpack.Stacks{{ID: "some-stack", Mixins: []string{"some-mixin", "run:run-mixin", "build:build-mixin"}}}
+					exporter.Buildpacks[1].Stacks = buildpack.Stacks{{ID: "some-stack", Mixins: []string{"some-mixin"}}}
+					h.AssertNil(t, fakeRunImage.SetLabel("io.buildpacks.stack.mixins", `["some-mixin"]`))
+					h.AssertError(t,
+						exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack),
+						"run image 'runImageName' is missing mixins: buildpack 'buildpack.id' requires run:run-mixin",
+					)
+				})
+
+				it("returns an error when the run image has a different stack ID", func() {
+					h.AssertNil(t, fakeRunImage.SetLabel("io.buildpacks.stack.id", "other-stack"))
+					h.AssertError(t,
+						exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack),
+						"run image 'runImageName' has stack ID 'other-stack', but the app was built for stack ID 'some-stack'",
+					)
+					h.AssertEq(t, fakeRunImage.IsSaved(), false)
+				})
+			})
+
+			when("the run image has stack deprecation labels", func() {
+				it("warns when the stack is deprecated or will reach end of life", func() {
+					h.AssertNil(t, fakeRunImage.SetLabel("io.buildpacks.stack.deprecated", "2001-02-03"))
+					h.AssertNil(t, fakeRunImage.SetLabel("io.buildpacks.stack.eol", "2999-01-01"))
+					h.AssertNil(t, exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack))
+
+					warnings := strings.Split(stderr.String(), "\n")
+					h.AssertContains(t, warnings, "Warning: run image 'runImageName' will reach end of life on 2999-01-01")
+					h.AssertContains(t, warnings, "Warning: run image 'runImageName' is deprecated since 2001-02-03, move to a supported stack")
+				})
+
+				it("returns an error when failing on deprecated stacks", func() {
+					h.AssertNil(t, fakeRunImage.SetLabel("io.buildpacks.stack.deprecated", "true"))
+					h.AssertNil(t, fakeRunImage.SetLabel("io.buildpacks.stack.eol", "2001-02-03T00:00:00Z"))
+					exporter.FailDeprecatedStack = true
+					h.AssertError(t,
+						exporter.Export(layersDir, appDir, fakeRunImage, nonExistingOriginalImage, launcherPath, stack),
+						"run image 'runImageName' is deprecated and reached end of life on 2001-02-03T00:00:00Z, move to a supported stack",
+					)
+					h.AssertEq(t, fakeRunImage.IsSaved(), false)
+				})
+			})
 		})
 
 		when("buildpack requires an escaped id", func() {
@@ -676,6 +1016,35 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 	})
 }
 
+type fakeSBOMAttacher struct {
+	repoName, digest, mediaType string
+	sbom                        []byte
+}
+
+func (f *fakeSBOMAttacher) AttachSBOM(repoName, digest, mediaType string, sbom []byte) (string, error) {
+	f.repoName, f.digest, f.mediaType, f.sbom = repoName, digest, mediaType, sbom
+	return "some-registry/app:sbom", nil
+}
+
+type fakeProvenanceAttacher struct {
+	repoName, digest, mediaType string
+	statement                   []byte
+}
+
+func (f *fakeProvenanceAttacher) AttachProvenance(repoName, digest, mediaType string, statement []byte) (string, error) {
+	f.repoName, f.digest, f.mediaType, f.statement = repoName, digest, mediaType, statement
+	return "some-registry/app:att", nil
+}
+
+type fakeIndexWriter struct {
+	indexName, repoName, digest string
+}
+
+func (f *fakeIndexWriter) AddToIndex(indexName, repoName, digest string) (string, error) {
+	f.indexName, f.repoName, f.digest = indexName, repoName, digest
+	return "sha256:some-index-digest", nil
+}
+
 func assertAddLayerLog(t *testing.T, stdout bytes.Buffer, name, layerPath string) {
 	t.Helper()
 	layerSHA := h.ComputeSHA256ForFile(t, layerPath)
@@ -0,0 +1,157 @@
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/telemetry"
+)
+
+// Extender applies the Dockerfiles written by extensions during detect.
+// Build Dockerfiles are run in the current container, which should be the
+// build image running as root, before the builder runs in it. Run
+// Dockerfiles may only select a different run image.
+type Extender struct {
+	GeneratedDir string
+	Extensions   []*Buildpack
+	Out, Err     io.Writer
+	Tracer       *telemetry.Tracer
+	Context      context.Context // build Dockerfile commands are stopped when it is done
+}
+
+func (e *Extender) ExtendBuild() error {
+	env := map[string]string{}
+	for _, ext := range e.Extensions {
+		path := filepath.Join(extensionOutputDir(e.GeneratedDir, ext), "build.Dockerfile")
+		instructions, err := parseDockerfile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "read build Dockerfile for extension '%s'", ext.ID)
+		}
+		span := e.Tracer.Start("extend").SetAttribute("extension.id", ext.ID).SetAttribute("extension.version", ext.Version)
+		err = e.applyBuild(path, instructions, env)
+		span.SetError(err)
+		span.Finish()
+		if err != nil {
+			return err
+		}
+	}
+
+	envDir := filepath.Join(e.GeneratedDir, "env.build")
+	if err := os.MkdirAll(envDir, 0777); err != nil {
+		return err
+	}
+	for name, value := range env {
+		if err := ioutil.WriteFile(filepath.Join(envDir, name+".override"), []byte(value), 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Extender) applyBuild(path string, instructions []instruction, env map[string]string) error {
+	args := map[string]string{"base_image": ""}
+	lookup := func(name string) string {
+		if v, ok := env[name]; ok {
+			return v
+		}
+		if v, ok := args[name]; ok {
+			return v
+		}
+		return os.Getenv(name)
+	}
+	for _, ins := range instructions {
+		switch ins.Cmd {
+		case "ARG":
+			for _, kv := range parseKeyValues(ins.Args) {
+				if _, ok := args[kv[0]]; !ok {
+					args[kv[0]] = os.Expand(kv[1], lookup)
+				}
+			}
+		case "FROM":
+			if ins.Args != "${base_image}" && ins.Args != "$base_image" {
+				return fmt.Errorf("%s:%d: build Dockerfiles must use FROM ${base_image}", path, ins.Line)
+			}
+		case "ENV":
+			for _, kv := range parseKeyValues(ins.Args) {
+				env[kv[0]] = os.Expand(kv[1], lookup)
+			}
+		case "RUN":
+			cmd, err := runCommand(e.Context, ins.Args)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %s", path, ins.Line, err)
+			}
+			cmd.Env = os.Environ()
+			for k, v := range args {
+				cmd.Env = append(cmd.Env, k+"="+v)
+			}
+			for k, v := range env {
+				cmd.Env = append(cmd.Env, k+"="+v)
+			}
+			cmd.Dir = "/"
+			cmd.Stdout = e.Out
+			cmd.Stderr = e.Err
+			if err := cmd.Run(); err != nil {
+				return errors.Wrapf(err, "%s:%d: run '%s'", path, ins.Line, ins.Args)
+			}
+		default:
+			return fmt.Errorf("%s:%d: unsupported instruction '%s' in build Dockerfile", path, ins.Line, ins.Cmd)
+		}
+	}
+	return nil
+}
+
+func runCommand(ctx context.Context, args string) (*exec.Cmd, error) {
+	if !strings.HasPrefix(args, "[") {
+		return command(ctx, "/bin/sh", "-c", args), nil
+	}
+	var argv []string
+	if err := json.Unmarshal([]byte(args), &argv); err != nil || len(argv) == 0 {
+		return nil, fmt.Errorf("invalid exec form '%s'", args)
+	}
+	return command(ctx, argv[0], argv[1:]...), nil
+}
+
+// RunImage returns the run image selected by the extensions' run
+// Dockerfiles, starting from baseImage. It returns false if no extension
+// wrote a run Dockerfile.
+func (e *Extender) RunImage(baseImage string) (string, bool, error) {
+	image, found := baseImage, false
+	for _, ext := range e.Extensions {
+		path := filepath.Join(extensionOutputDir(e.GeneratedDir, ext), "run.Dockerfile")
+		instructions, err := parseDockerfile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", false, errors.Wrapf(err, "read run Dockerfile for extension '%s'", ext.ID)
+		}
+		found = true
+		args := map[string]string{"base_image": image}
+		lookup := func(name string) string { return args[name] }
+		for _, ins := range instructions {
+			switch ins.Cmd {
+			case "ARG":
+				for _, kv := range parseKeyValues(ins.Args) {
+					if _, ok := args[kv[0]]; !ok {
+						args[kv[0]] = os.Expand(kv[1], lookup)
+					}
+				}
+			case "FROM":
+				image = os.Expand(ins.Args, lookup)
+			default:
+				return "", false, fmt.Errorf("%s:%d: run Dockerfiles may only contain ARG and FROM, found '%s'", path, ins.Line, ins.Cmd)
+			}
+		}
+	}
+	return image, found, nil
+}
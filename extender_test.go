@@ -0,0 +1,113 @@
+package lifecycle_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestExtender(t *testing.T) {
+	spec.Run(t, "Extender", testExtender, spec.Report(report.Terminal{}))
+}
+
+func testExtender(t *testing.T, when spec.G, it spec.S) {
+	var (
+		extender     *lifecycle.Extender
+		tmpDir       string
+		generatedDir string
+		stdout       *bytes.Buffer
+	)
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle")
+		h.AssertNil(t, err)
+		generatedDir = filepath.Join(tmpDir, "generated")
+		stdout = &bytes.Buffer{}
+		extender = &lifecycle.Extender{
+			GeneratedDir: generatedDir,
+			Extensions: []*lifecycle.Buildpack{
+				{ID: "ext/1", Extension: true},
+				{ID: "ext2", Extension: true},
+			},
+			Out: stdout,
+			Err: stdout,
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	writeDockerfile := func(id, name, contents string) {
+		dir := filepath.Join(generatedDir, "extensions", id)
+		mkdir(t, dir)
+		mkfile(t, contents, filepath.Join(dir, name))
+	}
+
+	when("#ExtendBuild", func() {
+		it("runs the build Dockerfiles and writes their environment", func() {
+			writeDockerfile("ext_1", "build.Dockerfile", `
+# some comment
+ARG base_image
+FROM ${base_image}
+ARG out=`+tmpDir+`/out
+ENV SOME_VAR="some value" \
+    OTHER_VAR=other
+run echo "$SOME_VAR" > $out
+`)
+			writeDockerfile("ext2", "build.Dockerfile", `FROM $base_image
+RUN ["/bin/sh", "-c", "echo $OTHER_VAR >> `+tmpDir+`/out"]
+`)
+			h.AssertNil(t, extender.ExtendBuild())
+			h.AssertEq(t, rdfile(t, filepath.Join(tmpDir, "out")), "some value\nother\n")
+			h.AssertEq(t, rdfile(t, filepath.Join(generatedDir, "env.build", "SOME_VAR.override")), "some value")
+			h.AssertEq(t, rdfile(t, filepath.Join(generatedDir, "env.build", "OTHER_VAR.override")), "other")
+		})
+
+		it("fails for a base image other than the build image", func() {
+			writeDockerfile("ext2", "build.Dockerfile", "FROM some-image\n")
+			path := filepath.Join(generatedDir, "extensions", "ext2", "build.Dockerfile")
+			h.AssertError(t, extender.ExtendBuild(), path+":1: build Dockerfiles must use FROM ${base_image}")
+		})
+
+		it("fails for unsupported instructions", func() {
+			writeDockerfile("ext2", "build.Dockerfile", "FROM ${base_image}\nCOPY . /\n")
+			path := filepath.Join(generatedDir, "extensions", "ext2", "build.Dockerfile")
+			h.AssertError(t, extender.ExtendBuild(), path+":2: unsupported instruction 'COPY' in build Dockerfile")
+		})
+	})
+
+	when("#RunImage", func() {
+		it("returns the base image when there are no run Dockerfiles", func() {
+			image, found, err := extender.RunImage("some-run-image")
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, false)
+			h.AssertEq(t, image, "some-run-image")
+		})
+
+		it("selects the image from the last run Dockerfile", func() {
+			writeDockerfile("ext_1", "run.Dockerfile", "FROM other-run-image\n")
+			writeDockerfile("ext2", "run.Dockerfile", "ARG base_image\nARG tag=full\nFROM ${base_image}-${tag}\n")
+			image, found, err := extender.RunImage("some-run-image")
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, true)
+			h.AssertEq(t, image, "other-run-image-full")
+		})
+
+		it("fails for instructions other than ARG and FROM", func() {
+			writeDockerfile("ext2", "run.Dockerfile", "FROM some-image\nRUN true\n")
+			path := filepath.Join(generatedDir, "extensions", "ext2", "run.Dockerfile")
+			_, _, err := extender.RunImage("some-run-image")
+			h.AssertError(t, err, path+":2: run Dockerfiles may only contain ARG and FROM, found 'RUN'")
+		})
+	})
+}
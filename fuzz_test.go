@@ -0,0 +1,51 @@
+package lifecycle_test
+
+import (
+	"testing"
+
+	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/lifecycle/metadata"
+)
+
+// The fuzz targets decode the files that buildpacks and platforms write. They
+// only check that malformed files fail to decode instead of panicking.
+
+func decodeTargets() []interface{} {
+	return []interface{}{
+		&metadata.LayerMetadata{},
+		&lifecycle.LaunchTOML{},
+		&lifecycle.BuildMetadata{},
+		&lifecycle.BuildpackGroup{},
+		&lifecycle.Plan{},
+	}
+}
+
+func FuzzDecodeTOML(f *testing.F) {
+	f.Add([]byte("launch = true\nbuild = false\n[metadata]\nsome-key = \"some-value\"\n"))
+	f.Add([]byte("[[processes]]\ntype = \"web\"\ncommand = \"some-command\"\nargs = [\"some-arg\"]\n"))
+	f.Add([]byte("[[buildpacks]]\nid = \"some/buildpack\"\nversion = \"1.2.3\"\n"))
+	f.Add([]byte("[[bom]]\nname = \"some-dep\"\n[bom.metadata]\nversion = \"1.2.3\"\n"))
+	f.Add([]byte("[some-dep]\nversion = \"1.2.3\"\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, v := range decodeTargets() {
+			if lifecycle.DecodeTOML("fuzz.toml", data, v, "metadata", "bom") != nil {
+				continue
+			}
+			if md, ok := v.(*metadata.LayerMetadata); ok {
+				md.Validate()
+			}
+		}
+		lifecycle.ParsePlan(data)
+	})
+}
+
+func FuzzDecodeJSON(f *testing.F) {
+	f.Add([]byte(`{"launch": true, "metadata": {"some-key": "some-value"}}`))
+	f.Add([]byte(`{"processes": [{"type": "web", "command": "some-command", "args": ["some-arg"]}]}`))
+	f.Add([]byte(`{"buildpacks": [{"id": "some/buildpack", "version": "1.2.3"}]}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, v := range decodeTargets() {
+			lifecycle.DecodeJSON("fuzz.json", data, v)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/buildpack/lifecycle/telemetry"
+)
+
+// Generator runs the generate executable of each detected extension, which
+// may write a build.Dockerfile and a run.Dockerfile to its output directory.
+type Generator struct {
+	AppDir       string
+	PlatformDir  string
+	GeneratedDir string
+	Extensions   []*Buildpack
+	Plan         Plan
+	Out, Err     io.Writer
+	Tracer       *telemetry.Tracer
+	Events       Events
+	LogPrefix    string
+	Context      context.Context // extensions are stopped when it is done
+}
+
+func (g *Generator) Generate() error {
+	platformDir, err := filepath.Abs(g.PlatformDir)
+	if err != nil {
+		return err
+	}
+	appDir, err := filepath.Abs(g.AppDir)
+	if err != nil {
+		return err
+	}
+	generatedDir, err := filepath.Abs(g.GeneratedDir)
+	if err != nil {
+		return err
+	}
+	for _, ext := range g.Extensions {
+		outputDir := extensionOutputDir(generatedDir, ext)
+		if err := os.RemoveAll(outputDir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(outputDir, 0777); err != nil {
+			return err
+		}
+		planIn := &bytes.Buffer{}
+		if err := toml.NewEncoder(planIn).Encode(g.Plan); err != nil {
+			return err
+		}
+		generatePath, err := filepath.Abs(filepath.Join(ext.Dir, "bin", "generate"))
+		if err != nil {
+			return err
+		}
+		cmd := command(g.Context, generatePath, outputDir, platformDir)
+		cmd.Dir = appDir
+		cmd.Stdin = planIn
+		cmd.Stdout = prefixLines(g.Out, ext.LogPrefix(g.LogPrefix))
+		cmd.Stderr = prefixLines(g.Err, ext.LogPrefix(g.LogPrefix))
+		span := g.Tracer.Start("generate").SetAttribute("buildpack.id", ext.ID).SetAttribute("buildpack.version", ext.Version)
+		events := eventsOrNop(g.Events)
+		events.OnBuildpackStarted(BuildpackEvent{Phase: "generate", Buildpack: *ext})
+		start := time.Now()
+		err = cmd.Run()
+		events.OnBuildpackFinished(BuildpackEvent{Phase: "generate", Buildpack: *ext, Duration: time.Since(start), Err: err})
+		span.SetError(err)
+		span.Finish()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extensionOutputDir(generatedDir string, ext *Buildpack) string {
+	return filepath.Join(generatedDir, "extensions", ext.EscapedID())
+}
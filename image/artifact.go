@@ -0,0 +1,167 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	v1remote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/image/auth"
+	"github.com/buildpack/lifecycle/progress"
+)
+
+// AttachSBOM pushes sbom as an artifact tagged after the image's digest,
+// following the tag scheme used by cosign for registries without the OCI
+// referrers API, and returns the artifact's reference.
+func (f *Factory) AttachSBOM(repoName, digest, mediaType string, sbom []byte) (string, error) {
+	return f.attach(repoName, digest, "sbom", mediaType, sbom)
+}
+
+// AttachProvenance pushes a provenance statement as an attestation artifact
+// tagged after the image's digest, and returns the artifact's reference.
+func (f *Factory) AttachProvenance(repoName, digest, mediaType string, statement []byte) (string, error) {
+	return f.attach(repoName, digest, "att", mediaType, statement)
+}
+
+func (f *Factory) attach(repoName, digest, suffix, mediaType string, data []byte) (string, error) {
+	ref, authenticator, err := auth.ReferenceForRepoName(f.pushKeychain(), repoName)
+	if err != nil {
+		return "", err
+	}
+	tag, err := name.NewTag(ref.Context().Name()+":"+strings.Replace(digest, ":", "-", 1)+"."+suffix, name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+
+	artifact, err := newArtifact(types.MediaType(mediaType), data)
+	if err != nil {
+		return "", err
+	}
+	transport := &registryTransport{ctx: f.context(), base: http.DefaultTransport, progress: progress.NewTracker(f.Out)}
+	if err := v1remote.Write(tag, artifact, authenticator, transport); err != nil {
+		if authErr := authError(err, f.pushKeychain(), repoName, "push"); authErr != nil {
+			return "", authErr
+		}
+		return "", errors.Wrapf(err, "push '%s'", tag)
+	}
+	return tag.String(), nil
+}
+
+// artifact is an OCI manifest with a single blob layer of any media type.
+type artifact struct {
+	manifest []byte
+	config   []byte
+	blob     []byte
+	digest   v1.Hash
+}
+
+func newArtifact(mediaType types.MediaType, blob []byte) (v1.Image, error) {
+	digest, size, err := v1.SHA256(bytes.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	config := []byte(fmt.Sprintf(`{"architecture":"","os":"","config":{},"rootfs":{"type":"layers","diff_ids":["%s"]}}`, digest))
+	configDigest, configSize, err := v1.SHA256(bytes.NewReader(config))
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := json.Marshal(&v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config:        v1.Descriptor{MediaType: types.OCIConfigJSON, Size: configSize, Digest: configDigest},
+		Layers:        []v1.Descriptor{{MediaType: mediaType, Size: size, Digest: digest}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return partial.CompressedToImage(&artifact{manifest: manifest, config: config, blob: blob, digest: digest})
+}
+
+func (a *artifact) MediaType() (types.MediaType, error) { return types.OCIManifestSchema1, nil }
+func (a *artifact) RawManifest() ([]byte, error)        { return a.manifest, nil }
+func (a *artifact) RawConfigFile() ([]byte, error)      { return a.config, nil }
+
+func (a *artifact) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	if h != a.digest {
+		return nil, fmt.Errorf("artifact has no blob with digest '%s'", h)
+	}
+	return &artifactBlob{digest: a.digest, blob: a.blob}, nil
+}
+
+type artifactBlob struct {
+	digest v1.Hash
+	blob   []byte
+}
+
+func (b *artifactBlob) Digest() (v1.Hash, error) { return b.digest, nil }
+func (b *artifactBlob) Size() (int64, error)     { return int64(len(b.blob)), nil }
+func (b *artifactBlob) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(b.blob)), nil
+}
+
+// ArtifactType is the media types of the config and layers of images that
+// are pushed as OCI artifacts instead of runnable images.
+type ArtifactType struct {
+	Config string
+	Layer  string
+}
+
+// WithArtifactType pushes the factory's remote images to registries as OCI
+// artifacts of the given type.
+func WithArtifactType(artifactType ArtifactType) func(factory *Factory) {
+	return func(factory *Factory) {
+		factory.ArtifactType = &artifactType
+	}
+}
+
+// artifactImage presents an image with an OCI manifest whose config and
+// layers have the media types of an artifact type.
+type artifactImage struct {
+	v1.Image
+	artifactType ArtifactType
+}
+
+func (i artifactImage) MediaType() (types.MediaType, error) { return types.OCIManifestSchema1, nil }
+
+func (i artifactImage) Manifest() (*v1.Manifest, error) {
+	manifest, err := i.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	artifact := *manifest
+	artifact.MediaType = types.OCIManifestSchema1
+	artifact.Config.MediaType = types.MediaType(i.artifactType.Config)
+	artifact.Layers = make([]v1.Descriptor, len(manifest.Layers))
+	for j, desc := range manifest.Layers {
+		desc.MediaType = types.MediaType(i.artifactType.Layer)
+		artifact.Layers[j] = desc
+	}
+	return &artifact, nil
+}
+
+func (i artifactImage) RawManifest() ([]byte, error) {
+	manifest, err := i.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(manifest)
+}
+
+func (i artifactImage) Digest() (v1.Hash, error) {
+	manifest, err := i.RawManifest()
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	digest, _, err := v1.SHA256(bytes.NewReader(manifest))
+	return digest, err
+}
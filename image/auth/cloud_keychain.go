@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+)
+
+var (
+	ecrRegistry = regexp.MustCompile(`^(\d{12})\.dkr\.ecr(-fips)?\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+	gcrRegistry = regexp.MustCompile(`^([a-z]+\.)?gcr\.io$|^[a-z0-9-]+-docker\.pkg\.dev$`)
+	acrRegistry = regexp.MustCompile(`^[a-z0-9]+\.azurecr\.(io|cn|us|de)$`)
+)
+
+// acrUsername is the username ACR expects with a refresh token.
+const acrUsername = "00000000-0000-0000-0000-000000000000"
+
+// CloudKeychain resolves short-lived credentials for Amazon ECR, Google
+// Container Registry and Artifact Registry, and Azure Container Registry
+// from the identity of the instance or workload the lifecycle runs as, so
+// that no docker config or credential helper binary is needed. Other
+// registries resolve to anonymous.
+type CloudKeychain struct {
+	Client *http.Client // defaults to a client with a 30 second timeout
+}
+
+func (k *CloudKeychain) Resolve(registry name.Registry) (authn.Authenticator, error) {
+	host := strings.ToLower(registry.RegistryStr())
+	var fetch func() (string, error)
+	if m := ecrRegistry.FindStringSubmatch(host); m != nil {
+		fetch = func() (string, error) { return k.ecrAuth(m[1], m[3], m[4]) }
+	} else if gcrRegistry.MatchString(host) {
+		fetch = k.gcrAuth
+	} else if acrRegistry.MatchString(host) {
+		fetch = func() (string, error) { return k.acrAuth(host) }
+	} else {
+		return authn.Anonymous, nil
+	}
+	return &cloudAuth{registry: host, fetch: fetch}, nil
+}
+
+func (k *CloudKeychain) String() string {
+	return "cloud identity"
+}
+
+// cloudAuth fetches its credentials once, when they are first used.
+type cloudAuth struct {
+	registry string
+	fetch    func() (string, error)
+	once     sync.Once
+	auth     string
+	err      error
+}
+
+func (c *cloudAuth) Authorization() (string, error) {
+	c.once.Do(func() {
+		c.auth, c.err = c.fetch()
+		c.err = errors.Wrapf(c.err, "get credentials for '%s'", c.registry)
+	})
+	return c.auth, c.err
+}
+
+func (k *CloudKeychain) client() *http.Client {
+	if k.Client == nil {
+		return &http.Client{Timeout: 30 * time.Second}
+	}
+	return k.Client
+}
+
+// do sends req and decodes the JSON or XML response into v.
+func (k *CloudKeychain) do(req *http.Request, v interface{}) error {
+	resp, err := k.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "xml") {
+		return xml.Unmarshal(body, v)
+	}
+	return json.Unmarshal(body, v)
+}
+
+func basicAuth(username, password string) (string, error) {
+	return (&authn.Basic{Username: username, Password: password}).Authorization()
+}
+
+// gcrAuth uses an access token for the default service account from the GCE
+// metadata server, which GKE workload identity also provides.
+func (k *CloudKeychain) gcrAuth() (string, error) {
+	req, err := http.NewRequest("GET", "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := k.do(req, &token); err != nil {
+		return "", err
+	}
+	return basicAuth("oauth2accesstoken", token.AccessToken)
+}
+
+// acrAuth exchanges an Azure AD token, from AKS workload identity or the
+// instance metadata service, for an ACR refresh token.
+func (k *CloudKeychain) acrAuth(registry string) (string, error) {
+	aadToken, err := k.azureToken()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"access_token": {aadToken},
+	}
+	if tenant := os.Getenv("AZURE_TENANT_ID"); tenant != "" {
+		form.Set("tenant", tenant)
+	}
+	req, err := http.NewRequest("POST", "https://"+registry+"/oauth2/exchange", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := k.do(req, &token); err != nil {
+		return "", err
+	}
+	return basicAuth(acrUsername, token.RefreshToken)
+}
+
+func (k *CloudKeychain) azureToken() (string, error) {
+	const resource = "https://management.azure.com/"
+	var req *http.Request
+	if tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); tokenFile != "" {
+		assertion, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return "", err
+		}
+		authority := os.Getenv("AZURE_AUTHORITY_HOST")
+		if authority == "" {
+			authority = "https://login.microsoftonline.com/"
+		}
+		form := url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_id":             {os.Getenv("AZURE_CLIENT_ID")},
+			"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+			"client_assertion":      {strings.TrimSpace(string(assertion))},
+			"scope":                 {resource + ".default"},
+		}
+		tokenURL := strings.TrimSuffix(authority, "/") + "/" + os.Getenv("AZURE_TENANT_ID") + "/oauth2/v2.0/token"
+		if req, err = http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode())); err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := url.Values{"api-version": {"2018-02-01"}, "resource": {resource}}
+		if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+			query.Set("client_id", clientID)
+		}
+		var err error
+		if req, err = http.NewRequest("GET", "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil); err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata", "true")
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := k.do(req, &token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"Token"`
+}
+
+// ecrAuth signs a GetAuthorizationToken request with the credentials of the
+// environment, EKS web identity, ECS task role or EC2 instance profile.
+func (k *CloudKeychain) ecrAuth(account, region, suffix string) (string, error) {
+	creds, err := k.awsCredentials(region, suffix)
+	if err != nil {
+		return "", err
+	}
+	body := fmt.Sprintf(`{"registryIds":["%s"]}`, account)
+	req, err := http.NewRequest("POST", "https://api.ecr."+region+".amazonaws.com"+suffix+"/", strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken")
+	signAWS(req, []byte(body), creds, region, "ecr", time.Now())
+	var token struct {
+		AuthorizationData []struct {
+			AuthorizationToken string `json:"authorizationToken"`
+		} `json:"authorizationData"`
+	}
+	if err := k.do(req, &token); err != nil {
+		return "", err
+	}
+	if len(token.AuthorizationData) == 0 {
+		return "", errors.New("no authorization data in ECR response")
+	}
+	// the token is already the base64 encoded username and password
+	return "Basic " + token.AuthorizationData[0].AuthorizationToken, nil
+}
+
+func (k *CloudKeychain) awsCredentials(region, suffix string) (awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		return k.awsWebIdentityCredentials(tokenFile, region, suffix)
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		var creds awsCredentials
+		req, err := http.NewRequest("GET", "http://169.254.170.2"+uri, nil)
+		if err != nil {
+			return creds, err
+		}
+		return creds, k.do(req, &creds)
+	}
+	return k.awsInstanceCredentials()
+}
+
+func (k *CloudKeychain) awsWebIdentityCredentials(tokenFile, region, suffix string) (awsCredentials, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {os.Getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {"lifecycle"},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequest("GET", "https://sts."+region+".amazonaws.com"+suffix+"/?"+query.Encode(), nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var resp struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := k.do(req, &resp); err != nil {
+		return awsCredentials{}, err
+	}
+	return awsCredentials(resp.Credentials), nil
+}
+
+// awsInstanceCredentials reads the instance profile credentials with IMDSv2.
+func (k *CloudKeychain) awsInstanceCredentials() (awsCredentials, error) {
+	const imds = "http://169.254.169.254/latest"
+	var creds awsCredentials
+	req, err := http.NewRequest("PUT", imds+"/api/token", nil)
+	if err != nil {
+		return creds, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := k.text(req)
+	if err != nil {
+		return creds, err
+	}
+	if req, err = http.NewRequest("GET", imds+"/meta-data/iam/security-credentials/", nil); err != nil {
+		return creds, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	role, err := k.text(req)
+	if err != nil {
+		return creds, err
+	}
+	if req, err = http.NewRequest("GET", imds+"/meta-data/iam/security-credentials/"+strings.Fields(role)[0], nil); err != nil {
+		return creds, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	return creds, k.do(req, &creds)
+}
+
+func (k *CloudKeychain) text(req *http.Request) (string, error) {
+	resp, err := k.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || len(strings.TrimSpace(string(body))) == 0 {
+		return "", fmt.Errorf("%s %s: %s", req.Method, req.URL.Host, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// signAWS adds an AWS Signature Version 4 to req.
+func signAWS(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,177 @@
+package auth_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/image/auth"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestCloudKeychain(t *testing.T) {
+	spec.Run(t, "Cloud Keychain", testCloudKeychain, spec.Sequential(), spec.Report(report.Terminal{}))
+}
+
+// handlerTransport serves every request with a handler instead of the network.
+type handlerTransport struct {
+	handler  http.HandlerFunc
+	requests []*http.Request
+}
+
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req)
+	rec := httptest.NewRecorder()
+	t.handler(rec, req)
+	return rec.Result(), nil
+}
+
+func testCloudKeychain(t *testing.T, when spec.G, it spec.S) {
+	var (
+		transport *handlerTransport
+		keychain  *auth.CloudKeychain
+	)
+
+	it.Before(func() {
+		transport = &handlerTransport{}
+		keychain = &auth.CloudKeychain{Client: &http.Client{Transport: transport}}
+		for _, k := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AZURE_FEDERATED_TOKEN_FILE", "AZURE_CLIENT_ID", "AZURE_TENANT_ID"} {
+			h.AssertNil(t, os.Unsetenv(k))
+		}
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.Unsetenv("AWS_ACCESS_KEY_ID"))
+		h.AssertNil(t, os.Unsetenv("AWS_SECRET_ACCESS_KEY"))
+		h.AssertNil(t, os.Unsetenv("AWS_SESSION_TOKEN"))
+	})
+
+	resolve := func(registry string) authn.Authenticator {
+		t.Helper()
+		reg, err := name.NewRegistry(registry, name.WeakValidation)
+		h.AssertNil(t, err)
+		authenticator, err := keychain.Resolve(reg)
+		h.AssertNil(t, err)
+		return authenticator
+	}
+
+	basic := func(username, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+
+	it("resolves other registries to anonymous", func() {
+		h.AssertEq(t, resolve("index.docker.io"), authn.Anonymous)
+		h.AssertEq(t, len(transport.requests), 0)
+	})
+
+	when("the registry is GCR or Artifact Registry", func() {
+		it("uses an access token from the metadata server", func() {
+			transport.handler = func(w http.ResponseWriter, r *http.Request) {
+				h.AssertEq(t, r.URL.String(), "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token")
+				h.AssertEq(t, r.Header.Get("Metadata-Flavor"), "Google")
+				fmt.Fprint(w, `{"access_token":"some-gcp-token"}`)
+			}
+
+			for _, registry := range []string{"gcr.io", "us.gcr.io", "europe-west1-docker.pkg.dev"} {
+				header, err := resolve(registry).Authorization()
+				h.AssertNil(t, err)
+				h.AssertEq(t, header, basic("oauth2accesstoken", "some-gcp-token"))
+			}
+		})
+
+		it("fails when the metadata server fails", func() {
+			transport.handler = func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "no service account", http.StatusNotFound)
+			}
+
+			_, err := resolve("gcr.io").Authorization()
+			h.AssertError(t, err, "get credentials for 'gcr.io'")
+		})
+	})
+
+	when("the registry is ACR", func() {
+		it("exchanges a managed identity token for a refresh token", func() {
+			transport.handler = func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Host {
+				case "169.254.169.254":
+					h.AssertEq(t, r.Header.Get("Metadata"), "true")
+					h.AssertEq(t, r.URL.Query().Get("resource"), "https://management.azure.com/")
+					fmt.Fprint(w, `{"access_token":"some-aad-token"}`)
+				case "someregistry.azurecr.io":
+					h.AssertEq(t, r.URL.Path, "/oauth2/exchange")
+					h.AssertNil(t, r.ParseForm())
+					h.AssertEq(t, r.PostForm.Get("access_token"), "some-aad-token")
+					h.AssertEq(t, r.PostForm.Get("service"), "someregistry.azurecr.io")
+					fmt.Fprint(w, `{"refresh_token":"some-refresh-token"}`)
+				default:
+					t.Fatalf("unexpected request: %s", r.URL)
+				}
+			}
+
+			header, err := resolve("someregistry.azurecr.io").Authorization()
+			h.AssertNil(t, err)
+			h.AssertEq(t, header, basic("00000000-0000-0000-0000-000000000000", "some-refresh-token"))
+		})
+	})
+
+	when("the registry is ECR", func() {
+		var ecrHandler = func(t *testing.T) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				h.AssertEq(t, r.URL.String(), "https://api.ecr.us-west-2.amazonaws.com/")
+				h.AssertEq(t, r.Header.Get("X-Amz-Target"), "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken")
+				if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=some-key-id/") {
+					t.Fatalf("expected request to be signed with some-key-id: %s", r.Header.Get("Authorization"))
+				}
+				h.AssertEq(t, r.Header.Get("X-Amz-Security-Token"), "some-session-token")
+				fmt.Fprint(w, `{"authorizationData":[{"authorizationToken":"QVdTOnNvbWUtcGFzc3dvcmQ="}]}`)
+			}
+		}
+
+		it("signs a token request with credentials from the environment", func() {
+			h.AssertNil(t, os.Setenv("AWS_ACCESS_KEY_ID", "some-key-id"))
+			h.AssertNil(t, os.Setenv("AWS_SECRET_ACCESS_KEY", "some-secret"))
+			h.AssertNil(t, os.Setenv("AWS_SESSION_TOKEN", "some-session-token"))
+			transport.handler = ecrHandler(t)
+
+			header, err := resolve("123456789012.dkr.ecr.us-west-2.amazonaws.com").Authorization()
+			h.AssertNil(t, err)
+			h.AssertEq(t, header, basic("AWS", "some-password"))
+		})
+
+		it("signs a token request with instance profile credentials", func() {
+			ecr := ecrHandler(t)
+			transport.handler = func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Host != "169.254.169.254" {
+					ecr(w, r)
+					return
+				}
+				switch r.URL.Path {
+				case "/latest/api/token":
+					h.AssertEq(t, r.Method, "PUT")
+					fmt.Fprint(w, "some-imds-token")
+				case "/latest/meta-data/iam/security-credentials/":
+					h.AssertEq(t, r.Header.Get("X-aws-ec2-metadata-token"), "some-imds-token")
+					fmt.Fprint(w, "some-role\n")
+				case "/latest/meta-data/iam/security-credentials/some-role":
+					h.AssertEq(t, r.Header.Get("X-aws-ec2-metadata-token"), "some-imds-token")
+					fmt.Fprint(w, `{"AccessKeyId":"some-key-id","SecretAccessKey":"some-secret","Token":"some-session-token"}`)
+				default:
+					t.Fatalf("unexpected request: %s", r.URL)
+				}
+			}
+
+			header, err := resolve("123456789012.dkr.ecr.us-west-2.amazonaws.com").Authorization()
+			h.AssertNil(t, err)
+			h.AssertEq(t, header, basic("AWS", "some-password"))
+		})
+	})
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"encoding/json"
 	"os"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
@@ -11,17 +12,34 @@ import (
 	"github.com/buildpack/lifecycle/cmd"
 )
 
-type EnvKeychain struct{}
+// EnvKeychain resolves authorization headers from a JSON map of registries
+// to headers in the variable EnvVar, which defaults to CNB_REGISTRY_AUTH.
+type EnvKeychain struct {
+	EnvVar string
+}
+
+// PullKeychain resolves credentials from CNB_REGISTRY_PULL_AUTH, and then
+// from CNB_REGISTRY_AUTH.
+func PullKeychain() authn.Keychain {
+	return NewMultiKeychain(&EnvKeychain{EnvVar: cmd.EnvRegistryPullAuth}, &EnvKeychain{})
+}
+
+// PushKeychain resolves credentials from CNB_REGISTRY_PUSH_AUTH, and then
+// from CNB_REGISTRY_AUTH.
+func PushKeychain() authn.Keychain {
+	return NewMultiKeychain(&EnvKeychain{EnvVar: cmd.EnvRegistryPushAuth}, &EnvKeychain{})
+}
 
-func (EnvKeychain) Resolve(registry name.Registry) (authn.Authenticator, error) {
-	env := os.Getenv(cmd.EnvRegistryAuth)
+func (k EnvKeychain) Resolve(registry name.Registry) (authn.Authenticator, error) {
+	envVar := k.String()
+	env := os.Getenv(envVar)
 	if env == "" {
 		return authn.Anonymous, nil
 	}
 	authMap := map[string]string{}
 	err := json.Unmarshal([]byte(env), &authMap)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to parse %s value", cmd.EnvRegistryAuth)
+		return nil, errors.Wrapf(err, "failed to parse %s value", envVar)
 	}
 	auth, ok := authMap[registry.Name()]
 	if ok {
@@ -31,6 +49,14 @@ func (EnvKeychain) Resolve(registry name.Registry) (authn.Authenticator, error)
 	return authn.Anonymous, nil
 }
 
+// String returns the name of the variable the keychain reads.
+func (k EnvKeychain) String() string {
+	if k.EnvVar == "" {
+		return cmd.EnvRegistryAuth
+	}
+	return k.EnvVar
+}
+
 type providedAuth struct {
 	auth string
 }
@@ -62,3 +88,32 @@ func BuildEnvVar(keychain authn.Keychain, images ...string) (string, error) {
 	}
 	return string(authData), nil
 }
+
+// DockerConfig returns a docker config.json holding the push credentials in
+// CNB_REGISTRY_AUTH and CNB_REGISTRY_PUSH_AUTH, for tools that read registry
+// credentials from DOCKER_CONFIG. Bearer tokens are stored as registry
+// tokens.
+func DockerConfig() ([]byte, error) {
+	authMap := map[string]string{}
+	for _, envVar := range []string{cmd.EnvRegistryAuth, cmd.EnvRegistryPushAuth} {
+		if env := os.Getenv(envVar); env != "" {
+			if err := json.Unmarshal([]byte(env), &authMap); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s value", envVar)
+			}
+		}
+	}
+	type entry struct {
+		Auth          string `json:"auth,omitempty"`
+		RegistryToken string `json:"registrytoken,omitempty"`
+	}
+	auths := map[string]entry{}
+	for registry, header := range authMap {
+		switch {
+		case strings.HasPrefix(header, "Basic "):
+			auths[registry] = entry{Auth: strings.TrimPrefix(header, "Basic ")}
+		case strings.HasPrefix(header, "Bearer "):
+			auths[registry] = entry{RegistryToken: strings.TrimPrefix(header, "Bearer ")}
+		}
+	}
+	return json.Marshal(map[string]interface{}{"auths": auths})
+}
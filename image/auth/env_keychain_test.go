@@ -88,6 +88,45 @@ func testEnvKeychain(t *testing.T, when spec.G, it spec.S) {
 				})
 			})
 		})
+
+		when("pull and push credentials are set", func() {
+			it.Before(func() {
+				h.AssertNil(t, os.Setenv("CNB_REGISTRY_AUTH", `{"some-registry.com": "shared-auth", "other-registry.com": "other-shared-auth"}`))
+				h.AssertNil(t, os.Setenv("CNB_REGISTRY_PULL_AUTH", `{"some-registry.com": "pull-auth"}`))
+				h.AssertNil(t, os.Setenv("CNB_REGISTRY_PUSH_AUTH", `{"some-registry.com": "push-auth"}`))
+			})
+
+			it.After(func() {
+				h.AssertNil(t, os.Unsetenv("CNB_REGISTRY_PULL_AUTH"))
+				h.AssertNil(t, os.Unsetenv("CNB_REGISTRY_PUSH_AUTH"))
+			})
+
+			it("resolves them separately, falling back to the shared credentials", func() {
+				for keychain, expected := range map[authn.Keychain][]string{
+					auth.PullKeychain(): {"pull-auth", "other-shared-auth"},
+					auth.PushKeychain(): {"push-auth", "other-shared-auth"},
+				} {
+					for i, registryName := range []string{"some-registry.com", "other-registry.com"} {
+						registry, err := name.NewRegistry(registryName, name.WeakValidation)
+						h.AssertNil(t, err)
+						authenticator, err := keychain.Resolve(registry)
+						h.AssertNil(t, err)
+						header, err := authenticator.Authorization()
+						h.AssertNil(t, err)
+						h.AssertEq(t, header, expected[i])
+					}
+				}
+			})
+
+			it("writes the push credentials to the docker config", func() {
+				h.AssertNil(t, os.Setenv("CNB_REGISTRY_AUTH", `{"some-registry.com": "Basic c2hhcmVk"}`))
+				h.AssertNil(t, os.Setenv("CNB_REGISTRY_PUSH_AUTH", `{"some-registry.com": "Basic cHVzaA=="}`))
+
+				config, err := auth.DockerConfig()
+				h.AssertNil(t, err)
+				h.AssertEq(t, string(config), `{"auths":{"some-registry.com":{"auth":"cHVzaA=="}}}`)
+			})
+		})
 	})
 
 	when("#BuildAuthEnvVar", func() {
@@ -120,6 +159,16 @@ func testEnvKeychain(t *testing.T, when spec.G, it spec.S) {
 
 			h.AssertEq(t, envVar, "{}")
 		})
+
+		when("#DockerConfig", func() {
+			it("converts basic auth and bearer tokens", func() {
+				h.AssertNil(t, os.Setenv("CNB_REGISTRY_AUTH", `{"some-registry.com": "Basic c29tZS11c2VyOnNvbWUtcGFzcw==", "other-registry.com": "Bearer some-token"}`))
+
+				config, err := auth.DockerConfig()
+				h.AssertNil(t, err)
+				h.AssertEq(t, string(config), `{"auths":{"other-registry.com":{"registrytoken":"some-token"},"some-registry.com":{"auth":"c29tZS11c2VyOnNvbWUtcGFzcw=="}}}`)
+			})
+		})
 	})
 }
 
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// MultiKeychain resolves credentials from the first of its keychains that
+// has them for a registry, like authn.NewMultiKeychain, while its keychains
+// can be listed by Sources.
+type MultiKeychain struct {
+	keychains []authn.Keychain
+}
+
+func NewMultiKeychain(keychains ...authn.Keychain) authn.Keychain {
+	return &MultiKeychain{keychains: keychains}
+}
+
+func (m *MultiKeychain) Resolve(registry name.Registry) (authn.Authenticator, error) {
+	for _, keychain := range m.keychains {
+		auth, err := keychain.Resolve(registry)
+		if err != nil {
+			return nil, err
+		}
+		if auth != authn.Anonymous {
+			return auth, nil
+		}
+	}
+	return authn.Anonymous, nil
+}
+
+// Sources returns the names of the credential sources keychain tries for
+// registry, in order, up to the one that has credentials for it, and whether
+// one does.
+func Sources(keychain authn.Keychain, registry name.Registry) ([]string, bool) {
+	if multi, ok := keychain.(*MultiKeychain); ok {
+		var tried []string
+		for _, keychain := range multi.keychains {
+			sources, found := Sources(keychain, registry)
+			tried = append(tried, sources...)
+			if found {
+				return tried, true
+			}
+		}
+		return tried, false
+	}
+	auth, err := keychain.Resolve(registry)
+	return []string{sourceName(keychain)}, err == nil && auth != authn.Anonymous
+}
+
+func sourceName(keychain authn.Keychain) string {
+	if keychain == authn.DefaultKeychain {
+		return "docker config"
+	}
+	if stringer, ok := keychain.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", keychain)
+}
@@ -0,0 +1,70 @@
+package auth_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/image/auth"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestMultiKeychain(t *testing.T) {
+	spec.Run(t, "Multi Keychain", testMultiKeychain, spec.Sequential(), spec.Report(report.Terminal{}))
+}
+
+func testMultiKeychain(t *testing.T, when spec.G, it spec.S) {
+	var (
+		keychain authn.Keychain
+		registry name.Registry
+	)
+
+	it.Before(func() {
+		keychain = auth.NewMultiKeychain(auth.PullKeychain(), &auth.CloudKeychain{})
+		var err error
+		registry, err = name.NewRegistry("some-registry.com", name.WeakValidation)
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.Unsetenv("CNB_REGISTRY_AUTH"))
+	})
+
+	when("#Resolve", func() {
+		it("resolves credentials from the first keychain that has them", func() {
+			h.AssertNil(t, os.Setenv("CNB_REGISTRY_AUTH", `{"some-registry.com": "some-auth-header"}`))
+
+			authenticator, err := keychain.Resolve(registry)
+			h.AssertNil(t, err)
+			header, err := authenticator.Authorization()
+			h.AssertNil(t, err)
+			h.AssertEq(t, header, "some-auth-header")
+		})
+
+		it("resolves to anonymous when no keychain has credentials", func() {
+			authenticator, err := keychain.Resolve(registry)
+			h.AssertNil(t, err)
+			h.AssertEq(t, authenticator, authn.Anonymous)
+		})
+	})
+
+	when("#Sources", func() {
+		it("lists the sources tried up to the one with credentials", func() {
+			h.AssertNil(t, os.Setenv("CNB_REGISTRY_AUTH", `{"some-registry.com": "some-auth-header"}`))
+
+			sources, found := auth.Sources(keychain, registry)
+			h.AssertEq(t, sources, []string{"CNB_REGISTRY_PULL_AUTH", "CNB_REGISTRY_AUTH"})
+			h.AssertEq(t, found, true)
+		})
+
+		it("lists every source when none has credentials", func() {
+			sources, found := auth.Sources(keychain, registry)
+			h.AssertEq(t, sources, []string{"CNB_REGISTRY_PULL_AUTH", "CNB_REGISTRY_AUTH", "cloud identity"})
+			h.AssertEq(t, found, false)
+		})
+	})
+}
@@ -0,0 +1,80 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultCompressionLevel matches the level used by go-containerregistry.
+const DefaultCompressionLevel = gzip.BestSpeed
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ParseCompressionLevel accepts a gzip level from 0 to 9 or one of
+// "default", "none", "fastest", or "best".
+func ParseCompressionLevel(level string) (int, error) {
+	switch level {
+	case "", "default":
+		return DefaultCompressionLevel, nil
+	case "none":
+		return gzip.NoCompression, nil
+	case "fastest":
+		return gzip.BestSpeed, nil
+	case "best":
+		return gzip.BestCompression, nil
+	}
+	n, err := strconv.Atoi(level)
+	if err != nil || n < gzip.NoCompression || n > gzip.BestCompression {
+		return 0, errors.Errorf("invalid compression level '%s'", level)
+	}
+	return n, nil
+}
+
+// WithCompressionLevel sets the gzip level used for layers pushed to a registry.
+// Layers exported to the daemon are never compressed.
+func WithCompressionLevel(level int) func(factory *Factory) {
+	return func(factory *Factory) {
+		factory.CompressionLevel = level
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// compressedOpener gzips the layer stream at the given level, passing it
+// through unchanged if it is already compressed.
+func compressedOpener(open Opener, level int) Opener {
+	return func() (io.ReadCloser, error) {
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		br := bufio.NewReader(rc)
+		if magic, err := br.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+			return readCloser{br, rc}, nil
+		}
+
+		pr, pw := io.Pipe()
+		go func() {
+			defer rc.Close()
+			gw, err := gzip.NewWriterLevel(pw, level)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(gw, br); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			pw.CloseWithError(gw.Close())
+		}()
+		return pr, nil
+	}
+}
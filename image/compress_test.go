@@ -0,0 +1,47 @@
+package image_test
+
+import (
+	"compress/gzip"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/image"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestCompress(t *testing.T) {
+	spec.Run(t, "Compress", testCompress, spec.Report(report.Terminal{}))
+}
+
+func testCompress(t *testing.T, when spec.G, it spec.S) {
+	when("#ParseCompressionLevel", func() {
+		it("accepts named levels", func() {
+			for name, expected := range map[string]int{
+				"":        image.DefaultCompressionLevel,
+				"default": image.DefaultCompressionLevel,
+				"none":    gzip.NoCompression,
+				"fastest": gzip.BestSpeed,
+				"best":    gzip.BestCompression,
+			} {
+				level, err := image.ParseCompressionLevel(name)
+				h.AssertNil(t, err)
+				h.AssertEq(t, level, expected)
+			}
+		})
+
+		it("accepts numeric levels", func() {
+			level, err := image.ParseCompressionLevel("6")
+			h.AssertNil(t, err)
+			h.AssertEq(t, level, 6)
+		})
+
+		it("rejects invalid levels", func() {
+			_, err := image.ParseCompressionLevel("10")
+			h.AssertError(t, err, "invalid compression level '10'")
+			_, err = image.ParseCompressionLevel("some-level")
+			h.AssertError(t, err, "invalid compression level 'some-level'")
+		})
+	})
+}
@@ -0,0 +1,177 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/progress"
+)
+
+// containerdStore reads and writes images in a containerd namespace with the
+// ctr CLI, which talks to the containerd socket in CONTAINERD_ADDRESS.
+type containerdStore struct {
+	ctr       string
+	namespace string
+	out       io.Writer
+
+	mu     sync.Mutex
+	dir    string
+	images map[string]v1.Image // exported images by name, nil when not found
+}
+
+// WithContainerd saves images to the containerd namespace, such as k8s.io
+// for images run by the kubelet, instead of pushing them to a registry.
+// Previous images are read from the namespace with NewContainerd. ctr is
+// the path to the ctr binary and defaults to ctr on the PATH.
+func WithContainerd(namespace, ctr string) func(*Factory) {
+	return func(factory *Factory) {
+		if ctr == "" {
+			ctr = "ctr"
+		}
+		factory.containerd = &containerdStore{ctr: ctr, namespace: namespace, out: factory.Out, images: map[string]v1.Image{}}
+	}
+}
+
+// NewContainerd returns the image with repoName in the factory's containerd
+// namespace. Saving it imports it into the namespace.
+func (f *Factory) NewContainerd(repoName string) (Image, error) {
+	if f.containerd == nil {
+		return nil, errors.New("no containerd namespace configured")
+	}
+	image, err := f.containerd.image(repoName)
+	if err != nil {
+		return nil, err
+	}
+	r := &remote{
+		keychain:     f.Keychain,
+		pushKeychain: f.pushKeychain(),
+		transport:    &registryTransport{ctx: f.context(), base: http.DefaultTransport, progress: progress.NewTracker(f.Out)},
+		RepoName:     repoName,
+		Image:        image,
+		prevOnce:     &sync.Once{},
+		containerd:   f.containerd,
+
+		platform:         f.Platform,
+		compressionLevel: f.CompressionLevel,
+	}
+	if image == nil {
+		r.Image, r.missing = empty.Image, true
+	}
+	return r, nil
+}
+
+// Cleanup removes the images exported from containerd.
+func (f *Factory) Cleanup() error {
+	if f.containerd == nil || f.containerd.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(f.containerd.dir)
+}
+
+// image exports repoName from the namespace, or returns nil if it is not
+// there.
+func (s *containerdStore) image(repoName string) (v1.Image, error) {
+	tag, err := name.NewTag(repoName, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	ref := normalizedName(tag)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if image, ok := s.images[ref]; ok {
+		return image, nil
+	}
+
+	var names bytes.Buffer
+	if err := s.run(nil, &names, "images", "list", "--quiet", "name=="+ref); err != nil {
+		return nil, err
+	}
+	found := false
+	for scanner := bufio.NewScanner(&names); scanner.Scan(); {
+		found = found || scanner.Text() == ref
+	}
+	if !found {
+		s.images[ref] = nil
+		return nil, nil
+	}
+
+	if s.dir == "" {
+		if s.dir, err = ioutil.TempDir("", "lifecycle.containerd."); err != nil {
+			return nil, err
+		}
+	}
+	path := filepath.Join(s.dir, tag.Context().RepositoryStr()+"-"+tag.TagStr()+".tar")
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+	if err := s.run(nil, nil, "images", "export", path, ref); err != nil {
+		return nil, err
+	}
+	image, err := tarball.ImageFromPath(path, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read image '%s' exported from containerd", ref)
+	}
+	s.images[ref] = image
+	return image, nil
+}
+
+// save imports image into the namespace as repoName and returns its ID.
+func (s *containerdStore) save(repoName string, image v1.Image) (string, error) {
+	tag, err := name.NewTag(repoName, name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tarball.Write(tag, image, pw))
+	}()
+	if err := s.run(progress.NewTracker(s.out).Reader(pr, "Importing "+repoName, 0), nil, "images", "import", "-"); err != nil {
+		pr.CloseWithError(err)
+		return "", err
+	}
+	id, err := image.ConfigName()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	delete(s.images, normalizedName(tag))
+	s.mu.Unlock()
+	return id.String(), nil
+}
+
+func (s *containerdStore) run(stdin io.Reader, stdout io.Writer, args ...string) error {
+	var stderr bytes.Buffer
+	c := exec.Command(s.ctr, append([]string{"--namespace", s.namespace}, args...)...)
+	c.Stdin = stdin
+	c.Stdout = stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return errors.Wrapf(err, "ctr %s %s: %s", args[0], args[1], bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+// normalizedName returns the name containerd stores tag under, with docker.io
+// for Docker Hub.
+func normalizedName(tag name.Tag) string {
+	registry := tag.Context().RegistryStr()
+	if registry == name.DefaultRegistry {
+		registry = "docker.io"
+	}
+	return registry + "/" + tag.Context().RepositoryStr() + ":" + tag.TagStr()
+}
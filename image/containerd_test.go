@@ -0,0 +1,97 @@
+package image_test
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/image"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestContainerd(t *testing.T) {
+	spec.Run(t, "Containerd", testContainerd, spec.Report(report.Terminal{}))
+}
+
+func testContainerd(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir  string
+		factory *image.Factory
+	)
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.containerd.test.")
+		h.AssertNil(t, err)
+
+		// a fake ctr that stores a single image
+		ctr := filepath.Join(tmpDir, "ctr")
+		h.AssertNil(t, ioutil.WriteFile(ctr, []byte(fmt.Sprintf(`#!/bin/sh
+echo "$@" >> %[1]s/calls
+case "$4" in
+  list) [ -f %[1]s/image.tar ] && echo docker.io/library/some-image:latest ;;
+  import) cat > %[1]s/image.tar ;;
+  export) cp %[1]s/image.tar "$5" ;;
+esac
+exit 0
+`, tmpDir)), 0777))
+
+		factory, err = image.NewFactory(image.WithContainerd("k8s.io", ctr))
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		h.AssertNil(t, factory.Cleanup())
+		os.RemoveAll(tmpDir)
+	})
+
+	it("imports saved images into the namespace and reads them back", func() {
+		img, err := factory.NewContainerd("some-image")
+		h.AssertNil(t, err)
+		found, err := img.Found()
+		h.AssertNil(t, err)
+		h.AssertEq(t, found, false)
+
+		layer, err := os.Create(filepath.Join(tmpDir, "layer.tar"))
+		h.AssertNil(t, err)
+		tw := tar.NewWriter(layer)
+		h.AssertNil(t, tw.WriteHeader(&tar.Header{Name: "some-file", Mode: 0644, Size: 4}))
+		_, err = tw.Write([]byte("data"))
+		h.AssertNil(t, err)
+		h.AssertNil(t, tw.Close())
+		h.AssertNil(t, layer.Close())
+
+		runImage := factory.NewEmptyRemote("some-image")
+		h.AssertNil(t, runImage.AddLayer(layer.Name()))
+		h.AssertNil(t, runImage.SetLabel("some-label", "some-value"))
+		id, err := runImage.Save()
+		h.AssertNil(t, err)
+		if !strings.HasPrefix(id, "sha256:") {
+			t.Fatalf("expected image ID, got '%s'", id)
+		}
+
+		img, err = factory.NewContainerd("some-image")
+		h.AssertNil(t, err)
+		found, err = img.Found()
+		h.AssertNil(t, err)
+		h.AssertEq(t, found, true)
+		label, err := img.Label("some-label")
+		h.AssertNil(t, err)
+		h.AssertEq(t, label, "some-value")
+
+		calls, err := ioutil.ReadFile(filepath.Join(tmpDir, "calls"))
+		h.AssertNil(t, err)
+		for _, call := range strings.Split(strings.TrimSpace(string(calls)), "\n") {
+			if !strings.HasPrefix(call, "--namespace k8s.io images ") {
+				t.Fatalf("expected ctr to target the k8s.io namespace: %s", call)
+			}
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package image_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/golang/mock/gomock"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/image"
+	"github.com/buildpack/lifecycle/image/testmock"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestDockerClient(t *testing.T) {
+	spec.Run(t, "DockerClient", testDockerClient, spec.Report(report.Terminal{}))
+}
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string  { return "not found" }
+func (notFoundError) NotFound() bool { return true }
+
+func testDockerClient(t *testing.T, when spec.G, it spec.S) {
+	var (
+		mockController *gomock.Controller
+		docker         *testmock.MockDockerClient
+		factory        *image.Factory
+	)
+
+	it.Before(func() {
+		mockController = gomock.NewController(t)
+		docker = testmock.NewMockDockerClient(mockController)
+		docker.EXPECT().ServerVersion(gomock.Any()).Return(types.Version{}, nil).AnyTimes()
+		factory = &image.Factory{Docker: docker, Out: ioutil.Discard}
+	})
+
+	it.After(func() {
+		mockController.Finish()
+	})
+
+	when("#NewLocal", func() {
+		it("reads the image the client inspects", func() {
+			inspect := types.ImageInspect{ID: "some-id", Config: &container.Config{Labels: map[string]string{"some-label": "some-value"}}}
+			docker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some-image").Return(inspect, nil, nil)
+
+			img, err := factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			found, err := img.Found()
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, true)
+			label, err := img.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+		})
+
+		it("does not find images the client does not find", func() {
+			docker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some-image").Return(types.ImageInspect{}, nil, notFoundError{})
+
+			img, err := factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			found, err := img.Found()
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, false)
+		})
+
+		it("returns other errors of the client", func() {
+			docker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some-image").Return(types.ImageInspect{}, nil, errors.New("some-error"))
+
+			_, err := factory.NewLocal("some-image")
+			h.AssertError(t, err, "some-error")
+		})
+	})
+
+	when("#Delete", func() {
+		it("removes the image by ID", func() {
+			inspect := types.ImageInspect{ID: "some-id", Config: &container.Config{}}
+			docker.EXPECT().ImageInspectWithRaw(gomock.Any(), "some-image").Return(inspect, nil, nil)
+			docker.EXPECT().ImageRemove(gomock.Any(), "some-id", types.ImageRemoveOptions{Force: true, PruneChildren: true})
+
+			img, err := factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.Delete())
+		})
+	})
+}
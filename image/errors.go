@@ -0,0 +1,124 @@
+package image
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/image/auth"
+)
+
+// Errors returned by images match these with errors.Is. Errors wrapped by
+// github.com/pkg/errors, which does not support errors.Is, match once
+// unwrapped with errors.Cause.
+var (
+	ErrImageNotFound = errors.New("image not found")
+	ErrLayerNotFound = errors.New("layer not found")
+	ErrAuthFailure   = errors.New("registry authentication failed")
+
+	// ErrRegistryUnavailable is matched by errors for registries that are
+	// rate limiting or failing requests, which are retryable.
+	ErrRegistryUnavailable = errors.New("registry unavailable")
+)
+
+// kindError keeps the message of an error while matching one of the sentinel
+// errors.
+type kindError struct {
+	err  error
+	kind error
+}
+
+func (e *kindError) Error() string        { return e.err.Error() }
+func (e *kindError) Unwrap() error        { return e.err }
+func (e *kindError) Is(target error) bool { return target == e.kind }
+func (e *kindError) Retryable() bool      { return e.kind == ErrRegistryUnavailable }
+
+func withKind(err, kind error) error {
+	if err == nil || kind == nil {
+		return err
+	}
+	return &kindError{err: err, kind: kind}
+}
+
+func notExistError(action, name string) error {
+	return withKind(errors.Errorf("failed to %s, image '%s' does not exist", action, name), ErrImageNotFound)
+}
+
+// AuthError is returned when a registry denies pulling or pushing an image
+// with the credentials, or lack of credentials, that the keychain resolved.
+// It matches ErrAuthFailure.
+type AuthError struct {
+	Registry  string
+	Operation string   // pull or push
+	Sources   []string // credential sources tried, in order
+	Found     bool     // whether the last source had credentials
+	Err       error
+}
+
+func (e *AuthError) Error() string {
+	credentials := "without credentials"
+	if e.Found {
+		credentials = "with credentials from " + e.Sources[len(e.Sources)-1]
+	}
+	return fmt.Sprintf("registry '%s' denied %s access %s (tried %s): %s", e.Registry, e.Operation, credentials, strings.Join(e.Sources, ", "), e.Err)
+}
+
+func (e *AuthError) Unwrap() error        { return e.Err }
+func (e *AuthError) Is(target error) bool { return target == ErrAuthFailure }
+
+// authError returns an *AuthError for err if it is an authentication failure
+// from the registry of repoName, or nil.
+func authError(err error, keychain authn.Keychain, repoName, operation string) error {
+	if registryErrorKind(err) != ErrAuthFailure {
+		return nil
+	}
+	ref, parseErr := name.ParseReference(repoName, name.WeakValidation)
+	if parseErr != nil {
+		return nil
+	}
+	sources, found := auth.Sources(keychain, ref.Context().Registry)
+	return &AuthError{Registry: ref.Context().RegistryStr(), Operation: operation, Sources: sources, Found: found, Err: err}
+}
+
+// registryError makes an error from a registry match the sentinel error for
+// its code, if any.
+func registryError(err error) error {
+	return withKind(err, registryErrorKind(err))
+}
+
+func registryErrorKind(err error) error {
+	transportErr, ok := err.(*transport.Error)
+	if !ok || len(transportErr.Errors) == 0 {
+		// responses without a structured error, such as those to HEAD
+		// requests, are only described by their status code
+		var status int
+		if err == nil {
+			return nil
+		} else if _, scanErr := fmt.Sscanf(err.Error(), "unsupported status code %d;", &status); scanErr != nil {
+			return nil
+		}
+		switch {
+		case status == http.StatusUnauthorized, status == http.StatusForbidden:
+			return ErrAuthFailure
+		case status == http.StatusTooManyRequests, status >= 500:
+			return ErrRegistryUnavailable
+		}
+		return nil
+	}
+	switch transportErr.Errors[0].Code {
+	case transport.UnauthorizedErrorCode, transport.DeniedErrorCode:
+		return ErrAuthFailure
+	case transport.ManifestUnknownErrorCode, transport.NameUnknownErrorCode:
+		return ErrImageNotFound
+	case transport.BlobUnknownErrorCode:
+		return ErrLayerNotFound
+	case "TOOMANYREQUESTS", "UNAVAILABLE":
+		return ErrRegistryUnavailable
+	}
+	return nil
+}
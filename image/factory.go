@@ -1,9 +1,13 @@
 package image
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
+	"os"
+	"sync"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/pkg/errors"
@@ -11,16 +15,58 @@ import (
 	"github.com/buildpack/lifecycle/image/auth"
 )
 
+//go:generate mockgen -package testmock -destination testmock/docker_client.go github.com/buildpack/lifecycle/image DockerClient
+
+// DockerClient is the part of the Docker client that local images use, so
+// that tests and other daemons can stand in for it. Errors for images that
+// are not found must have a NotFound method that returns true, as the
+// client's do.
+type DockerClient interface {
+	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+	ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error)
+	ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error)
+	ImageSave(ctx context.Context, imageIDs []string) (io.ReadCloser, error)
+	ImageTag(ctx context.Context, source, target string) error
+	ServerVersion(ctx context.Context) (types.Version, error)
+}
+
 type Factory struct {
-	Docker   *client.Client
+	Docker   DockerClient
 	Keychain authn.Keychain
 	Out      io.Writer
+	Context  context.Context
+
+	CompressionLevel int
+
+	// Platform, when set, selects the image for the platform from manifest
+	// lists and image indexes.
+	Platform *Platform
+
+	// ArtifactType, when set, makes remote images pushed to registries OCI
+	// artifacts of its media types.
+	ArtifactType *ArtifactType
+
+	// PushKeychain provides credentials for writing to registries, when it
+	// differs from Keychain.
+	PushKeychain authn.Keychain
+
+	inspects *inspectCache
+
+	podmanOnce sync.Once
+	podman     bool
+
+	containerd *containerdStore
 }
 
 func NewFactory(ops ...func(*Factory)) (*Factory, error) {
 	f := &Factory{
 		Out:      ioutil.Discard,
 		Keychain: authn.DefaultKeychain,
+		Context:  context.Background(),
+
+		CompressionLevel: DefaultCompressionLevel,
+
+		inspects: newInspectCache(),
 	}
 
 	var err error
@@ -35,8 +81,22 @@ func NewFactory(ops ...func(*Factory)) (*Factory, error) {
 	return f, nil
 }
 
+// WithEnvKeychain resolves credentials from the environment before falling
+// back to the factory's keychains, using separate credentials for reading
+// and writing images when they are provided.
 func WithEnvKeychain(factory *Factory) {
-	factory.Keychain = authn.NewMultiKeychain(&auth.EnvKeychain{}, factory.Keychain)
+	factory.PushKeychain = auth.NewMultiKeychain(auth.PushKeychain(), factory.pushKeychain())
+	factory.Keychain = auth.NewMultiKeychain(auth.PullKeychain(), factory.Keychain)
+}
+
+// WithCloudKeychain falls back to credentials from the identity of the cloud
+// instance or workload for ECR, GCR, Artifact Registry and ACR.
+func WithCloudKeychain(factory *Factory) {
+	cloud := &auth.CloudKeychain{}
+	if factory.PushKeychain != nil {
+		factory.PushKeychain = auth.NewMultiKeychain(factory.PushKeychain, cloud)
+	}
+	factory.Keychain = auth.NewMultiKeychain(factory.Keychain, cloud)
 }
 
 func WithOutWriter(w io.Writer) func(factory *Factory) {
@@ -45,8 +105,36 @@ func WithOutWriter(w io.Writer) func(factory *Factory) {
 	}
 }
 
+func WithContext(ctx context.Context) func(factory *Factory) {
+	return func(factory *Factory) {
+		factory.Context = ctx
+	}
+}
+
+func (f *Factory) pushKeychain() authn.Keychain {
+	if f.PushKeychain == nil {
+		return f.Keychain
+	}
+	return f.PushKeychain
+}
+
+func (f *Factory) context() context.Context {
+	if f.Context == nil {
+		return context.Background()
+	}
+	return f.Context
+}
+
 func newDocker() (*client.Client, error) {
-	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithVersion("1.38"))
+	opts := []func(*client.Client) error{client.FromEnv, client.WithVersion("1.38")}
+	if os.Getenv("DOCKER_HOST") == "" {
+		if _, err := os.Stat("/var/run/docker.sock"); os.IsNotExist(err) {
+			if socket := podmanSocket(); socket != "" {
+				opts = append(opts, client.WithHost("unix://"+socket))
+			}
+		}
+	}
+	docker, err := client.NewClientWithOpts(opts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "new docker client")
 	}
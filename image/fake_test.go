@@ -0,0 +1,560 @@
+package image_test
+
+import (
+	"archive/tar"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/archive"
+	"github.com/buildpack/lifecycle/exitcode"
+	"github.com/buildpack/lifecycle/image"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+// TestFake runs against the in-process fake registry and daemon, so unlike
+// TestRemote and TestLocal it does not need Docker.
+func TestFake(t *testing.T) {
+	spec.Run(t, "Fake", testFake, spec.Report(report.Terminal{}))
+}
+
+type basicKeychain authn.Basic
+
+func (k basicKeychain) Resolve(name.Registry) (authn.Authenticator, error) {
+	return &authn.Basic{Username: k.Username, Password: k.Password}, nil
+}
+
+func (k basicKeychain) String() string {
+	return "some-keychain"
+}
+
+func testFake(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir    string
+		layerPath string
+	)
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.fake.test.")
+		h.AssertNil(t, err)
+
+		layerPath = filepath.Join(tmpDir, "layer.tar")
+		f, err := os.Create(layerPath)
+		h.AssertNil(t, err)
+		tw := tar.NewWriter(f)
+		h.AssertNil(t, tw.WriteHeader(&tar.Header{Name: "some-file", Mode: 0644, Size: 4}))
+		_, err = tw.Write([]byte("data"))
+		h.AssertNil(t, err)
+		h.AssertNil(t, tw.Close())
+		h.AssertNil(t, f.Close())
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	when("remote", func() {
+		for _, auth := range []struct {
+			desc string
+			op   h.RegistryOption
+		}{
+			{"basic auth", h.WithBasicAuth("some-user", "some-password")},
+			{"token auth", h.WithTokenAuth("some-user", "some-password")},
+		} {
+			auth := auth
+			when(auth.desc, func() {
+				var registry *h.FakeRegistry
+
+				it.Before(func() {
+					registry = h.NewFakeRegistry(auth.op)
+					registry.Start(t)
+				})
+
+				it.After(func() {
+					registry.Stop(t)
+				})
+
+				it("saves, reads and reuses layers with credentials", func() {
+					factory := image.Factory{
+						Keychain: basicKeychain{Username: "some-user", Password: "some-password"},
+						Out:      ioutil.Discard,
+					}
+					repoName := registry.RepoName("some-app")
+
+					img := factory.NewEmptyRemote(repoName)
+					h.AssertNil(t, img.AddLayer(layerPath))
+					h.AssertNil(t, img.SetLabel("some-label", "some-value"))
+					digest, err := img.Save()
+					h.AssertNil(t, err)
+
+					img, err = factory.NewRemote(repoName)
+					h.AssertNil(t, err)
+					found, err := img.Found()
+					h.AssertNil(t, err)
+					h.AssertEq(t, found, true)
+					savedDigest, err := img.Digest()
+					h.AssertNil(t, err)
+					h.AssertEq(t, savedDigest, digest)
+					label, err := img.Label("some-label")
+					h.AssertNil(t, err)
+					h.AssertEq(t, label, "some-value")
+					topLayer, err := img.TopLayer()
+					h.AssertNil(t, err)
+
+					rebuilt := factory.NewEmptyRemote(repoName)
+					h.AssertNil(t, rebuilt.ReuseLayer(topLayer))
+					_, err = rebuilt.Save()
+					h.AssertNil(t, err)
+				})
+
+				it("tags images without changing them", func() {
+					factory := image.Factory{
+						Keychain: basicKeychain{Username: "some-user", Password: "some-password"},
+						Out:      ioutil.Discard,
+					}
+					img := factory.NewEmptyRemote(registry.RepoName("some-app"))
+					h.AssertNil(t, img.AddLayer(layerPath))
+					digest, err := img.Save()
+					h.AssertNil(t, err)
+
+					img, err = factory.NewRemote(registry.RepoName("some-app"))
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.Tag(registry.RepoName("some-app:previous")))
+
+					img, err = factory.NewRemote(registry.RepoName("some-app:previous"))
+					h.AssertNil(t, err)
+					taggedDigest, err := img.Digest()
+					h.AssertNil(t, err)
+					h.AssertEq(t, taggedDigest, digest)
+				})
+
+				it("pushes and reads images as OCI artifacts", func() {
+					factory := image.Factory{
+						Keychain:     basicKeychain{Username: "some-user", Password: "some-password"},
+						Out:          ioutil.Discard,
+						ArtifactType: &image.ArtifactType{Config: "application/some-config", Layer: "application/some-layer"},
+					}
+					img := factory.NewEmptyRemote(registry.RepoName("some-cache"))
+					h.AssertNil(t, img.AddLayer(layerPath))
+					h.AssertNil(t, img.SetLabel("some-label", "some-value"))
+					digest, err := img.Save()
+					h.AssertNil(t, err)
+
+					stored, ok := registry.Manifest("some-cache", "latest")
+					h.AssertEq(t, ok, true)
+					h.AssertEq(t, stored.MediaType, "application/vnd.oci.image.manifest.v1+json")
+					var manifest struct {
+						Config struct {
+							MediaType string `json:"mediaType"`
+						} `json:"config"`
+						Layers []struct {
+							MediaType string `json:"mediaType"`
+						} `json:"layers"`
+					}
+					h.AssertNil(t, json.Unmarshal(stored.Body, &manifest))
+					h.AssertEq(t, manifest.Config.MediaType, "application/some-config")
+					h.AssertEq(t, len(manifest.Layers), 1)
+					h.AssertEq(t, manifest.Layers[0].MediaType, "application/some-layer")
+
+					img, err = factory.NewRemote(registry.RepoName("some-cache"))
+					h.AssertNil(t, err)
+					savedDigest, err := img.Digest()
+					h.AssertNil(t, err)
+					h.AssertEq(t, savedDigest, digest)
+					label, err := img.Label("some-label")
+					h.AssertNil(t, err)
+					h.AssertEq(t, label, "some-value")
+					topLayer, err := img.TopLayer()
+					h.AssertNil(t, err)
+					rc, err := img.GetLayer(topLayer)
+					h.AssertNil(t, err)
+					rc.Close()
+
+					rebuilt := factory.NewEmptyRemote(registry.RepoName("some-cache"))
+					h.AssertNil(t, rebuilt.ReuseLayer(topLayer))
+					_, err = rebuilt.Save()
+					h.AssertNil(t, err)
+				})
+
+				it("fails with the wrong credentials", func() {
+					factory := image.Factory{
+						Keychain: basicKeychain{Username: "some-user", Password: "wrong-password"},
+						Out:      ioutil.Discard,
+					}
+					img := factory.NewEmptyRemote(registry.RepoName("some-app"))
+					h.AssertNil(t, img.AddLayer(layerPath))
+					_, err := img.Save()
+					if !errors.Is(err, image.ErrAuthFailure) {
+						t.Fatalf("expected an auth failure, got: %v", err)
+					}
+					authErr, ok := err.(*image.AuthError)
+					if !ok {
+						t.Fatalf("expected an *image.AuthError, got: %v", err)
+					}
+					h.AssertEq(t, authErr.Operation, "push")
+					h.AssertEq(t, authErr.Sources, []string{"some-keychain"})
+					h.AssertError(t, err, "registry '"+registry.Host+"' denied push access with credentials from some-keychain (tried some-keychain)")
+				})
+			})
+		}
+	})
+
+	when("the registry is unavailable", func() {
+		it("returns retryable errors", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/v2/" {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer server.Close()
+			factory := image.Factory{Keychain: authn.DefaultKeychain, Out: ioutil.Discard}
+
+			img, err := factory.NewRemote(strings.TrimPrefix(server.URL, "http://") + "/some-app")
+			h.AssertNil(t, err)
+			_, err = img.Found()
+			h.AssertEq(t, errors.Is(err, image.ErrRegistryUnavailable), true)
+			h.AssertEq(t, exitcode.IsRetryable(err), true)
+			h.AssertEq(t, exitcode.Of(err), exitcode.Retryable)
+		})
+	})
+
+	when("tls", func() {
+		it("serves a certificate signed by the registry CA", func() {
+			registry := h.NewFakeRegistry(h.WithTLS())
+			registry.Start(t)
+			defer registry.Stop(t)
+
+			if _, err := http.Get("https://" + registry.Host + "/v2/"); err == nil {
+				t.Fatal("expected the registry certificate not to be trusted by default")
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: registry.CertPool()}}}
+			resp, err := client.Get("https://" + registry.Host + "/v2/")
+			h.AssertNil(t, err)
+			defer resp.Body.Close()
+			h.AssertEq(t, resp.StatusCode, http.StatusOK)
+		})
+	})
+
+	when("fixtures", func() {
+		fixture := h.ImageFixture{
+			Layers: []map[string]string{
+				{"/etc/some-file": "some-contents"},
+				{"some-dir/other-file": "other-contents", "some-dir/nested/file": "nested"},
+			},
+			Labels: map[string]string{"some-label": "some-value"},
+			Env:    []string{"SOME_VAR=some-value"},
+		}
+
+		it("have reproducible digests", func() {
+			digest, err := fixture.Image(t).Digest()
+			h.AssertNil(t, err)
+			again, err := fixture.Image(t).Digest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, again, digest)
+
+			other := fixture
+			other.Labels = map[string]string{"some-label": "other-value"}
+			otherDigest, err := other.Image(t).Digest()
+			h.AssertNil(t, err)
+			if otherDigest == digest {
+				t.Fatal("expected fixtures with different labels to have different digests")
+			}
+		})
+
+		it("are pushed to registries", func() {
+			registry := h.NewFakeRegistry()
+			registry.Start(t)
+			defer registry.Stop(t)
+			repoName := registry.RepoName("some-fixture")
+
+			digest := fixture.Push(t, repoName)
+			img, err := (&image.Factory{Keychain: authn.DefaultKeychain}).NewRemote(repoName)
+			h.AssertNil(t, err)
+			savedDigest, err := img.Digest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, savedDigest, digest)
+			env, err := img.Env("SOME_VAR")
+			h.AssertNil(t, err)
+			h.AssertEq(t, env, "some-value")
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, fixture.TopLayer(t))
+		})
+
+		it("are loaded into daemons", func() {
+			daemon := h.NewFakeDaemon()
+			daemon.Start(t)
+			defer daemon.Stop(t)
+
+			id := fixture.Load(t, daemon.Client(t), "some-fixture")
+			h.AssertEq(t, daemon.Tags(id), []string{"docker.io/library/some-fixture:latest"})
+			img, err := (&image.Factory{Docker: daemon.Client(t), Keychain: authn.DefaultKeychain}).NewLocal("some-fixture")
+			h.AssertNil(t, err)
+			label, err := img.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, fixture.TopLayer(t))
+		})
+	})
+
+	when("local", func() {
+		var (
+			daemon  *h.FakeDaemon
+			factory image.Factory
+		)
+
+		it.Before(func() {
+			daemon = h.NewFakeDaemon()
+			daemon.Start(t)
+			factory = image.Factory{
+				Docker:   daemon.Client(t),
+				Keychain: authn.DefaultKeychain,
+				Out:      ioutil.Discard,
+			}
+		})
+
+		it.After(func() {
+			daemon.Stop(t)
+		})
+
+		it("saves, reads, reuses layers of and deletes images", func() {
+			img, err := factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			found, err := img.Found()
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, false)
+
+			img = factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, img.AddLayer(layerPath))
+			h.AssertNil(t, img.SetLabel("some-label", "some-value"))
+			id, err := img.Save()
+			h.AssertNil(t, err)
+			h.AssertEq(t, daemon.Tags(id), []string{"docker.io/library/some-image:latest"})
+
+			img, err = factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			found, err = img.Found()
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, true)
+			label, err := img.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+
+			rebuilt := factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, rebuilt.ReuseLayer(topLayer))
+			h.AssertNil(t, rebuilt.SetLabel("some-label", "other-value"))
+			_, err = rebuilt.Save()
+			h.AssertNil(t, err)
+
+			img, err = factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			label, err = img.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "other-value")
+			rc, err := img.GetLayer(topLayer)
+			h.AssertNil(t, err)
+			rc.Close()
+
+			h.AssertNil(t, img.Delete())
+			img, err = factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			found, err = img.Found()
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, false)
+		})
+
+		it("returns errors for missing images and layers", func() {
+			img, err := factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			_, err = img.Label("some-label")
+			h.AssertEq(t, errors.Is(err, image.ErrImageNotFound), true)
+
+			img = factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, img.AddLayer(layerPath))
+			_, err = img.Save()
+			h.AssertNil(t, err)
+			img, err = factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			_, err = img.GetLayer("sha256:some-missing-layer")
+			h.AssertEq(t, errors.Is(err, image.ErrLayerNotFound), true)
+			err = img.ReuseLayer("sha256:some-missing-layer")
+			h.AssertEq(t, errors.Is(err, image.ErrLayerNotFound), true)
+		})
+
+		it("tags images, replacing the image the tag was on", func() {
+			img := factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, img.AddLayer(layerPath))
+			firstID, err := img.Save()
+			h.AssertNil(t, err)
+			img, err = factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.Tag("some-image:previous"))
+			h.AssertEq(t, daemon.Tags(firstID), []string{"docker.io/library/some-image:latest", "docker.io/library/some-image:previous"})
+
+			img = factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, img.SetLabel("some-label", "some-value"))
+			secondID, err := img.Save()
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.Tag("some-image:previous"))
+			h.AssertEq(t, daemon.Tags(secondID), []string{"docker.io/library/some-image:latest", "docker.io/library/some-image:previous"})
+
+			img, err = factory.NewLocal(firstID)
+			h.AssertNil(t, err)
+			found, err := img.Found()
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, false)
+		})
+
+		it("reuses the bottom layers of the previous image without saving it", func() {
+			img := factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, img.AddLayer(layerPath))
+			_, err := img.Save()
+			h.AssertNil(t, err)
+			img, err = factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+
+			rebuilt := factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, rebuilt.ReuseLayer(topLayer))
+			_, err = rebuilt.Save()
+			h.AssertNil(t, err)
+			h.AssertEq(t, daemon.Saves(), 0)
+
+			emptyPath := filepath.Join(tmpDir, "empty.tar")
+			h.AssertNil(t, ioutil.WriteFile(emptyPath, make([]byte, 1024), 0644))
+			rebuilt = factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, rebuilt.AddLayer(emptyPath))
+			h.AssertNil(t, rebuilt.ReuseLayer(topLayer))
+			_, err = rebuilt.Save()
+			h.AssertNil(t, err)
+			h.AssertEq(t, daemon.Saves(), 1)
+		})
+
+		it("sends the content of base layers to Podman", func() {
+			daemon.Platform = "Podman Engine"
+
+			img := factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, img.AddLayer(layerPath))
+			_, err := img.Save()
+			h.AssertNil(t, err)
+
+			img, err = factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("some-label", "some-value"))
+			_, err = img.Save()
+			h.AssertNil(t, err)
+			h.AssertEq(t, daemon.Saves(), 1)
+
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			rebuilt := factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, rebuilt.ReuseLayer(topLayer))
+			_, err = rebuilt.Save()
+			h.AssertNil(t, err)
+		})
+
+		it("does not send the content of base layers to Docker", func() {
+			img := factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, img.AddLayer(layerPath))
+			_, err := img.Save()
+			h.AssertNil(t, err)
+
+			img, err = factory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("some-label", "some-value"))
+			_, err = img.Save()
+			h.AssertNil(t, err)
+			h.AssertEq(t, daemon.Saves(), 0)
+		})
+
+		it("ignores the localhost digests Podman records for images that were not pushed", func() {
+			img := factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, img.AddLayer(layerPath))
+			id, err := img.Save()
+			h.AssertNil(t, err)
+			daemon.SetRepoDigests(id, "localhost/some-image@sha256:local-digest", "registry.example.com/some-image@sha256:pushed-digest")
+
+			daemon.Platform = "Podman Engine"
+			podmanFactory := image.Factory{Docker: daemon.Client(t), Keychain: authn.DefaultKeychain, Out: ioutil.Discard}
+			img, err = podmanFactory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			digest, err := img.Digest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, digest, "sha256:pushed-digest")
+
+			daemon.SetRepoDigests(id, "localhost/some-image@sha256:local-digest")
+			img, err = podmanFactory.NewLocal("some-image")
+			h.AssertNil(t, err)
+			digest, err = img.Digest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, digest, "")
+		})
+
+		it("returns the errors the daemon reports while loading images", func() {
+			daemon.LoadError = "some-load-error"
+
+			img := factory.NewEmptyLocal("some-image")
+			h.AssertNil(t, img.AddLayer(layerPath))
+			_, err := img.Save()
+			h.AssertError(t, err, "load image 'some-image': some-load-error")
+		})
+	})
+}
+
+// BenchmarkLocalSave exports images to the fake daemon, so it measures the
+// lifecycle's side of a daemon export without Docker.
+func BenchmarkLocalSave(b *testing.B) {
+	tmpDir, err := ioutil.TempDir("", "lifecycle.fake.bench.")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	daemon := h.NewFakeDaemon()
+	daemon.Start(b)
+	defer daemon.Stop(b)
+	factory := image.Factory{Docker: daemon.Client(b), Keychain: authn.DefaultKeychain, Out: ioutil.Discard}
+
+	for _, set := range h.LayerSets {
+		layerDir := filepath.Join(tmpDir, set.Name)
+		set.Write(b, layerDir)
+		layerPath := layerDir + ".tar"
+		if _, err := archive.WriteTarFile(layerDir, layerPath, 1234, 2345); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(set.Name, func(b *testing.B) {
+			b.SetBytes(set.Bytes())
+			for i := 0; i < b.N; i++ {
+				img := factory.NewEmptyLocal("some-image")
+				if err := img.AddLayer(layerPath); err != nil {
+					b.Fatal(err)
+				}
+				if err := img.SetLabel("some-label", "some-value"); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := img.Save(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -45,9 +45,12 @@ type Image struct {
 	name         string
 	entryPoint   []string
 	cmd          []string
+	user         string
 	base         string
 	createdAt    time.Time
 	layerDir     string
+	streamDir    string
+	tags         []string
 }
 
 func (f *Image) CreatedAt() (time.Time, error) {
@@ -100,6 +103,16 @@ func (f *Image) SetCmd(v ...string) error {
 	return nil
 }
 
+func (f *Image) User() (string, error) {
+	return f.user, nil
+}
+
+func (f *Image) SetUser(user string) error {
+	f.assertNotAlreadySaved()
+	f.user = user
+	return nil
+}
+
 func (f *Image) Env(k string) (string, error) {
 	return f.env[k], nil
 }
@@ -116,6 +129,37 @@ func (f *Image) AddLayer(path string) error {
 	return nil
 }
 
+func (f *Image) AddLayerFromOpener(diffID string, size int64, open image.Opener) error {
+	f.assertNotAlreadySaved()
+
+	if f.streamDir == "" {
+		var err error
+		if f.streamDir, err = ioutil.TempDir("", "fake-image-stream"); err != nil {
+			f.t.Fatalf("failed to create tmpDir: %s", err)
+		}
+	}
+	path := filepath.Join(f.streamDir, strings.TrimPrefix(diffID, "sha256:")+".tar")
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	dst, err := os.Create(path)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	defer dst.Close()
+	if n, err := io.Copy(dst, rc); err != nil {
+		return err
+	} else if n != size {
+		f.t.Fatalf("layer '%s' has size %d, expected %d", diffID, n, size)
+	}
+	if sha := "sha256:" + shaForFile(f.t, path); sha != diffID {
+		f.t.Fatalf("layer has diff ID '%s', expected '%s'", sha, diffID)
+	}
+	return f.AddLayer(path)
+}
+
 func shaForFile(t *testing.T, path string) string {
 	t.Helper()
 
@@ -194,6 +238,11 @@ func (f *Image) copyLayer(path, newPath string) {
 	}
 }
 
+func (f *Image) Tag(name string) error {
+	f.tags = append(f.tags, name)
+	return nil
+}
+
 func (f *Image) Delete() error {
 	f.deleted = true
 	return nil
@@ -209,6 +258,9 @@ func (f *Image) Cleanup() {
 	if err := os.RemoveAll(f.layerDir); err != nil {
 		f.t.Fatal(err)
 	}
+	if err := os.RemoveAll(f.streamDir); err != nil {
+		f.t.Fatal(err)
+	}
 }
 
 func (f *Image) AppLayerPath() string {
@@ -231,6 +283,10 @@ func (f *Image) ReusedLayers() []string {
 	return f.reusedLayers
 }
 
+func (f *Image) Tags() []string {
+	return f.tags
+}
+
 func (f *Image) FindLayerWithPath(path string) string {
 	f.t.Helper()
 
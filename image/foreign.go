@@ -0,0 +1,44 @@
+package image
+
+import (
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// withoutForeignLayers hides foreign layers from v1remote.Write, which
+// would otherwise try to upload them. Windows base images reference their
+// OS layers by URL, and those layers may not be pushed to other registries.
+type withoutForeignLayers struct {
+	v1.Image
+}
+
+func (i withoutForeignLayers) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := i.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	foreign := map[v1.Hash]bool{}
+	for _, desc := range manifest.Layers {
+		if desc.MediaType == types.DockerForeignLayer {
+			foreign[desc.Digest] = true
+		}
+	}
+	if len(foreign) == 0 {
+		return layers, nil
+	}
+	var pushed []v1.Layer
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if !foreign[digest] {
+			pushed = append(pushed, layer)
+		}
+	}
+	return pushed, nil
+}
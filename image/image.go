@@ -15,13 +15,21 @@ type Image interface {
 	SetEnv(string, string) error
 	SetEntrypoint(...string) error
 	SetCmd(...string) error
+	User() (string, error)
+	SetUser(string) error
 	Rebase(string, Image) error
 	AddLayer(path string) error
+	AddLayerFromOpener(diffID string, size int64, open Opener) error
 	ReuseLayer(sha string) error
 	TopLayer() (string, error)
 	Save() (string, error)
+	Tag(name string) error
 	Found() (bool, error)
 	GetLayer(string) (io.ReadCloser, error)
 	Delete() error
 	CreatedAt() (time.Time, error)
 }
+
+// Opener returns a new reader of the same uncompressed layer tar each time
+// it is called, so that layers can be streamed instead of written to disk.
+type Opener func() (io.ReadCloser, error)
@@ -0,0 +1,210 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/image/auth"
+	"github.com/buildpack/lifecycle/progress"
+)
+
+// AddToIndex adds the image repoName@digest to the manifest list indexName,
+// creating the list if it does not exist and replacing any image for the
+// same platform, and returns the list's digest. Images exported for each
+// architecture can be assembled into one multi-arch image this way. The
+// image must be in the same repository as the list.
+func (f *Factory) AddToIndex(indexName, repoName, digest string) (string, error) {
+	ref, authenticator, err := auth.ReferenceForRepoName(f.pushKeychain(), indexName)
+	if err != nil {
+		return "", err
+	}
+	imageRef, err := name.ParseReference(repoName, name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+	if imageRef.Context().Name() != ref.Context().Name() {
+		return "", errors.Errorf("image '%s' is not in the repository of index '%s'", repoName, indexName)
+	}
+	digestRef, err := name.NewDigest(ref.Context().Name()+"@"+digest, name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+	t := &registryTransport{ctx: f.context(), base: http.DefaultTransport, progress: progress.NewTracker(f.Out)}
+	client, err := registryClient(ref, authenticator, t, transport.PushScope)
+	if err != nil {
+		return "", err
+	}
+
+	desc, err := imageDescriptor(client, digestRef)
+	if err != nil {
+		return "", errors.Wrapf(err, "read image '%s'", digestRef)
+	}
+	index, err := getIndex(client, ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "read index '%s'", indexName)
+	}
+	if index == nil {
+		index = &v1.IndexManifest{SchemaVersion: 2, MediaType: types.DockerManifestList}
+	}
+	manifests := []v1.Descriptor{}
+	for _, m := range index.Manifests {
+		if m.Platform == nil || !samePlatform(*m.Platform, *desc.Platform) {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = append(manifests, desc)
+
+	body, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+	if err := putManifest(client, ref, index.MediaType, body); err != nil {
+		return "", errors.Wrapf(err, "push index '%s'", indexName)
+	}
+	indexDigest, _, err := v1.SHA256(bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	return indexDigest.String(), nil
+}
+
+func samePlatform(a, b v1.Platform) bool {
+	return a.OS == b.OS && a.Architecture == b.Architecture && a.Variant == b.Variant
+}
+
+// imageDescriptor describes the image manifest at ref, with the platform in
+// its config.
+func imageDescriptor(client *http.Client, ref name.Digest) (v1.Descriptor, error) {
+	body, mediaType, err := getManifest(client, ref, types.DockerManifestSchema2, types.OCIManifestSchema1)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	manifest, err := v1.ParseManifest(bytes.NewReader(body))
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	resp, err := client.Get(registryURL(ref, "blobs", manifest.Config.Digest.String()))
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	defer resp.Body.Close()
+	if err := transport.CheckError(resp, http.StatusOK); err != nil {
+		return v1.Descriptor{}, err
+	}
+	var config struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return v1.Descriptor{}, errors.Wrap(err, "parse config")
+	}
+	if config.OS == "" || config.Architecture == "" {
+		return v1.Descriptor{}, errors.New("config does not declare an OS and architecture")
+	}
+	hash, err := v1.NewHash(ref.DigestStr())
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	return v1.Descriptor{
+		MediaType: mediaType,
+		Size:      int64(len(body)),
+		Digest:    hash,
+		Platform:  &v1.Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant},
+	}, nil
+}
+
+// getIndex returns the manifest list or image index at ref, or nil if ref
+// does not exist or is an image.
+func getIndex(client *http.Client, ref name.Reference) (*v1.IndexManifest, error) {
+	body, mediaType, err := getManifest(client, ref, types.DockerManifestList, types.OCIImageIndex, types.DockerManifestSchema2, types.OCIManifestSchema1)
+	if err == errManifestUnknown {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if mediaType != types.DockerManifestList && mediaType != types.OCIImageIndex {
+		return nil, nil
+	}
+	index, err := v1.ParseIndexManifest(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if index.MediaType == "" {
+		index.MediaType = mediaType
+	}
+	return index, nil
+}
+
+var errManifestUnknown = errors.New("manifest unknown")
+
+func getManifest(client *http.Client, ref name.Reference, accept ...types.MediaType) ([]byte, types.MediaType, error) {
+	req, err := http.NewRequest(http.MethodGet, registryURL(ref, "manifests", ref.Identifier()), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var accepted []string
+	for _, mediaType := range accept {
+		accepted = append(accepted, string(mediaType))
+	}
+	req.Header.Set("Accept", strings.Join(accepted, ","))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", errManifestUnknown
+	}
+	if err := transport.CheckError(resp, http.StatusOK); err != nil {
+		return nil, "", err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, types.MediaType(resp.Header.Get("Content-Type")), nil
+}
+
+func putManifest(client *http.Client, ref name.Reference, mediaType types.MediaType, body []byte) error {
+	req, err := http.NewRequest(http.MethodPut, registryURL(ref, "manifests", ref.Identifier()), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(mediaType))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return transport.CheckError(resp, http.StatusOK, http.StatusCreated, http.StatusAccepted)
+}
+
+func registryClient(ref name.Reference, authenticator authn.Authenticator, t http.RoundTripper, scope string) (*http.Client, error) {
+	tr, err := transport.New(ref.Context().Registry, authenticator, t, []string{ref.Scope(scope)})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: tr}, nil
+}
+
+func registryURL(ref name.Reference, resource, identifier string) string {
+	u := url.URL{
+		Scheme: ref.Context().Registry.Scheme(),
+		Host:   ref.Context().RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/%s/%s", ref.Context().RepositoryStr(), resource, identifier),
+	}
+	return u.String()
+}
@@ -0,0 +1,135 @@
+package image_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/image"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestIndex(t *testing.T) {
+	spec.Run(t, "Index", testIndex, spec.Report(report.Terminal{}))
+}
+
+// addImage stores an image with a config for the platform, labeled with the
+// tag so that each tag has its own digest, and returns its digest.
+func addImage(t *testing.T, registry *h.FakeRegistry, repo, tag, os, arch string) string {
+	t.Helper()
+	config := []byte(`{"os":"` + os + `","architecture":"` + arch + `","config":{"Labels":{"tag":"` + tag + `"}},"rootfs":{"type":"layers","diff_ids":[]}}`)
+	configDigest, configSize, err := v1.SHA256(bytes.NewReader(config))
+	h.AssertNil(t, err)
+	manifest, err := json.Marshal(&v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.DockerManifestSchema2,
+		Config:        v1.Descriptor{MediaType: types.DockerConfigJSON, Size: configSize, Digest: configDigest},
+		Layers:        []v1.Descriptor{},
+	})
+	h.AssertNil(t, err)
+
+	registry.PutBlob(config)
+	return registry.PutManifest(repo, tag, string(types.DockerManifestSchema2), manifest)
+}
+
+func indexManifest(t *testing.T, registry *h.FakeRegistry, repo, ref string) *v1.IndexManifest {
+	t.Helper()
+	manifest, ok := registry.Manifest(repo, ref)
+	if !ok {
+		t.Fatalf("no manifest '%s:%s'", repo, ref)
+	}
+	h.AssertEq(t, manifest.MediaType, string(types.DockerManifestList))
+	index, err := v1.ParseIndexManifest(bytes.NewReader(manifest.Body))
+	h.AssertNil(t, err)
+	return index
+}
+
+func testIndex(t *testing.T, when spec.G, it spec.S) {
+	var (
+		registry *h.FakeRegistry
+		repo     string
+	)
+
+	it.Before(func() {
+		registry = h.NewFakeRegistry()
+		registry.Start(t)
+		repo = registry.RepoName("some-app")
+	})
+
+	it.After(func() {
+		registry.Stop(t)
+	})
+
+	when("#AddToIndex", func() {
+		it("assembles a list with one image per platform", func() {
+			factory, err := image.NewFactory()
+			h.AssertNil(t, err)
+
+			amd64 := addImage(t, registry, "some-app", "amd64", "linux", "amd64")
+			arm64 := addImage(t, registry, "some-app", "arm64", "linux", "arm64")
+			_, err = factory.AddToIndex(repo+":latest", repo+":amd64", amd64)
+			h.AssertNil(t, err)
+			_, err = factory.AddToIndex(repo+":latest", repo+":arm64", arm64)
+			h.AssertNil(t, err)
+
+			rebuilt := addImage(t, registry, "some-app", "amd64-rebuilt", "linux", "amd64")
+			_, err = factory.AddToIndex(repo+":latest", repo+":amd64-rebuilt", rebuilt)
+			h.AssertNil(t, err)
+
+			index := indexManifest(t, registry, "some-app", "latest")
+			h.AssertEq(t, len(index.Manifests), 2)
+			h.AssertEq(t, index.Manifests[0].Digest.String(), arm64)
+			h.AssertEq(t, index.Manifests[0].Platform.Architecture, "arm64")
+			h.AssertEq(t, index.Manifests[1].Digest.String(), rebuilt)
+			h.AssertEq(t, index.Manifests[1].Platform.Architecture, "amd64")
+		})
+
+		it("rejects images in other repositories", func() {
+			factory, err := image.NewFactory()
+			h.AssertNil(t, err)
+
+			digest := addImage(t, registry, "other-app", "latest", "linux", "amd64")
+			_, err = factory.AddToIndex(repo+":latest", strings.Replace(repo, "some-app", "other-app", 1), digest)
+			h.AssertError(t, err, "is not in the repository of index")
+		})
+	})
+
+	when("#WithPlatform", func() {
+		it("selects the image for the platform from a list", func() {
+			addImage(t, registry, "some-app", "amd64", "linux", "amd64")
+			arm64 := addImage(t, registry, "some-app", "arm64", "linux", "arm64")
+			factory, err := image.NewFactory()
+			h.AssertNil(t, err)
+			_, err = factory.AddToIndex(repo+":latest", repo+":arm64", arm64)
+			h.AssertNil(t, err)
+
+			platform, err := image.ParsePlatform("linux/arm64")
+			h.AssertNil(t, err)
+			factory, err = image.NewFactory(image.WithPlatform(platform))
+			h.AssertNil(t, err)
+			img, err := factory.NewRemote(repo + ":latest")
+			h.AssertNil(t, err)
+			digest, err := img.Digest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, digest, arm64)
+
+			platform, err = image.ParsePlatform("linux/s390x")
+			h.AssertNil(t, err)
+			factory, err = image.NewFactory(image.WithPlatform(platform))
+			h.AssertNil(t, err)
+			_, err = factory.NewRemote(repo + ":latest")
+			h.AssertError(t, err, "no image for platform 'linux/s390x'")
+		})
+
+		it("rejects invalid platforms", func() {
+			_, err := image.ParsePlatform("linux")
+			h.AssertError(t, err, "invalid platform 'linux'")
+		})
+	})
+}
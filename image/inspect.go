@@ -0,0 +1,100 @@
+package image
+
+import (
+	"context"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	dockerclient "github.com/docker/docker/client"
+)
+
+// inspectCache remembers daemon image inspections for the lifetime of a
+// factory, so that an image opened, renamed and reused during a phase is
+// only inspected once. A nil cache inspects every time.
+type inspectCache struct {
+	mu      sync.Mutex
+	entries map[string]types.ImageInspect
+}
+
+func newInspectCache() *inspectCache {
+	return &inspectCache{entries: map[string]types.ImageInspect{}}
+}
+
+// inspect returns the inspection of ref, remembering it if the image exists
+// or is not found. Not found images return an empty inspection and an error
+// satisfying dockerclient.IsErrNotFound.
+func (c *inspectCache) inspect(ctx context.Context, docker DockerClient, ref string) (types.ImageInspect, error) {
+	if c == nil {
+		inspect, _, err := docker.ImageInspectWithRaw(ctx, ref)
+		return inspect, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if inspect, ok := c.entries[ref]; ok {
+		if inspect.ID == "" {
+			return inspect, notFoundError(ref)
+		}
+		return copyInspect(inspect), nil
+	}
+	inspect, _, err := docker.ImageInspectWithRaw(ctx, ref)
+	if err != nil && !dockerclient.IsErrNotFound(err) {
+		return inspect, err
+	}
+	c.entries[ref] = copyInspect(inspect)
+	return inspect, err
+}
+
+// put records that ref now refers to the image described by inspect.
+func (c *inspectCache) put(ref string, inspect types.ImageInspect) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[ref] = copyInspect(inspect)
+}
+
+// forgetID drops every inspection of the image with the given ID.
+func (c *inspectCache) forgetID(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ref, inspect := range c.entries {
+		if inspect.ID == id {
+			delete(c.entries, ref)
+		}
+	}
+}
+
+// copyInspect copies the parts of inspect that local images modify in place.
+func copyInspect(inspect types.ImageInspect) types.ImageInspect {
+	inspect.RootFS.Layers = append([]string(nil), inspect.RootFS.Layers...)
+	if inspect.Config != nil {
+		config := *inspect.Config
+		config.Env = append([]string(nil), config.Env...)
+		if config.Labels != nil {
+			config.Labels = make(map[string]string, len(inspect.Config.Labels))
+			for k, v := range inspect.Config.Labels {
+				config.Labels[k] = v
+			}
+		}
+		inspect.Config = &config
+	}
+	return inspect
+}
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return "No such image: " + string(e)
+}
+
+func (e notFoundError) NotFound() bool {
+	return true
+}
+
+func (e notFoundError) Is(target error) bool {
+	return target == ErrImageNotFound
+}
@@ -23,33 +23,64 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/buildpack/lifecycle/archive"
+	"github.com/buildpack/lifecycle/progress"
 )
 
 type local struct {
+	ctx              context.Context
 	RepoName         string
-	Docker           *dockerclient.Client
+	Docker           DockerClient
 	Inspect          types.ImageInspect
-	layerPaths       []string
+	layers           []localLayer
 	Stdout           io.Writer
 	currentTempImage string
 	prevDir          string
 	prevMap          map[string]string
 	prevOnce         *sync.Once
+	prevLayers       []string
 	easyAddLayers    []string
+	progress         *progress.Tracker
+	inspects         *inspectCache
+
+	// podman is set when the daemon is Podman, which needs the content of
+	// every layer to load an image. Layers of the base image, baseID, are
+	// then saved from the daemon.
+	podman   bool
+	baseID   string
+	baseDir  string
+	baseMap  map[string]string
+	baseOnce *sync.Once
+}
+
+// localLayer is a layer added to a local image, read from path, streamed
+// from open, or read from the previous image when the image is saved.
+// Layers of the base image have none of these.
+type localLayer struct {
+	path   string
+	diffID string
+	size   int64
+	open   Opener
+	prev   bool
 }
 
 func (f *Factory) NewLocal(repoName string) (Image, error) {
-	inspect, _, err := f.Docker.ImageInspectWithRaw(context.Background(), repoName)
+	inspect, err := f.inspects.inspect(f.context(), f.Docker, repoName)
 	if err != nil && !dockerclient.IsErrNotFound(err) {
 		return nil, err
 	}
 
 	return &local{
-		Docker:     f.Docker,
-		RepoName:   repoName,
-		Inspect:    inspect,
-		layerPaths: make([]string, len(inspect.RootFS.Layers)),
-		prevOnce:   &sync.Once{},
+		ctx:      f.context(),
+		Docker:   f.Docker,
+		RepoName: repoName,
+		Inspect:  inspect,
+		layers:   make([]localLayer, len(inspect.RootFS.Layers)),
+		prevOnce: &sync.Once{},
+		progress: progress.NewTracker(f.Out),
+		inspects: f.inspects,
+		podman:   f.isPodman(),
+		baseID:   inspect.ID,
+		baseOnce: &sync.Once{},
 	}, nil
 }
 
@@ -59,16 +90,21 @@ func (f *Factory) NewEmptyLocal(repoName string) Image {
 		Labels: map[string]string{},
 	}
 	return &local{
+		ctx:      f.context(),
 		RepoName: repoName,
 		Docker:   f.Docker,
 		Inspect:  inspect,
 		prevOnce: &sync.Once{},
+		progress: progress.NewTracker(f.Out),
+		inspects: f.inspects,
+		podman:   f.isPodman(),
+		baseOnce: &sync.Once{},
 	}
 }
 
 func (l *local) Label(key string) (string, error) {
 	if l.Inspect.Config == nil {
-		return "", fmt.Errorf("failed to get label, image '%s' does not exist", l.RepoName)
+		return "", notExistError("get label", l.RepoName)
 	}
 	labels := l.Inspect.Config.Labels
 	return labels[key], nil
@@ -76,7 +112,7 @@ func (l *local) Label(key string) (string, error) {
 
 func (l *local) Env(key string) (string, error) {
 	if l.Inspect.Config == nil {
-		return "", fmt.Errorf("failed to get env var, image '%s' does not exist", l.RepoName)
+		return "", notExistError("get env var", l.RepoName)
 	}
 	for _, envVar := range l.Inspect.Config.Env {
 		parts := strings.Split(envVar, "=")
@@ -89,7 +125,7 @@ func (l *local) Env(key string) (string, error) {
 
 func (l *local) Rename(name string) {
 	l.easyAddLayers = nil
-	if prevInspect, _, err := l.Docker.ImageInspectWithRaw(context.TODO(), name); err == nil {
+	if prevInspect, err := l.inspects.inspect(l.ctx, l.Docker, name); err == nil {
 		if l.sameBase(prevInspect) {
 			l.easyAddLayers = prevInspect.RootFS.Layers[len(l.Inspect.RootFS.Layers):]
 		}
@@ -122,14 +158,25 @@ func (l *local) Digest() (string, error) {
 	if found, err := l.Found(); err != nil {
 		return "", errors.Wrap(err, "determining image existence")
 	} else if !found {
-		return "", fmt.Errorf("failed to get digest, image '%s' does not exist", l.RepoName)
+		return "", notExistError("get digest", l.RepoName)
+	}
+	repoDigests := l.Inspect.RepoDigests
+	if l.podman {
+		// Podman records digests for images that were never pushed under
+		// the localhost registry
+		repoDigests = nil
+		for _, d := range l.Inspect.RepoDigests {
+			if !strings.HasPrefix(d, "localhost/") {
+				repoDigests = append(repoDigests, d)
+			}
+		}
 	}
-	if len(l.Inspect.RepoDigests) == 0 {
+	if len(repoDigests) == 0 {
 		return "", nil
 	}
-	parts := strings.Split(l.Inspect.RepoDigests[0], "@")
+	parts := strings.Split(repoDigests[0], "@")
 	if len(parts) != 2 {
-		return "", fmt.Errorf("failed to get digest, image '%s' has malformed digest '%s'", l.RepoName, l.Inspect.RepoDigests[0])
+		return "", fmt.Errorf("failed to get digest, image '%s' has malformed digest '%s'", l.RepoName, repoDigests[0])
 	}
 	return parts[1], nil
 }
@@ -145,7 +192,7 @@ func (l *local) CreatedAt() (time.Time, error) {
 }
 
 func (l *local) Rebase(baseTopLayer string, newBase Image) error {
-	ctx := context.Background()
+	ctx := l.ctx
 
 	// FIND TOP LAYER
 	keepLayers := -1
@@ -160,44 +207,35 @@ func (l *local) Rebase(baseTopLayer string, newBase Image) error {
 	}
 
 	// SWITCH BASE LAYERS
-	newBaseInspect, _, err := l.Docker.ImageInspectWithRaw(ctx, newBase.Name())
-	if err != nil {
-		return errors.Wrap(err, "analyze read previous image config")
-	}
-	l.Inspect.RootFS.Layers = newBaseInspect.RootFS.Layers
-	l.layerPaths = make([]string, len(l.Inspect.RootFS.Layers))
-
-	// SAVE CURRENT IMAGE TO DISK
-	if err := l.prevDownload(); err != nil {
-		return err
-	}
-
-	// READ MANIFEST.JSON
-	b, err := ioutil.ReadFile(filepath.Join(l.prevDir, "manifest.json"))
-	if err != nil {
-		return err
-	}
-	var manifest []struct{ Layers []string }
-	if err := json.Unmarshal(b, &manifest); err != nil {
-		return err
-	}
-	if len(manifest) != 1 {
-		return fmt.Errorf("expected 1 image received %d", len(manifest))
+	var newBaseInspect types.ImageInspect
+	if newBaseLocal, ok := newBase.(*local); ok {
+		newBaseInspect = newBaseLocal.Inspect
+	} else {
+		var err error
+		newBaseInspect, err = l.inspects.inspect(ctx, l.Docker, newBase.Name())
+		if err != nil {
+			return errors.Wrap(err, "analyze read previous image config")
+		}
 	}
+	keep := l.Inspect.RootFS.Layers[len(l.Inspect.RootFS.Layers)-keepLayers:]
+	l.prevLayers = l.Inspect.RootFS.Layers
+	l.Inspect.RootFS.Layers = append([]string{}, newBaseInspect.RootFS.Layers...)
+	l.layers = make([]localLayer, len(l.Inspect.RootFS.Layers))
+	l.resetBase(newBaseInspect.ID)
 
-	// ADD EXISTING LAYERS
-	for _, filename := range manifest[0].Layers[(len(manifest[0].Layers) - keepLayers):] {
-		if err := l.AddLayer(filepath.Join(l.prevDir, filename)); err != nil {
-			return err
-		}
+	// KEEP EXISTING LAYERS, READ FROM THE CURRENT IMAGE ON SAVE
+	for _, diffID := range keep {
+		l.Inspect.RootFS.Layers = append(l.Inspect.RootFS.Layers, diffID)
+		l.layers = append(l.layers, localLayer{diffID: diffID, prev: true})
 	}
+	l.easyAddLayers = nil
 
 	return nil
 }
 
 func (l *local) SetLabel(key, val string) error {
 	if l.Inspect.Config == nil {
-		return fmt.Errorf("failed to set label, image '%s' does not exist", l.RepoName)
+		return notExistError("set label", l.RepoName)
 	}
 	l.Inspect.Config.Labels[key] = val
 	return nil
@@ -205,7 +243,7 @@ func (l *local) SetLabel(key, val string) error {
 
 func (l *local) SetEnv(key, val string) error {
 	if l.Inspect.Config == nil {
-		return fmt.Errorf("failed to set env var, image '%s' does not exist", l.RepoName)
+		return notExistError("set env var", l.RepoName)
 	}
 	l.Inspect.Config.Env = append(l.Inspect.Config.Env, fmt.Sprintf("%s=%s", key, val))
 	return nil
@@ -213,7 +251,7 @@ func (l *local) SetEnv(key, val string) error {
 
 func (l *local) SetEntrypoint(ep ...string) error {
 	if l.Inspect.Config == nil {
-		return fmt.Errorf("failed to set entrypoint, image '%s' does not exist", l.RepoName)
+		return notExistError("set entrypoint", l.RepoName)
 	}
 	l.Inspect.Config.Entrypoint = ep
 	return nil
@@ -221,12 +259,27 @@ func (l *local) SetEntrypoint(ep ...string) error {
 
 func (l *local) SetCmd(cmd ...string) error {
 	if l.Inspect.Config == nil {
-		return fmt.Errorf("failed to set cmd, image '%s' does not exist", l.RepoName)
+		return notExistError("set cmd", l.RepoName)
 	}
 	l.Inspect.Config.Cmd = cmd
 	return nil
 }
 
+func (l *local) User() (string, error) {
+	if l.Inspect.Config == nil {
+		return "", notExistError("get user", l.RepoName)
+	}
+	return l.Inspect.Config.User, nil
+}
+
+func (l *local) SetUser(user string) error {
+	if l.Inspect.Config == nil {
+		return notExistError("set user", l.RepoName)
+	}
+	l.Inspect.Config.User = user
+	return nil
+}
+
 func (l *local) TopLayer() (string, error) {
 	all := l.Inspect.RootFS.Layers
 	topLayer := all[len(all)-1]
@@ -237,7 +290,7 @@ func (l *local) GetLayer(sha string) (io.ReadCloser, error) {
 	l.prevDownload()
 	layerID, ok := l.prevMap[sha]
 	if !ok {
-		return nil, fmt.Errorf("image '%s' does not contain layer with diff ID '%s'", l.RepoName, sha)
+		return nil, withKind(fmt.Errorf("image '%s' does not contain layer with diff ID '%s'", l.RepoName, sha), ErrLayerNotFound)
 	}
 	return os.Open(filepath.Join(l.prevDir, layerID))
 }
@@ -255,54 +308,131 @@ func (l *local) AddLayer(path string) error {
 	sha := hex.EncodeToString(hasher.Sum(make([]byte, 0, hasher.Size())))
 
 	l.Inspect.RootFS.Layers = append(l.Inspect.RootFS.Layers, "sha256:"+sha)
-	l.layerPaths = append(l.layerPaths, path)
+	l.layers = append(l.layers, localLayer{path: path})
 	l.easyAddLayers = nil
 
 	return nil
 }
 
+func (l *local) AddLayerFromOpener(diffID string, size int64, open Opener) error {
+	l.Inspect.RootFS.Layers = append(l.Inspect.RootFS.Layers, diffID)
+	l.layers = append(l.layers, localLayer{diffID: diffID, size: size, open: open})
+	l.easyAddLayers = nil
+	return nil
+}
+
 func (l *local) ReuseLayer(sha string) error {
 	if len(l.easyAddLayers) > 0 && l.easyAddLayers[0] == sha {
 		l.Inspect.RootFS.Layers = append(l.Inspect.RootFS.Layers, sha)
-		l.layerPaths = append(l.layerPaths, "")
+		l.layers = append(l.layers, localLayer{})
 		l.easyAddLayers = l.easyAddLayers[1:]
 		return nil
 	}
 
-	if err := l.prevDownload(); err != nil {
+	prevLayers, err := l.previousLayers()
+	if err != nil {
 		return err
 	}
+	if !contains(prevLayers, sha) {
+		return withKind(fmt.Errorf("SHA %s was not found in %s", sha, l.RepoName), ErrLayerNotFound)
+	}
+
+	if !l.podman && l.extendsPrevious(prevLayers, sha) {
+		// the daemon already has the layer on top of the same layers, so
+		// the previous image doesn't need to be saved to send it again
+		l.Inspect.RootFS.Layers = append(l.Inspect.RootFS.Layers, sha)
+		l.layers = append(l.layers, localLayer{})
+		return nil
+	}
+
+	l.Inspect.RootFS.Layers = append(l.Inspect.RootFS.Layers, sha)
+	l.layers = append(l.layers, localLayer{diffID: sha, prev: true})
+	l.easyAddLayers = nil
+	return nil
+}
+
+// extendsPrevious reports whether the image's layers followed by sha are the
+// bottom layers of the previous image.
+func (l *local) extendsPrevious(prevLayers []string, sha string) bool {
+	n := len(l.Inspect.RootFS.Layers)
+	if n >= len(prevLayers) || prevLayers[n] != sha {
+		return false
+	}
+	for i, layer := range l.Inspect.RootFS.Layers {
+		if layer != prevLayers[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// previousLayers returns the diff IDs of the image currently stored under
+// the image's name, without saving the image from the daemon.
+func (l *local) previousLayers() ([]string, error) {
+	if l.prevLayers == nil {
+		prevInspect, err := l.inspects.inspect(l.ctx, l.Docker, l.RepoName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "inspect previous image '%s'", l.RepoName)
+		}
+		l.prevLayers = prevInspect.RootFS.Layers
+	}
+	return l.prevLayers, nil
+}
 
-	reuseLayer, ok := l.prevMap[sha]
+// prevLayerPath saves the previous image from the daemon, if it has not been
+// already, and returns the path of the layer with the given diff ID.
+func (l *local) prevLayerPath(diffID string) (string, error) {
+	if err := l.prevDownload(); err != nil {
+		return "", err
+	}
+	layerID, ok := l.prevMap[diffID]
 	if !ok {
-		return fmt.Errorf("SHA %s was not found in %s", sha, l.RepoName)
+		return "", withKind(fmt.Errorf("SHA %s was not found in %s", diffID, l.RepoName), ErrLayerNotFound)
 	}
+	return filepath.Join(l.prevDir, layerID), nil
+}
 
-	return l.AddLayer(filepath.Join(l.prevDir, reuseLayer))
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
 }
 
 func (l *local) Save() (string, error) {
-	ctx := context.Background()
+	ctx := l.ctx
 	done := make(chan error)
 
 	t, err := name.NewTag(l.RepoName, name.WeakValidation)
 	if err != nil {
 		return "", err
 	}
-	repoName := t.String()
+	repoName := loadTag(t, l.podman)
+
+	for _, layer := range l.layers {
+		if layer.prev {
+			if err := l.prevDownload(); err != nil {
+				return "", err
+			}
+			break
+		}
+	}
 
 	pr, pw := io.Pipe()
 	defer pw.Close()
 	go func() {
-		res, err := l.Docker.ImageLoad(ctx, pr, true)
+		res, err := l.Docker.ImageLoad(ctx, l.progress.Reader(pr, "Loading "+l.RepoName, 0), true)
 		if err != nil {
+			pr.CloseWithError(err)
 			done <- err
 			return
 		}
 		defer res.Body.Close()
-		io.Copy(ioutil.Discard, res.Body)
-
-		done <- nil
+		err = loadError(res.Body)
+		io.Copy(ioutil.Discard, pr)
+		done <- err
 	}()
 
 	tw := tar.NewWriter(pw)
@@ -319,23 +449,45 @@ func (l *local) Save() (string, error) {
 	}
 
 	var layerPaths []string
-	for _, path := range l.layerPaths {
-		if path == "" {
-			layerPaths = append(layerPaths, "")
-			continue
+	for i, layer := range l.layers {
+		if l.podman && !layer.prev && layer.path == "" && layer.open == nil {
+			if layer.path, err = l.podmanLayerPath(l.Inspect.RootFS.Layers[i]); err != nil {
+				return "", err
+			}
 		}
-		layerName := fmt.Sprintf("/%x.tar", sha256.Sum256([]byte(path)))
-		f, err := os.Open(path)
-		if err != nil {
-			return "", err
+		if layer.prev {
+			if layer.path, err = l.prevLayerPath(layer.diffID); err != nil {
+				return "", err
+			}
 		}
-		defer f.Close()
-		if err := archive.AddFileToTar(tw, layerName, f); err != nil {
-			return "", err
+		switch {
+		case layer.path != "":
+			layerName := fmt.Sprintf("/%x.tar", sha256.Sum256([]byte(layer.path)))
+			f, err := os.Open(layer.path)
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			if err := archive.AddFileToTar(tw, layerName, f); err != nil {
+				return "", err
+			}
+			f.Close()
+			layerPaths = append(layerPaths, layerName)
+		case layer.open != nil:
+			layerName := "/" + strings.TrimPrefix(layer.diffID, "sha256:") + ".tar"
+			rc, err := layer.open()
+			if err != nil {
+				return "", err
+			}
+			err = archive.AddReaderToTar(tw, layerName, layer.size, rc)
+			rc.Close()
+			if err != nil {
+				return "", errors.Wrapf(err, "stream layer '%s'", layer.diffID)
+			}
+			layerPaths = append(layerPaths, layerName)
+		default:
+			layerPaths = append(layerPaths, "")
 		}
-		f.Close()
-		layerPaths = append(layerPaths, layerName)
-
 	}
 
 	manifest, err := json.Marshal([]map[string]interface{}{
@@ -355,7 +507,7 @@ func (l *local) Save() (string, error) {
 
 	tw.Close()
 	pw.Close()
-	err = <-done
+	loadErr := <-done
 
 	if l.prevDir != "" {
 		os.RemoveAll(l.prevDir)
@@ -363,13 +515,21 @@ func (l *local) Save() (string, error) {
 		l.prevMap = nil
 		l.prevOnce = &sync.Once{}
 	}
+	l.prevLayers = nil
+	l.resetBase(l.baseID)
+	if loadErr != nil {
+		return "", errors.Wrapf(loadErr, "load image '%s'", l.RepoName)
+	}
 
-	if _, _, err = l.Docker.ImageInspectWithRaw(context.Background(), imgID); err != nil {
+	saved, _, err := l.Docker.ImageInspectWithRaw(l.ctx, imgID)
+	if err != nil {
 		if dockerclient.IsErrNotFound(err) {
 			return "", fmt.Errorf("save image '%s'", l.RepoName)
 		}
 		return "", err
 	}
+	l.inspects.put(l.RepoName, saved)
+	l.Inspect.ID = saved.ID
 
 	return imgID, err
 }
@@ -387,6 +547,25 @@ func (l *local) configFile() ([]byte, error) {
 	return json.Marshal(imgConfig)
 }
 
+// Tag tags the image with name as well. An image that name tagged before is
+// untagged, and removed if it has no other tags.
+func (l *local) Tag(name string) error {
+	if found, err := l.Found(); err != nil {
+		return errors.Wrap(err, "determining image existence")
+	} else if !found {
+		return notExistError("tag", l.RepoName)
+	}
+	options := dockertypes.ImageRemoveOptions{PruneChildren: true}
+	if _, err := l.Docker.ImageRemove(l.ctx, name, options); err != nil && !dockerclient.IsErrNotFound(err) {
+		return err
+	}
+	if err := l.Docker.ImageTag(l.ctx, l.Inspect.ID, name); err != nil {
+		return err
+	}
+	l.inspects.put(name, l.Inspect)
+	return nil
+}
+
 func (l *local) Delete() error {
 	if found, err := l.Found(); err != nil {
 		return errors.Wrap(err, "determining image existence")
@@ -395,10 +574,11 @@ func (l *local) Delete() error {
 			Force:         true,
 			PruneChildren: true,
 		}
-		_, err := l.Docker.ImageRemove(context.Background(), l.Inspect.ID, options)
+		_, err := l.Docker.ImageRemove(l.ctx, l.Inspect.ID, options)
 		if err != nil {
 			return err
 		}
+		l.inspects.forgetID(l.Inspect.ID)
 	}
 	return nil
 }
@@ -406,76 +586,128 @@ func (l *local) Delete() error {
 func (l *local) prevDownload() error {
 	var outerErr error
 	l.prevOnce.Do(func() {
-		ctx := context.Background()
+		l.prevDir, l.prevMap, outerErr = l.download(l.RepoName)
+	})
+	return outerErr
+}
 
-		tarFile, err := l.Docker.ImageSave(ctx, []string{l.RepoName})
-		if err != nil {
-			outerErr = err
-			return
-		}
-		defer tarFile.Close()
+// resetBase sets the base image and removes any saved copy of the previous
+// one.
+func (l *local) resetBase(id string) {
+	if l.baseDir != "" {
+		os.RemoveAll(l.baseDir)
+		l.baseDir = ""
+		l.baseMap = nil
+	}
+	l.baseID = id
+	l.baseOnce = &sync.Once{}
+}
 
-		l.prevDir, err = ioutil.TempDir("", "packs.local.reuse-layer.")
-		if err != nil {
-			outerErr = errors.Wrap(err, "local reuse-layer create temp dir")
-			return
+// loadError returns the error reported in the JSON messages of an image
+// load response, if any.
+func loadError(body io.Reader) error {
+	dec := json.NewDecoder(body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
 		}
-
-		err = archive.Untar(tarFile, l.prevDir)
-		if err != nil {
-			outerErr = err
-			return
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			// not every daemon responds with JSON messages
+			return nil
 		}
-
-		mf, err := os.Open(filepath.Join(l.prevDir, "manifest.json"))
-		if err != nil {
-			outerErr = err
-			return
+		if msg.Error != "" {
+			return errors.New(msg.Error)
 		}
-		defer mf.Close()
+	}
+}
 
-		var manifest []struct {
-			Config string
-			Layers []string
+// baseDownload saves the base image from the daemon, for daemons that cannot
+// load images referring to layers they already have.
+func (l *local) baseDownload() error {
+	var outerErr error
+	l.baseOnce.Do(func() {
+		l.baseDir, l.baseMap, outerErr = l.download(l.baseID)
+	})
+	return outerErr
+}
+
+// podmanLayerPath returns the path of a layer the image has no content for,
+// saving it from the base or the previous image. Podman, unlike Docker, does
+// not load images with such layers.
+func (l *local) podmanLayerPath(diffID string) (string, error) {
+	if l.baseID != "" {
+		if err := l.baseDownload(); err != nil {
+			return "", err
 		}
-		if err := json.NewDecoder(mf).Decode(&manifest); err != nil {
-			outerErr = err
-			return
+		if layerID, ok := l.baseMap[diffID]; ok {
+			return filepath.Join(l.baseDir, layerID), nil
 		}
+	}
+	return l.prevLayerPath(diffID)
+}
 
-		if len(manifest) != 1 {
-			outerErr = fmt.Errorf("manifest.json had unexpected number of entries: %d", len(manifest))
-			return
-		}
+// download saves ref from the daemon to a temporary directory and returns
+// it, with the paths of the layers in it by diff ID.
+func (l *local) download(ref string) (string, map[string]string, error) {
+	tarFile, err := l.Docker.ImageSave(l.ctx, []string{ref})
+	if err != nil {
+		return "", nil, err
+	}
+	tarFile = l.progress.Reader(tarFile, "Saving "+ref, 0)
+	defer tarFile.Close()
 
-		df, err := os.Open(filepath.Join(l.prevDir, manifest[0].Config))
-		if err != nil {
-			outerErr = err
-			return
-		}
-		defer df.Close()
+	dir, err := ioutil.TempDir("", "packs.local.reuse-layer.")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "local reuse-layer create temp dir")
+	}
 
-		var details struct {
-			RootFS struct {
-				DiffIDs []string `json:"diff_ids"`
-			} `json:"rootfs"`
-		}
+	if err := archive.Untar(tarFile, dir); err != nil {
+		return dir, nil, err
+	}
 
-		if err = json.NewDecoder(df).Decode(&details); err != nil {
-			outerErr = err
-			return
-		}
+	mf, err := os.Open(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return dir, nil, err
+	}
+	defer mf.Close()
 
-		if len(manifest[0].Layers) != len(details.RootFS.DiffIDs) {
-			outerErr = fmt.Errorf("layers and diff IDs do not match, there are %d layers and %d diffIDs", len(manifest[0].Layers), len(details.RootFS.DiffIDs))
-			return
-		}
+	var manifest []struct {
+		Config string
+		Layers []string
+	}
+	if err := json.NewDecoder(mf).Decode(&manifest); err != nil {
+		return dir, nil, err
+	}
 
-		l.prevMap = make(map[string]string, len(manifest[0].Layers))
-		for i, diffID := range details.RootFS.DiffIDs {
-			layerID := manifest[0].Layers[i]
-			l.prevMap[diffID] = layerID
-		}
-	})
-	return outerErr
+	if len(manifest) != 1 {
+		return dir, nil, fmt.Errorf("manifest.json had unexpected number of entries: %d", len(manifest))
+	}
+
+	df, err := os.Open(filepath.Join(dir, manifest[0].Config))
+	if err != nil {
+		return dir, nil, err
+	}
+	defer df.Close()
+
+	var details struct {
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+
+	if err = json.NewDecoder(df).Decode(&details); err != nil {
+		return dir, nil, err
+	}
+
+	if len(manifest[0].Layers) != len(details.RootFS.DiffIDs) {
+		return dir, nil, fmt.Errorf("layers and diff IDs do not match, there are %d layers and %d diffIDs", len(manifest[0].Layers), len(details.RootFS.DiffIDs))
+	}
+
+	layers := make(map[string]string, len(manifest[0].Layers))
+	for i, diffID := range details.RootFS.DiffIDs {
+		layers[diffID] = manifest[0].Layers[i]
+	}
+	return dir, layers, nil
 }
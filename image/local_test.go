@@ -25,15 +25,9 @@ import (
 	h "github.com/buildpack/lifecycle/testhelpers"
 )
 
-var localTestRegistry *h.DockerRegistry
-
 func TestLocal(t *testing.T) {
 	rand.Seed(time.Now().UTC().UnixNano())
-
-	localTestRegistry = h.NewDockerRegistry()
-	localTestRegistry.Start(t)
-	defer localTestRegistry.Stop(t)
-
+	h.SharedRegistry(t)
 	spec.Run(t, "local", testLocal, spec.Parallel(), spec.Report(report.Terminal{}))
 }
 
@@ -82,7 +76,7 @@ func testLocal(t *testing.T, when spec.G, it spec.S) {
 
 		when("image is not available locally", func() {
 			it("returns an error", func() {
-				repoName = "localhost:" + localTestRegistry.Port + "/pack-image-test-" + h.RandString(10)
+				repoName = "localhost:" + h.SharedRegistry(t).Port + "/pack-image-test-" + h.RandString(10)
 
 				localImage, e := factory.NewLocal(repoName)
 				h.AssertNil(t, e)
@@ -302,6 +296,20 @@ func testLocal(t *testing.T, when spec.G, it spec.S) {
 				label = inspect.Config.Labels["somekey"]
 				h.AssertEq(t, strings.TrimSpace(label), "new-val")
 			})
+
+			it("does not share labels with other images opened from the same name", func() {
+				cachingFactory, err := image.NewFactory(image.WithOutWriter(ioutil.Discard))
+				h.AssertNil(t, err)
+				img, err := cachingFactory.NewLocal(repoName)
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.SetLabel("somekey", "new-val"))
+
+				other, err := cachingFactory.NewLocal(repoName)
+				h.AssertNil(t, err)
+				label, err := other.Label("somekey")
+				h.AssertNil(t, err)
+				h.AssertEq(t, label, "")
+			})
 		})
 	})
 
@@ -697,6 +705,11 @@ func testLocal(t *testing.T, when spec.G, it spec.S) {
 			_, err = h.CopySingleFileFromImage(dockerCli, repoName, "layer-2.txt")
 			h.AssertMatch(t, err.Error(), regexp.MustCompile(`Error: No such container:path: .*:layer-2.txt`))
 		})
+
+		it("fails for a layer that is not in the previous image", func() {
+			err := img.ReuseLayer("sha256:not-exist")
+			h.AssertError(t, err, fmt.Sprintf("SHA sha256:not-exist was not found in %s", repoName))
+		})
 	})
 
 	when("#Save", func() {
@@ -0,0 +1,14 @@
+package image_test
+
+import (
+	"os"
+	"testing"
+
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	h.StopSharedRegistry()
+	os.Exit(code)
+}
@@ -0,0 +1,140 @@
+package image_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/archive"
+	h "github.com/buildpack/lifecycle/testhelpers"
+	"github.com/buildpack/lifecycle/testutil"
+)
+
+// TestImageMatrix checks that local and remote images behave the same.
+func TestImageMatrix(t *testing.T) {
+	spec.Run(t, "ImageMatrix", testImageMatrix, spec.Report(report.Terminal{}))
+}
+
+func testImageMatrix(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir    string
+		layerPath string
+		layerSHA  string
+	)
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.matrix.test.")
+		h.AssertNil(t, err)
+		layerDir := filepath.Join(tmpDir, "layer")
+		h.AssertNil(t, os.MkdirAll(layerDir, 0777))
+		h.AssertNil(t, ioutil.WriteFile(filepath.Join(layerDir, "some-file"), []byte("some-contents"), 0666))
+		layerPath = filepath.Join(tmpDir, "layer.tar")
+		layerSHA, err = archive.WriteTarFile(layerDir, layerPath, 1234, 2345)
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	testutil.ImageMatrix(t, when, it, func(target *testutil.ImageTarget) {
+		it("does not find missing images", func() {
+			img, err := target.New(target.RepoName("missing-image"))
+			h.AssertNil(t, err)
+			found, err := img.Found()
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, false)
+		})
+
+		it("saves and reads config and layers", func() {
+			repoName := target.RepoName("some-image")
+			img := target.NewEmpty(repoName)
+			h.AssertNil(t, img.SetLabel("some-label", "some-value"))
+			h.AssertNil(t, img.SetEnv("SOME_VAR", "some-value"))
+			h.AssertNil(t, img.SetUser("some-user"))
+			h.AssertNil(t, img.SetEntrypoint("some-entrypoint"))
+			h.AssertNil(t, img.SetCmd("some-cmd"))
+			h.AssertNil(t, img.AddLayer(layerPath))
+			_, err := img.Save()
+			h.AssertNil(t, err)
+
+			img, err = target.New(repoName)
+			h.AssertNil(t, err)
+			found, err := img.Found()
+			h.AssertNil(t, err)
+			h.AssertEq(t, found, true)
+			label, err := img.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+			env, err := img.Env("SOME_VAR")
+			h.AssertNil(t, err)
+			h.AssertEq(t, env, "some-value")
+			user, err := img.User()
+			h.AssertNil(t, err)
+			h.AssertEq(t, user, "some-user")
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, layerSHA)
+
+			rc, err := img.GetLayer(topLayer)
+			h.AssertNil(t, err)
+			defer rc.Close()
+			restored := filepath.Join(tmpDir, "restored")
+			h.AssertNil(t, archive.Untar(rc, restored))
+			contents, err := ioutil.ReadFile(filepath.Join(restored, tmpDir, "layer", "some-file"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "some-contents")
+		})
+
+		it("returns empty values for missing labels and env vars", func() {
+			img := target.NewEmpty(target.RepoName("some-image"))
+			label, err := img.Label("missing-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "")
+			env, err := img.Env("MISSING_VAR")
+			h.AssertNil(t, err)
+			h.AssertEq(t, env, "")
+		})
+
+		it("reuses layers of the previous image with the same name", func() {
+			repoName := target.RepoName("some-image")
+			img := target.NewEmpty(repoName)
+			h.AssertNil(t, img.AddLayer(layerPath))
+			_, err := img.Save()
+			h.AssertNil(t, err)
+
+			rebuilt := target.NewEmpty(repoName)
+			h.AssertNil(t, rebuilt.ReuseLayer(layerSHA))
+			h.AssertNil(t, rebuilt.SetLabel("some-label", "other-value"))
+			_, err = rebuilt.Save()
+			h.AssertNil(t, err)
+
+			img, err = target.New(repoName)
+			h.AssertNil(t, err)
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, layerSHA)
+			label, err := img.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "other-value")
+		})
+
+		it("fails to reuse layers the previous image does not have", func() {
+			repoName := target.RepoName("some-image")
+			img := target.NewEmpty(repoName)
+			h.AssertNil(t, img.AddLayer(layerPath))
+			_, err := img.Save()
+			h.AssertNil(t, err)
+
+			rebuilt := target.NewEmpty(repoName)
+			if err := rebuilt.ReuseLayer("sha256:0000000000000000000000000000000000000000000000000000000000000000"); err == nil {
+				t.Fatal("expected reusing a missing layer to fail")
+			}
+		})
+	})
+}
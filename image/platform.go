@@ -0,0 +1,85 @@
+package image
+
+import (
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
+)
+
+// Platform is the OS, architecture and optional variant of an image, such as
+// linux/arm64/v8.
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// DefaultPlatform is the platform the lifecycle is running on.
+func DefaultPlatform() Platform {
+	return Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+}
+
+// ParsePlatform parses os/arch[/variant], defaulting to DefaultPlatform.
+func ParsePlatform(platform string) (Platform, error) {
+	if platform == "" {
+		return DefaultPlatform(), nil
+	}
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, errors.Errorf("invalid platform '%s', expected os/arch[/variant]", platform)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// WithPlatform selects the image for platform when a registry image is a
+// manifest list or image index. Without it, registries choose the image.
+func WithPlatform(platform Platform) func(factory *Factory) {
+	return func(factory *Factory) {
+		factory.Platform = &platform
+	}
+}
+
+func (p Platform) matches(other *v1.Platform) bool {
+	return other != nil && other.OS == p.OS && other.Architecture == p.Architecture &&
+		(p.Variant == "" || other.Variant == p.Variant)
+}
+
+// resolvePlatform returns the reference of the image for platform when ref
+// is a manifest list or image index, and ref otherwise. Failures to read the
+// manifest are left to be reported when the image is used.
+func resolvePlatform(ref name.Reference, auth authn.Authenticator, t http.RoundTripper, platform *Platform) (name.Reference, error) {
+	if platform == nil {
+		return ref, nil
+	}
+	client, err := registryClient(ref, auth, t, transport.PullScope)
+	if err != nil {
+		return ref, nil
+	}
+	index, err := getIndex(client, ref)
+	if err != nil || index == nil {
+		return ref, nil
+	}
+	for _, desc := range index.Manifests {
+		if platform.matches(desc.Platform) {
+			return name.NewDigest(ref.Context().Name()+"@"+desc.Digest.String(), name.WeakValidation)
+		}
+	}
+	return nil, errors.Errorf("no image for platform '%s' in '%s'", platform, ref)
+}
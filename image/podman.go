@@ -0,0 +1,52 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// podmanSocket returns the Docker API compatible socket of rootless or
+// rootful Podman, or an empty string when Podman is not listening.
+func podmanSocket() string {
+	var sockets []string
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		sockets = append(sockets, filepath.Join(dir, "podman", "podman.sock"))
+	}
+	sockets = append(sockets, "/run/podman/podman.sock")
+	for _, s := range sockets {
+		if fi, err := os.Stat(s); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			return s
+		}
+	}
+	return ""
+}
+
+// isPodman reports whether the daemon is Podman, asking it once per factory.
+func (f *Factory) isPodman() bool {
+	f.podmanOnce.Do(func() {
+		version, err := f.Docker.ServerVersion(f.context())
+		if err != nil {
+			return
+		}
+		f.podman = strings.Contains(version.Platform.Name, "Podman")
+		for _, c := range version.Components {
+			if strings.Contains(c.Name, "Podman") {
+				f.podman = true
+			}
+		}
+	})
+	return f.podman
+}
+
+// loadTag returns the tag to load an image as. Podman does not normalize
+// index.docker.io to docker.io, so images loaded with it could not be found
+// by their short names.
+func loadTag(t name.Tag, podman bool) string {
+	if podman {
+		return normalizedName(t)
+	}
+	return t.String()
+}
@@ -1,15 +1,20 @@
 package image
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	v1remote "github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
@@ -18,38 +23,83 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/buildpack/lifecycle/image/auth"
+	"github.com/buildpack/lifecycle/progress"
 )
 
 type remote struct {
-	keychain   authn.Keychain
-	RepoName   string
-	Image      v1.Image
-	PrevLayers []v1.Layer
-	prevOnce   *sync.Once
+	keychain     authn.Keychain
+	pushKeychain authn.Keychain
+	transport    http.RoundTripper
+	RepoName     string
+	Image        v1.Image
+	PrevLayers   []v1.Layer
+	prevOnce     *sync.Once
+
+	// containerd, when set, is where the image is saved and its previous
+	// layers are read from, instead of the registry.
+	containerd *containerdStore
+	missing    bool // set for images not found in containerd
+
+	platform         *Platform
+	compressionLevel int
+	artifactType     *ArtifactType
 }
 
 func (f *Factory) NewRemote(repoName string) (Image, error) {
-	image, err := newV1Image(f.Keychain, repoName)
+	transport := &registryTransport{ctx: f.context(), base: http.DefaultTransport, progress: progress.NewTracker(f.Out)}
+	image, err := newV1Image(f.Keychain, transport, repoName, f.Platform)
 	if err != nil {
 		return nil, err
 	}
 
 	return &remote{
-		keychain: f.Keychain,
-		RepoName: repoName,
-		Image:    image,
-		prevOnce: &sync.Once{},
+		keychain:     f.Keychain,
+		pushKeychain: f.pushKeychain(),
+		transport:    transport,
+		RepoName:     repoName,
+		Image:        image,
+		prevOnce:     &sync.Once{},
+		containerd:   f.containerd,
+
+		platform:         f.Platform,
+		compressionLevel: f.CompressionLevel,
+		artifactType:     f.ArtifactType,
 	}, nil
 }
 
-func newV1Image(keychain authn.Keychain, repoName string) (v1.Image, error) {
+// NewEmptyRemote returns an image without layers or configuration, to be
+// saved to repoName.
+func (f *Factory) NewEmptyRemote(repoName string) Image {
+	return &remote{
+		keychain:     f.Keychain,
+		pushKeychain: f.pushKeychain(),
+		transport:    &registryTransport{ctx: f.context(), base: http.DefaultTransport, progress: progress.NewTracker(f.Out)},
+		RepoName:     repoName,
+		Image:        empty.Image,
+		prevOnce:     &sync.Once{},
+		containerd:   f.containerd,
+
+		platform:         f.Platform,
+		compressionLevel: f.CompressionLevel,
+		artifactType:     f.ArtifactType,
+	}
+}
+
+func newV1Image(keychain authn.Keychain, transport http.RoundTripper, repoName string, platform *Platform) (v1.Image, error) {
 	ref, auth, err := auth.ReferenceForRepoName(keychain, repoName)
 	if err != nil {
 		return nil, err
 	}
-	image, err := v1remote.Image(ref, v1remote.WithAuth(auth))
+	ref, err = resolvePlatform(ref, auth, transport, platform)
 	if err != nil {
-		return nil, fmt.Errorf("connect to repo store '%s': %s", repoName, err.Error())
+		return nil, err
+	}
+	image, err := v1remote.Image(ref, v1remote.WithAuth(auth), v1remote.WithTransport(transport))
+	if err != nil {
+		if authErr := authError(err, keychain, repoName, "pull"); authErr != nil {
+			return nil, authErr
+		}
+		return nil, withKind(fmt.Errorf("connect to repo store '%s': %s", repoName, err.Error()), registryErrorKind(err))
 	}
 	return image, nil
 }
@@ -57,7 +107,7 @@ func newV1Image(keychain authn.Keychain, repoName string) (v1.Image, error) {
 func (r *remote) Label(key string) (string, error) {
 	cfg, err := r.Image.ConfigFile()
 	if err != nil || cfg == nil {
-		return "", fmt.Errorf("failed to get label, image '%s' does not exist", r.RepoName)
+		return "", notExistError("get label", r.RepoName)
 	}
 	labels := cfg.Config.Labels
 	return labels[key], nil
@@ -67,7 +117,7 @@ func (r *remote) Label(key string) (string, error) {
 func (r *remote) Env(key string) (string, error) {
 	cfg, err := r.Image.ConfigFile()
 	if err != nil || cfg == nil {
-		return "", fmt.Errorf("failed to get env var, image '%s' does not exist", r.RepoName)
+		return "", notExistError("get env var", r.RepoName)
 	}
 	for _, envVar := range cfg.Config.Env {
 		parts := strings.Split(envVar, "=")
@@ -87,6 +137,9 @@ func (r *remote) Name() string {
 }
 
 func (r *remote) Found() (bool, error) {
+	if r.missing {
+		return false, nil
+	}
 	if _, err := r.Image.RawManifest(); err != nil {
 		if transportErr, ok := err.(*transport.Error); ok && len(transportErr.Errors) > 0 {
 			switch transportErr.Errors[0].Code {
@@ -94,7 +147,10 @@ func (r *remote) Found() (bool, error) {
 				return false, nil
 			}
 		}
-		return false, err
+		if authErr := authError(err, r.keychain, r.RepoName, "pull"); authErr != nil {
+			return false, authErr
+		}
+		return false, registryError(err)
 	}
 	return true, nil
 }
@@ -187,6 +243,25 @@ func (r *remote) SetCmd(cmd ...string) error {
 	return err
 }
 
+func (r *remote) User() (string, error) {
+	cfg, err := r.Image.ConfigFile()
+	if err != nil || cfg == nil {
+		return "", notExistError("get user", r.RepoName)
+	}
+	return cfg.Config.User, nil
+}
+
+func (r *remote) SetUser(user string) error {
+	configFile, err := r.Image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.User = user
+	r.Image, err = mutate.Config(r.Image, config)
+	return err
+}
+
 func (r *remote) TopLayer() (string, error) {
 	all, err := r.Image.Layers()
 	if err != nil {
@@ -200,12 +275,40 @@ func (r *remote) TopLayer() (string, error) {
 	return hex.String(), nil
 }
 
-func (r *remote) GetLayer(string) (io.ReadCloser, error) {
-	panic("not implemented")
+func (r *remote) GetLayer(sha string) (io.ReadCloser, error) {
+	hash, err := v1.NewHash(sha)
+	if err != nil {
+		return nil, err
+	}
+	layer, err := r.Image.LayerByDiffID(hash)
+	if err != nil {
+		if authErr := authError(err, r.keychain, r.RepoName, "pull"); authErr != nil {
+			return nil, authErr
+		}
+		kind := registryErrorKind(err)
+		if kind == nil {
+			kind = ErrLayerNotFound
+		}
+		return nil, withKind(errors.Wrapf(err, "image '%s' does not contain layer with diff ID '%s'", r.RepoName, sha), kind)
+	}
+	return layer.Uncompressed()
 }
 
 func (r *remote) AddLayer(path string) error {
-	layer, err := tarball.LayerFromFile(path)
+	open := func() (io.ReadCloser, error) { return os.Open(path) }
+	layer, err := tarball.LayerFromOpener(tarball.Opener(compressedOpener(open, r.compressionLevel)))
+	if err != nil {
+		return err
+	}
+	r.Image, err = mutate.AppendLayers(r.Image, layer)
+	if err != nil {
+		return errors.Wrap(err, "add layer")
+	}
+	return nil
+}
+
+func (r *remote) AddLayerFromOpener(diffID string, size int64, open Opener) error {
+	layer, err := tarball.LayerFromOpener(tarball.Opener(compressedOpener(open, r.compressionLevel)))
 	if err != nil {
 		return err
 	}
@@ -220,14 +323,16 @@ func (r *remote) ReuseLayer(sha string) error {
 	var outerErr error
 
 	r.prevOnce.Do(func() {
-		prevImage, err := newV1Image(r.keychain, r.RepoName)
+		prevImage, err := r.previous()
 		if err != nil {
 			outerErr = err
 			return
 		}
 		r.PrevLayers, err = prevImage.Layers()
-		if err != nil {
-			outerErr = fmt.Errorf("failed to get layers for previous image with repo name '%s': %s", r.RepoName, err)
+		if authErr := authError(err, r.keychain, r.RepoName, "pull"); authErr != nil {
+			outerErr = authErr
+		} else if err != nil {
+			outerErr = withKind(fmt.Errorf("failed to get layers for previous image with repo name '%s': %s", r.RepoName, err), registryErrorKind(err))
 		}
 	})
 	if outerErr != nil {
@@ -242,6 +347,20 @@ func (r *remote) ReuseLayer(sha string) error {
 	return err
 }
 
+// previous returns the image currently stored under the image's name.
+func (r *remote) previous() (v1.Image, error) {
+	if r.containerd == nil {
+		return newV1Image(r.keychain, r.transport, r.RepoName, r.platform)
+	}
+	image, err := r.containerd.image(r.RepoName)
+	if err != nil {
+		return nil, err
+	} else if image == nil {
+		return nil, withKind(fmt.Errorf("previous image '%s' not found in containerd", r.RepoName), ErrImageNotFound)
+	}
+	return image, nil
+}
+
 func findLayerWithSha(layers []v1.Layer, sha string) (v1.Layer, error) {
 	for _, layer := range layers {
 		diffID, err := layer.DiffID()
@@ -252,25 +371,33 @@ func findLayerWithSha(layers []v1.Layer, sha string) (v1.Layer, error) {
 			return layer, nil
 		}
 	}
-	return nil, fmt.Errorf(`previous image did not have layer with sha '%s'`, sha)
+	return nil, withKind(fmt.Errorf(`previous image did not have layer with sha '%s'`, sha), ErrLayerNotFound)
 }
 
 func (r *remote) Save() (string, error) {
-	ref, auth, err := auth.ReferenceForRepoName(r.keychain, r.RepoName)
+	var err error
+	r.Image, err = mutate.CreatedAt(r.Image, v1.Time{Time: time.Now()})
 	if err != nil {
 		return "", err
 	}
+	if r.containerd != nil {
+		return r.containerd.save(r.RepoName, r.Image)
+	}
 
-	r.Image, err = mutate.CreatedAt(r.Image, v1.Time{Time: time.Now()})
+	ref, auth, err := auth.ReferenceForRepoName(r.pushKeychain, r.RepoName)
 	if err != nil {
 		return "", err
 	}
 
-	if err := v1remote.Write(ref, r.Image, auth, http.DefaultTransport); err != nil {
-		return "", err
+	pushed := r.pushed()
+	if err := v1remote.Write(ref, pushed, auth, r.transport); err != nil {
+		if authErr := authError(err, r.pushKeychain, r.RepoName, "push"); authErr != nil {
+			return "", authErr
+		}
+		return "", registryError(err)
 	}
 
-	hex, err := r.Image.Digest()
+	hex, err := pushed.Digest()
 	if err != nil {
 		return "", err
 	}
@@ -278,6 +405,34 @@ func (r *remote) Save() (string, error) {
 	return hex.String(), nil
 }
 
+// Tag writes the image, as it was read, to the registry with name as well.
+func (r *remote) Tag(name string) error {
+	if r.containerd != nil {
+		_, err := r.containerd.save(name, r.Image)
+		return err
+	}
+	ref, auth, err := auth.ReferenceForRepoName(r.pushKeychain, name)
+	if err != nil {
+		return err
+	}
+	if err := v1remote.Write(ref, r.pushed(), auth, r.transport); err != nil {
+		if authErr := authError(err, r.pushKeychain, name, "push"); authErr != nil {
+			return authErr
+		}
+		return registryError(err)
+	}
+	return nil
+}
+
+// pushed returns the image as it is written to registries.
+func (r *remote) pushed() v1.Image {
+	var img v1.Image = withoutForeignLayers{r.Image}
+	if r.artifactType != nil {
+		img = artifactImage{Image: img, artifactType: *r.artifactType}
+	}
+	return img
+}
+
 func (r *remote) Delete() error {
 	return errors.New("remote image does not implement Delete")
 }
@@ -313,3 +468,48 @@ func (si *subImage) Manifest() (*v1.Manifest, error)         { panic("Not Implem
 func (si *subImage) RawManifest() ([]byte, error)            { panic("Not Implemented") }
 func (si *subImage) LayerByDigest(v1.Hash) (v1.Layer, error) { panic("Not Implemented") }
 func (si *subImage) LayerByDiffID(v1.Hash) (v1.Layer, error) { panic("Not Implemented") }
+
+// registryTransport cancels requests when ctx is done and reports the
+// progress of blob uploads and downloads.
+type registryTransport struct {
+	ctx      context.Context
+	base     http.RoundTripper
+	progress *progress.Tracker
+}
+
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.WithContext(t.ctx)
+	if strings.Contains(req.URL.Path, "/manifests/") && req.Header.Get("Accept") == string(types.DockerManifestSchema2) {
+		// the registry client only accepts Docker manifests, while artifacts
+		// such as cache images have OCI manifests
+		header := http.Header{}
+		for k, v := range req.Header {
+			header[k] = v
+		}
+		header.Add("Accept", string(types.OCIManifestSchema1))
+		req.Header = header
+	}
+	isBlob := strings.Contains(req.URL.Path, "/blobs/")
+	if isBlob && req.Body != nil {
+		req.Body = t.progress.Reader(req.Body, "Pushing "+blobName(req.URL), req.ContentLength)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if isBlob && req.Method == http.MethodGet && resp.StatusCode == http.StatusOK {
+		resp.Body = t.progress.Reader(resp.Body, "Pulling "+blobName(req.URL), resp.ContentLength)
+	}
+	return resp, nil
+}
+
+func blobName(u *url.URL) string {
+	name := u.Query().Get("digest")
+	if name == "" {
+		name = path.Base(u.Path)
+	}
+	if len(name) > 19 && strings.HasPrefix(name, "sha256:") {
+		name = name[7:19]
+	}
+	return name
+}
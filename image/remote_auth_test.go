@@ -0,0 +1,137 @@
+package image_test
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/image"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestRemoteAuth(t *testing.T) {
+	spec.Run(t, "remote auth", testRemoteAuth, spec.Sequential(), spec.Report(report.Terminal{}))
+}
+
+func testRemoteAuth(t *testing.T, when spec.G, it spec.S) {
+	for _, auth := range []struct {
+		desc string
+		op   h.RegistryOption
+	}{
+		{"basic auth", h.WithBasicAuth("some-user", "some-password")},
+		{"token auth", h.WithTokenAuth("some-user", "some-password")},
+	} {
+		auth := auth
+		when(auth.desc, func() {
+			var (
+				registry  *h.DockerRegistry
+				configDir string
+				layerPath string
+				repoName  string
+				oldEnv    map[string]string
+			)
+
+			setEnv := func(key, value string) {
+				if _, ok := oldEnv[key]; !ok {
+					oldEnv[key] = os.Getenv(key)
+				}
+				h.AssertNil(t, os.Setenv(key, value))
+			}
+
+			it.Before(func() {
+				registry = h.NewDockerRegistry(auth.op)
+				registry.Start(t)
+				repoName = "localhost:" + registry.Port + "/pack-image-test-" + h.RandString(10)
+
+				var err error
+				configDir, err = ioutil.TempDir("", "lifecycle.remote-auth.test.")
+				h.AssertNil(t, err)
+				oldEnv = map[string]string{}
+				setEnv("DOCKER_CONFIG", configDir)
+
+				layerPath = filepath.Join(configDir, "layer.tar")
+				layer, err := h.CreateSingleFileTar("some-file", "some-content")
+				h.AssertNil(t, err)
+				f, err := os.Create(layerPath)
+				h.AssertNil(t, err)
+				_, err = f.ReadFrom(layer)
+				h.AssertNil(t, err)
+				h.AssertNil(t, f.Close())
+			})
+
+			it.After(func() {
+				for key, value := range oldEnv {
+					os.Setenv(key, value)
+				}
+				registry.Stop(t)
+				os.RemoveAll(configDir)
+			})
+
+			save := func() error {
+				factory := image.Factory{Keychain: authn.DefaultKeychain, Out: ioutil.Discard}
+				img := factory.NewEmptyRemote(repoName)
+				h.AssertNil(t, img.AddLayer(layerPath))
+				h.AssertNil(t, img.SetLabel("some-label", "some-value"))
+				if _, err := img.Save(); err != nil {
+					return err
+				}
+				img, err := factory.NewRemote(repoName)
+				h.AssertNil(t, err)
+				label, err := img.Label("some-label")
+				h.AssertNil(t, err)
+				h.AssertEq(t, label, "some-value")
+				return nil
+			}
+
+			it("uses credentials from the docker config", func() {
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(configDir, "config.json"), []byte(fmt.Sprintf(
+					`{"auths":{"localhost:%s":{"auth":"%s"}}}`,
+					registry.Port, base64.StdEncoding.EncodeToString([]byte("some-user:some-password")),
+				)), 0666))
+				h.AssertNil(t, save())
+			})
+
+			it("uses credentials from a credential helper", func() {
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(configDir, "docker-credential-test"), []byte(
+					`#!/bin/sh
+echo '{"ServerURL":"","Username":"some-user","Secret":"some-password"}'
+`), 0777))
+				setEnv("PATH", configDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(configDir, "config.json"), []byte(fmt.Sprintf(
+					`{"credHelpers":{"localhost:%s":"test"}}`, registry.Port,
+				)), 0666))
+				h.AssertNil(t, save())
+			})
+
+			it("fails without credentials", func() {
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{}`), 0666))
+				if err := save(); err == nil {
+					t.Fatal("expected save to fail")
+				}
+			})
+		})
+	}
+
+	when("tls", func() {
+		it("serves a certificate signed by the registry CA", func() {
+			registry := h.NewDockerRegistry(h.WithTLS())
+			registry.Start(t)
+			defer registry.Stop(t)
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: registry.CertPool()}}}
+			resp, err := client.Get("https://localhost:" + registry.Port + "/v2/")
+			h.AssertNil(t, err)
+			defer resp.Body.Close()
+			h.AssertEq(t, resp.StatusCode, http.StatusOK)
+		})
+	})
+}
@@ -24,23 +24,15 @@ import (
 	h "github.com/buildpack/lifecycle/testhelpers"
 )
 
-var registryPort string
-
 func TestRemote(t *testing.T) {
 	rand.Seed(time.Now().UTC().UnixNano())
-
-	dockerRegistry := h.NewDockerRegistry()
-	dockerRegistry.Start(t)
-	defer dockerRegistry.Stop(t)
-
-	registryPort = dockerRegistry.Port
-
+	h.SharedRegistry(t)
 	spec.Run(t, "remote", testRemote, spec.Sequential(), spec.Report(report.Terminal{}))
 }
 
 func testRemote(t *testing.T, when spec.G, it spec.S) {
 	var factory image.Factory
-	var repoName string
+	var repoName, registryPort string
 	var dockerCli *dockerClient.Client
 
 	it.Before(func() {
@@ -51,6 +43,7 @@ func testRemote(t *testing.T, when spec.G, it spec.S) {
 			Docker:   dockerCli,
 			Keychain: authn.DefaultKeychain,
 		}
+		registryPort = h.SharedRegistry(t).Port
 		repoName = "localhost:" + registryPort + "/pack-image-test-" + h.RandString(10)
 	})
 
@@ -58,14 +51,8 @@ func testRemote(t *testing.T, when spec.G, it spec.S) {
 		when("image exists", func() {
 			var img image.Image
 			it.Before(func() {
-				h.CreateImageOnRemote(t, dockerCli, repoName, fmt.Sprintf(`
-					FROM scratch
-					LABEL repo_name_for_randomisation=%s
-					LABEL mykey=myvalue other=data
-				`, repoName), nil)
-
 				var err error
-				img, err = factory.NewRemote(repoName)
+				img, err = factory.NewRemote(h.SharedFixture(t, "labels", h.ImageFixture{Labels: map[string]string{"mykey": "myvalue", "other": "data"}}))
 				h.AssertNil(t, err)
 			})
 
@@ -95,27 +82,20 @@ func testRemote(t *testing.T, when spec.G, it spec.S) {
 
 	when("#Env", func() {
 		when("image exists", func() {
+			var img image.Image
 			it.Before(func() {
-				h.CreateImageOnRemote(t, dockerCli, repoName, fmt.Sprintf(`
-					FROM scratch
-					LABEL repo_name_for_randomisation=%s
-					ENV MY_VAR=my_val
-				`, repoName), nil)
+				var err error
+				img, err = factory.NewRemote(h.SharedFixture(t, "env", h.ImageFixture{Env: []string{"MY_VAR=my_val"}}))
+				h.AssertNil(t, err)
 			})
 
 			it("returns the label value", func() {
-				img, err := factory.NewRemote(repoName)
-				h.AssertNil(t, err)
-
 				val, err := img.Env("MY_VAR")
 				h.AssertNil(t, err)
 				h.AssertEq(t, val, "my_val")
 			})
 
 			it("returns an empty string for a missing label", func() {
-				img, err := factory.NewRemote(repoName)
-				h.AssertNil(t, err)
-
 				val, err := img.Env("MISSING_VAR")
 				h.AssertNil(t, err)
 				h.AssertEq(t, val, "")
@@ -172,11 +152,7 @@ func testRemote(t *testing.T, when spec.G, it spec.S) {
 		var img image.Image
 		when("image exists", func() {
 			it.Before(func() {
-				h.CreateImageOnRemote(t, dockerCli, repoName, fmt.Sprintf(`
-					FROM scratch
-					LABEL repo_name_for_randomisation=%s
-					LABEL mykey=myvalue other=data
-				`, repoName), nil)
+				h.ImageFixture{Labels: map[string]string{"mykey": "myvalue", "other": "data"}}.Push(t, repoName)
 
 				var err error
 				img, err = factory.NewRemote(repoName)
@@ -208,11 +184,7 @@ func testRemote(t *testing.T, when spec.G, it spec.S) {
 		)
 		it.Before(func() {
 			var err error
-			h.CreateImageOnRemote(t, dockerCli, repoName, fmt.Sprintf(`
-					FROM scratch
-					LABEL repo_name_for_randomisation=%s
-					LABEL some-key=some-value
-				`, repoName), nil)
+			h.ImageFixture{Labels: map[string]string{"some-key": "some-value"}}.Push(t, repoName)
 			img, err = factory.NewRemote(repoName)
 			h.AssertNil(t, err)
 		})
@@ -240,10 +212,7 @@ func testRemote(t *testing.T, when spec.G, it spec.S) {
 		)
 		it.Before(func() {
 			var err error
-			h.CreateImageOnRemote(t, dockerCli, repoName, fmt.Sprintf(`
-					FROM scratch
-					LABEL repo_name_for_randomisation=%s
-				`, repoName), nil)
+			h.ImageFixture{}.Push(t, repoName)
 			img, err = factory.NewRemote(repoName)
 			h.AssertNil(t, err)
 		})
@@ -271,10 +240,7 @@ func testRemote(t *testing.T, when spec.G, it spec.S) {
 		)
 		it.Before(func() {
 			var err error
-			h.CreateImageOnRemote(t, dockerCli, repoName, fmt.Sprintf(`
-					FROM scratch
-					LABEL repo_name_for_randomisation=%s
-				`, repoName), nil)
+			h.ImageFixture{}.Push(t, repoName)
 			img, err = factory.NewRemote(repoName)
 			h.AssertNil(t, err)
 		})
@@ -563,10 +529,7 @@ func testRemote(t *testing.T, when spec.G, it spec.S) {
 	when("#Found", func() {
 		when("it exists", func() {
 			it.Before(func() {
-				h.CreateImageOnRemote(t, dockerCli, repoName, fmt.Sprintf(`
-					FROM scratch
-					LABEL repo_name_for_randomisation=%s
-				`, repoName), nil)
+				h.ImageFixture{}.Push(t, repoName)
 			})
 
 			it("returns true, nil", func() {
@@ -8,7 +8,9 @@ import (
 	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
 
+	"github.com/buildpack/lifecycle/archive"
 	"github.com/buildpack/lifecycle/metadata"
 )
 
@@ -61,6 +63,16 @@ func cached(l bpLayer) bool {
 	return err == nil && md.Cache
 }
 
+func notLaunch(l bpLayer) bool {
+	md, err := l.read()
+	return err == nil && !md.Launch
+}
+
+func notCached(l bpLayer) bool {
+	md, err := l.read()
+	return err == nil && !md.Cache
+}
+
 func (bd *bpLayersDir) findLayers(f func(layer bpLayer) bool) []bpLayer {
 	var selectedLayers []bpLayer
 	for _, l := range bd.layers {
@@ -90,29 +102,38 @@ const (
 	cacheMalformed
 )
 
+func reuseReason(sha, previousSHA string) string {
+	switch {
+	case previousSHA == "":
+		return fmt.Sprintf("no previous SHA, adding SHA %s", sha)
+	case sha == previousSHA:
+		return fmt.Sprintf("SHA %s matches previous SHA, reusing", sha)
+	default:
+		return fmt.Sprintf("SHA %s does not match previous SHA %s, adding", sha, previousSHA)
+	}
+}
+
 type bpLayer struct {
 	layer
 }
 
-func (bp *bpLayer) classifyCache(metadataLayers map[string]metadata.LayerMetadata) cacheType {
+// classifyCache also returns the reason for the classification, for debug output.
+func (bp *bpLayer) classifyCache(metadataLayers map[string]metadata.LayerMetadata) (cacheType, string) {
 	cachedLayer, err := bp.read()
 	if err != nil {
-		return cacheMalformed
+		return cacheMalformed, fmt.Sprintf("cannot read layer metadata: %s", err)
 	}
 	if !cachedLayer.Launch {
-		return cacheNotForLaunch
-	}
-	if metadataLayers == nil {
-		return cacheStaleNoMetadata
+		return cacheNotForLaunch, "launch=false, previous image cannot tell whether it is stale"
 	}
 	layerMetadata, ok := metadataLayers[bp.name()]
 	if !ok {
-		return cacheStaleNoMetadata
+		return cacheStaleNoMetadata, "launch=true, but previous image has no metadata for it"
 	}
 	if layerMetadata.SHA != cachedLayer.SHA {
-		return cacheStaleWrongSHA
+		return cacheStaleWrongSHA, fmt.Sprintf("cached SHA %s does not match previous image SHA %s", cachedLayer.SHA, layerMetadata.SHA)
 	}
-	return cacheValid
+	return cacheValid, fmt.Sprintf("cached SHA %s matches previous image", cachedLayer.SHA)
 }
 
 func (bp *bpLayer) read() (metadata.LayerMetadata, error) {
@@ -125,9 +146,12 @@ func (bp *bpLayer) read() (metadata.LayerMetadata, error) {
 		return metadata.LayerMetadata{}, err
 	}
 	defer fh.Close()
-	if _, err := toml.DecodeFile(tomlPath, &data); err != nil {
+	if err := DecodeFile(tomlPath, &data, "metadata"); err != nil {
 		return metadata.LayerMetadata{}, err
 	}
+	if err := data.Validate(); err != nil {
+		return metadata.LayerMetadata{}, errors.Wrapf(err, "invalid layer metadata in '%s'", tomlPath)
+	}
 	sha, err := ioutil.ReadFile(bp.path + ".sha")
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -147,6 +171,9 @@ func (bp *bpLayer) remove() error {
 	if err := os.Remove(bp.path + ".sha"); err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	if err := os.Remove(bp.path + ".restored"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	if err := os.Remove(bp.path + ".toml"); err != nil && !os.IsNotExist(err) {
 		return err
 	}
@@ -155,6 +182,9 @@ func (bp *bpLayer) remove() error {
 
 func (bp *bpLayer) writeMetadata(metadataLayers map[string]metadata.LayerMetadata) error {
 	layerMetadata := metadataLayers[bp.name()]
+	if err := layerMetadata.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid metadata for layer '%s'", bp.Identifier())
+	}
 	path := filepath.Join(bp.path + ".toml")
 	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
 		return err
@@ -180,6 +210,31 @@ func (bp *bpLayer) writeSha(sha string) error {
 	return nil
 }
 
+// writeRestored records the state of the layer's files after it was
+// restored, so that the exporter can tell whether they were modified since.
+func (bp *bpLayer) writeRestored() error {
+	fingerprint, err := archive.ChangeFingerprint(bp.path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(bp.path+".restored", []byte(fingerprint), 0666)
+}
+
+// modifiedSinceRestore reports whether a layer restored with a SHA may have
+// been modified since, because its files changed or their state was not
+// recorded.
+func (bp *bpLayer) modifiedSinceRestore() bool {
+	if _, err := os.Stat(bp.path + ".sha"); err != nil {
+		return false
+	}
+	recorded, err := ioutil.ReadFile(bp.path + ".restored")
+	if err != nil {
+		return true
+	}
+	current, err := archive.ChangeFingerprint(bp.path)
+	return err != nil || current != string(recorded)
+}
+
 func (bp *bpLayer) name() string {
 	return filepath.Base(bp.path)
 }
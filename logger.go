@@ -0,0 +1,144 @@
+package lifecycle
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/buildpack/lifecycle/progress"
+)
+
+// Logger is satisfied by most structured logging libraries (e.g. a logrus
+// Logger or a zap SugaredLogger) without an adapter.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// DefaultLogger writes debug and info messages to Out, and warnings and
+// errors to Err. Debug messages are dropped unless DebugEnabled is set.
+// When Color is set, headers, buildpack names, warnings and errors are
+// highlighted with ANSI escape codes. OnWarn, if set, is also called with
+// each warning, uncolored, so that platforms can collect them.
+type DefaultLogger struct {
+	Out, Err     *log.Logger
+	DebugEnabled bool
+	Color        bool
+	OnWarn       func(message string)
+}
+
+// NewDefaultLogger enables color when out is a terminal.
+func NewDefaultLogger(out, err io.Writer) *DefaultLogger {
+	return &DefaultLogger{
+		Out:   log.New(out, "", 0),
+		Err:   log.New(err, "", 0),
+		Color: progress.IsTerminal(out),
+	}
+}
+
+// SetPrefix sets the prefix of every line written by the logger.
+func (l *DefaultLogger) SetPrefix(prefix string) {
+	l.Out.SetPrefix(prefix)
+	l.Err.SetPrefix(prefix)
+}
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorBlue   = "\033[34m"
+	colorCyan   = "\033[1;36m"
+)
+
+// logHeader and logBuildpack mark arguments that DefaultLogger highlights.
+// Other loggers format them as plain strings.
+type logHeader string
+type logBuildpack string
+
+func (l *DefaultLogger) Debugf(format string, v ...interface{}) {
+	if l.DebugEnabled {
+		l.Out.Printf(format, l.colorArgs(v)...)
+	}
+}
+
+func (l *DefaultLogger) Infof(format string, v ...interface{}) {
+	l.Out.Printf(format, l.colorArgs(v)...)
+}
+
+func (l *DefaultLogger) Warnf(format string, v ...interface{}) {
+	if l.OnWarn != nil {
+		l.OnWarn(fmt.Sprintf(format, v...))
+	}
+	l.Err.Printf(l.color(colorYellow, "Warning: ")+format, l.colorArgs(v)...)
+}
+
+func (l *DefaultLogger) Errorf(format string, v ...interface{}) {
+	l.Err.Printf(l.color(colorRed, "Error: ")+format, l.colorArgs(v)...)
+}
+
+func (l *DefaultLogger) colorArgs(v []interface{}) []interface{} {
+	if !l.Color {
+		return v
+	}
+	out := make([]interface{}, len(v))
+	for i, arg := range v {
+		switch arg := arg.(type) {
+		case logHeader:
+			out[i] = l.color(colorCyan, string(arg))
+		case logBuildpack:
+			out[i] = l.color(colorBlue, string(arg))
+		default:
+			out[i] = arg
+		}
+	}
+	return out
+}
+
+func (l *DefaultLogger) color(code, s string) string {
+	if !l.Color {
+		return s
+	}
+	return fmt.Sprint(code, s, colorReset)
+}
+
+// LogPrefix expands the {id}, {version} and {name} placeholders in format
+// with the buildpack's fields.
+func (bp *Buildpack) LogPrefix(format string) string {
+	return strings.NewReplacer("{id}", bp.ID, "{version}", bp.Version, "{name}", bp.Name).Replace(format)
+}
+
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	mid    bool
+}
+
+// prefixLines returns a writer that writes prefix to w at the start of every
+// line, or w itself when prefix is empty.
+func prefixLines(w io.Writer, prefix string) io.Writer {
+	if prefix == "" {
+		return w
+	}
+	return &prefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	out := make([]byte, 0, len(b)+len(p.prefix))
+	for _, c := range b {
+		if !p.mid {
+			out = append(out, p.prefix...)
+			p.mid = true
+		}
+		out = append(out, c)
+		if c == '\n' {
+			p.mid = false
+		}
+	}
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,74 @@
+package lifecycle_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestLogger(t *testing.T) {
+	spec.Run(t, "Logger", testLogger, spec.Report(report.Terminal{}))
+}
+
+func testLogger(t *testing.T, when spec.G, it spec.S) {
+	var (
+		logger         *lifecycle.DefaultLogger
+		stdout, stderr *bytes.Buffer
+	)
+
+	it.Before(func() {
+		stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+		logger = lifecycle.NewDefaultLogger(stdout, stderr)
+	})
+
+	when("#NewDefaultLogger", func() {
+		it("disables color when out is not a terminal", func() {
+			h.AssertEq(t, logger.Color, false)
+		})
+	})
+
+	when("color is disabled", func() {
+		it("writes plain prefixes", func() {
+			logger.Infof("some-info")
+			logger.Warnf("some-%s", "warning")
+			logger.Errorf("some-error")
+			h.AssertEq(t, stdout.String(), "some-info\n")
+			h.AssertEq(t, stderr.String(), "Warning: some-warning\nError: some-error\n")
+		})
+
+		it("calls OnWarn with each warning", func() {
+			var warnings []string
+			logger.OnWarn = func(message string) { warnings = append(warnings, message) }
+			logger.Warnf("some-%s", "warning")
+			logger.Warnf("other-warning")
+			h.AssertEq(t, warnings, []string{"some-warning", "other-warning"})
+			h.AssertEq(t, stderr.String(), "Warning: some-warning\nWarning: other-warning\n")
+		})
+
+		it("drops debug messages unless enabled", func() {
+			logger.Debugf("some-debug")
+			h.AssertEq(t, stdout.String(), "")
+
+			logger.DebugEnabled = true
+			logger.Debugf("some-debug")
+			h.AssertEq(t, stdout.String(), "some-debug\n")
+		})
+	})
+
+	when("color is enabled", func() {
+		it.Before(func() {
+			logger.Color = true
+		})
+
+		it("colors warning and error prefixes", func() {
+			logger.Warnf("some-warning")
+			logger.Errorf("some-error")
+			h.AssertEq(t, stderr.String(), "\033[33mWarning: \033[0msome-warning\n\033[31mError: \033[0msome-error\n")
+		})
+	})
+}
@@ -3,23 +3,18 @@ package lifecycle
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 
-	"github.com/BurntSushi/toml"
 	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/archive"
+	"github.com/buildpack/lifecycle/buildpack"
 )
 
 const buildpackVersionLatest = "latest"
 
 type BuildpackMap map[string]*Buildpack
 
-type buildpackTOML struct {
-	Buildpack struct {
-		ID      string `toml:"id"`
-		Version string `toml:"version"`
-		Name    string `toml:"name"`
-	} `toml:"buildpack"`
-}
-
 func NewBuildpackMap(dir string) (BuildpackMap, error) {
 	buildpacks := BuildpackMap{}
 	glob := filepath.Join(dir, "*", "*", "buildpack.toml")
@@ -29,27 +24,64 @@ func NewBuildpackMap(dir string) (BuildpackMap, error) {
 	}
 	for _, file := range files {
 		buildpackDir := filepath.Dir(file)
-		var bpTOML buildpackTOML
-		if _, err := toml.DecodeFile(file, &bpTOML); err != nil {
+		descriptor, err := buildpack.Read(file)
+		if err != nil {
 			return nil, err
 		}
 
 		_, version := filepath.Split(buildpackDir)
-		key := bpTOML.Buildpack.ID + "@" + version
+		key := descriptor.Buildpack.ID + "@" + version
 		if version != buildpackVersionLatest {
-			key = bpTOML.Buildpack.ID + "@" + bpTOML.Buildpack.Version
+			key = descriptor.Buildpack.ID + "@" + descriptor.Buildpack.Version
 		}
 
 		buildpacks[key] = &Buildpack{
-			ID:      bpTOML.Buildpack.ID,
-			Version: bpTOML.Buildpack.Version,
-			Name:    bpTOML.Buildpack.Name,
+			ID:      descriptor.Buildpack.ID,
+			Version: descriptor.Buildpack.Version,
+			Name:    descriptor.Buildpack.Name,
+			API:     descriptor.API,
+			Stacks:  descriptor.Stacks,
 			Dir:     buildpackDir,
 		}
 	}
 	return buildpacks, nil
 }
 
+// AddExtensions adds the extensions in dir to the map. Extensions are
+// referenced in order and group files with extension = true.
+func (m BuildpackMap) AddExtensions(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*", "extension.toml"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		extensionDir := filepath.Dir(file)
+		descriptor, err := buildpack.ReadExtension(file)
+		if err != nil {
+			return err
+		}
+
+		_, version := filepath.Split(extensionDir)
+		key := descriptor.Buildpack.ID + "@" + version
+		if version != buildpackVersionLatest {
+			key = descriptor.Buildpack.ID + "@" + descriptor.Buildpack.Version
+		}
+
+		m[extensionKeyPrefix+key] = &Buildpack{
+			ID:        descriptor.Buildpack.ID,
+			Version:   descriptor.Buildpack.Version,
+			Name:      descriptor.Buildpack.Name,
+			API:       descriptor.API,
+			Stacks:    descriptor.Stacks,
+			Dir:       extensionDir,
+			Extension: true,
+		}
+	}
+	return nil
+}
+
+const extensionKeyPrefix = "extension:"
+
 func (m BuildpackMap) lookup(l []*Buildpack) ([]*Buildpack, error) {
 	out := make([]*Buildpack, 0, len(l))
 	for _, b := range l {
@@ -57,22 +89,53 @@ func (m BuildpackMap) lookup(l []*Buildpack) ([]*Buildpack, error) {
 		if b.Version == "" {
 			ref += "latest"
 		}
+		kind := "buildpack"
+		if b.Extension {
+			ref = extensionKeyPrefix + ref
+			kind = "extension"
+		}
 		if bp, ok := m[ref]; ok {
 			bp := *bp
-			bp.Optional = b.Optional
+			// extensions never fail detection of their group
+			bp.Optional = b.Optional || bp.Extension
+			bp.Digest = b.Digest
+			if err := bp.verify(); err != nil {
+				return nil, err
+			}
 			out = append(out, &bp)
 		} else {
-			return nil, fmt.Errorf("buildpack '%s' missing from image", ref)
+			return nil, fmt.Errorf("%s '%s' missing from image", kind, strings.TrimPrefix(ref, extensionKeyPrefix))
 		}
 	}
 	return out, nil
 }
 
+// verify checks the contents of the buildpack directory against the digest
+// expected by the order or group, if any, so that a modified buildpack is
+// never executed.
+func (bp *Buildpack) verify() error {
+	if bp.Digest == "" {
+		return nil
+	}
+	kind := "buildpack"
+	if bp.Extension {
+		kind = "extension"
+	}
+	digest, err := archive.ContentDigest(bp.Dir)
+	if err != nil {
+		return errors.Wrapf(err, "digest %s '%s'", kind, bp.ID)
+	}
+	if digest != bp.Digest {
+		return fmt.Errorf("%s '%s@%s' has digest '%s', expected '%s'", kind, bp.ID, bp.Version, digest, bp.Digest)
+	}
+	return nil
+}
+
 func (m BuildpackMap) ReadOrder(orderPath string) (BuildpackOrder, error) {
 	var order struct {
-		Groups BuildpackOrder `toml:"groups"`
+		Groups BuildpackOrder `toml:"groups" json:"groups"`
 	}
-	if _, err := toml.DecodeFile(orderPath, &order); err != nil {
+	if err := DecodeFile(orderPath, &order); err != nil {
 		return nil, err
 	}
 
@@ -91,22 +154,30 @@ func (m BuildpackMap) ReadOrder(orderPath string) (BuildpackOrder, error) {
 
 func (g *BuildpackGroup) Write(path string) error {
 	data := struct {
-		Buildpacks []*Buildpack `toml:"buildpacks"`
+		Buildpacks []*Buildpack `toml:"buildpacks" json:"buildpacks"`
+		Extensions []*Buildpack `toml:"extensions,omitempty" json:"extensions,omitempty"`
 	}{
 		Buildpacks: g.Buildpacks,
+		Extensions: g.Extensions,
 	}
-	return WriteTOML(path, data)
+	return WriteFile(path, data)
 }
 
 func (m BuildpackMap) ReadGroup(path string) (*BuildpackGroup, error) {
 	var group BuildpackGroup
 	var err error
-	if _, err := toml.DecodeFile(path, &group); err != nil {
+	if err := DecodeFile(path, &group); err != nil {
 		return nil, err
 	}
 	group.Buildpacks, err = m.lookup(group.Buildpacks)
 	if err != nil {
 		return nil, errors.Wrap(err, "lookup buildpacks")
 	}
+	if len(group.Extensions) > 0 {
+		group.Extensions, err = m.lookup(group.Extensions)
+		if err != nil {
+			return nil, errors.Wrap(err, "lookup extensions")
+		}
+	}
 	return &group, nil
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/sclevine/spec/report"
 
 	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/lifecycle/archive"
 )
 
 func TestMap(t *testing.T) {
@@ -111,6 +112,46 @@ func testMap(t *testing.T, when spec.G, it spec.S) {
 			}
 		})
 
+		when("order specifies buildpack digests", func() {
+			var m lifecycle.BuildpackMap
+
+			it.Before(func() {
+				bpDir := filepath.Join(tmpDir, "buildpack1", "version1.1")
+				mkdir(t, filepath.Join(bpDir, "bin"))
+				mkfile(t, "some-script", filepath.Join(bpDir, "bin", "detect"))
+				m = lifecycle.BuildpackMap{
+					"buildpack1@version1.1": {ID: "buildpack1", Version: "version1.1", Dir: bpDir},
+				}
+			})
+
+			it("accepts buildpacks with matching contents", func() {
+				digest, err := archive.ContentDigest(m["buildpack1@version1.1"].Dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				mkfile(t, fmt.Sprintf(`groups = [{ buildpacks = [{id = "buildpack1", version = "version1.1", digest = "%s"}] }]`, digest),
+					filepath.Join(tmpDir, "order.toml"),
+				)
+				actual, err := m.ReadOrder(filepath.Join(tmpDir, "order.toml"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if actual[0].Buildpacks[0].Digest != digest {
+					t.Fatalf("Expected digest to be kept: %s", actual[0].Buildpacks[0].Digest)
+				}
+			})
+
+			it("returns an error for buildpacks that do not match", func() {
+				mkfile(t, `groups = [{ buildpacks = [{id = "buildpack1", version = "version1.1", digest = "sha256:some-digest"}] }]`,
+					filepath.Join(tmpDir, "order.toml"),
+				)
+				_, err := m.ReadOrder(filepath.Join(tmpDir, "order.toml"))
+				if err == nil || !strings.Contains(err.Error(), "expected 'sha256:some-digest'") {
+					t.Fatalf("Expected digest mismatch error, got: %v", err)
+				}
+			})
+		})
+
 		when("order references a missing buildpack", func() {
 			it("returns an error", func() {
 				m := lifecycle.BuildpackMap{
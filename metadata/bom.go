@@ -0,0 +1,36 @@
+package metadata
+
+import "sort"
+
+// BOMEntry describes a dependency contributed to the app image. Buildpack is
+// empty for entries that were only requested in the build plan.
+type BOMEntry struct {
+	Name      string                 `toml:"name" json:"name"`
+	Version   string                 `toml:"version,omitempty" json:"version,omitempty"`
+	Buildpack string                 `toml:"buildpack,omitempty" json:"buildpack,omitempty"`
+	Metadata  map[string]interface{} `toml:"metadata,omitempty" json:"metadata,omitempty"`
+}
+
+// MergeBOM merges boms in order, so that later entries replace earlier
+// entries with the same name and version. The result is sorted by name and
+// version.
+func MergeBOM(boms ...[]BOMEntry) []BOMEntry {
+	type key struct{ name, version string }
+	merged := map[key]BOMEntry{}
+	for _, bom := range boms {
+		for _, entry := range bom {
+			merged[key{entry.Name, entry.Version}] = entry
+		}
+	}
+	out := make([]BOMEntry, 0, len(merged))
+	for _, entry := range merged {
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Version < out[j].Version
+	})
+	return out
+}
@@ -0,0 +1,37 @@
+package metadata_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/metadata"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestBOM(t *testing.T) {
+	spec.Run(t, "BOM", testBOM, spec.Report(report.Terminal{}))
+}
+
+func testBOM(t *testing.T, when spec.G, it spec.S) {
+	when(".MergeBOM", func() {
+		it("replaces entries with the same name and version with later entries", func() {
+			merged := metadata.MergeBOM(
+				[]metadata.BOMEntry{
+					{Name: "dep-b", Version: "1.0", Buildpack: "buildpack1"},
+					{Name: "dep-a", Version: "2.0", Buildpack: "buildpack1"},
+				},
+				[]metadata.BOMEntry{
+					{Name: "dep-a", Version: "2.0", Buildpack: "buildpack2"},
+					{Name: "dep-a", Version: "1.0", Buildpack: "buildpack2"},
+				},
+			)
+			h.AssertEq(t, merged, []metadata.BOMEntry{
+				{Name: "dep-a", Version: "1.0", Buildpack: "buildpack2"},
+				{Name: "dep-a", Version: "2.0", Buildpack: "buildpack2"},
+				{Name: "dep-b", Version: "1.0", Buildpack: "buildpack1"},
+			})
+		})
+	})
+}
@@ -0,0 +1,51 @@
+package metadata_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/buildpack/lifecycle/metadata"
+)
+
+// The fuzz targets parse label values read from images, which may have been
+// written by anyone. They only check that malformed values fail to parse
+// instead of panicking.
+
+func FuzzParseAppMetadata(f *testing.F) {
+	f.Add([]byte(`{"app": {"sha": "some-sha"}, "buildpacks": [{"key": "some-buildpack", "layers": {"some-layer": {"sha": "some-layer-sha", "launch": true}}}]}`))
+	f.Add([]byte(`{"schemaVersion": 2, "runImage": {"topLayer": "some-layer", "reference": "some-ref"}}`))
+	f.Add([]byte(`{"schemaVersion": -1}`))
+	f.Add([]byte(`null`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		metadata.ParseAppMetadata(data)
+	})
+}
+
+func FuzzParseMixins(f *testing.F) {
+	f.Add([]byte(`["some-mixin", "run:other-mixin"]`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		metadata.ParseMixins(data)
+	})
+}
+
+func FuzzDecodeCompressedLabel(f *testing.F) {
+	f.Add([]byte("gzip+base64:H4sIAAAAAAACA6tWSiwoULJSqFYqzkgE0krF+bmpuiB2bS0A3KpPXBwAAAA="))
+	f.Add([]byte("gzip+base64:"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		metadata.DecodeCompressedLabel(data)
+	})
+}
+
+func FuzzParseOverflowLayer(f *testing.F) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	contents := []byte(`{"app": {"sha": "some-sha"}}`)
+	tw.WriteHeader(&tar.Header{Name: "cnb/metadata/some-label.json", Mode: 0644, Size: int64(len(contents))})
+	tw.Write(contents)
+	tw.Close()
+	f.Add(buf.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		metadata.ParseOverflowLayer("some-label", data)
+	})
+}
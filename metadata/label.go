@@ -0,0 +1,184 @@
+package metadata
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/image"
+)
+
+// DefaultLabelPrefix namespaces the labels read and written by the lifecycle.
+const DefaultLabelPrefix = "io.buildpacks"
+
+// LabelPrefix replaces DefaultLabelPrefix in label names, so that platforms
+// can namespace lifecycle metadata under their own prefix.
+var LabelPrefix = DefaultLabelPrefix
+
+// LabelName returns label with DefaultLabelPrefix replaced by LabelPrefix.
+func LabelName(label string) string {
+	if !strings.HasPrefix(label, DefaultLabelPrefix+".") {
+		return label
+	}
+	return LabelPrefix + strings.TrimPrefix(label, DefaultLabelPrefix)
+}
+
+// DefaultLabelSizeLimit keeps metadata labels well within the image config
+// sizes accepted by daemons and registries.
+const DefaultLabelSizeLimit = 64 * 1024
+
+// MaxDecompressedLabelSize bounds the contents of compressed labels read from
+// images, which may be untrusted. Metadata that SetLabel compressed within a
+// label size limit is far smaller.
+const MaxDecompressedLabelSize = 32 * 1024 * 1024
+
+const (
+	gzipLabelPrefix  = "gzip+base64:"
+	layerLabelPrefix = "layer:"
+	overflowDir      = "cnb/metadata"
+)
+
+// SetLabel sets label on img to contents. Contents larger than limit are
+// compressed, and if still too large, written to a layer in dir that the
+// label refers to by diff ID. GetRawMetadata reverses either encoding.
+// The label name is namespaced with LabelName.
+func SetLabel(img image.Image, label string, contents []byte, limit int, dir string) error {
+	label = LabelName(label)
+	if len(contents) <= limit {
+		return img.SetLabel(label, string(contents))
+	}
+	compressed, err := compressLabel(contents)
+	if err != nil {
+		return errors.Wrapf(err, "compress label '%s'", label)
+	}
+	if len(compressed) <= limit {
+		return img.SetLabel(label, compressed)
+	}
+	tarPath := filepath.Join(dir, label+".tar")
+	sha, err := writeOverflowLayer(tarPath, label, contents)
+	if err != nil {
+		return errors.Wrapf(err, "write overflow layer for label '%s'", label)
+	}
+	if err := img.AddLayer(tarPath); err != nil {
+		return errors.Wrapf(err, "add overflow layer for label '%s'", label)
+	}
+	return img.SetLabel(label, layerLabelPrefix+sha)
+}
+
+func compressLabel(contents []byte) (string, error) {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(contents); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return gzipLabelPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func writeOverflowLayer(tarPath, label string, contents []byte) (string, error) {
+	f, err := os.Create(tarPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(f, hasher))
+	for _, dir := range []string{"cnb", overflowDir} {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0755}); err != nil {
+			return "", err
+		}
+	}
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: overflowPath(label), Mode: 0644, Size: int64(len(contents))}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(contents); err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func overflowPath(label string) string {
+	return overflowDir + "/" + label + ".json"
+}
+
+func decodeLabel(img image.Image, label, value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, gzipLabelPrefix):
+		contents, err := DecodeCompressedLabel([]byte(value))
+		return string(contents), err
+	case strings.HasPrefix(value, layerLabelPrefix):
+		rc, err := img.GetLayer(strings.TrimPrefix(value, layerLabelPrefix))
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		layer, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return "", err
+		}
+		contents, err := ParseOverflowLayer(label, layer)
+		return string(contents), err
+	default:
+		return value, nil
+	}
+}
+
+// DecodeCompressedLabel returns the contents of a label value that SetLabel
+// compressed. It fails on contents larger than MaxDecompressedLabelSize.
+func DecodeCompressedLabel(value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, []byte(gzipLabelPrefix)) {
+		return nil, fmt.Errorf("label value is not prefixed with '%s'", gzipLabelPrefix)
+	}
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(value)-len(gzipLabelPrefix)))
+	n, err := base64.StdEncoding.Decode(data, value[len(gzipLabelPrefix):])
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data[:n]))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	contents, err := ioutil.ReadAll(io.LimitReader(zr, MaxDecompressedLabelSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) > MaxDecompressedLabelSize {
+		return nil, fmt.Errorf("compressed label decompresses to more than %d bytes", MaxDecompressedLabelSize)
+	}
+	return contents, nil
+}
+
+// ParseOverflowLayer returns the contents of label in the uncompressed
+// overflow layer that SetLabel wrote for it.
+func ParseOverflowLayer(label string, layer []byte) ([]byte, error) {
+	tr := tar.NewReader(bytes.NewReader(layer))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("overflow layer has no '%s'", overflowPath(label))
+		} else if err != nil {
+			return nil, err
+		}
+		if strings.TrimPrefix(hdr.Name, "/") == overflowPath(label) {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package metadata_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/image/fakes"
+	"github.com/buildpack/lifecycle/metadata"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestLabel(t *testing.T) {
+	spec.Run(t, "Label", testLabel, spec.Report(report.Terminal{}))
+}
+
+func testLabel(t *testing.T, when spec.G, it spec.S) {
+	var (
+		img      *fakes.Image
+		tmpDir   string
+		contents string
+	)
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.metadata.label")
+		h.AssertNil(t, err)
+		img = fakes.NewImage(t, "some-image", "", "")
+		contents = `{"buildpacks": [` + strings.Repeat(`{"key": "some-buildpack"},`, 100) + `{}]}`
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	when("#SetLabel", func() {
+		it("sets contents within the limit as is", func() {
+			h.AssertNil(t, metadata.SetLabel(img, "some-label", []byte(contents), len(contents), tmpDir))
+
+			value, err := img.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, value, contents)
+			h.AssertEq(t, img.NumberOfAddedLayers(), 0)
+		})
+
+		it("compresses contents over the limit", func() {
+			h.AssertNil(t, metadata.SetLabel(img, "some-label", []byte(contents), len(contents)-1, tmpDir))
+
+			value, err := img.Label("some-label")
+			h.AssertNil(t, err)
+			if !strings.HasPrefix(value, "gzip+base64:") || len(value) >= len(contents) {
+				t.Fatalf("Expected a compressed label, got %s", value)
+			}
+			h.AssertEq(t, img.NumberOfAddedLayers(), 0)
+
+			raw, err := metadata.GetRawMetadata(img, "some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, raw, contents)
+		})
+
+		it("moves contents to a layer when compressed contents are over the limit", func() {
+			h.AssertNil(t, metadata.SetLabel(img, "some-label", []byte(contents), 10, tmpDir))
+
+			value, err := img.Label("some-label")
+			h.AssertNil(t, err)
+			if !strings.HasPrefix(value, "layer:sha256:") {
+				t.Fatalf("Expected a layer reference, got %s", value)
+			}
+			h.AssertEq(t, img.NumberOfAddedLayers(), 1)
+
+			raw, err := metadata.GetRawMetadata(img, "some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, raw, contents)
+		})
+	})
+
+	when("#DecodeCompressedLabel", func() {
+		// compressedZeros returns a compressed label value with n zero bytes.
+		compressedZeros := func(n int) []byte {
+			buf := &bytes.Buffer{}
+			zw := gzip.NewWriter(buf)
+			chunk := make([]byte, 1024*1024)
+			for ; n > 0; n -= len(chunk) {
+				if n < len(chunk) {
+					chunk = chunk[:n]
+				}
+				_, err := zw.Write(chunk)
+				h.AssertNil(t, err)
+			}
+			h.AssertNil(t, zw.Close())
+			return []byte("gzip+base64:" + base64.StdEncoding.EncodeToString(buf.Bytes()))
+		}
+
+		it("decodes contents up to the maximum size", func() {
+			contents, err := metadata.DecodeCompressedLabel(compressedZeros(metadata.MaxDecompressedLabelSize))
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(contents), metadata.MaxDecompressedLabelSize)
+		})
+
+		it("fails on contents over the maximum size", func() {
+			_, err := metadata.DecodeCompressedLabel(compressedZeros(metadata.MaxDecompressedLabelSize + 1))
+			h.AssertError(t, err, "compressed label decompresses to more than")
+		})
+	})
+
+	when("a label prefix is configured", func() {
+		it.Before(func() {
+			metadata.LabelPrefix = "com.example"
+		})
+
+		it.After(func() {
+			metadata.LabelPrefix = metadata.DefaultLabelPrefix
+		})
+
+		it("namespaces lifecycle labels with the prefix", func() {
+			h.AssertEq(t, metadata.LabelName(metadata.AppMetadataLabel), "com.example.lifecycle.metadata")
+			h.AssertEq(t, metadata.LabelName("some-label"), "some-label")
+
+			h.AssertNil(t, metadata.SetLabel(img, metadata.AppMetadataLabel, []byte(contents), len(contents), tmpDir))
+
+			value, err := img.Label("com.example.lifecycle.metadata")
+			h.AssertNil(t, err)
+			h.AssertEq(t, value, contents)
+
+			raw, err := metadata.GetRawMetadata(img, metadata.AppMetadataLabel)
+			h.AssertNil(t, err)
+			h.AssertEq(t, raw, contents)
+		})
+	})
+}
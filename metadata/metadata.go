@@ -2,21 +2,46 @@ package metadata
 
 import (
 	"encoding/json"
+	"fmt"
 
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/pkg/errors"
 
 	"github.com/buildpack/lifecycle/image"
 )
 
-const AppMetadataLabel = "io.buildpacks.lifecycle.metadata"
+const (
+	AppMetadataLabel = "io.buildpacks.lifecycle.metadata"
+	BuildIDLabel     = "io.buildpacks.lifecycle.build-id"
+	StackIDLabel     = "io.buildpacks.stack.id"
+	MixinsLabel      = "io.buildpacks.stack.mixins"
+
+	// StackDeprecatedLabel is "true" or the date the stack was deprecated,
+	// and StackEOLLabel is the date it reaches end of life. Dates are
+	// YYYY-MM-DD or RFC 3339.
+	StackDeprecatedLabel = "io.buildpacks.stack.deprecated"
+	StackEOLLabel        = "io.buildpacks.stack.eol"
+
+	// AppMetadataVersion is the schema version of the app metadata label
+	// written by this lifecycle. Labels without a version have version 1.
+	AppMetadataVersion = 2
+)
+
+// migrations[v] converts raw app metadata from schema version v to v+1.
+var migrations = map[int]func(raw map[string]interface{}) error{
+	// version 2 adds the schema version, the layout is unchanged
+	1: func(raw map[string]interface{}) error { return nil },
+}
 
 type AppImageMetadata struct {
-	App        AppMetadata         `json:"app"`
-	Config     ConfigMetadata      `json:"config"`
-	Launcher   LauncherMetadata    `json:"launcher"`
-	Buildpacks []BuildpackMetadata `json:"buildpacks"`
-	RunImage   RunImageMetadata    `json:"runImage"`
-	Stack      StackMetadata       `json:"stack"`
+	SchemaVersion int                 `json:"schemaVersion"`
+	App           AppMetadata         `json:"app"`
+	Config        ConfigMetadata      `json:"config"`
+	Launcher      LauncherMetadata    `json:"launcher"`
+	Buildpacks    []BuildpackMetadata `json:"buildpacks"`
+	RunImage      RunImageMetadata    `json:"runImage"`
+	Stack         StackMetadata       `json:"stack"`
+	BOM           []BOMEntry          `json:"bom,omitempty"`
 }
 
 type AppMetadata struct {
@@ -45,6 +70,18 @@ type LayerMetadata struct {
 	Cache  bool        `json:"cache" toml:"cache"`
 }
 
+// Validate returns an error describing why the layer metadata cannot be
+// written to or read from a layer TOML file.
+func (l LayerMetadata) Validate() error {
+	if l.Data == nil {
+		return nil
+	}
+	if _, ok := l.Data.(map[string]interface{}); !ok {
+		return fmt.Errorf("metadata must be a table, got %T", l.Data)
+	}
+	return nil
+}
+
 type RunImageMetadata struct {
 	TopLayer string `json:"topLayer"`
 	SHA      string `json:"sha"`
@@ -68,18 +105,69 @@ func (m *AppImageMetadata) MetadataForBuildpack(id string) BuildpackMetadata {
 	return BuildpackMetadata{}
 }
 
+// IncompatibleError is returned for app metadata that cannot be read by this
+// lifecycle. The metadata returned with it is empty.
+type IncompatibleError struct {
+	Err error
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("incompatible metadata: %s", e.Err)
+}
+
 func GetAppMetadata(image image.Image) (AppImageMetadata, error) {
 	contents, err := GetRawMetadata(image, AppMetadataLabel)
 	if err != nil {
 		return AppImageMetadata{}, err
 	}
+	return ParseAppMetadata([]byte(contents))
+}
 
+// ParseAppMetadata migrates contents from its schema version to
+// AppMetadataVersion before decoding it.
+func ParseAppMetadata(contents []byte) (AppImageMetadata, error) {
+	if len(contents) == 0 {
+		return AppImageMetadata{}, nil
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(contents, &raw); err != nil {
+		return AppImageMetadata{}, &IncompatibleError{Err: err}
+	}
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+	version := 1
+	if v, ok := raw["schemaVersion"].(float64); ok {
+		version = int(v)
+		if v < 1 || v != float64(version) {
+			return AppImageMetadata{}, &IncompatibleError{Err: fmt.Errorf("invalid schema version %v", v)}
+		}
+	}
+	if version > AppMetadataVersion {
+		return AppImageMetadata{}, &IncompatibleError{Err: fmt.Errorf("schema version %d is newer than supported version %d", version, AppMetadataVersion)}
+	}
+	for ; version < AppMetadataVersion; version++ {
+		if err := migrations[version](raw); err != nil {
+			return AppImageMetadata{}, &IncompatibleError{Err: errors.Wrapf(err, "migrate schema version %d", version)}
+		}
+	}
+	raw["schemaVersion"] = AppMetadataVersion
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return AppImageMetadata{}, err
+	}
 	meta := AppImageMetadata{}
-	_ = json.Unmarshal([]byte(contents), &meta)
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return AppImageMetadata{}, &IncompatibleError{Err: err}
+	}
 	return meta, nil
 }
 
+// GetRawMetadata returns the decoded contents of the label, namespaced with
+// LabelName.
 func GetRawMetadata(image image.Image, metadataLabel string) (string, error) {
+	metadataLabel = LabelName(metadataLabel)
 	if found, err := image.Found(); err != nil {
 		return "", err
 	} else if !found {
@@ -89,5 +177,52 @@ func GetRawMetadata(image image.Image, metadataLabel string) (string, error) {
 	if err != nil {
 		return "", errors.Wrapf(err, "retrieving label '%s' for image '%s'", metadataLabel, image.Name())
 	}
+	contents, err = decodeLabel(image, metadataLabel, contents)
+	if err != nil {
+		return "", errors.Wrapf(err, "decoding label '%s' for image '%s'", metadataLabel, image.Name())
+	}
 	return contents, nil
 }
+
+// ParseMixins parses the value of a stack image's mixins label, a JSON array
+// of mixin names.
+func ParseMixins(value []byte) ([]string, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	var mixins []string
+	if err := json.Unmarshal(value, &mixins); err != nil {
+		return nil, errors.Wrapf(err, "parse mixins '%s'", value)
+	}
+	return mixins, nil
+}
+
+// RunImageForRegistry returns the run image or mirror in the same registry as
+// appImageRef, or the run image if none of them are.
+func (s StackMetadata) RunImageForRegistry(appImageRef string) (string, error) {
+	if s.RunImage.Image == "" {
+		return "", errors.New("stack has no run image")
+	}
+	registry, err := registryOf(appImageRef)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse app image '%s'", appImageRef)
+	}
+	for _, img := range append([]string{s.RunImage.Image}, s.RunImage.Mirrors...) {
+		r, err := registryOf(img)
+		if err != nil {
+			return "", errors.Wrapf(err, "parse run image '%s'", img)
+		}
+		if r == registry {
+			return img, nil
+		}
+	}
+	return s.RunImage.Image, nil
+}
+
+func registryOf(ref string) (string, error) {
+	r, err := name.ParseReference(ref, name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+	return r.Context().RegistryStr(), nil
+}
@@ -0,0 +1,122 @@
+package metadata_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/metadata"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestMetadata(t *testing.T) {
+	spec.Run(t, "Metadata", testMetadata, spec.Report(report.Terminal{}))
+}
+
+func testMetadata(t *testing.T, when spec.G, it spec.S) {
+	when("#ParseAppMetadata", func() {
+		it("returns empty metadata for an empty label", func() {
+			meta, err := metadata.ParseAppMetadata(nil)
+			h.AssertNil(t, err)
+			h.AssertEq(t, meta.SchemaVersion, 0)
+		})
+
+		it("migrates metadata without a schema version", func() {
+			meta, err := metadata.ParseAppMetadata([]byte(`{"app": {"sha": "some-sha"}, "buildpacks": [{"key": "some-buildpack", "layers": {"some-layer": {"sha": "some-layer-sha", "launch": true}}}]}`))
+			h.AssertNil(t, err)
+			h.AssertEq(t, meta.SchemaVersion, metadata.AppMetadataVersion)
+			h.AssertEq(t, meta.App.SHA, "some-sha")
+			h.AssertEq(t, meta.MetadataForBuildpack("some-buildpack").Layers["some-layer"].SHA, "some-layer-sha")
+		})
+
+		it("returns an incompatible error for a newer schema version", func() {
+			_, err := metadata.ParseAppMetadata([]byte(`{"schemaVersion": 99}`))
+			if _, ok := err.(*metadata.IncompatibleError); !ok {
+				t.Fatalf("Expected an incompatible error, got: %v", err)
+			}
+			h.AssertError(t, err, "schema version 99 is newer than supported version 2")
+		})
+
+		it("returns an incompatible error for an invalid schema version", func() {
+			_, err := metadata.ParseAppMetadata([]byte(`{"schemaVersion": -1}`))
+			if _, ok := err.(*metadata.IncompatibleError); !ok {
+				t.Fatalf("Expected an incompatible error, got: %v", err)
+			}
+			h.AssertError(t, err, "invalid schema version -1")
+		})
+
+		it("parses null metadata as empty", func() {
+			meta, err := metadata.ParseAppMetadata([]byte(`null`))
+			h.AssertNil(t, err)
+			h.AssertEq(t, meta.SchemaVersion, metadata.AppMetadataVersion)
+		})
+
+		it("returns an incompatible error for malformed metadata", func() {
+			_, err := metadata.ParseAppMetadata([]byte(`{["bad", "metadata"]}`))
+			if _, ok := err.(*metadata.IncompatibleError); !ok {
+				t.Fatalf("Expected an incompatible error, got: %v", err)
+			}
+		})
+	})
+	when("LayerMetadata#Validate", func() {
+		it("accepts layers without metadata", func() {
+			h.AssertNil(t, metadata.LayerMetadata{Launch: true, Cache: true}.Validate())
+		})
+
+		it("accepts a metadata table", func() {
+			h.AssertNil(t, metadata.LayerMetadata{Data: map[string]interface{}{"some-key": "some-value"}}.Validate())
+		})
+
+		it("rejects metadata that is not a table", func() {
+			err := metadata.LayerMetadata{Data: "some-value"}.Validate()
+			h.AssertError(t, err, "metadata must be a table, got string")
+		})
+	})
+
+	when(".ParseMixins", func() {
+		it("parses a JSON array of mixins", func() {
+			mixins, err := metadata.ParseMixins([]byte(`["some-mixin", "run:other-mixin"]`))
+			h.AssertNil(t, err)
+			h.AssertEq(t, mixins, []string{"some-mixin", "run:other-mixin"})
+		})
+
+		it("returns no mixins for an empty label", func() {
+			mixins, err := metadata.ParseMixins(nil)
+			h.AssertNil(t, err)
+			h.AssertEq(t, mixins, []string(nil))
+		})
+	})
+
+	when("StackMetadata#RunImageForRegistry", func() {
+		var stack metadata.StackMetadata
+
+		it.Before(func() {
+			stack = metadata.StackMetadata{RunImage: metadata.StackRunImageMetadata{
+				Image:   "some-registry.io/run",
+				Mirrors: []string{"gcr.io/some/run", "index.docker.io/some/run"},
+			}}
+		})
+
+		it("returns the mirror in the app image's registry", func() {
+			runImage, err := stack.RunImageForRegistry("gcr.io/some/app:latest")
+			h.AssertNil(t, err)
+			h.AssertEq(t, runImage, "gcr.io/some/run")
+
+			runImage, err = stack.RunImageForRegistry("some/app")
+			h.AssertNil(t, err)
+			h.AssertEq(t, runImage, "index.docker.io/some/run")
+		})
+
+		it("returns the run image when no mirror matches", func() {
+			runImage, err := stack.RunImageForRegistry("other-registry.io/some/app")
+			h.AssertNil(t, err)
+			h.AssertEq(t, runImage, "some-registry.io/run")
+		})
+
+		it("returns an error when the stack has no run image", func() {
+			_, err := metadata.StackMetadata{}.RunImageForRegistry("some/app")
+			h.AssertError(t, err, "stack has no run image")
+		})
+	})
+}
@@ -0,0 +1,128 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+const (
+	barWidth       = 30
+	renderInterval = 100 * time.Millisecond
+)
+
+// Tracker reports the progress of layer transfers to Out. When Out is a
+// terminal each transfer is rendered as a bar that is redrawn in place,
+// otherwise a single summary line is written when the transfer completes.
+type Tracker struct {
+	Out io.Writer
+	TTY bool
+	mu  sync.Mutex
+}
+
+// NewTracker returns nil when out is nil, and a nil *Tracker does not
+// report anything.
+func NewTracker(out io.Writer) *Tracker {
+	if out == nil {
+		return nil
+	}
+	return &Tracker{Out: out, TTY: IsTerminal(out)}
+}
+
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Reader wraps rc so that reads from it are reported under name. A size less
+// than or equal to zero means the total size is unknown.
+func (t *Tracker) Reader(rc io.ReadCloser, name string, size int64) io.ReadCloser {
+	if t == nil {
+		return rc
+	}
+	return &reader{ReadCloser: rc, tracker: t, name: name, size: size, start: time.Now()}
+}
+
+type reader struct {
+	io.ReadCloser
+	tracker  *Tracker
+	name     string
+	size     int64
+	read     int64
+	start    time.Time
+	rendered time.Time
+	doneOnce sync.Once
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	if err == io.EOF {
+		r.done()
+	} else if r.tracker.TTY && time.Since(r.rendered) >= renderInterval {
+		r.rendered = time.Now()
+		r.tracker.write("\r\033[K" + r.line())
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	r.done()
+	return r.ReadCloser.Close()
+}
+
+func (r *reader) done() {
+	r.doneOnce.Do(func() {
+		if r.tracker.TTY {
+			r.tracker.write("\r\033[K" + r.line() + "\n")
+			return
+		}
+		elapsed := time.Since(r.start)
+		r.tracker.write(fmt.Sprintf("%s: transferred %s in %s (%s/s)\n",
+			r.name, units.HumanSize(float64(r.read)), elapsed.Round(time.Millisecond), rate(r.read, elapsed)))
+	})
+}
+
+func (r *reader) line() string {
+	elapsed := time.Since(r.start)
+	if r.size <= 0 {
+		return fmt.Sprintf("%s %s %s/s", r.name, units.HumanSize(float64(r.read)), rate(r.read, elapsed))
+	}
+	filled := int(float64(barWidth) * float64(r.read) / float64(r.size))
+	if filled > barWidth {
+		filled = barWidth
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	return fmt.Sprintf("%s [%s] %s/%s %s/s ETA %s",
+		r.name, bar, units.HumanSize(float64(r.read)), units.HumanSize(float64(r.size)), rate(r.read, elapsed), eta(r.read, r.size, elapsed))
+}
+
+func (t *Tracker) write(s string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.Out, s)
+}
+
+func rate(n int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return units.HumanSize(0)
+	}
+	return units.HumanSize(float64(n) / elapsed.Seconds())
+}
+
+func eta(read, size int64, elapsed time.Duration) string {
+	if read <= 0 || read >= size {
+		return "0s"
+	}
+	remaining := time.Duration(float64(elapsed) * float64(size-read) / float64(read))
+	return remaining.Round(time.Second).String()
+}
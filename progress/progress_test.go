@@ -0,0 +1,91 @@
+package progress_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/progress"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestProgress(t *testing.T) {
+	spec.Run(t, "Progress", testProgress, spec.Report(report.Terminal{}))
+}
+
+func testProgress(t *testing.T, when spec.G, it spec.S) {
+	var (
+		out     *bytes.Buffer
+		tracker *progress.Tracker
+	)
+
+	it.Before(func() {
+		out = &bytes.Buffer{}
+		tracker = progress.NewTracker(out)
+	})
+
+	when("#NewTracker", func() {
+		it("does not treat a buffer as a terminal", func() {
+			h.AssertEq(t, tracker.TTY, false)
+		})
+
+		it("returns nil for a nil writer", func() {
+			if progress.NewTracker(nil) != nil {
+				t.Fatal("expected a nil tracker")
+			}
+		})
+	})
+
+	when("#Reader", func() {
+		it("passes the contents through", func() {
+			rc := tracker.Reader(ioutil.NopCloser(strings.NewReader("some-data")), "some-layer", 9)
+			data, err := ioutil.ReadAll(rc)
+			h.AssertNil(t, err)
+			h.AssertNil(t, rc.Close())
+			h.AssertEq(t, string(data), "some-data")
+		})
+
+		when("not a terminal", func() {
+			it("writes a single summary line", func() {
+				rc := tracker.Reader(ioutil.NopCloser(strings.NewReader("some-data")), "some-layer", 9)
+				_, err := ioutil.ReadAll(rc)
+				h.AssertNil(t, err)
+				h.AssertNil(t, rc.Close())
+
+				h.AssertEq(t, strings.Count(out.String(), "\n"), 1)
+				if !strings.HasPrefix(out.String(), "some-layer: transferred 9B in ") {
+					t.Fatalf("unexpected output: %s", out.String())
+				}
+			})
+		})
+
+		when("a terminal", func() {
+			it.Before(func() {
+				tracker.TTY = true
+			})
+
+			it("renders a completed bar", func() {
+				rc := tracker.Reader(ioutil.NopCloser(strings.NewReader("some-data")), "some-layer", 9)
+				_, err := ioutil.ReadAll(rc)
+				h.AssertNil(t, err)
+				h.AssertNil(t, rc.Close())
+
+				if !strings.Contains(out.String(), "some-layer ["+strings.Repeat("=", 30)+"] 9B/9B") {
+					t.Fatalf("unexpected output: %q", out.String())
+				}
+			})
+		})
+
+		when("the tracker is nil", func() {
+			it("returns the reader unchanged", func() {
+				var nilTracker *progress.Tracker
+				rc := ioutil.NopCloser(strings.NewReader("some-data"))
+				h.AssertEq(t, nilTracker.Reader(rc, "some-layer", 9) == rc, true)
+			})
+		})
+	})
+}
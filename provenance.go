@@ -0,0 +1,102 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildpack/lifecycle/metadata"
+)
+
+const (
+	provenanceMediaType     = "application/vnd.in-toto+json"
+	provenanceStatementType = "https://in-toto.io/Statement/v0.1"
+	provenancePredicateType = "https://slsa.dev/provenance/v0.2"
+	provenanceBuilderID     = "https://github.com/buildpack/lifecycle"
+	provenanceBuildType     = "https://buildpacks.io/lifecycle/export@v1"
+)
+
+// ProvenanceAttacher attaches a provenance statement to a saved image.
+type ProvenanceAttacher interface {
+	AttachProvenance(repoName, digest, mediaType string, statement []byte) (string, error)
+}
+
+type provenanceStatement struct {
+	Type          string              `json:"_type"`
+	PredicateType string              `json:"predicateType"`
+	Subject       []provenanceSubject `json:"subject"`
+	Predicate     provenancePredicate `json:"predicate"`
+}
+
+type provenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type provenancePredicate struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType  string `json:"buildType"`
+	Invocation struct {
+		Parameters map[string]string `json:"parameters"`
+	} `json:"invocation"`
+	Materials []provenanceMaterial `json:"materials"`
+}
+
+type provenanceMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// provenance describes how the image saved with digest was built: from which
+// run image and buildpacks, and with which parameters.
+func (e *Exporter) provenance(imageName, digest, runImageName string, meta metadata.AppImageMetadata) provenanceStatement {
+	s := provenanceStatement{
+		Type:          provenanceStatementType,
+		PredicateType: provenancePredicateType,
+		Subject:       []provenanceSubject{{Name: imageName, Digest: digestMap(digest)}},
+	}
+	s.Predicate.Builder.ID = provenanceBuilderID
+	s.Predicate.BuildType = provenanceBuildType
+	s.Predicate.Invocation.Parameters = map[string]string{"runImage": runImageName}
+	for k, v := range map[string]string{"buildID": e.BuildID, "stackID": e.StackID, "user": e.User} {
+		if v != "" {
+			s.Predicate.Invocation.Parameters[k] = v
+		}
+	}
+
+	s.Predicate.Materials = append(s.Predicate.Materials, provenanceMaterial{
+		URI:    "pkg:docker/" + runImageName,
+		Digest: digestMap(meta.RunImage.SHA),
+	})
+	for _, bp := range e.Buildpacks {
+		s.Predicate.Materials = append(s.Predicate.Materials, provenanceMaterial{
+			URI:    "urn:cnb:buildpack:" + bp.ID + "@" + bp.Version,
+			Digest: digestMap(bp.Digest),
+		})
+	}
+	return s
+}
+
+// digestMap converts an "algorithm:hex" digest to the form used by in-toto.
+func digestMap(digest string) map[string]string {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil
+	}
+	return map[string]string{parts[0]: parts[1]}
+}
+
+func writeProvenance(path string, statement []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(statement, '\n'), 0666)
+}
+
+func marshalProvenance(s provenanceStatement) ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
@@ -1,34 +1,77 @@
 package lifecycle
 
 import (
-	"log"
+	"context"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/pkg/errors"
 
 	"github.com/buildpack/lifecycle/archive"
 	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/progress"
+	"github.com/buildpack/lifecycle/telemetry"
 )
 
 type Restorer struct {
 	LayersDir  string
 	Buildpacks []*Buildpack
-	Out, Err   *log.Logger
+	Logger     Logger
+	Progress   *progress.Tracker
+	Tracer     *telemetry.Tracer
+	Events     Events
 	UID        int
 	GID        int
+
+	// LinkLayers restores layers from caches that support it by reflinking
+	// files instead of extracting them.
+	LinkLayers bool
+
+	// Context stops the restore when it is done.
+	Context context.Context
+	// OnInterrupt, if set, is called with a function that removes the layer
+	// being restored, for the platform to run if it stops the restorer
+	// before the restore finishes.
+	OnInterrupt func(cleanup func())
+
+	restoringMu sync.Mutex
+	restoring   *bpLayer
+}
+
+// layerLinker is implemented by caches that can restore a layer from an
+// extracted copy instead of its tar. The restored files are owned by uid and
+// gid.
+type layerLinker interface {
+	LinkLayer(sha string, layerPath string, uid, gid int) error
 }
 
 func (r *Restorer) Restore(cache Cache) error {
 	meta, err := cache.RetrieveMetadata()
+	if _, ok := err.(*metadata.IncompatibleError); ok {
+		r.Logger.Warnf("ignoring metadata of cache '%s': %s", cache.Name(), err)
+		err = nil
+	}
 	if err != nil {
 		return err
 	}
 
 	if len(meta.Buildpacks) == 0 {
-		r.Out.Printf("cache '%s': metadata not found, nothing to restore", cache.Name())
+		r.Logger.Infof("cache '%s': metadata not found, nothing to restore", cache.Name())
 		return nil
 	}
 
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if r.OnInterrupt != nil {
+		r.OnInterrupt(r.removeRestoring)
+	}
+
+	var restored []*bpLayer
 	for _, bp := range r.Buildpacks {
 		layersDir, err := readBuildpackLayersDir(r.LayersDir, *bp)
 		if err != nil {
@@ -37,12 +80,27 @@ func (r *Restorer) Restore(cache Cache) error {
 		bpMD := meta.MetadataForBuildpack(bp.ID)
 		for name, layer := range bpMD.Layers {
 			if !layer.Cache {
+				r.Logger.Debugf("layer '%s:%s': cache=false in cache metadata, not restoring", bp.ID, name)
 				continue
 			}
 
-			if err := r.restoreLayer(name, bpMD, layer, layersDir, cache); err != nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			span := r.Tracer.Start("restore-layer").SetAttribute("layer", bp.ID+":"+name).SetAttribute("sha", layer.SHA)
+			err := r.restoreLayer(ctx, name, bpMD, layer, layersDir, cache)
+			span.SetError(err)
+			span.Finish()
+			if isCacheCorrupt(err) {
+				r.Logger.Warnf("layer '%s:%s' in cache '%s' is corrupt, not restoring it: %s", bp.ID, name, cache.Name(), err)
+				continue
+			}
+			if err != nil {
 				return err
 			}
+			if layer.Launch {
+				restored = append(restored, layersDir.newBPLayer(name))
+			}
 		}
 	}
 
@@ -54,13 +112,30 @@ func (r *Restorer) Restore(cache Cache) error {
 			return errors.Wrapf(err, "chowning layers dir to '%d/%d'", r.UID, r.GID)
 		}
 	}
+	for _, layer := range restored {
+		if err := layer.writeRestored(); err != nil {
+			return errors.Wrapf(err, "recording state of restored layer '%s'", layer.Identifier())
+		}
+	}
 	return nil
 }
 
-func (r *Restorer) restoreLayer(name string, bpMD metadata.BuildpackMetadata, layer metadata.LayerMetadata, layersDir bpLayersDir, cache Cache) error {
+// restoreLayer restores the layer with the given name, removing what it
+// restored of the layer if it fails.
+func (r *Restorer) restoreLayer(ctx context.Context, name string, bpMD metadata.BuildpackMetadata, layer metadata.LayerMetadata, layersDir bpLayersDir, cache Cache) (err error) {
 	bpLayer := layersDir.newBPLayer(name)
+	r.setRestoring(bpLayer)
+	defer func() {
+		r.setRestoring(nil)
+		if err != nil {
+			if removeErr := bpLayer.remove(); removeErr != nil {
+				r.Logger.Warnf("could not remove partially restored layer '%s': %s", bpLayer.Identifier(), removeErr)
+			}
+		}
+	}()
 
-	r.Out.Printf("restoring cached layer '%s'", bpLayer.Identifier())
+	r.Logger.Infof("restoring cached layer '%s'", bpLayer.Identifier())
+	r.Logger.Debugf("layer '%s': cache=true, restoring SHA %s (launch=%t, build=%t)", bpLayer.Identifier(), layer.SHA, layer.Launch, layer.Build)
 	if err := bpLayer.writeMetadata(bpMD.Layers); err != nil {
 		return err
 	}
@@ -71,11 +146,66 @@ func (r *Restorer) restoreLayer(name string, bpMD metadata.BuildpackMetadata, la
 		}
 	}
 
+	// cache layers may not be trusted, so they are only restored into their own directory
+	layerPath, err := filepath.Abs(bpLayer.Path())
+	if err != nil {
+		return err
+	}
+	if linker, ok := cache.(layerLinker); ok && r.LinkLayers {
+		err := linker.LinkLayer(layer.SHA, layerPath, r.UID, r.GID)
+		if err == nil {
+			eventsOrNop(r.Events).OnLayerRestored(LayerEvent{ID: bpLayer.Identifier(), SHA: layer.SHA})
+			return nil
+		}
+		r.Logger.Debugf("layer '%s': %s, extracting instead", bpLayer.Identifier(), err)
+	}
+
 	rc, err := cache.RetrieveLayer(layer.SHA)
 	if err != nil {
 		return err
 	}
+	rc = r.Progress.Reader(rc, "Restoring "+bpLayer.Identifier(), 0)
+	rc = &contextReader{ctx: ctx, ReadCloser: rc}
 	defer rc.Close()
 
-	return archive.Untar(rc, "/")
+	// layer paths are relative to the root of the volume, such as C:\ on Windows
+	root := filepath.VolumeName(layerPath) + string(filepath.Separator)
+	if err := archive.UntarWithin(rc, root, layerPath); err != nil {
+		return err
+	}
+	// read the rest of the layer, so that caches can check its digest
+	if _, err := io.Copy(ioutil.Discard, rc); err != nil {
+		return err
+	}
+	eventsOrNop(r.Events).OnLayerRestored(LayerEvent{ID: bpLayer.Identifier(), SHA: layer.SHA})
+	return nil
+}
+
+func (r *Restorer) setRestoring(layer *bpLayer) {
+	r.restoringMu.Lock()
+	defer r.restoringMu.Unlock()
+	r.restoring = layer
+}
+
+// removeRestoring removes the layer being restored, if any.
+func (r *Restorer) removeRestoring() {
+	r.restoringMu.Lock()
+	defer r.restoringMu.Unlock()
+	if r.restoring != nil {
+		r.restoring.remove()
+	}
+}
+
+// contextReader fails reads once ctx is done, so that extracting a large
+// layer stops when the restore is stopped.
+type contextReader struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
 }
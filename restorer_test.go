@@ -1,11 +1,13 @@
 package lifecycle_test
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -34,7 +36,7 @@ func testRestorer(t *testing.T, when spec.G, it spec.S) {
 		it.Before(func() {
 			var err error
 
-			emptyLogger := log.New(ioutil.Discard, "", 0)
+			emptyLogger := lifecycle.NewDefaultLogger(ioutil.Discard, ioutil.Discard)
 
 			layersDir, err = ioutil.TempDir("", "lifecycle-layer-dir")
 			h.AssertNil(t, err)
@@ -51,9 +53,9 @@ func testRestorer(t *testing.T, when spec.G, it spec.S) {
 					{ID: "buildpack.id"},
 					{ID: "escaped/buildpack/id"},
 				},
-				Out: emptyLogger,
-				UID: 1234,
-				GID: 4321,
+				Logger: emptyLogger,
+				UID:    1234,
+				GID:    4321,
 			}
 		})
 
@@ -68,6 +70,17 @@ func testRestorer(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 
+		when("the cache metadata is incompatible", func() {
+			it("ignores the cache", func() {
+				h.AssertNil(t, ioutil.WriteFile(filepath.Join(cacheDir, "committed", cache.MetadataLabel), []byte(`{"schemaVersion": 99}`), 0666))
+
+				h.AssertNil(t, restorer.Restore(testCache))
+				fis, err := ioutil.ReadDir(layersDir)
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(fis), 0)
+			})
+		})
+
 		when("there is a cache", func() {
 			var (
 				tarTempDir          string
@@ -208,6 +221,56 @@ func testRestorer(t *testing.T, when spec.G, it spec.S) {
 				}
 			})
 
+			it("links cached layers from an extracted copy in the cache", func() {
+				restorer.LinkLayers = true
+				h.AssertNil(t, restorer.Restore(testCache))
+
+				path := filepath.Join(layersDir, "buildpack.id", "cache-only", "file-from-cache-only-layer")
+				txt, err := ioutil.ReadFile(path)
+				h.AssertNil(t, err)
+				h.AssertEq(t, strings.TrimSpace(string(txt)), "echo text from cache-only layer")
+
+				_, err = os.Stat(filepath.Join(cacheDir, "committed", cacheOnlyLayerSHA, path))
+				h.AssertNil(t, err)
+			})
+
+			it("skips cached layers that do not match their SHA", func() {
+				f, err := os.OpenFile(filepath.Join(cacheDir, "committed", cacheOnlyLayerSHA+".tar"), os.O_WRONLY|os.O_APPEND, 0)
+				h.AssertNil(t, err)
+				_, err = f.Write([]byte("corrupted"))
+				h.AssertNil(t, err)
+				h.AssertNil(t, f.Close())
+				stderr := &bytes.Buffer{}
+				restorer.Logger = lifecycle.NewDefaultLogger(ioutil.Discard, stderr)
+
+				h.AssertNil(t, restorer.Restore(testCache))
+				for _, path := range []string{"cache-only", "cache-only.toml", "cache-only.sha"} {
+					if _, err := os.Stat(filepath.Join(layersDir, "buildpack.id", path)); !os.IsNotExist(err) {
+						t.Fatalf("Error: expected corrupt layer file '%s' to be removed", path)
+					}
+				}
+				if _, err := os.Stat(filepath.Join(layersDir, "buildpack.id", "cache-launch", "file-from-cache-launch-layer")); err != nil {
+					t.Fatalf("Error: expected other layers to be restored: %s", err)
+				}
+				h.AssertMatch(t, stderr.String(), regexp.MustCompile(`Warning: layer 'buildpack.id:cache-only' in cache '.*' is corrupt, not restoring it: layer tar has digest`))
+			})
+
+			it("stops restoring when the context is done", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				restorer.Context = ctx
+				var cleanups []func()
+				restorer.OnInterrupt = func(cleanup func()) { cleanups = append(cleanups, cleanup) }
+
+				if err := restorer.Restore(testCache); err != context.Canceled {
+					t.Fatalf("expected context.Canceled, got: %v", err)
+				}
+				h.AssertEq(t, len(cleanups), 1)
+				if _, err := os.Stat(filepath.Join(layersDir, "buildpack.id")); !os.IsNotExist(err) {
+					t.Fatal("Error: expected no layers to be restored")
+				}
+			})
+
 			it("write a .sha file for launch layers", func() {
 				h.AssertNil(t, restorer.Restore(testCache))
 				expectedMetadata := `[metadata]
@@ -230,6 +293,9 @@ func testRestorer(t *testing.T, when spec.G, it spec.S) {
 				} else if string(sha) != cacheLaunchLayerSHA {
 					t.Fatalf(`Error: expected '%s' to be equal to '%s'`, sha, cacheLaunchLayerSHA)
 				}
+				if _, err := os.Stat(filepath.Join(layersDir, "buildpack.id", "cache-launch.restored")); err != nil {
+					t.Fatalf("Error: expected the state of the restored layer to be recorded: %s", err)
+				}
 			})
 
 			it("doesn't restore cache false layers", func() {
@@ -0,0 +1,31 @@
+package lifecycle
+
+import (
+	"github.com/buildpack/lifecycle/image"
+	"github.com/buildpack/lifecycle/telemetry"
+)
+
+// checkRunImageDigest warns when digest, the digest of the run image in use,
+// is not the digest of the same run image in the registry, so that operators
+// know to rebase. The check is advisory and never fails the phase.
+func checkRunImageDigest(logger Logger, tracer *telemetry.Tracer, digest string, registryImage image.Image) {
+	if digest == "" {
+		logger.Debugf("run image '%s' has no digest, not checking whether it is outdated", registryImage.Name())
+		return
+	}
+	current, err := registryImage.Digest()
+	if err != nil {
+		logger.Warnf("could not check whether run image '%s' is outdated: %s", registryImage.Name(), err)
+		return
+	}
+	outdated := current != digest
+	tracer.Start("check-run-image").
+		SetAttribute("run_image", registryImage.Name()).
+		SetAttribute("digest", digest).
+		SetAttribute("current_digest", current).
+		SetAttribute("outdated", outdated).
+		Finish()
+	if outdated {
+		logger.Warnf("run image '%s' is outdated, using %s but the registry has %s, rebase the app image to update it", registryImage.Name(), digest, current)
+	}
+}
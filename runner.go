@@ -0,0 +1,219 @@
+package lifecycle
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpack/lifecycle/image"
+	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/progress"
+	"github.com/buildpack/lifecycle/telemetry"
+)
+
+// ErrFailedDetection is returned by Runner.Detect when no group passes.
+var ErrFailedDetection = errors.New("no buildpack group passed detection")
+
+// Runner runs the phases of a build in one process with shared
+// configuration, passing the detected group and plan between them in memory
+// instead of through group.toml and plan.toml, so that platforms can embed
+// the lifecycle as a library. Logger, Out and Err are required.
+type Runner struct {
+	AppDir      string
+	LayersDir   string
+	PlatformDir string
+	StackID     string
+	BuildMixins []string // mixins are not checked when nil
+	UID, GID    int
+	Env         BuildEnv // defaults to the environment of the process
+	Secrets     *Secrets
+	Logger      Logger
+	Out, Err    io.Writer
+	Tracer      *telemetry.Tracer
+	Events      Events
+	LogPrefix   string
+
+	// Exporter configures the export, such as signing or attaching the BOM.
+	// Its buildpacks, logger, tracer, events, UID and GID are set by the
+	// runner.
+	Exporter Exporter
+}
+
+// Phases are the inputs of Runner.Run.
+type Phases struct {
+	Order BuildpackOrder
+
+	// PreviousImage is analyzed and its layers are reused by the export,
+	// which saves the app image under its name.
+	PreviousImage image.Image
+
+	// Cache, when set, is restored before the build and updated after the
+	// export.
+	Cache Cache
+
+	RunImage image.Image
+	Launcher string
+	Stack    metadata.StackMetadata
+}
+
+// Run detects, analyzes, restores, builds and exports the app, and caches
+// its layers.
+func (r *Runner) Run(p Phases) error {
+	group, plan, err := r.Detect(p.Order)
+	if err != nil {
+		return err
+	}
+	if err := r.Analyze(group, p.PreviousImage); err != nil {
+		return errors.Wrap(err, "analyze")
+	}
+	if p.Cache != nil {
+		if err := r.Restore(group, p.Cache); err != nil {
+			return errors.Wrap(err, "restore")
+		}
+	}
+	if _, err := r.Build(group, plan); err != nil {
+		return errors.Wrap(err, "build")
+	}
+	if err := r.Export(group, p.RunImage, p.PreviousImage, p.Launcher, p.Stack); err != nil {
+		return errors.Wrap(err, "export")
+	}
+	if p.Cache != nil {
+		if err := r.Cache(group, p.Cache); err != nil {
+			return errors.Wrap(err, "cache")
+		}
+	}
+	return nil
+}
+
+// Detect returns the first group in order that passes detection and its
+// build plan. Extensions are not supported, since they require the extender
+// to run on the build image.
+func (r *Runner) Detect(order BuildpackOrder) (*BuildpackGroup, Plan, error) {
+	planTOML, group := order.Detect(&DetectConfig{
+		AppDir:      r.AppDir,
+		PlatformDir: r.PlatformDir,
+		StackID:     r.StackID,
+		BuildMixins: r.BuildMixins,
+		Logger:      r.Logger,
+		Tracer:      r.Tracer,
+		Events:      r.Events,
+		LogPrefix:   r.LogPrefix,
+	})
+	if group == nil {
+		return nil, nil, ErrFailedDetection
+	}
+	if len(group.Extensions) > 0 {
+		return nil, nil, errors.New("extensions passed detection, but are not supported when embedding the lifecycle")
+	}
+	plan, err := ParsePlan(planTOML)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse build plan")
+	}
+	return group, plan, nil
+}
+
+// Analyze restores the metadata of the group's layers from previousImage.
+func (r *Runner) Analyze(group *BuildpackGroup, previousImage image.Image) error {
+	analyzer := &Analyzer{
+		Buildpacks: group.Buildpacks,
+		AppDir:     r.AppDir,
+		LayersDir:  r.LayersDir,
+		Logger:     r.Logger,
+		Tracer:     r.Tracer,
+		UID:        r.UID,
+		GID:        r.GID,
+	}
+	return analyzer.Analyze(previousImage)
+}
+
+// Restore restores the group's cached layers from cache.
+func (r *Runner) Restore(group *BuildpackGroup, cache Cache) error {
+	restorer := &Restorer{
+		LayersDir:  r.LayersDir,
+		Buildpacks: group.Buildpacks,
+		Logger:     r.Logger,
+		Progress:   progress.NewTracker(r.Out),
+		Tracer:     r.Tracer,
+		Events:     r.Events,
+		UID:        r.UID,
+		GID:        r.GID,
+	}
+	return restorer.Restore(cache)
+}
+
+// Build runs the group's buildpacks with plan and writes the resulting
+// metadata to the config layer, where the export reads it from.
+func (r *Runner) Build(group *BuildpackGroup, plan Plan) (*BuildMetadata, error) {
+	env := r.Env
+	if env == nil {
+		env = &Env{
+			Getenv:  os.Getenv,
+			Setenv:  os.Setenv,
+			Environ: os.Environ,
+			Map:     POSIXBuildEnv,
+		}
+	}
+	builder := &Builder{
+		PlatformDir: r.PlatformDir,
+		LayersDir:   r.LayersDir,
+		AppDir:      r.AppDir,
+		Env:         env,
+		Buildpacks:  group.Buildpacks,
+		Plan:        plan,
+		StackID:     r.StackID,
+		UID:         r.UID,
+		GID:         r.GID,
+		Secrets:     r.Secrets,
+		Out:         r.Out,
+		Err:         r.Err,
+		Tracer:      r.Tracer,
+		Events:      r.Events,
+		LogPrefix:   r.LogPrefix,
+	}
+	md, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteTOML(filepath.Join(r.LayersDir, "config", "metadata.toml"), md); err != nil {
+		return nil, errors.Wrap(err, "write metadata")
+	}
+	return md, nil
+}
+
+// Export saves the app image built on runImage under the name of origImage.
+func (r *Runner) Export(group *BuildpackGroup, runImage, origImage image.Image, launcher string, stack metadata.StackMetadata) error {
+	artifactsDir, err := ioutil.TempDir("", "lifecycle.exporter.layer")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(artifactsDir)
+
+	exporter := r.Exporter
+	exporter.Buildpacks = group.Buildpacks
+	exporter.ArtifactsDir = artifactsDir
+	exporter.Logger = r.Logger
+	exporter.Tracer = r.Tracer
+	exporter.Events = r.Events
+	exporter.UID, exporter.GID = r.UID, r.GID
+	if exporter.StackID == "" {
+		exporter.StackID = r.StackID
+	}
+	return exporter.Export(r.LayersDir, r.AppDir, runImage, origImage, launcher, stack)
+}
+
+// Cache saves the group's cached layers to cache.
+func (r *Runner) Cache(group *BuildpackGroup, cache Cache) error {
+	cacher := &Cacher{
+		Buildpacks:  group.Buildpacks,
+		DiffIDIndex: r.Exporter.DiffIDIndex,
+		Logger:      r.Logger,
+		Tracer:      r.Tracer,
+		Events:      r.Events,
+		UID:         r.UID,
+		GID:         r.GID,
+	}
+	return cacher.Cache(r.LayersDir, cache)
+}
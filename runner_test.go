@@ -0,0 +1,85 @@
+package lifecycle_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestRunner(t *testing.T) {
+	spec.Run(t, "Runner", testRunner, spec.Report(report.Terminal{}))
+}
+
+func testRunner(t *testing.T, when spec.G, it spec.S) {
+	var (
+		runner *lifecycle.Runner
+		order  lifecycle.BuildpackOrder
+		tmpDir string
+		appDir string
+	)
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.runner")
+		h.AssertNil(t, err)
+		appDir = filepath.Join(tmpDir, "app")
+		platformDir := filepath.Join(tmpDir, "platform")
+		mkdir(t, appDir, filepath.Join(platformDir, "env"), filepath.Join(tmpDir, "layers"))
+
+		runner = &lifecycle.Runner{
+			AppDir:      appDir,
+			LayersDir:   filepath.Join(tmpDir, "layers"),
+			PlatformDir: platformDir,
+			UID:         os.Getuid(),
+			GID:         os.Getgid(),
+			Logger:      lifecycle.NewDefaultLogger(it.Out(), it.Out()),
+			Out:         it.Out(),
+			Err:         it.Out(),
+		}
+		order = lifecycle.BuildpackOrder{{
+			Buildpacks: []*lifecycle.Buildpack{{ID: "buildpack1", Version: "1.2.3", Name: "buildpack1-name", Dir: filepath.Join("testdata", "buildpack")}},
+		}}
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	it("passes the detected group and plan to the build in memory", func() {
+		mkfile(t, "1", filepath.Join(appDir, "add"))
+		mkfile(t, "3", filepath.Join(appDir, "last"))
+
+		group, plan, err := runner.Detect(order)
+		h.AssertNil(t, err)
+		h.AssertEq(t, len(group.Buildpacks), 1)
+		h.AssertEq(t, plan["1"], map[string]interface{}{"1": true})
+
+		md, err := runner.Build(group, plan)
+		h.AssertNil(t, err)
+		h.AssertEq(t, md.Buildpacks, []string{"buildpack1"})
+		if !strings.Contains(rdfile(t, filepath.Join(appDir, "plan.toml")), "[1]") {
+			t.Fatalf("expected the buildpack to receive the detected plan")
+		}
+		if !strings.Contains(rdfile(t, filepath.Join(tmpDir, "layers", "config", "metadata.toml")), "process-type") {
+			t.Fatalf("expected metadata to be written for the export")
+		}
+	})
+
+	it("fails when no group passes detection", func() {
+		mkfile(t, "1", filepath.Join(appDir, "add"))
+		mkfile(t, "0", filepath.Join(appDir, "last"))
+
+		_, _, err := runner.Detect(order)
+		if err != lifecycle.ErrFailedDetection {
+			t.Fatalf("expected detection to fail, got: %v", err)
+		}
+	})
+}
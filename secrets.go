@@ -0,0 +1,131 @@
+package lifecycle
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// minSecretLength is the length below which secret values are not scrubbed,
+// since they would match unrelated files.
+const minSecretLength = 8
+
+// Secrets are files provided by the platform, such as credentials for private
+// package registries, that buildpacks may read during the build but that
+// must not be exported. Files in the env subdirectory are also provided to
+// buildpacks as environment variables. A nil Secrets provides nothing.
+type Secrets struct {
+	Dir    string
+	values [][]byte
+}
+
+// ReadSecrets reads the values of the secret files in dir.
+func ReadSecrets(dir string) (*Secrets, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	secrets := &Secrets{Dir: dir}
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		value, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if value = bytes.TrimSpace(value); len(value) >= minSecretLength {
+			secrets.values = append(secrets.values, value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// env returns CNB_SECRETS_DIR and the variables defined by the env
+// subdirectory.
+func (s *Secrets) env() ([]string, error) {
+	if s == nil {
+		return nil, nil
+	}
+	env := []string{"CNB_SECRETS_DIR=" + s.Dir}
+	err := eachEnvFile(filepath.Join(s.Dir, "env"), func(k, v string) error {
+		env = append(env, k+"="+v)
+		return nil
+	})
+	return env, err
+}
+
+// Scrub removes the regular files in dirs that contain any secret value, and
+// returns their paths. The secrets directory itself is skipped.
+func (s *Secrets) Scrub(dirs ...string) ([]string, error) {
+	if s == nil || len(s.values) == 0 {
+		return nil, nil
+	}
+	var removed []string
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if fi.IsDir() && (path == s.Dir || strings.HasPrefix(path, s.Dir+string(filepath.Separator))) {
+				return filepath.SkipDir
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			found, err := s.contains(path)
+			if err != nil || !found {
+				return err
+			}
+			removed = append(removed, path)
+			return os.Remove(path)
+		})
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// contains reports whether the file at path contains any secret value. The
+// file is read in chunks that overlap by the length of the longest value.
+func (s *Secrets) contains(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	overlap := 0
+	for _, v := range s.values {
+		if len(v) > overlap {
+			overlap = len(v)
+		}
+	}
+	buf := make([]byte, overlap+64*1024)
+	kept := 0
+	for {
+		n, err := io.ReadFull(f, buf[kept:])
+		window := buf[:kept+n]
+		for _, v := range s.values {
+			if bytes.Contains(window, v) {
+				return true, nil
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		kept = copy(buf, window[len(window)-overlap:])
+	}
+}
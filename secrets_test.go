@@ -0,0 +1,63 @@
+package lifecycle_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestSecrets(t *testing.T) {
+	spec.Run(t, "Secrets", testSecrets, spec.Report(report.Terminal{}))
+}
+
+func testSecrets(t *testing.T, when spec.G, it spec.S) {
+	var tmpDir, secretsDir, layersDir string
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.test")
+		h.AssertNil(t, err)
+		secretsDir = filepath.Join(tmpDir, "secrets")
+		layersDir = filepath.Join(tmpDir, "layers")
+		mkdir(t, secretsDir, layersDir)
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	when("#Scrub", func() {
+		it("removes files containing secrets, including across read boundaries", func() {
+			mkfile(t, "some-secret-value\n", filepath.Join(secretsDir, "registry-token"))
+			mkfile(t, "short", filepath.Join(secretsDir, "short"))
+			mkfile(t, strings.Repeat("x", 64*1024+10)+"some-secret-value", filepath.Join(layersDir, "large"))
+			mkfile(t, "short and not secret", filepath.Join(layersDir, "other"))
+			secrets, err := lifecycle.ReadSecrets(secretsDir)
+			h.AssertNil(t, err)
+
+			removed, err := secrets.Scrub(layersDir, filepath.Join(tmpDir, "missing"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, removed, []string{filepath.Join(layersDir, "large")})
+			testExists(t, filepath.Join(layersDir, "other"))
+		})
+
+		it("skips the secrets directory", func() {
+			mkfile(t, "some-secret-value", filepath.Join(secretsDir, "registry-token"))
+			secrets, err := lifecycle.ReadSecrets(secretsDir)
+			h.AssertNil(t, err)
+
+			removed, err := secrets.Scrub(tmpDir)
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(removed), 0)
+			testExists(t, filepath.Join(secretsDir, "registry-token"))
+		})
+	})
+}
@@ -0,0 +1,42 @@
+package lifecycle
+
+import (
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+)
+
+// Signer signs exported images with cosign and pushes the signatures to
+// the image's registry.
+type Signer struct {
+	Cosign string   // path to the cosign binary, defaults to cosign on the PATH
+	Key    string   // key file or KMS URI, passed to cosign as --key
+	Env    []string // added to the environment of cosign
+	Out    io.Writer
+	Err    io.Writer
+}
+
+// Sign signs the image with the given name and digest.
+func (s *Signer) Sign(imageName, digest string) error {
+	ref := imageName
+	if tag, err := name.NewTag(imageName, name.WeakValidation); err == nil {
+		ref = tag.Context().Name()
+	}
+	ref += "@" + digest
+
+	cosign := s.Cosign
+	if cosign == "" {
+		cosign = "cosign"
+	}
+	c := exec.Command(cosign, "sign", "--yes", "--key", s.Key, ref)
+	c.Env = append(os.Environ(), s.Env...)
+	c.Stdout = s.Out
+	c.Stderr = s.Err
+	if err := c.Run(); err != nil {
+		return errors.Wrapf(err, "cosign sign '%s'", ref)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Package stack reads the stack.toml file that describes the stack an app is
+// built on.
+package stack
+
+import (
+	"github.com/BurntSushi/toml"
+
+	"github.com/buildpack/lifecycle/metadata"
+)
+
+type Stack struct {
+	ID         string     `toml:"id"`
+	BuildImage BuildImage `toml:"build-image"`
+	RunImage   RunImage   `toml:"run-image"`
+}
+
+type BuildImage struct {
+	Image  string   `toml:"image"`
+	Mixins []string `toml:"mixins"`
+}
+
+type RunImage struct {
+	Image   string   `toml:"image"`
+	Mirrors []string `toml:"mirrors"`
+	Mixins  []string `toml:"mixins"`
+}
+
+// DecodeFile decodes the file at path into v. The lifecycle replaces it with
+// its own DecodeFile, which reports unknown keys.
+var DecodeFile = func(path string, v interface{}) error {
+	_, err := toml.DecodeFile(path, v)
+	return err
+}
+
+func Read(path string) (*Stack, error) {
+	var s Stack
+	if err := DecodeFile(path, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Metadata returns the stack metadata saved in the app image label.
+func (s *Stack) Metadata() metadata.StackMetadata {
+	return metadata.StackMetadata{
+		RunImage: metadata.StackRunImageMetadata{
+			Image:   s.RunImage.Image,
+			Mirrors: s.RunImage.Mirrors,
+		},
+	}
+}
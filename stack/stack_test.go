@@ -0,0 +1,67 @@
+package stack_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/metadata"
+	"github.com/buildpack/lifecycle/stack"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestStack(t *testing.T) {
+	spec.Run(t, "Stack", testStack, spec.Report(report.Terminal{}))
+}
+
+func testStack(t *testing.T, when spec.G, it spec.S) {
+	var tmpDir string
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.stack")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	when("#Read", func() {
+		it("reads the stack", func() {
+			path := filepath.Join(tmpDir, "stack.toml")
+			h.AssertNil(t, ioutil.WriteFile(path, []byte(`
+id = "some-stack"
+
+[build-image]
+image = "some/build"
+mixins = ["some-mixin", "build:build-mixin"]
+
+[run-image]
+image = "some/run"
+mirrors = ["gcr.io/some/run"]
+mixins = ["some-mixin"]
+`), 0666))
+
+			s, err := stack.Read(path)
+			h.AssertNil(t, err)
+			h.AssertEq(t, s, &stack.Stack{
+				ID:         "some-stack",
+				BuildImage: stack.BuildImage{Image: "some/build", Mixins: []string{"some-mixin", "build:build-mixin"}},
+				RunImage:   stack.RunImage{Image: "some/run", Mirrors: []string{"gcr.io/some/run"}, Mixins: []string{"some-mixin"}},
+			})
+			h.AssertEq(t, s.Metadata(), metadata.StackMetadata{
+				RunImage: metadata.StackRunImageMetadata{Image: "some/run", Mirrors: []string{"gcr.io/some/run"}},
+			})
+		})
+
+		it("returns a not exist error when there is no stack.toml", func() {
+			_, err := stack.Read(filepath.Join(tmpDir, "stack.toml"))
+			h.AssertEq(t, os.IsNotExist(err), true)
+		})
+	})
+}
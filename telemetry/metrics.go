@@ -0,0 +1,143 @@
+package telemetry
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const metricsContentType = "text/plain; version=0.0.4"
+
+type metric struct {
+	name, help string
+}
+
+var (
+	phaseDuration     = metric{"lifecycle_phase_duration_seconds", "Duration of the lifecycle phase."}
+	phaseSuccess      = metric{"lifecycle_phase_success", "Whether the lifecycle phase succeeded."}
+	buildpackDuration = metric{"lifecycle_buildpack_duration_seconds", "Duration of a buildpack operation."}
+	layerDuration     = metric{"lifecycle_layer_duration_seconds", "Duration of a layer operation."}
+	layerSize         = metric{"lifecycle_layer_size_bytes", "Size of the layer tarball."}
+	layerReused       = metric{"lifecycle_layer_reused", "Whether the layer was reused rather than added."}
+)
+
+// Metrics renders the tracer's spans in the Prometheus text exposition
+// format.
+func (t *Tracer) Metrics() []byte {
+	samples := map[metric][]string{}
+	root := t.Root()
+	phase := root.Name
+	add := func(m metric, labels [][2]string, value float64) {
+		common := [][2]string{{"phase", phase}}
+		if t.BuildID != "" {
+			common = append(common, [2]string{"build_id", t.BuildID})
+		}
+		labels = append(common, labels...)
+		var pairs []string
+		for _, l := range labels {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l[0], escapeLabel(l[1])))
+		}
+		samples[m] = append(samples[m], fmt.Sprintf("%s{%s} %g", m.name, strings.Join(pairs, ","), value))
+	}
+
+	for _, s := range t.Spans() {
+		if s == root {
+			add(phaseDuration, nil, s.Duration().Seconds())
+			add(phaseSuccess, nil, boolValue(s.Error == ""))
+			continue
+		}
+		if layer, ok := s.Attributes["layer"]; ok {
+			labels := [][2]string{{"operation", s.Name}, {"layer", fmt.Sprint(layer)}}
+			add(layerDuration, labels, s.Duration().Seconds())
+			if size, ok := s.Attributes["size"].(int64); ok {
+				add(layerSize, labels, float64(size))
+			}
+			if reused, ok := s.Attributes["reused"].(bool); ok {
+				add(layerReused, labels, boolValue(reused))
+			}
+			continue
+		}
+		if id, ok := s.Attributes["buildpack.id"]; ok {
+			labels := [][2]string{{"operation", s.Name}, {"buildpack_id", fmt.Sprint(id)}}
+			if version, ok := s.Attributes["buildpack.version"]; ok {
+				labels = append(labels, [2]string{"buildpack_version", fmt.Sprint(version)})
+			}
+			add(buildpackDuration, labels, s.Duration().Seconds())
+		}
+	}
+
+	out := &bytes.Buffer{}
+	for _, m := range []metric{phaseDuration, phaseSuccess, buildpackDuration, layerDuration, layerSize, layerReused} {
+		if len(samples[m]) == 0 {
+			continue
+		}
+		sort.Strings(samples[m])
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, sample := range samples[m] {
+			fmt.Fprintln(out, sample)
+		}
+	}
+	return out.Bytes()
+}
+
+// WriteMetricsFile atomically writes the metrics to path so that a
+// node-exporter textfile collector never reads a partial file.
+func (t *Tracer) WriteMetricsFile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "create metrics file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(t.Metrics()); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write metrics file")
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PushMetrics replaces the metrics of the phase's group on the Prometheus
+// Pushgateway at gateway.
+func (t *Tracer) PushMetrics(gateway string) error {
+	endpoint := fmt.Sprintf("%s/metrics/job/lifecycle/phase/%s", strings.TrimRight(gateway, "/"), url.PathEscape(t.Root().Name))
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(t.Metrics()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", metricsContentType)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "push metrics to '%s'", gateway)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("push metrics to '%s': unexpected status %s", gateway, resp.Status)
+	}
+	return nil
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package telemetry_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/telemetry"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestMetrics(t *testing.T) {
+	spec.Run(t, "Metrics", testMetrics, spec.Report(report.Terminal{}))
+}
+
+func testMetrics(t *testing.T, when spec.G, it spec.S) {
+	var tracer *telemetry.Tracer
+
+	it.Before(func() {
+		tracer = telemetry.NewTracer("exporter", "")
+		tracer.Start("build").SetAttribute("buildpack.id", "some-buildpack").SetAttribute("buildpack.version", "1.2.3").Finish()
+		tracer.Start("export-layer").
+			SetAttribute("layer", `some-buildpack:"quoted"`).
+			SetAttribute("size", int64(1024)).
+			SetAttribute("reused", true).
+			Finish()
+		tracer.Root().SetError(errors.New("some-error"))
+		tracer.Root().Finish()
+	})
+
+	when("#Metrics", func() {
+		it("renders each span as gauges labelled with the phase", func() {
+			out := string(tracer.Metrics())
+
+			for _, expected := range []string{
+				"# TYPE lifecycle_phase_duration_seconds gauge\n",
+				`lifecycle_phase_success{phase="exporter"} 0` + "\n",
+				`lifecycle_buildpack_duration_seconds{phase="exporter",operation="build",buildpack_id="some-buildpack",buildpack_version="1.2.3"} `,
+				`lifecycle_layer_size_bytes{phase="exporter",operation="export-layer",layer="some-buildpack:\"quoted\""} 1024` + "\n",
+				`lifecycle_layer_reused{phase="exporter",operation="export-layer",layer="some-buildpack:\"quoted\""} 1` + "\n",
+			} {
+				if !strings.Contains(out, expected) {
+					t.Fatalf("Expected metrics\n%s\nto contain\n%s", out, expected)
+				}
+			}
+		})
+	})
+
+	when("#WriteMetricsFile", func() {
+		var tmpDir string
+
+		it.Before(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "lifecycle.telemetry.metrics")
+			h.AssertNil(t, err)
+		})
+
+		it.After(func() {
+			os.RemoveAll(tmpDir)
+		})
+
+		it("writes the metrics to the file", func() {
+			path := filepath.Join(tmpDir, "lifecycle.prom")
+			h.AssertNil(t, tracer.WriteMetricsFile(path))
+
+			contents, err := ioutil.ReadFile(path)
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), string(tracer.Metrics()))
+
+			files, err := ioutil.ReadDir(tmpDir)
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(files), 1)
+		})
+	})
+
+	when("#PushMetrics", func() {
+		it("puts the metrics to the phase's group", func() {
+			var method, path, body string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, path = r.Method, r.URL.Path
+				b, _ := ioutil.ReadAll(r.Body)
+				body = string(b)
+			}))
+			defer server.Close()
+
+			h.AssertNil(t, tracer.PushMetrics(server.URL+"/"))
+			h.AssertEq(t, method, http.MethodPut)
+			h.AssertEq(t, path, "/metrics/job/lifecycle/phase/exporter")
+			h.AssertEq(t, body, string(tracer.Metrics()))
+		})
+	})
+}
@@ -0,0 +1,100 @@
+package telemetry_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/telemetry"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestOTLP(t *testing.T) {
+	spec.Run(t, "OTLP", testOTLP, spec.Sequential(), spec.Report(report.Terminal{}))
+}
+
+func testOTLP(t *testing.T, when spec.G, it spec.S) {
+	when("#OTLPEndpointFromEnv", func() {
+		it.After(func() {
+			h.AssertNil(t, os.Unsetenv(telemetry.EnvOTLPEndpoint))
+			h.AssertNil(t, os.Unsetenv(telemetry.EnvOTLPTracesEndpoint))
+		})
+
+		it("returns empty when nothing is configured", func() {
+			h.AssertEq(t, telemetry.OTLPEndpointFromEnv(), "")
+		})
+
+		it("appends the traces path to the base endpoint", func() {
+			h.AssertNil(t, os.Setenv(telemetry.EnvOTLPEndpoint, "http://collector:4318/"))
+			h.AssertEq(t, telemetry.OTLPEndpointFromEnv(), "http://collector:4318/v1/traces")
+		})
+
+		it("prefers the traces endpoint", func() {
+			h.AssertNil(t, os.Setenv(telemetry.EnvOTLPEndpoint, "http://collector:4318"))
+			h.AssertNil(t, os.Setenv(telemetry.EnvOTLPTracesEndpoint, "http://other:4318/traces"))
+			h.AssertEq(t, telemetry.OTLPEndpointFromEnv(), "http://other:4318/traces")
+		})
+	})
+
+	when("#ExportOTLP", func() {
+		var (
+			server   *httptest.Server
+			received map[string]interface{}
+			header   http.Header
+		)
+
+		it.Before(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				h.AssertNil(t, json.NewDecoder(r.Body).Decode(&received))
+			}))
+		})
+
+		it.After(func() {
+			server.Close()
+		})
+
+		it("posts the spans as OTLP JSON", func() {
+			tracer := telemetry.NewTracer("some-phase", "")
+			span := tracer.Start("some-span").SetAttribute("buildpack.id", "some-buildpack")
+			span.SetError(errors.New("some-error"))
+			span.Finish()
+			tracer.Root().Finish()
+
+			err := telemetry.ExportOTLP(server.URL, map[string]string{"Authorization": "some-token"}, tracer.Spans())
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, header.Get("Authorization"), "some-token")
+			h.AssertEq(t, header.Get("Content-Type"), "application/json")
+
+			spans := received["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+			h.AssertEq(t, len(spans), 2)
+
+			child := spans[0].(map[string]interface{})
+			root := spans[1].(map[string]interface{})
+			h.AssertEq(t, child["name"], "some-span")
+			h.AssertEq(t, root["name"], "some-phase")
+			h.AssertEq(t, child["traceId"], tracer.TraceID)
+			h.AssertEq(t, child["parentSpanId"], root["spanId"])
+			h.AssertEq(t, child["status"], map[string]interface{}{"code": float64(2), "message": "some-error"})
+			h.AssertEq(t, child["attributes"], []interface{}{
+				map[string]interface{}{"key": "buildpack.id", "value": map[string]interface{}{"stringValue": "some-buildpack"}},
+			})
+		})
+
+		it("returns an error when the collector rejects the spans", func() {
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+			})
+
+			err := telemetry.ExportOTLP(server.URL, nil, nil)
+			h.AssertError(t, err, "unexpected status 400")
+		})
+	})
+}
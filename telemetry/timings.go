@@ -0,0 +1,69 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+type Timings struct {
+	Phase      string            `json:"phase"`
+	TraceID    string            `json:"traceId"`
+	BuildID    string            `json:"buildId,omitempty"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Duration   float64           `json:"durationSeconds"`
+	Error      string            `json:"error,omitempty"`
+	Operations []OperationTiming `json:"operations"`
+}
+
+type OperationTiming struct {
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Duration   float64                `json:"durationSeconds"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Timings summarizes the phase and every operation recorded within it,
+// ordered by start time.
+func (t *Tracer) Timings() Timings {
+	root := t.Root()
+	timings := Timings{
+		Phase:      root.Name,
+		TraceID:    t.TraceID,
+		BuildID:    t.BuildID,
+		Start:      root.Start,
+		End:        root.End,
+		Duration:   root.Duration().Seconds(),
+		Error:      root.Error,
+		Operations: []OperationTiming{},
+	}
+	for _, s := range t.Spans() {
+		if s == root {
+			continue
+		}
+		timings.Operations = append(timings.Operations, OperationTiming{
+			Name:       s.Name,
+			Start:      s.Start,
+			End:        s.End,
+			Duration:   s.Duration().Seconds(),
+			Attributes: s.Attributes,
+			Error:      s.Error,
+		})
+	}
+	sort.SliceStable(timings.Operations, func(i, j int) bool {
+		return timings.Operations[i].Start.Before(timings.Operations[j].Start)
+	})
+	return timings
+}
+
+func (t *Tracer) WriteTimingsFile(path string) error {
+	data, err := json.MarshalIndent(t.Timings(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0666)
+}
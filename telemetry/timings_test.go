@@ -0,0 +1,61 @@
+package telemetry_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/telemetry"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestTimings(t *testing.T) {
+	spec.Run(t, "Timings", testTimings, spec.Report(report.Terminal{}))
+}
+
+func testTimings(t *testing.T, when spec.G, it spec.S) {
+	when("#WriteTimingsFile", func() {
+		var tmpDir string
+
+		it.Before(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "lifecycle.telemetry.timings")
+			h.AssertNil(t, err)
+		})
+
+		it.After(func() {
+			os.RemoveAll(tmpDir)
+		})
+
+		it("writes the phase and its operations in start order", func() {
+			tracer := telemetry.NewTracer("restorer", "")
+			first := tracer.Start("restore-layer").SetAttribute("layer", "some-buildpack:first")
+			second := tracer.Start("restore-layer").SetAttribute("layer", "some-buildpack:second")
+			second.Finish()
+			first.Finish()
+			tracer.Root().Finish()
+
+			path := filepath.Join(tmpDir, "timings.json")
+			h.AssertNil(t, tracer.WriteTimingsFile(path))
+
+			var timings telemetry.Timings
+			contents, err := ioutil.ReadFile(path)
+			h.AssertNil(t, err)
+			h.AssertNil(t, json.Unmarshal(contents, &timings))
+
+			h.AssertEq(t, timings.Phase, "restorer")
+			h.AssertEq(t, timings.TraceID, tracer.TraceID)
+			h.AssertEq(t, len(timings.Operations), 2)
+			h.AssertEq(t, timings.Operations[0].Attributes["layer"], "some-buildpack:first")
+			h.AssertEq(t, timings.Operations[1].Attributes["layer"], "some-buildpack:second")
+			if timings.Duration <= 0 || timings.End.Before(timings.Start) {
+				t.Fatalf("unexpected phase timing: %+v", timings)
+			}
+		})
+	})
+}
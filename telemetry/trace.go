@@ -0,0 +1,134 @@
+package telemetry
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Tracer records the spans of a single lifecycle phase. Every span started
+// from the Tracer is a child of the phase's root span. A nil *Tracer, and the
+// nil *Spans it returns, record nothing.
+type Tracer struct {
+	TraceID string
+	BuildID string
+	root    *Span
+	mu      sync.Mutex
+	spans   []*Span
+}
+
+type Span struct {
+	Name       string
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Error      string
+	tracer     *Tracer
+	mu         sync.Mutex
+	endOnce    sync.Once
+}
+
+// NewTracer derives the trace ID from buildID when it is set, so that the
+// spans of every phase of a build share a trace.
+func NewTracer(phase, buildID string) *Tracer {
+	t := &Tracer{TraceID: newID(16), BuildID: buildID}
+	if buildID != "" {
+		sum := sha256.Sum256([]byte(buildID))
+		t.TraceID = hex.EncodeToString(sum[:16])
+	}
+	t.root = t.newSpan(phase, "")
+	if buildID != "" {
+		t.root.SetAttribute("build.id", buildID)
+	}
+	return t
+}
+
+// Root returns the span covering the whole phase.
+func (t *Tracer) Root() *Span {
+	if t == nil {
+		return nil
+	}
+	return t.root
+}
+
+func (t *Tracer) Start(name string) *Span {
+	if t == nil {
+		return nil
+	}
+	return t.newSpan(name, t.root.SpanID)
+}
+
+// Spans returns the spans that have ended, in the order they ended.
+func (t *Tracer) Spans() []*Span {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Span(nil), t.spans...)
+}
+
+func (t *Tracer) newSpan(name, parentID string) *Span {
+	return &Span{
+		Name:       name,
+		TraceID:    t.TraceID,
+		SpanID:     newID(8),
+		ParentID:   parentID,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+		tracer:     t,
+	}
+}
+
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.newSpan(name, s.SpanID)
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) *Span {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+	return s
+}
+
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.endOnce.Do(func() {
+		s.End = time.Now()
+		s.tracer.mu.Lock()
+		defer s.tracer.mu.Unlock()
+		s.tracer.spans = append(s.tracer.spans, s)
+	})
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,37 @@
+package telemetry_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/telemetry"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+func TestTrace(t *testing.T) {
+	spec.Run(t, "Trace", testTrace, spec.Report(report.Terminal{}))
+}
+
+func testTrace(t *testing.T, when spec.G, it spec.S) {
+	when("#NewTracer", func() {
+		it("generates a different trace ID for each phase without a build ID", func() {
+			first := telemetry.NewTracer("analyzer", "")
+			second := telemetry.NewTracer("restorer", "")
+			h.AssertEq(t, len(first.TraceID), 32)
+			if first.TraceID == second.TraceID {
+				t.Fatalf("Expected different trace IDs, got %s", first.TraceID)
+			}
+		})
+
+		it("shares the trace ID between the phases of a build", func() {
+			first := telemetry.NewTracer("analyzer", "some-build")
+			second := telemetry.NewTracer("restorer", "some-build")
+			h.AssertEq(t, len(first.TraceID), 32)
+			h.AssertEq(t, first.TraceID, second.TraceID)
+			h.AssertEq(t, second.Root().Attributes["build.id"], "some-build")
+			h.AssertEq(t, second.Timings().BuildID, "some-build")
+		})
+	})
+}
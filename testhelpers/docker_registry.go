@@ -1,23 +1,41 @@
 package testhelpers
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
 	"testing"
 	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	dockercli "github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 )
 
 type DockerRegistry struct {
 	Port string
 	Name string
+
+	// CACert is the PEM encoded CA of the registry's certificate when it
+	// serves TLS.
+	CACert []byte
+
+	config      registryConfig
+	tokenServer *tokenServer
 }
 
-func NewDockerRegistry() *DockerRegistry {
-	return &DockerRegistry{}
+// NewDockerRegistry returns a registry:2 container, which is anonymous unless
+// configured with WithBasicAuth or WithTokenAuth, and serves plain HTTP
+// unless configured WithTLS.
+func NewDockerRegistry(ops ...RegistryOption) *DockerRegistry {
+	return &DockerRegistry{config: newRegistryConfig(ops)}
 }
 
 func (registry *DockerRegistry) Start(t *testing.T) {
@@ -27,8 +45,23 @@ func (registry *DockerRegistry) Start(t *testing.T) {
 
 	AssertNil(t, PullImage(DockerCli(t), "registry:2"))
 	ctx := context.Background()
+	env, files := registry.authConfig(t)
+	if registry.config.tls {
+		certs := newRegistryCerts(t)
+		registry.CACert = certs.ca
+		env = append(env,
+			"REGISTRY_HTTP_TLS_CERTIFICATE=/certs/registry.crt",
+			"REGISTRY_HTTP_TLS_KEY=/certs/registry.key",
+		)
+		if files == nil {
+			files = map[string][]byte{}
+		}
+		files["certs/registry.crt"] = certs.cert
+		files["certs/registry.key"] = certs.key
+	}
 	ctr, err := DockerCli(t).ContainerCreate(ctx, &container.Config{
 		Image: "registry:2",
+		Env:   env,
 	}, &container.HostConfig{
 		AutoRemove: true,
 		PortBindings: nat.PortMap{
@@ -36,6 +69,9 @@ func (registry *DockerRegistry) Start(t *testing.T) {
 		},
 	}, nil, registry.Name)
 	AssertNil(t, err)
+	if len(files) > 0 {
+		AssertNil(t, DockerCli(t).CopyToContainer(ctx, ctr.ID, "/", filesTar(t, files), dockertypes.CopyToContainerOptions{}))
+	}
 	err = DockerCli(t).ContainerStart(ctx, ctr.ID, dockertypes.ContainerStartOptions{})
 	AssertNil(t, err)
 
@@ -43,17 +79,115 @@ func (registry *DockerRegistry) Start(t *testing.T) {
 	AssertNil(t, err)
 	registry.Port = inspect.NetworkSettings.Ports["5000/tcp"][0].HostPort
 
+	scheme, client := "http", registryCerts{ca: registry.CACert}.client()
+	if registry.config.tls {
+		scheme = "https"
+	}
 	Eventually(t, func() bool {
-		txt, err := HttpGetE(fmt.Sprintf("http://localhost:%s/v2/", registry.Port))
-		return err == nil && txt != ""
+		resp, err := client.Get(fmt.Sprintf("%s://localhost:%s/v2/", scheme, registry.Port))
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnauthorized
 	}, 100*time.Millisecond, 10*time.Second)
 }
 
+// CertPool returns a pool with CACert, or nil for a registry without TLS.
+func (registry *DockerRegistry) CertPool() *x509.CertPool {
+	return registryCerts{ca: registry.CACert}.certPool()
+}
+
 func (registry *DockerRegistry) Stop(t *testing.T) {
 	t.Log("stop registry")
 	t.Helper()
+	registry.stop(DockerCli(t))
+}
+
+func (registry *DockerRegistry) stop(docker *dockercli.Client) {
 	if registry.Name != "" {
-		DockerCli(t).ContainerKill(context.Background(), registry.Name, "SIGKILL")
-		DockerCli(t).ContainerRemove(context.TODO(), registry.Name, dockertypes.ContainerRemoveOptions{Force: true})
+		docker.ContainerKill(context.Background(), registry.Name, "SIGKILL")
+		docker.ContainerRemove(context.TODO(), registry.Name, dockertypes.ContainerRemoveOptions{Force: true})
+	}
+	if registry.tokenServer != nil {
+		registry.tokenServer.close()
+	}
+}
+
+// authConfig returns the environment of the registry and the files, relative
+// to /, it needs for its authentication.
+func (registry *DockerRegistry) authConfig(t *testing.T) ([]string, map[string][]byte) {
+	t.Helper()
+	switch {
+	case registry.config.username == "":
+		return nil, nil
+	case registry.config.token:
+		registry.tokenServer = startTokenServer(t, registry.config, "test-registry")
+		return []string{
+			"REGISTRY_AUTH=token",
+			"REGISTRY_AUTH_TOKEN_REALM=" + registry.tokenServer.realm(),
+			"REGISTRY_AUTH_TOKEN_SERVICE=test-registry",
+			"REGISTRY_AUTH_TOKEN_ISSUER=" + registry.tokenServer.issuer,
+			"REGISTRY_AUTH_TOKEN_ROOTCERTBUNDLE=/auth/root.crt",
+		}, map[string][]byte{
+			"auth/root.crt": registry.tokenServer.rootCertBundle(),
+		}
+	default:
+		return []string{
+			"REGISTRY_AUTH=htpasswd",
+			"REGISTRY_AUTH_HTPASSWD_REALM=test-registry",
+			"REGISTRY_AUTH_HTPASSWD_PATH=/auth/htpasswd",
+		}, map[string][]byte{
+			"auth/htpasswd": htpasswd(t, registry.config.username, registry.config.password),
+		}
+	}
+}
+
+// htpasswd returns an htpasswd entry for username and password, hashed with
+// bcrypt as the registry requires.
+func htpasswd(t *testing.T, username, password string) []byte {
+	t.Helper()
+	ctx := context.Background()
+	AssertNil(t, PullImage(DockerCli(t), "httpd:2"))
+	ctr, err := DockerCli(t).ContainerCreate(ctx, &container.Config{
+		Image:      "httpd:2",
+		Entrypoint: []string{"htpasswd"},
+		Cmd:        []string{"-Bbn", username, password},
+		Tty:        true,
+	}, &container.HostConfig{}, nil, "")
+	AssertNil(t, err)
+	defer DockerCli(t).ContainerRemove(ctx, ctr.ID, dockertypes.ContainerRemoveOptions{Force: true})
+
+	AssertNil(t, DockerCli(t).ContainerStart(ctx, ctr.ID, dockertypes.ContainerStartOptions{}))
+	statusCh, errCh := DockerCli(t).ContainerWait(ctx, ctr.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		AssertNil(t, err)
+	case <-statusCh:
+	}
+	logs, err := DockerCli(t).ContainerLogs(ctx, ctr.ID, dockertypes.ContainerLogsOptions{ShowStdout: true})
+	AssertNil(t, err)
+	defer logs.Close()
+	out, err := ioutil.ReadAll(logs)
+	AssertNil(t, err)
+	return []byte(strings.TrimSpace(string(out)) + "\n")
+}
+
+// filesTar returns a tar of files, by path, and their parent directories.
+func filesTar(t *testing.T, files map[string][]byte) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dirs := map[string]bool{}
+	for name, data := range files {
+		if dir := path.Dir(name); dir != "." && !dirs[dir] {
+			dirs[dir] = true
+			AssertNil(t, tw.WriteHeader(&tar.Header{Name: dir + "/", Mode: 0755, Typeflag: tar.TypeDir}))
+		}
+		AssertNil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		AssertNil(t, err)
 	}
+	AssertNil(t, tw.Close())
+	return bytes.NewReader(buf.Bytes())
 }
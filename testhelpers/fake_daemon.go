@@ -0,0 +1,382 @@
+package testhelpers
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	dockercli "github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// FakeDaemon serves the parts of the Docker API that images use to inspect,
+// load, save and remove images, keeping them in memory, so that local images
+// can be tested without Docker.
+type FakeDaemon struct {
+	// Platform is the platform name reported by the version endpoint, such
+	// as "Podman Engine".
+	Platform string
+	// LoadError, when set, is reported in the response to every image load,
+	// as daemons report errors in the JSON messages of the response.
+	LoadError string
+
+	server  *httptest.Server
+	mu      sync.Mutex
+	images  map[string]*fakeDaemonImage // by ID
+	tags    map[string]string           // image IDs by normalized name
+	layers  map[string][]byte           // layer tars by diff ID
+	digests map[string][]string         // repo digests by image ID
+	saves   int
+}
+
+type fakeDaemonImage struct {
+	id     string
+	config []byte
+	parsed struct {
+		Created      string            `json:"created"`
+		OS           string            `json:"os"`
+		Architecture string            `json:"architecture"`
+		Config       *container.Config `json:"config"`
+		RootFS       struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+}
+
+func NewFakeDaemon() *FakeDaemon {
+	return &FakeDaemon{
+		images:  map[string]*fakeDaemonImage{},
+		tags:    map[string]string{},
+		layers:  map[string][]byte{},
+		digests: map[string][]string{},
+	}
+}
+
+func (d *FakeDaemon) Start(t testing.TB) {
+	t.Helper()
+	d.server = httptest.NewServer(d)
+}
+
+func (d *FakeDaemon) Stop(t testing.TB) {
+	t.Helper()
+	if d.server != nil {
+		d.server.Close()
+	}
+}
+
+// Client returns a Docker client for the daemon.
+func (d *FakeDaemon) Client(t testing.TB) *dockercli.Client {
+	t.Helper()
+	docker, err := dockercli.NewClientWithOpts(
+		dockercli.WithHost("tcp://"+strings.TrimPrefix(d.server.URL, "http://")),
+		dockercli.WithVersion("1.38"),
+	)
+	AssertNil(t, err)
+	return docker
+}
+
+// Tags returns the names the image with id is tagged with.
+func (d *FakeDaemon) Tags(id string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tagsOf(strings.TrimPrefix(id, "sha256:"))
+}
+
+// SetRepoDigests sets the repo digests the image with id is inspected with.
+func (d *FakeDaemon) SetRepoDigests(id string, digests ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.digests[strings.TrimPrefix(id, "sha256:")] = digests
+}
+
+// Saves returns the number of times images were saved from the daemon.
+func (d *FakeDaemon) Saves() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.saves
+}
+
+func (d *FakeDaemon) tagsOf(id string) []string {
+	var tags []string
+	for tag, tagged := range d.tags {
+		if tagged == id {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func (d *FakeDaemon) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p := req.URL.Path
+	if strings.HasPrefix(p, "/v1.") {
+		p = p[strings.Index(p[1:], "/")+1:]
+	}
+	if p == "/images/load" && req.Method == http.MethodPost {
+		// read the archive first, since clients may save other images while
+		// they send it
+		d.load(w, req)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case p == "/_ping":
+		w.Write([]byte("OK"))
+	case p == "/version":
+		version := dockertypes.Version{APIVersion: "1.38"}
+		version.Platform.Name = d.Platform
+		json.NewEncoder(w).Encode(version)
+	case p == "/images/get" && req.Method == http.MethodGet:
+		d.saves++
+		d.save(w, req.URL.Query()["names"])
+	case strings.HasPrefix(p, "/images/") && strings.HasSuffix(p, "/json") && req.Method == http.MethodGet:
+		d.inspect(w, strings.TrimSuffix(strings.TrimPrefix(p, "/images/"), "/json"))
+	case strings.HasPrefix(p, "/images/") && strings.HasSuffix(p, "/tag") && req.Method == http.MethodPost:
+		d.tag(w, strings.TrimSuffix(strings.TrimPrefix(p, "/images/"), "/tag"), req.URL.Query())
+	case strings.HasPrefix(p, "/images/") && req.Method == http.MethodDelete:
+		d.remove(w, strings.TrimPrefix(p, "/images/"))
+	default:
+		writeDaemonError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+// find returns the image with ref, a name, an ID or a prefix of an ID.
+func (d *FakeDaemon) find(ref string) (*fakeDaemonImage, bool) {
+	id := strings.TrimPrefix(ref, "sha256:")
+	if img, ok := d.images[id]; ok {
+		return img, true
+	}
+	if tag, err := name.NewTag(ref, name.WeakValidation); err == nil {
+		if img, ok := d.images[d.tags[daemonName(tag)]]; ok {
+			return img, true
+		}
+	}
+	if len(id) >= 12 {
+		for imgID, img := range d.images {
+			if strings.HasPrefix(imgID, id) {
+				return img, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (d *FakeDaemon) inspect(w http.ResponseWriter, ref string) {
+	img, ok := d.find(ref)
+	if !ok {
+		writeDaemonError(w, http.StatusNotFound, "No such image: "+ref)
+		return
+	}
+	inspect := dockertypes.ImageInspect{
+		ID:           "sha256:" + img.id,
+		RepoTags:     []string{},
+		RepoDigests:  append([]string{}, d.digests[img.id]...),
+		Created:      img.parsed.Created,
+		Os:           img.parsed.OS,
+		Architecture: img.parsed.Architecture,
+		Config:       img.parsed.Config,
+	}
+	if inspect.Config == nil {
+		inspect.Config = &container.Config{}
+	}
+	for tag, id := range d.tags {
+		if id == img.id {
+			inspect.RepoTags = append(inspect.RepoTags, tag)
+		}
+	}
+	inspect.RootFS.Type = "layers"
+	inspect.RootFS.Layers = append([]string{}, img.parsed.RootFS.DiffIDs...)
+	json.NewEncoder(w).Encode(inspect)
+}
+
+// load reads a docker save archive. Layers it has no content for must
+// already be in the daemon, and are rejected when it pretends to be Podman.
+func (d *FakeDaemon) load(w http.ResponseWriter, req *http.Request) {
+	files := map[string][]byte{}
+	tr := tar.NewReader(req.Body)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			writeDaemonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, tr); err != nil {
+			writeDaemonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		files[path.Clean("/"+header.Name)] = buf.Bytes()
+	}
+
+	var manifest []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	if err := json.Unmarshal(files["/manifest.json"], &manifest); err != nil {
+		writeDaemonError(w, http.StatusBadRequest, "invalid manifest.json: "+err.Error())
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if d.LoadError != "" {
+		enc.Encode(map[string]string{"error": d.LoadError})
+		return
+	}
+	for _, m := range manifest {
+		img := &fakeDaemonImage{config: files[path.Clean("/"+m.Config)]}
+		if err := json.Unmarshal(img.config, &img.parsed); err != nil {
+			enc.Encode(map[string]string{"error": "invalid image config: " + err.Error()})
+			return
+		}
+		if len(m.Layers) != len(img.parsed.RootFS.DiffIDs) {
+			enc.Encode(map[string]string{"error": "layers and diff IDs do not match"})
+			return
+		}
+		for i, layer := range m.Layers {
+			diffID := img.parsed.RootFS.DiffIDs[i]
+			if layer == "" {
+				if _, ok := d.layers[diffID]; !ok || strings.Contains(d.Platform, "Podman") {
+					enc.Encode(map[string]string{"error": "missing layer " + diffID})
+					return
+				}
+				continue
+			}
+			d.layers[diffID] = files[path.Clean("/"+layer)]
+		}
+		sum := sha256.Sum256(img.config)
+		img.id = hex.EncodeToString(sum[:])
+		d.images[img.id] = img
+		for _, repoTag := range m.RepoTags {
+			tag, err := name.NewTag(repoTag, name.WeakValidation)
+			if err != nil {
+				enc.Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			d.tags[daemonName(tag)] = img.id
+			enc.Encode(map[string]string{"stream": "Loaded image: " + repoTag + "\n"})
+		}
+	}
+}
+
+// save writes the images with refs as a docker save archive.
+func (d *FakeDaemon) save(w http.ResponseWriter, refs []string) {
+	var imgs []*fakeDaemonImage
+	for _, ref := range refs {
+		img, ok := d.find(ref)
+		if !ok {
+			writeDaemonError(w, http.StatusNotFound, "No such image: "+ref)
+			return
+		}
+		imgs = append(imgs, img)
+	}
+
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	var manifest []map[string]interface{}
+	for _, img := range imgs {
+		var layers []string
+		for _, diffID := range img.parsed.RootFS.DiffIDs {
+			layer := strings.TrimPrefix(diffID, "sha256:") + "/layer.tar"
+			addDaemonFile(tw, layer, d.layers[diffID])
+			layers = append(layers, layer)
+		}
+		addDaemonFile(tw, img.id+".json", img.config)
+		manifest = append(manifest, map[string]interface{}{
+			"Config":   img.id + ".json",
+			"RepoTags": []string{},
+			"Layers":   layers,
+		})
+	}
+	data, _ := json.Marshal(manifest)
+	addDaemonFile(tw, "manifest.json", data)
+}
+
+func (d *FakeDaemon) tag(w http.ResponseWriter, ref string, query url.Values) {
+	img, ok := d.find(ref)
+	if !ok {
+		writeDaemonError(w, http.StatusNotFound, "No such image: "+ref)
+		return
+	}
+	target := query.Get("repo")
+	if t := query.Get("tag"); t != "" {
+		target += ":" + t
+	}
+	tag, err := name.NewTag(target, name.WeakValidation)
+	if err != nil {
+		writeDaemonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	d.tags[daemonName(tag)] = img.id
+	w.WriteHeader(http.StatusCreated)
+}
+
+// remove deletes the image with ref, or only untags it when ref is one of
+// several names of the image, as Docker does.
+func (d *FakeDaemon) remove(w http.ResponseWriter, ref string) {
+	img, ok := d.find(ref)
+	if !ok {
+		writeDaemonError(w, http.StatusNotFound, "No such image: "+ref)
+		return
+	}
+	if tag, err := name.NewTag(ref, name.WeakValidation); err == nil && d.tags[daemonName(tag)] == img.id && len(d.tagsOf(img.id)) > 1 {
+		delete(d.tags, daemonName(tag))
+		json.NewEncoder(w).Encode([]dockertypes.ImageDeleteResponseItem{{Untagged: daemonName(tag)}})
+		return
+	}
+	var items []dockertypes.ImageDeleteResponseItem
+	for tag, id := range d.tags {
+		if id == img.id {
+			delete(d.tags, tag)
+			items = append(items, dockertypes.ImageDeleteResponseItem{Untagged: tag})
+		}
+	}
+	delete(d.images, img.id)
+	items = append(items, dockertypes.ImageDeleteResponseItem{Deleted: "sha256:" + img.id})
+	json.NewEncoder(w).Encode(items)
+}
+
+// daemonName returns the name Docker stores tag under, with docker.io for
+// Docker Hub.
+func daemonName(tag name.Tag) string {
+	registry := tag.Context().RegistryStr()
+	if registry == name.DefaultRegistry {
+		registry = "docker.io"
+	}
+	return fmt.Sprintf("%s/%s:%s", registry, tag.Context().RepositoryStr(), tag.TagStr())
+}
+
+func addDaemonFile(tw *tar.Writer, name string, data []byte) {
+	tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+	tw.Write(data)
+}
+
+func writeDaemonError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
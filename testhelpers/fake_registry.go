@@ -0,0 +1,334 @@
+package testhelpers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// FakeRegistry is an in-process registry that keeps blobs and manifests in
+// memory, so that registry code can be tested without Docker.
+type FakeRegistry struct {
+	Host string // host:port, served over plain HTTP unless WithTLS
+
+	// CACert is the PEM encoded CA of the registry's certificate when it
+	// serves TLS.
+	CACert []byte
+
+	config registryConfig
+
+	server    *httptest.Server
+	mu        sync.Mutex
+	blobs     map[string][]byte       // by digest
+	uploads   map[string][]byte       // by upload ID
+	manifests map[string]FakeManifest // by repository and tag or digest
+	uploadID  int
+	blobReads []string // digests of blobs served to GET requests
+}
+
+// FakeManifest is a manifest stored in a FakeRegistry.
+type FakeManifest struct {
+	MediaType string
+	Body      []byte
+}
+
+// NewFakeRegistry returns a registry that issues tokens from its own /token
+// endpoint when configured with WithTokenAuth.
+func NewFakeRegistry(ops ...RegistryOption) *FakeRegistry {
+	return &FakeRegistry{
+		config:    newRegistryConfig(ops),
+		blobs:     map[string][]byte{},
+		uploads:   map[string][]byte{},
+		manifests: map[string]FakeManifest{},
+	}
+}
+
+func (r *FakeRegistry) Start(t *testing.T) {
+	t.Helper()
+	r.server = httptest.NewUnstartedServer(r)
+	if r.config.tls {
+		certs := newRegistryCerts(t)
+		pair, err := tls.X509KeyPair(certs.cert, certs.key)
+		AssertNil(t, err)
+		r.server.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+		r.server.StartTLS()
+		r.CACert = certs.ca
+	} else {
+		r.server.Start()
+	}
+	r.Host = strings.TrimPrefix(strings.TrimPrefix(r.server.URL, "http://"), "https://")
+}
+
+func (r *FakeRegistry) Stop(t *testing.T) {
+	t.Helper()
+	if r.server != nil {
+		r.server.Close()
+	}
+}
+
+// CertPool returns a pool with CACert, or nil for a registry without TLS.
+func (r *FakeRegistry) CertPool() *x509.CertPool {
+	return registryCerts{ca: r.CACert}.certPool()
+}
+
+// RepoName returns the name of repo in the registry.
+func (r *FakeRegistry) RepoName(repo string) string {
+	return r.Host + "/" + repo
+}
+
+// PutBlob stores data and returns its digest.
+func (r *FakeRegistry) PutBlob(data []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := sha256Digest(data)
+	r.blobs[digest] = data
+	return digest
+}
+
+// PutManifest stores body under ref, a tag or digest, in repo, and under its
+// digest, which it returns.
+func (r *FakeRegistry) PutManifest(repo, ref, mediaType string, body []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.putManifest(repo, ref, FakeManifest{MediaType: mediaType, Body: body})
+}
+
+// Manifest returns the manifest stored under ref in repo.
+func (r *FakeRegistry) Manifest(repo, ref string) (FakeManifest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.manifests[repo+"@"+ref]
+	return m, ok
+}
+
+// BlobReads returns the digests of the blobs the registry has served to GET
+// requests, in order.
+func (r *FakeRegistry) BlobReads() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.blobReads...)
+}
+
+func (r *FakeRegistry) putManifest(repo, ref string, m FakeManifest) string {
+	digest := sha256Digest(m.Body)
+	r.manifests[repo+"@"+ref] = m
+	r.manifests[repo+"@"+digest] = m
+	return digest
+}
+
+func (r *FakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	if !r.authorized(req) {
+		if r.config.token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry"`, r.server.URL))
+		} else {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fake-registry"`)
+		}
+		writeRegistryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return
+	}
+	if req.URL.Path == "/v2/" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		r.serveUpload(w, req, path[:strings.Index(path, "/blobs/uploads/")], path[strings.Index(path, "/blobs/uploads/")+len("/blobs/uploads/"):])
+	case strings.Contains(path, "/blobs/"):
+		r.serveBlob(w, req, path[strings.LastIndex(path, "/blobs/")+len("/blobs/"):])
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		r.serveManifest(w, req, path[:i], path[i+len("/manifests/"):])
+	case strings.HasSuffix(path, "/tags/list"):
+		r.serveTags(w, strings.TrimSuffix(path, "/tags/list"))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *FakeRegistry) authorized(req *http.Request) bool {
+	if r.config.username == "" {
+		return true
+	}
+	if r.config.token {
+		return req.Header.Get("Authorization") == "Bearer "+r.issuedToken()
+	}
+	return r.config.validCredentials(req)
+}
+
+func (r *FakeRegistry) issuedToken() string {
+	return "token-for-" + r.config.username
+}
+
+func (r *FakeRegistry) serveToken(w http.ResponseWriter, req *http.Request) {
+	if !r.config.token || !r.config.validCredentials(req) {
+		writeRegistryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"token": r.issuedToken(), "access_token": r.issuedToken()})
+}
+
+func (r *FakeRegistry) serveBlob(w http.ResponseWriter, req *http.Request, digest string) {
+	blob, ok := r.blobs[digest]
+	if !ok {
+		writeRegistryError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown")
+		return
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+	w.Header().Set("Docker-Content-Digest", digest)
+	if req.Method != http.MethodHead {
+		r.blobReads = append(r.blobReads, digest)
+		w.Write(blob)
+	}
+}
+
+func (r *FakeRegistry) serveUpload(w http.ResponseWriter, req *http.Request, repo, id string) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
+	switch req.Method {
+	case http.MethodPost:
+		if mount := req.URL.Query().Get("mount"); mount != "" {
+			if _, ok := r.blobs[mount]; ok {
+				w.Header().Set("Location", "/v2/"+repo+"/blobs/"+mount)
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		r.uploadID++
+		id = fmt.Sprint(r.uploadID)
+		r.uploads[id] = body
+	case http.MethodPatch:
+		if _, ok := r.uploads[id]; !ok {
+			writeRegistryError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload unknown")
+			return
+		}
+		r.uploads[id] = append(r.uploads[id], body...)
+	case http.MethodPut:
+		data, ok := r.uploads[id]
+		if !ok {
+			writeRegistryError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload unknown")
+			return
+		}
+		data = append(data, body...)
+		digest := req.URL.Query().Get("digest")
+		if sha256Digest(data) != digest {
+			writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", "digest does not match content")
+			return
+		}
+		delete(r.uploads, id)
+		r.blobs[digest] = data
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/"+digest)
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+id)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", len(r.uploads[id])))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (r *FakeRegistry) serveManifest(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		m, ok := r.manifests[repo+"@"+ref]
+		if !ok {
+			writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		if !accepts(req, m.MediaType) {
+			// like the distribution registry, for clients that cannot
+			// parse the manifest
+			writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown to clients that do not accept "+m.MediaType)
+			return
+		}
+		w.Header().Set("Content-Type", m.MediaType)
+		w.Header().Set("Content-Length", fmt.Sprint(len(m.Body)))
+		w.Header().Set("Docker-Content-Digest", sha256Digest(m.Body))
+		if req.Method == http.MethodGet {
+			w.Write(m.Body)
+		}
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			writeRegistryError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		digest := r.putManifest(repo, ref, FakeManifest{MediaType: req.Header.Get("Content-Type"), Body: body})
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		m, ok := r.manifests[repo+"@"+ref]
+		if !ok {
+			writeRegistryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		for key, other := range r.manifests {
+			if strings.HasPrefix(key, repo+"@") && bytes.Equal(other.Body, m.Body) {
+				delete(r.manifests, key)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func accepts(req *http.Request, mediaType string) bool {
+	accept := req.Header["Accept"]
+	if len(accept) == 0 {
+		return true
+	}
+	for _, value := range accept {
+		for _, accepted := range strings.Split(value, ",") {
+			if strings.TrimSpace(accepted) == mediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *FakeRegistry) serveTags(w http.ResponseWriter, repo string) {
+	tags := []string{}
+	for key := range r.manifests {
+		if ref := strings.TrimPrefix(key, repo+"@"); ref != key && !strings.HasPrefix(ref, "sha256:") {
+			tags = append(tags, ref)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": repo, "tags": tags})
+}
+
+func writeRegistryError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
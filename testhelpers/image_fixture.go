@@ -0,0 +1,215 @@
+package testhelpers
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	dockercli "github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ImageFixture describes an image that is built in memory instead of with a
+// Dockerfile. Its digest and diff IDs only depend on its fields, so fixtures
+// are reproducible.
+type ImageFixture struct {
+	// Layers are the files of each layer, by path. Parent directories are
+	// added to each layer.
+	Layers []map[string]string
+
+	Labels     map[string]string
+	Env        []string
+	Entrypoint []string
+	Cmd        []string
+	User       string
+
+	OS           string // defaults to linux
+	Architecture string // defaults to amd64
+}
+
+// fixtureTime is the time of every fixture and file in it.
+var fixtureTime = time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC)
+
+// Image returns the fixture as an image.
+func (f ImageFixture) Image(t *testing.T) v1.Image {
+	t.Helper()
+	img := &fixtureImage{layers: map[v1.Hash][]byte{}}
+	var diffIDs []v1.Hash
+	for _, files := range f.Layers {
+		layer, err := fixtureLayer(files)
+		AssertNil(t, err)
+		diffID, _, err := v1.SHA256(bytes.NewReader(layer))
+		AssertNil(t, err)
+		img.layers[diffID] = layer
+		diffIDs = append(diffIDs, diffID)
+	}
+
+	config := v1.ConfigFile{
+		Architecture: f.Architecture,
+		OS:           f.OS,
+		Created:      v1.Time{Time: fixtureTime},
+		Config: v1.Config{
+			Labels:     f.Labels,
+			Env:        f.Env,
+			Entrypoint: f.Entrypoint,
+			Cmd:        f.Cmd,
+			User:       f.User,
+		},
+		RootFS: v1.RootFS{Type: "layers", DiffIDs: diffIDs},
+	}
+	if config.OS == "" {
+		config.OS = "linux"
+	}
+	if config.Architecture == "" {
+		config.Architecture = "amd64"
+	}
+	for range diffIDs {
+		config.History = append(config.History, v1.History{Created: v1.Time{Time: fixtureTime}})
+	}
+	var err error
+	img.config, err = json.Marshal(config)
+	AssertNil(t, err)
+
+	v1Image, err := partial.UncompressedToImage(img)
+	AssertNil(t, err)
+	return v1Image
+}
+
+// TopLayer returns the diff ID of the fixture's top layer.
+func (f ImageFixture) TopLayer(t *testing.T) string {
+	t.Helper()
+	config, err := f.Image(t).ConfigFile()
+	AssertNil(t, err)
+	diffIDs := config.RootFS.DiffIDs
+	if len(diffIDs) == 0 {
+		t.Fatal("image fixture has no layers")
+	}
+	return diffIDs[len(diffIDs)-1].String()
+}
+
+// Push saves the fixture to a registry as repoName, using credentials from
+// the docker config, and returns its digest.
+func (f ImageFixture) Push(t *testing.T, repoName string) string {
+	t.Helper()
+	ref, err := name.ParseReference(repoName, name.WeakValidation)
+	AssertNil(t, err)
+	auth, err := authn.DefaultKeychain.Resolve(ref.Context().Registry)
+	AssertNil(t, err)
+	img := f.Image(t)
+	AssertNil(t, remote.Write(ref, img, auth, http.DefaultTransport))
+	digest, err := img.Digest()
+	AssertNil(t, err)
+	return digest.String()
+}
+
+// Load loads the fixture into the daemon as repoName and returns its ID.
+func (f ImageFixture) Load(t *testing.T, dockerCli *dockercli.Client, repoName string) string {
+	t.Helper()
+	tag, err := name.NewTag(repoName, name.WeakValidation)
+	AssertNil(t, err)
+	img := f.Image(t)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tarball.Write(tag, img, pw))
+	}()
+	res, err := dockerCli.ImageLoad(context.Background(), pr, true)
+	AssertNil(t, err)
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	AssertNil(t, err)
+	if strings.Contains(string(body), `"error"`) {
+		t.Fatalf("load image '%s': %s", repoName, body)
+	}
+	id, err := img.ConfigName()
+	AssertNil(t, err)
+	return id.String()
+}
+
+// fixtureLayer returns an uncompressed layer with files and their parent
+// directories, in order and with fixed times and owners.
+func fixtureLayer(files map[string]string) ([]byte, error) {
+	contents := map[string]string{}
+	dirs := map[string]bool{}
+	for p, c := range files {
+		p = strings.TrimPrefix(path.Clean("/"+p), "/")
+		contents[p] = c
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	var paths []string
+	for p := range contents {
+		paths = append(paths, p)
+	}
+	for dir := range dirs {
+		paths = append(paths, dir)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, p := range paths {
+		header := &tar.Header{Name: p, ModTime: fixtureTime, Typeflag: tar.TypeReg, Mode: 0644}
+		if dirs[p] {
+			header.Name, header.Typeflag, header.Mode = p+"/", tar.TypeDir, 0755
+		} else {
+			header.Size = int64(len(contents[p]))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(contents[p])); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fixtureImage is an image with an uncompressed layer for each diff ID.
+type fixtureImage struct {
+	config []byte
+	layers map[v1.Hash][]byte
+}
+
+func (i *fixtureImage) RawConfigFile() ([]byte, error) {
+	return i.config, nil
+}
+
+func (i *fixtureImage) MediaType() (types.MediaType, error) {
+	return types.DockerManifestSchema2, nil
+}
+
+func (i *fixtureImage) LayerByDiffID(diffID v1.Hash) (partial.UncompressedLayer, error) {
+	return &fixtureLayerData{diffID: diffID, data: i.layers[diffID]}, nil
+}
+
+type fixtureLayerData struct {
+	diffID v1.Hash
+	data   []byte
+}
+
+func (l *fixtureLayerData) DiffID() (v1.Hash, error) {
+	return l.diffID, nil
+}
+
+func (l *fixtureLayerData) Uncompressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l.data)), nil
+}
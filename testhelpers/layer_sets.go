@@ -0,0 +1,43 @@
+package testhelpers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// LayerSet describes a synthetic layer of Files files of Size bytes each, for
+// benchmarks.
+type LayerSet struct {
+	Name  string
+	Files int
+	Size  int
+}
+
+// LayerSets range from many small files to a few large ones.
+var LayerSets = []LayerSet{
+	{Name: "many-small-files", Files: 1000, Size: 1024},
+	{Name: "medium-files", Files: 32, Size: 256 * 1024},
+	{Name: "few-large-files", Files: 4, Size: 16 * 1024 * 1024},
+}
+
+// Bytes returns the total size of the layer's files.
+func (s LayerSet) Bytes() int64 {
+	return int64(s.Files) * int64(s.Size)
+}
+
+// Write writes the layer's files to dir. Their contents are random, so they
+// do not compress, but the same for every run.
+func (s LayerSet) Write(tb testing.TB, dir string) {
+	tb.Helper()
+	AssertNil(tb, os.MkdirAll(dir, 0777))
+	data := make([]byte, s.Size)
+	r := rand.New(rand.NewSource(int64(s.Files)))
+	for i := 0; i < s.Files; i++ {
+		r.Read(data)
+		AssertNil(tb, ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d", i)), data, 0666))
+	}
+}
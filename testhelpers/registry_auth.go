@@ -0,0 +1,186 @@
+package testhelpers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// registryConfig is the authentication a test registry requires and whether
+// it serves TLS. Registries without a username are anonymous.
+type registryConfig struct {
+	username, password string
+	token              bool
+	tls                bool
+}
+
+// RegistryOption configures the authentication or transport of a
+// DockerRegistry or a FakeRegistry.
+type RegistryOption func(*registryConfig)
+
+// WithBasicAuth requires basic authentication with username and password.
+func WithBasicAuth(username, password string) RegistryOption {
+	return func(a *registryConfig) {
+		a.username, a.password, a.token = username, password, false
+	}
+}
+
+// WithTokenAuth requires a bearer token, which the registry's token server
+// issues for username and password.
+func WithTokenAuth(username, password string) RegistryOption {
+	return func(a *registryConfig) {
+		a.username, a.password, a.token = username, password, true
+	}
+}
+
+func newRegistryConfig(ops []RegistryOption) registryConfig {
+	var a registryConfig
+	for _, op := range ops {
+		op(&a)
+	}
+	return a
+}
+
+func (a registryConfig) validCredentials(req *http.Request) bool {
+	username, password, ok := req.BasicAuth()
+	return ok && username == a.username && password == a.password
+}
+
+// tokenServer issues JWTs for a registry:2 container configured with
+// REGISTRY_AUTH=token, signed with a self-signed certificate that the
+// registry trusts.
+type tokenServer struct {
+	config  registryConfig
+	service string
+	issuer  string
+	key     *rsa.PrivateKey
+	cert    []byte // DER
+	server  *httptest.Server
+}
+
+func startTokenServer(t *testing.T, config registryConfig, service string) *tokenServer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	AssertNil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-token-issuer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	AssertNil(t, err)
+
+	s := &tokenServer{config: config, service: service, issuer: "test-token-issuer", key: key, cert: cert}
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+func (s *tokenServer) realm() string {
+	return s.server.URL + "/token"
+}
+
+// rootCertBundle returns the PEM encoded certificate for the registry's
+// REGISTRY_AUTH_TOKEN_ROOTCERTBUNDLE.
+func (s *tokenServer) rootCertBundle() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.cert})
+}
+
+func (s *tokenServer) close() {
+	s.server.Close()
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/token" {
+		http.NotFound(w, req)
+		return
+	}
+	if !s.config.validCredentials(req) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="test-token-issuer"`)
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	token, err := s.sign(req.URL.Query()["scope"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":        token,
+		"access_token": token,
+		"expires_in":   3600,
+		"issued_at":    time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// sign returns a JWT granting every action in scopes, which have the form
+// repository:name:actions.
+func (s *tokenServer) sign(scopes []string) (string, error) {
+	type access struct {
+		Type    string   `json:"type"`
+		Name    string   `json:"name"`
+		Actions []string `json:"actions"`
+	}
+	granted := []access{}
+	for _, scope := range scopes {
+		for _, field := range strings.Split(scope, " ") {
+			parts := strings.Split(field, ":")
+			if len(parts) < 3 {
+				continue
+			}
+			granted = append(granted, access{
+				Type:    parts[0],
+				Name:    strings.Join(parts[1:len(parts)-1], ":"),
+				Actions: strings.Split(parts[len(parts)-1], ","),
+			})
+		}
+	}
+
+	now := time.Now()
+	header, err := json.Marshal(map[string]interface{}{
+		"typ": "JWT",
+		"alg": "RS256",
+		"x5c": []string{base64.StdEncoding.EncodeToString(s.cert)},
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":    s.issuer,
+		"sub":    s.config.username,
+		"aud":    s.service,
+		"exp":    now.Add(time.Hour).Unix(),
+		"nbf":    now.Add(-time.Minute).Unix(),
+		"iat":    now.Unix(),
+		"jti":    fmt.Sprint(now.UnixNano()),
+		"access": granted,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(payload))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
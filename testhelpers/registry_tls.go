@@ -0,0 +1,109 @@
+package testhelpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// WithTLS serves the registry over HTTPS with a certificate for localhost and
+// the addresses of this host, signed by a CA generated for the registry.
+//
+// Clients of go-containerregistry always use plain HTTP for localhost and
+// 127.0.0.1, so they must address the registry by another IP of this host.
+func WithTLS() RegistryOption {
+	return func(c *registryConfig) {
+		c.tls = true
+	}
+}
+
+// registryCerts are the PEM encoded CA, certificate and key of a TLS
+// registry.
+type registryCerts struct {
+	ca, cert, key []byte
+}
+
+func newRegistryCerts(t *testing.T) registryCerts {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	AssertNil(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-registry-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	AssertNil(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	AssertNil(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	AssertNil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test-registry"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  hostIPs(),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	AssertNil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	AssertNil(t, err)
+
+	return registryCerts{
+		ca:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// hostIPs returns the loopback addresses and the addresses of every
+// interface of this host.
+func hostIPs() []net.IP {
+	ips := []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips
+}
+
+func (c registryCerts) certPool() *x509.CertPool {
+	if c.ca == nil {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(c.ca)
+	return pool
+}
+
+// client returns a client that trusts the CA, or the default client for
+// registries without TLS.
+func (c registryCerts) client() *http.Client {
+	if c.ca == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: c.certPool()}}}
+}
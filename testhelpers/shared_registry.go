@@ -0,0 +1,69 @@
+package testhelpers
+
+import (
+	"sync"
+	"testing"
+)
+
+var (
+	sharedRegistryOnce sync.Once
+	sharedRegistry     *DockerRegistry
+
+	sharedFixturesMu sync.Mutex
+	sharedFixtures   = map[string]*sharedFixture{}
+)
+
+type sharedFixture struct {
+	once     sync.Once
+	repoName string
+}
+
+// SharedRegistry returns an anonymous registry that is started by the first
+// test to ask for it and shared by every test in the binary, including
+// parallel ones. Tests must use repositories of their own in it. Call
+// StopSharedRegistry from TestMain once the tests have run.
+func SharedRegistry(t *testing.T) *DockerRegistry {
+	t.Helper()
+	sharedRegistryOnce.Do(func() {
+		sharedRegistry = NewDockerRegistry()
+		sharedRegistry.Start(t)
+	})
+	if sharedRegistry.Port == "" {
+		t.Fatal("shared registry failed to start")
+	}
+	return sharedRegistry
+}
+
+// StopSharedRegistry stops the registry returned by SharedRegistry, if it was
+// started.
+func StopSharedRegistry() {
+	if sharedRegistry == nil || dockerCliVal == nil {
+		return
+	}
+	sharedRegistry.stop(dockerCliVal)
+}
+
+// SharedFixture pushes fixture to the shared registry the first time it is
+// asked for with name, and returns its name in the registry. Shared fixtures
+// are read by many tests, which must not change or delete them.
+func SharedFixture(t *testing.T, name string, fixture ImageFixture) string {
+	t.Helper()
+	registry := SharedRegistry(t)
+	sharedFixturesMu.Lock()
+	f, ok := sharedFixtures[name]
+	if !ok {
+		f = &sharedFixture{}
+		sharedFixtures[name] = f
+	}
+	sharedFixturesMu.Unlock()
+
+	f.once.Do(func() {
+		repoName := "localhost:" + registry.Port + "/fixtures/" + name
+		fixture.Push(t, repoName)
+		f.repoName = repoName
+	})
+	if f.repoName == "" {
+		t.Fatalf("shared fixture '%s' failed to push", name)
+	}
+	return f.repoName
+}
@@ -73,7 +73,7 @@ func AssertError(t *testing.T, actual error, expected string) {
 	}
 }
 
-func AssertNil(t *testing.T, actual interface{}) {
+func AssertNil(t testing.TB, actual interface{}) {
 	t.Helper()
 	if actual != nil {
 		t.Fatalf("Expected nil: %s", actual)
@@ -6,6 +6,7 @@ package testmock
 
 import (
 	cache "github.com/buildpack/lifecycle/cache"
+	image "github.com/buildpack/lifecycle/image"
 	gomock "github.com/golang/mock/gomock"
 	io "io"
 	reflect "reflect"
@@ -48,6 +49,20 @@ func (mr *MockCacheMockRecorder) AddLayer(arg0, arg1, arg2 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddLayer", reflect.TypeOf((*MockCache)(nil).AddLayer), arg0, arg1, arg2)
 }
 
+// AddLayerFromOpener mocks base method
+func (m *MockCache) AddLayerFromOpener(arg0, arg1 string, arg2 int64, arg3 image.Opener) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddLayerFromOpener", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddLayerFromOpener indicates an expected call of AddLayerFromOpener
+func (mr *MockCacheMockRecorder) AddLayerFromOpener(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddLayerFromOpener", reflect.TypeOf((*MockCache)(nil).AddLayerFromOpener), arg0, arg1, arg2, arg3)
+}
+
 // Commit mocks base method
 func (m *MockCache) Commit() error {
 	m.ctrl.T.Helper()
@@ -62,6 +77,21 @@ func (mr *MockCacheMockRecorder) Commit() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Commit", reflect.TypeOf((*MockCache)(nil).Commit))
 }
 
+// List mocks base method
+func (m *MockCache) List() ([]cache.LayerInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List")
+	ret0, _ := ret[0].([]cache.LayerInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List
+func (mr *MockCacheMockRecorder) List() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockCache)(nil).List))
+}
+
 // Name mocks base method
 func (m *MockCache) Name() string {
 	m.ctrl.T.Helper()
@@ -49,6 +49,20 @@ func (mr *MockImageMockRecorder) AddLayer(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddLayer", reflect.TypeOf((*MockImage)(nil).AddLayer), arg0)
 }
 
+// AddLayerFromOpener mocks base method
+func (m *MockImage) AddLayerFromOpener(arg0 string, arg1 int64, arg2 image.Opener) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddLayerFromOpener", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddLayerFromOpener indicates an expected call of AddLayerFromOpener
+func (mr *MockImageMockRecorder) AddLayerFromOpener(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddLayerFromOpener", reflect.TypeOf((*MockImage)(nil).AddLayerFromOpener), arg0, arg1, arg2)
+}
+
 // CreatedAt mocks base method
 func (m *MockImage) CreatedAt() (time.Time, error) {
 	m.ctrl.T.Helper()
@@ -181,6 +195,20 @@ func (mr *MockImageMockRecorder) Rebase(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rebase", reflect.TypeOf((*MockImage)(nil).Rebase), arg0, arg1)
 }
 
+// Tag mocks base method
+func (m *MockImage) Tag(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Tag", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Tag indicates an expected call of Tag
+func (mr *MockImageMockRecorder) Tag(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Tag", reflect.TypeOf((*MockImage)(nil).Tag), arg0)
+}
+
 // Rename mocks base method
 func (m *MockImage) Rename(arg0 string) {
 	m.ctrl.T.Helper()
@@ -286,6 +314,20 @@ func (mr *MockImageMockRecorder) SetLabel(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLabel", reflect.TypeOf((*MockImage)(nil).SetLabel), arg0, arg1)
 }
 
+// SetUser mocks base method
+func (m *MockImage) SetUser(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetUser", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetUser indicates an expected call of SetUser
+func (mr *MockImageMockRecorder) SetUser(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUser", reflect.TypeOf((*MockImage)(nil).SetUser), arg0)
+}
+
 // TopLayer mocks base method
 func (m *MockImage) TopLayer() (string, error) {
 	m.ctrl.T.Helper()
@@ -300,3 +342,18 @@ func (mr *MockImageMockRecorder) TopLayer() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopLayer", reflect.TypeOf((*MockImage)(nil).TopLayer))
 }
+
+// User mocks base method
+func (m *MockImage) User() (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "User")
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// User indicates an expected call of User
+func (mr *MockImageMockRecorder) User() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "User", reflect.TypeOf((*MockImage)(nil).User))
+}
@@ -0,0 +1,281 @@
+// Package testutil lets platforms check their integration with the lifecycle
+// by building apps with the real phases and asserting on the exported
+// images, without Docker.
+package testutil
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+
+	"github.com/buildpack/lifecycle"
+	"github.com/buildpack/lifecycle/image"
+	"github.com/buildpack/lifecycle/metadata"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+// DefaultStackID is the stack of harness run images and buildpacks.
+const DefaultStackID = "io.buildpacks.stacks.test"
+
+// Harness builds apps in a temporary directory and exports them to an
+// in-process registry. Buildpacks added with Buildpack form the only group
+// of the order. Close removes the directory and stops the registry.
+type Harness struct {
+	Dir           string
+	AppDir        string
+	LayersDir     string
+	PlatformDir   string
+	BuildpacksDir string
+	Launcher      string
+
+	Order    lifecycle.BuildpackOrder
+	Runner   *lifecycle.Runner
+	Registry *h.FakeRegistry
+	Factory  *image.Factory
+
+	// Cache, when set, is restored before and updated after each build.
+	Cache lifecycle.Cache
+
+	t *testing.T
+}
+
+// NewHarness returns a harness whose phases log to the test. The runner can
+// be configured before building, for example to sign images.
+func NewHarness(t *testing.T) *Harness {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "lifecycle.harness.")
+	h.AssertNil(t, err)
+	harness := &Harness{
+		Dir:           dir,
+		AppDir:        filepath.Join(dir, "app"),
+		LayersDir:     filepath.Join(dir, "layers"),
+		PlatformDir:   filepath.Join(dir, "platform"),
+		BuildpacksDir: filepath.Join(dir, "buildpacks"),
+		Launcher:      filepath.Join(dir, "launcher"),
+		Order:         lifecycle.BuildpackOrder{{}},
+		Registry:      h.NewFakeRegistry(),
+		Factory:       &image.Factory{Keychain: authn.DefaultKeychain, Out: ioutil.Discard},
+		t:             t,
+	}
+	for _, d := range []string{harness.AppDir, harness.LayersDir, filepath.Join(harness.PlatformDir, "env"), harness.BuildpacksDir} {
+		h.AssertNil(t, os.MkdirAll(d, 0777))
+	}
+	h.AssertNil(t, ioutil.WriteFile(harness.Launcher, []byte("launcher"), 0777))
+	harness.Registry.Start(t)
+
+	out := testWriter{t}
+	harness.Runner = &lifecycle.Runner{
+		AppDir:      harness.AppDir,
+		LayersDir:   harness.LayersDir,
+		PlatformDir: harness.PlatformDir,
+		StackID:     DefaultStackID,
+		UID:         os.Getuid(),
+		GID:         os.Getgid(),
+		Logger:      lifecycle.NewDefaultLogger(out, out),
+		Out:         out,
+		Err:         out,
+	}
+	return harness
+}
+
+func (hr *Harness) Close() {
+	hr.Registry.Stop(hr.t)
+	os.RemoveAll(hr.Dir)
+}
+
+// ImageName returns the name of repo in the harness registry.
+func (hr *Harness) ImageName(repo string) string {
+	return hr.Registry.RepoName(repo)
+}
+
+// AppFile writes contents to path in the app directory.
+func (hr *Harness) AppFile(path, contents string) {
+	hr.t.Helper()
+	path = filepath.Join(hr.AppDir, path)
+	h.AssertNil(hr.t, os.MkdirAll(filepath.Dir(path), 0777))
+	h.AssertNil(hr.t, ioutil.WriteFile(path, []byte(contents), 0666))
+}
+
+// Buildpack adds a buildpack to the group with bash scripts as its
+// bin/detect and bin/build. Scripts run in the app directory with the
+// arguments of the Buildpack API.
+func (hr *Harness) Buildpack(id, version, detect, build string) *lifecycle.Buildpack {
+	hr.t.Helper()
+	bp := &lifecycle.Buildpack{ID: id, Version: version, Name: id}
+	bp.Dir = filepath.Join(hr.BuildpacksDir, bp.EscapedID(), version)
+	h.AssertNil(hr.t, os.MkdirAll(filepath.Join(bp.Dir, "bin"), 0777))
+	for name, script := range map[string]string{"detect": detect, "build": build} {
+		contents := "#!/usr/bin/env bash\nset -eo pipefail\n" + script + "\n"
+		h.AssertNil(hr.t, ioutil.WriteFile(filepath.Join(bp.Dir, "bin", name), []byte(contents), 0777))
+	}
+	hr.Order[0].Buildpacks = append(hr.Order[0].Buildpacks, bp)
+	return bp
+}
+
+// RunImage saves a run image for the harness stack with a single layer and
+// labels to repo in the registry, and returns its name.
+func (hr *Harness) RunImage(repo string, labels map[string]string) string {
+	hr.t.Helper()
+	name := hr.ImageName(repo)
+	layer := filepath.Join(hr.Dir, repo+".tar")
+	h.AssertNil(hr.t, os.MkdirAll(filepath.Dir(layer), 0777))
+	tarContents, err := h.CreateSingleFileTar("etc/"+path.Base(repo), repo)
+	h.AssertNil(hr.t, err)
+	f, err := os.Create(layer)
+	h.AssertNil(hr.t, err)
+	_, err = io.Copy(f, tarContents)
+	h.AssertNil(hr.t, err)
+	h.AssertNil(hr.t, f.Close())
+
+	img := hr.Factory.NewEmptyRemote(name)
+	h.AssertNil(hr.t, img.AddLayer(layer))
+	h.AssertNil(hr.t, img.SetLabel(metadata.StackIDLabel, DefaultStackID))
+	for k, v := range labels {
+		h.AssertNil(hr.t, img.SetLabel(k, v))
+	}
+	_, err = img.Save()
+	h.AssertNil(hr.t, err)
+	return name
+}
+
+// Build runs every phase to build the app on runImage, reusing the layers
+// of the previous image with appName, and returns the exported image.
+func (hr *Harness) Build(runImage, appName string) image.Image {
+	hr.t.Helper()
+	img, err := hr.BuildE(runImage, appName)
+	h.AssertNil(hr.t, err)
+	return img
+}
+
+// BuildE is Build for builds expected to fail. The layers directory is
+// emptied first, as it is on a new build container.
+func (hr *Harness) BuildE(runImage, appName string) (image.Image, error) {
+	if err := os.RemoveAll(hr.LayersDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(hr.LayersDir, 0777); err != nil {
+		return nil, err
+	}
+
+	run, err := hr.Factory.NewRemote(runImage)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := hr.Factory.NewRemote(appName)
+	if err != nil {
+		return nil, err
+	}
+	err = hr.Runner.Run(lifecycle.Phases{
+		Order:         hr.Order,
+		PreviousImage: prev,
+		Cache:         hr.Cache,
+		RunImage:      run,
+		Launcher:      hr.Launcher,
+		Stack:         metadata.StackMetadata{RunImage: metadata.StackRunImageMetadata{Image: runImage}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hr.Factory.NewRemote(appName)
+}
+
+// AssertLabel fails the test if img does not have the label with value.
+func AssertLabel(t *testing.T, img image.Image, key, value string) {
+	t.Helper()
+	actual, err := img.Label(key)
+	h.AssertNil(t, err)
+	if actual != value {
+		t.Fatalf("expected label '%s' of image '%s' to be '%s', got '%s'", key, img.Name(), value, actual)
+	}
+}
+
+// AssertLayer fails the test if the app metadata of img does not record the
+// buildpack's layer, and returns its metadata.
+func AssertLayer(t *testing.T, img image.Image, buildpackID, layer string) metadata.LayerMetadata {
+	t.Helper()
+	md, err := metadata.GetAppMetadata(img)
+	h.AssertNil(t, err)
+	for _, bp := range md.Buildpacks {
+		if bp.ID != buildpackID {
+			continue
+		}
+		if l, ok := bp.Layers[layer]; ok {
+			return l
+		}
+	}
+	t.Fatalf("expected image '%s' to have layer '%s' of buildpack '%s'", img.Name(), layer, buildpackID)
+	return metadata.LayerMetadata{}
+}
+
+// AssertNoLayer fails the test if the app metadata of img records the
+// buildpack's layer.
+func AssertNoLayer(t *testing.T, img image.Image, buildpackID, layer string) {
+	t.Helper()
+	md, err := metadata.GetAppMetadata(img)
+	h.AssertNil(t, err)
+	for _, bp := range md.Buildpacks {
+		if _, ok := bp.Layers[layer]; ok && bp.ID == buildpackID {
+			t.Fatalf("expected image '%s' not to have layer '%s' of buildpack '%s'", img.Name(), layer, buildpackID)
+		}
+	}
+}
+
+// AssertLayerFile fails the test if the buildpack's layer in img does not
+// contain the file at path, relative to the layer, with contents.
+func (hr *Harness) AssertLayerFile(img image.Image, buildpackID, layer, path, contents string) {
+	hr.t.Helper()
+	l := AssertLayer(hr.t, img, buildpackID, layer)
+	bp := lifecycle.Buildpack{ID: buildpackID}
+	hr.assertFile(img, l.SHA, filepath.Join(hr.LayersDir, bp.EscapedID(), layer, path), contents)
+}
+
+// AssertAppFile fails the test if the app layer of img does not contain the
+// file at path, relative to the app directory, with contents.
+func (hr *Harness) AssertAppFile(img image.Image, path, contents string) {
+	hr.t.Helper()
+	md, err := metadata.GetAppMetadata(img)
+	h.AssertNil(hr.t, err)
+	hr.assertFile(img, md.App.SHA, filepath.Join(hr.AppDir, path), contents)
+}
+
+func (hr *Harness) assertFile(img image.Image, diffID, path, contents string) {
+	hr.t.Helper()
+	rc, err := img.GetLayer(diffID)
+	h.AssertNil(hr.t, err)
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		h.AssertNil(hr.t, err)
+		if filepath.Clean("/"+header.Name) != filepath.Clean("/"+path) {
+			continue
+		}
+		actual, err := ioutil.ReadAll(tr)
+		h.AssertNil(hr.t, err)
+		if string(actual) != contents {
+			hr.t.Fatalf("expected '%s' in image '%s' to contain '%s', got '%s'", path, img.Name(), contents, actual)
+		}
+		return
+	}
+	hr.t.Fatalf("expected layer '%s' of image '%s' to contain '%s'", diffID, img.Name(), path)
+}
+
+// testWriter logs each write to the test.
+type testWriter struct {
+	t *testing.T
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
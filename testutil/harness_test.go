@@ -0,0 +1,60 @@
+package testutil_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpack/lifecycle/metadata"
+	h "github.com/buildpack/lifecycle/testhelpers"
+	"github.com/buildpack/lifecycle/testutil"
+)
+
+func TestHarness(t *testing.T) {
+	spec.Run(t, "Harness", testHarness, spec.Report(report.Terminal{}))
+}
+
+func testHarness(t *testing.T, when spec.G, it spec.S) {
+	var harness *testutil.Harness
+
+	it.Before(func() {
+		harness = testutil.NewHarness(t)
+	})
+
+	it.After(func() {
+		harness.Close()
+	})
+
+	it("builds, exports and rebuilds an app", func() {
+		harness.AppFile("app.txt", "some-app")
+		harness.Buildpack("some/buildpack", "1.2.3", `[[ -f app.txt ]] || exit 100`, `
+if [[ ! -f "$1/some-layer.toml" ]]; then
+  mkdir -p "$1/some-layer"
+  echo "some-contents" > "$1/some-layer/some-file"
+fi
+echo "launch = true" > "$1/some-layer.toml"
+echo 'processes = [{ type = "web", command = "some-command" }]' > "$1/launch.toml"
+`)
+		runImage := harness.RunImage("some-run-image", map[string]string{"some-label": "some-value"})
+		appName := harness.ImageName("some-app")
+
+		img := harness.Build(runImage, appName)
+		testutil.AssertLabel(t, img, metadata.StackIDLabel, testutil.DefaultStackID)
+		testutil.AssertLabel(t, img, "some-label", "some-value")
+		layer := testutil.AssertLayer(t, img, "some/buildpack", "some-layer")
+		h.AssertEq(t, layer.Launch, true)
+		harness.AssertLayerFile(img, "some/buildpack", "some-layer", "some-file", "some-contents\n")
+		harness.AssertAppFile(img, "app.txt", "some-app")
+		testutil.AssertNoLayer(t, img, "some/buildpack", "other-layer")
+
+		rebuilt := harness.Build(runImage, appName)
+		h.AssertEq(t, testutil.AssertLayer(t, rebuilt, "some/buildpack", "some-layer").SHA, layer.SHA)
+	})
+
+	it("fails when no buildpack passes detection", func() {
+		harness.Buildpack("some/buildpack", "1.2.3", "exit 100", "exit 1")
+		_, err := harness.BuildE(harness.RunImage("some-run-image", nil), harness.ImageName("some-app"))
+		h.AssertError(t, err, "no buildpack group passed detection")
+	})
+}
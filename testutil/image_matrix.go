@@ -0,0 +1,81 @@
+package testutil
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/lifecycle/image"
+	h "github.com/buildpack/lifecycle/testhelpers"
+)
+
+// ImageTarget is where the images of an ImageMatrix suite are stored: an
+// in-process daemon for local images, or an in-process registry for remote
+// images.
+type ImageTarget struct {
+	Name    string // "local" or "remote"
+	Factory *image.Factory
+
+	registry *h.FakeRegistry
+}
+
+// RepoName returns the name of repo in the target.
+func (tg *ImageTarget) RepoName(repo string) string {
+	if tg.registry == nil {
+		return repo
+	}
+	return tg.registry.RepoName(repo)
+}
+
+// New returns the image with repoName, which may not be found.
+func (tg *ImageTarget) New(repoName string) (image.Image, error) {
+	if tg.registry == nil {
+		return tg.Factory.NewLocal(repoName)
+	}
+	return tg.Factory.NewRemote(repoName)
+}
+
+// NewEmpty returns an image with repoName and no layers.
+func (tg *ImageTarget) NewEmpty(repoName string) image.Image {
+	if tg.registry == nil {
+		return tg.Factory.NewEmptyLocal(repoName)
+	}
+	return tg.Factory.NewEmptyRemote(repoName)
+}
+
+// ImageMatrix registers suite once for local images and once for remote
+// images, so that both implementations of image.Image are held to the same
+// behavior. The target is started before and stopped after each spec.
+func ImageMatrix(t *testing.T, when spec.G, it spec.S, suite func(target *ImageTarget)) {
+	for _, name := range []string{"local", "remote"} {
+		target := &ImageTarget{Name: name}
+		when(name, func() {
+			var daemon *h.FakeDaemon
+
+			it.Before(func() {
+				target.Factory = &image.Factory{Keychain: authn.DefaultKeychain, Out: ioutil.Discard}
+				if target.Name == "local" {
+					daemon = h.NewFakeDaemon()
+					daemon.Start(t)
+					target.Factory.Docker = daemon.Client(t)
+				} else {
+					target.registry = h.NewFakeRegistry()
+					target.registry.Start(t)
+				}
+			})
+
+			it.After(func() {
+				if daemon != nil {
+					daemon.Stop(t)
+				}
+				if target.registry != nil {
+					target.registry.Stop(t)
+				}
+			})
+
+			suite(target)
+		})
+	}
+}
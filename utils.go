@@ -1,9 +1,12 @@
 package lifecycle
 
 import (
+	"context"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -23,3 +26,19 @@ func WriteTOML(path string, data interface{}) error {
 func escapeIdentifier(id string) string {
 	return strings.Replace(id, "/", "_", -1)
 }
+
+// stopTimeout is how long a command has to exit after being asked to stop
+// before it is killed.
+const stopTimeout = 10 * time.Second
+
+// command returns a command that is stopped when ctx, which may be nil, is
+// done: it is asked to exit and killed if it has not within stopTimeout.
+func command(ctx context.Context, name string, arg ...string) *exec.Cmd {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Cancel = func() error { return stopProcess(cmd.Process) }
+	cmd.WaitDelay = stopTimeout
+	return cmd
+}